@@ -0,0 +1,185 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/Shopify/sarama"
+	"github.com/kong/konnect-ingest/internal/admin"
+	"github.com/kong/konnect-ingest/internal/config"
+	"go.uber.org/zap"
+)
+
+const usage = `usage: admin <command> [flags]
+
+commands:
+  topics    create or reconcile the main, DLQ, retry and snapshot topics from configuration
+  offsets   show the committed offsets and lag of the consumer group (offsets show), or
+            reset them (offsets reset -to oldest|newest|<timestamp>|<partition:offset,...>)
+`
+
+func main() {
+	logger, _ := zap.NewProduction()
+	defer logger.Sync()
+
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	// Load configuration
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		logger.Fatal("Failed to load configuration", zap.Error(err))
+	}
+
+	var exitCode int
+	switch os.Args[1] {
+	case "topics":
+		exitCode = runTopics(cfg, os.Args[2:], logger)
+	case "offsets":
+		exitCode = runOffsets(cfg, os.Args[2:], logger)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		exitCode = 2
+	}
+	logger.Sync()
+	os.Exit(exitCode)
+}
+
+func runTopics(cfg *config.Config, args []string, logger *zap.Logger) int {
+	flags := flag.NewFlagSet("topics", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "only report drift, do not change the cluster")
+	allowPartitionIncrease := flags.Bool("allow-partition-increase", false,
+		"add partitions when a topic has fewer than configured; remaps keys of hash partitioners")
+	flags.Parse(args)
+
+	clusterAdmin, err := newClusterAdmin(cfg)
+	if err != nil {
+		logger.Error("Failed to create cluster admin", zap.Error(err))
+		return 1
+	}
+	defer clusterAdmin.Close()
+
+	reconciler := admin.NewTopicReconciler(clusterAdmin, logger)
+	drifts, err := reconciler.Reconcile(admin.SpecsFromConfig(cfg), admin.ReconcileOptions{
+		DryRun:                 *dryRun,
+		AllowPartitionIncrease: *allowPartitionIncrease,
+	})
+
+	unresolved := 0
+	for _, drift := range drifts {
+		fmt.Println(drift.String())
+		if !drift.Fixed {
+			unresolved++
+		}
+	}
+	if err != nil {
+		logger.Error("Topic reconciliation failed", zap.Error(err))
+		return 1
+	}
+	if len(drifts) == 0 {
+		fmt.Println("all topics match configuration")
+	}
+	if unresolved > 0 {
+		return 3
+	}
+	return 0
+}
+
+func runOffsets(cfg *config.Config, args []string, logger *zap.Logger) int {
+	if len(args) < 1 || (args[0] != "show" && args[0] != "reset") {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+	flags := flag.NewFlagSet("offsets "+args[0], flag.ExitOnError)
+	group := flags.String("group", cfg.Kafka.GroupID, "consumer group")
+	topic := flags.String("topic", cfg.Kafka.Topic, "topic")
+	to := flags.String("to", "", "reset position: oldest, newest, a timestamp (epoch ms or RFC3339) or partition:offset pairs")
+	dryRun := flags.Bool("dry-run", false, "only print the offsets a reset would commit")
+	flags.Parse(args[1:])
+
+	kafkaConfig := sarama.NewConfig()
+	kafkaConfig.ClientID = cfg.Kafka.ClientID
+	client, err := sarama.NewClient(cfg.Kafka.Brokers, kafkaConfig)
+	if err != nil {
+		logger.Error("Failed to create Kafka client", zap.Error(err))
+		return 1
+	}
+	defer client.Close()
+	clusterAdmin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		logger.Error("Failed to create cluster admin", zap.Error(err))
+		return 1
+	}
+
+	if args[0] == "show" {
+		lags, err := admin.GroupLag(clusterAdmin, client, *group, *topic)
+		if err != nil {
+			logger.Error("Failed to fetch group offsets", zap.Error(err))
+			return 1
+		}
+		printLags(*group, *topic, lags)
+		return 0
+	}
+
+	position, err := admin.ParsePosition(*to)
+	if err != nil {
+		logger.Error("Invalid reset position", zap.Error(err))
+		return 2
+	}
+	// Active members would overwrite the reset with their own commits
+	if err := admin.EnsureInactive(clusterAdmin, *group); err != nil {
+		logger.Error("Refusing to reset offsets", zap.Error(err))
+		return 3
+	}
+	offsets, err := admin.ResolveOffsets(client, *topic, position)
+	if err != nil {
+		logger.Error("Failed to resolve reset offsets", zap.Error(err))
+		return 1
+	}
+	partitions := make([]int32, 0, len(offsets))
+	for partition := range offsets {
+		partitions = append(partitions, partition)
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+	for _, partition := range partitions {
+		fmt.Printf("%s/%d -> %d\n", *topic, partition, offsets[partition])
+	}
+	if *dryRun {
+		fmt.Println("dry run, no offsets committed")
+		return 0
+	}
+	if err := admin.CommitGroupOffsets(client, *group, *topic, offsets); err != nil {
+		logger.Error("Failed to commit offsets", zap.Error(err))
+		return 1
+	}
+	fmt.Printf("reset group %s on %s\n", *group, *topic)
+	return 0
+}
+
+func printLags(group string, topic string, lags []admin.PartitionLag) {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(writer, "GROUP\tTOPIC\tPARTITION\tCOMMITTED\tNEWEST\tLAG\n")
+	var total int64
+	for _, lag := range lags {
+		committed, behind := "-", "-"
+		if lag.Committed >= 0 {
+			committed = fmt.Sprint(lag.Committed)
+			behind = fmt.Sprint(lag.Lag)
+			total += lag.Lag
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%d\t%s\t%d\t%s\n", group, topic, lag.Partition, committed, lag.Newest, behind)
+	}
+	writer.Flush()
+	fmt.Printf("total lag: %d\n", total)
+}
+
+func newClusterAdmin(cfg *config.Config) (sarama.ClusterAdmin, error) {
+	kafkaConfig := sarama.NewConfig()
+	kafkaConfig.ClientID = cfg.Kafka.ClientID
+	return sarama.NewClusterAdmin(cfg.Kafka.Brokers, kafkaConfig)
+}
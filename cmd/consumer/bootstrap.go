@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Shopify/sarama"
+	"github.com/kong/konnect-ingest/internal/admin"
+	"github.com/kong/konnect-ingest/internal/config"
+	"github.com/kong/konnect-ingest/internal/consumer"
+	"go.uber.org/zap"
+)
+
+// bootstrapFromSnapshot loads the snapshot topic and commits the live topic offsets it
+// reflects for the consumer group, so that the group resumes right after the snapshot
+func bootstrapFromSnapshot(
+	ctx context.Context,
+	cfg *config.Config,
+	kafkaConfig *sarama.Config,
+	processor consumer.MessageProcessor,
+	logger *zap.Logger,
+) error {
+	client, err := sarama.NewClient(cfg.Kafka.Brokers, kafkaConfig)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	clusterAdmin, err := sarama.NewClusterAdmin(cfg.Kafka.Brokers, kafkaConfig)
+	if err != nil {
+		return err
+	}
+	defer clusterAdmin.Close()
+
+	partitions, err := client.Partitions(cfg.Kafka.Topic)
+	if err != nil {
+		return err
+	}
+
+	// Loading on top of committed offsets would either skip or repeat live records
+	committed, err := admin.CommittedOffsets(clusterAdmin, cfg.Kafka.GroupID, cfg.Kafka.Topic, partitions)
+	if err != nil {
+		return err
+	}
+	if len(committed) > 0 {
+		return fmt.Errorf("group %s already has committed offsets on %s, bootstrap is only allowed for new groups",
+			cfg.Kafka.GroupID, cfg.Kafka.Topic)
+	}
+
+	bootstrapper := consumer.NewSnapshotBootstrapper(client, processor, cfg.Kafka.SnapshotTopic, cfg.Kafka.Topic, logger)
+	positions, err := bootstrapper.Load(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := admin.CommitGroupOffsets(client, cfg.Kafka.GroupID, cfg.Kafka.Topic, positions); err != nil {
+		return err
+	}
+
+	logger.Info("Bootstrapped from snapshot",
+		zap.String("snapshotTopic", cfg.Kafka.SnapshotTopic),
+		zap.Any("liveOffsets", positions),
+	)
+	return nil
+}
@@ -2,19 +2,41 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/Shopify/sarama"
+	"github.com/kong/konnect-ingest/internal/admin"
+	"github.com/kong/konnect-ingest/internal/archive"
 	"github.com/kong/konnect-ingest/internal/config"
 	"github.com/kong/konnect-ingest/internal/consumer"
 	"github.com/kong/konnect-ingest/internal/data_processing"
+	"github.com/kong/konnect-ingest/internal/webhook"
+	_ "github.com/lib/pq"
 	"github.com/opensearch-project/opensearch-go/v2"
 	"go.uber.org/zap"
+	_ "modernc.org/sqlite"
+)
+
+// Exit codes of the consumer
+const (
+	exitOK = iota
+	// exitDrainIncomplete means in-flight messages were abandoned on shutdown and will be consumed again
+	exitDrainIncomplete
+	// exitConsumeStopped means the consumer group stopped consuming without a shutdown signal
+	exitConsumeStopped
 )
 
 func main() {
+	os.Exit(run())
+}
+
+func run() int {
 	logger, _ := zap.NewProduction()
 	defer logger.Sync()
 
@@ -34,52 +56,454 @@ func main() {
 
 	// Create Kafka consumer
 	kafkaConfig := sarama.NewConfig()
-	kafkaConfig.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
-	kafkaConfig.Consumer.Offsets.Initial = sarama.OffsetOldest
+	rebalanceStrategy, err := consumer.RebalanceStrategy(cfg.Kafka.RebalanceStrategy)
+	if err != nil {
+		logger.Fatal("Invalid rebalance strategy", zap.Error(err))
+	}
+	kafkaConfig.Consumer.Group.Rebalance.GroupStrategies = []sarama.BalanceStrategy{rebalanceStrategy}
+	initialPosition, err := admin.ParsePosition(cfg.Consumer.InitialOffset)
+	if err != nil {
+		logger.Fatal("Invalid initial offset", zap.Error(err))
+	}
+	switch initialPosition.Kind {
+	case admin.PositionOldest, admin.PositionTimestamp:
+		kafkaConfig.Consumer.Offsets.Initial = sarama.OffsetOldest
+	case admin.PositionNewest:
+		kafkaConfig.Consumer.Offsets.Initial = sarama.OffsetNewest
+	default:
+		logger.Fatal("Initial offset must be oldest, newest or a timestamp", zap.String("initialOffset", cfg.Consumer.InitialOffset))
+	}
+	if err := consumer.ValidateCommitMode(cfg.Consumer.CommitMode); err != nil {
+		logger.Fatal("Invalid commit mode", zap.Error(err))
+	}
+	commitInterval, err := time.ParseDuration(cfg.Consumer.CommitInterval)
+	if err != nil {
+		logger.Fatal("Invalid commit interval", zap.Error(err))
+	}
+	kafkaConfig.Consumer.Offsets.AutoCommit.Interval = commitInterval
+	kafkaConfig.Consumer.Offsets.AutoCommit.Enable = cfg.Consumer.CommitMode == consumer.CommitModeAuto
 
 	kafkaConsumer, err := sarama.NewConsumerGroup(cfg.Kafka.Brokers, cfg.Kafka.GroupID, kafkaConfig)
 	if err != nil {
 		logger.Fatal("Failed to create consumer group", zap.Error(err))
 	}
-	defer kafkaConsumer.Close()
+	shutdownTimeout, err := time.ParseDuration(cfg.Consumer.ShutdownTimeout)
+	if err != nil {
+		logger.Fatal("Invalid shutdown timeout", zap.Error(err))
+	}
 
 	// Setup signal handling for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
 
-	go func() {
-		<-signals
-		logger.Info("Received shutdown signal, stopping...")
-		cancel()
-	}()
-
 	// Create components
-	indexer := data_processing.NewOpenSearchIndexer(osClient, logger)
-	entityExtractor := data_processing.NewCDCEntityExtractor(logger)
+	openSearchIndexer := data_processing.NewOpenSearchIndexer(osClient, logger)
+	var indexer data_processing.DocumentIndexer = openSearchIndexer
+	// Re-enrichment updates indexed documents where writes go
+	var referenceUpdater data_processing.ReferenceUpdater = openSearchIndexer
+	var controlPlaneUpdater data_processing.ControlPlaneUpdater = openSearchIndexer
+	if cfg.OpenSearch.UseAliases {
+		refreshInterval, err := time.ParseDuration(cfg.OpenSearch.AliasRefreshInterval)
+		if err != nil {
+			logger.Fatal("Invalid alias refresh interval", zap.Error(err))
+		}
+		aliasManager := data_processing.NewAliasManager(osClient, logger)
+		aliasIndexer := data_processing.NewAliasIndexer(indexer, aliasManager, refreshInterval, logger)
+		indexer = aliasIndexer
+		referenceUpdater = aliasIndexer
+		controlPlaneUpdater = aliasIndexer
+	}
+	var backpressure *consumer.BackpressureController
+	if cfg.Consumer.Backpressure.Enabled {
+		backpressureConfig, err := consumer.BackpressureConfigFromConfig(cfg)
+		if err != nil {
+			logger.Fatal("Invalid backpressure configuration", zap.Error(err))
+		}
+		backpressure = consumer.NewBackpressureController(backpressureConfig, logger)
+		backpressure.SetPauser(kafkaConsumer)
+		go backpressure.Run(ctx)
+		indexer = consumer.NewObservedIndexer(indexer, backpressure)
+	}
+	// Shared by the last change of documents and the webhook notifications
+	differ := data_processing.NewFieldDiffer(cfg.Consumer.Diff.IgnoreFields)
+	// Every document is written to all enabled sinks
+	sinks := data_processing.NewFanOutSink(logger)
+	if cfg.Sinks.OpenSearch.Enabled {
+		policy, err := data_processing.ParseFailurePolicy(cfg.Sinks.OpenSearch.Policy)
+		if err != nil {
+			logger.Fatal("Invalid OpenSearch sink configuration", zap.Error(err))
+		}
+		sinks.AddSink(data_processing.NewIndexerSink("opensearch", indexer), policy)
+	}
+	if cfg.Sinks.SQL.Enabled {
+		policy, err := data_processing.ParseFailurePolicy(cfg.Sinks.SQL.Policy)
+		if err != nil {
+			logger.Fatal("Invalid SQL sink configuration", zap.Error(err))
+		}
+		dialect, err := data_processing.ParseSQLDialect(cfg.Sinks.SQL.Dialect)
+		if err != nil {
+			logger.Fatal("Invalid SQL sink configuration", zap.Error(err))
+		}
+		db, err := sql.Open(dialect.Driver(), cfg.Sinks.SQL.DSN)
+		if err != nil {
+			logger.Fatal("Failed to open SQL database", zap.Error(err))
+		}
+		defer db.Close()
+		if dialect == data_processing.SQLite {
+			// SQLite has a single writer, serialize writes instead of failing them as busy
+			db.SetMaxOpenConns(1)
+		}
+		if err := db.Ping(); err != nil {
+			logger.Fatal("Failed to connect to SQL database", zap.Error(err))
+		}
+		sqlIndexer := data_processing.NewSQLIndexer(db, dialect, cfg.OpenSearch.IndexPrefix, logger)
+		for _, topicProcessing := range cfg.Consumer.Topics {
+			sqlIndexer.AddIndexPrefix(topicProcessing.IndexPrefix)
+		}
+		sinks.AddSink(data_processing.NewIndexerSink("sql", sqlIndexer), policy)
+	}
+	if cfg.Sinks.Archive.Enabled {
+		policy, err := data_processing.ParseFailurePolicy(cfg.Sinks.Archive.Policy)
+		if err != nil {
+			logger.Fatal("Invalid archive sink configuration", zap.Error(err))
+		}
+		format, err := archive.ParseFormat(cfg.Sinks.Archive.Format)
+		if err != nil {
+			logger.Fatal("Invalid archive sink configuration", zap.Error(err))
+		}
+		maxFileAge, err := time.ParseDuration(cfg.Sinks.Archive.MaxFileAge)
+		if err != nil {
+			logger.Fatal("Invalid archive max file age", zap.Error(err))
+		}
+		archiveWriter, err := archive.NewWriter(archive.Config{
+			Dir:          cfg.Sinks.Archive.Dir,
+			Format:       format,
+			MaxFileBytes: cfg.Sinks.Archive.MaxFileBytes,
+			MaxFileAge:   maxFileAge,
+		}, logger)
+		if err != nil {
+			logger.Fatal("Failed to open the archive", zap.Error(err))
+		}
+		// Runs after the consumer stopped, completing the files of the drained events
+		defer func() {
+			if err := archiveWriter.Close(); err != nil {
+				logger.Error("Failed to complete the archive files", zap.Error(err))
+			}
+		}()
+		sinks.AddSink(archiveWriter, policy)
+	}
+	if cfg.Sinks.Webhook.Enabled {
+		policy, err := data_processing.ParseFailurePolicy(cfg.Sinks.Webhook.Policy)
+		if err != nil {
+			logger.Fatal("Invalid webhook sink configuration", zap.Error(err))
+		}
+		webhookConfig, err := webhook.ConfigFromConfig(cfg)
+		if err != nil {
+			logger.Fatal("Invalid webhook sink configuration", zap.Error(err))
+		}
+		notifier := webhook.NewNotifier(webhookConfig, logger)
+		notifier.SetDiffer(differ)
+		defer notifier.Close()
+		sinks.AddSink(notifier, policy)
+	}
+	if sinks.Len() == 0 {
+		logger.Fatal("No sink enabled")
+	}
+	// Auto-committed offsets are not bound to flushes, a crash would lose the buffered writes
+	// of committed events
+	if buffered := sinks.RequiredBuffered(); len(buffered) > 0 && cfg.Consumer.CommitMode != consumer.CommitModeManual {
+		logger.Fatal("Required buffered sinks need consumer.commit_mode: manual", zap.Strings("sinks", buffered))
+	}
+	// Lookups and updates of indexed documents read and write OpenSearch directly, so they
+	// only run when documents are indexed there
+	openSearchSink := cfg.Sinks.OpenSearch.Enabled
+	if !openSearchSink {
+		logger.Info("OpenSearch sink disabled, OpenSearch lookups and re-enrichment updates are off")
+	}
+
+	var redactor *data_processing.FieldRedactor
+	if cfg.Redaction.Enabled {
+		redactor, err = data_processing.NewFieldRedactor(data_processing.RedactionRulesFromConfig(cfg))
+		if err != nil {
+			logger.Fatal("Invalid redaction configuration", zap.Error(err))
+		}
+	}
+	var freshnessTracker *consumer.FreshnessTracker
+	if cfg.Consumer.SystemEvents.Enabled {
+		freshnessTracker = consumer.NewFreshnessTracker(logger)
+		if cfg.Consumer.SystemEvents.ConsistencyChecks && openSearchSink {
+			checkDelay, err := time.ParseDuration(cfg.Consumer.SystemEvents.CheckDelay)
+			if err != nil {
+				logger.Fatal("Invalid consistency check delay", zap.Error(err))
+			}
+			freshnessTracker.SetConsistencyChecker(consumer.NewIndexConsistencyChecker(
+				openSearchIndexer,
+				cfg.OpenSearch.IndexPrefix,
+				checkDelay,
+				logger,
+			))
+		}
+	}
+	freshnessInterval, err := time.ParseDuration(cfg.Consumer.ChangeDetection.FreshnessInterval)
+	if err != nil {
+		logger.Fatal("Invalid freshness interval", zap.Error(err))
+	}
+	var changeDetector *consumer.ChangeDetector
+	if cfg.Consumer.ChangeDetection.Enabled {
+		changeDetector = consumer.NewChangeDetector(
+			cfg.Consumer.ChangeDetection.VolatileFields,
+			freshnessInterval,
+			cfg.Consumer.ChangeDetection.CacheSize,
+			logger,
+		)
+		if cfg.Consumer.ChangeDetection.StoredHashLookup && openSearchSink {
+			changeDetector.SetDocumentGetter(openSearchIndexer)
+		}
+	}
+
+	// newEventProcessor creates the processor of the documents indexed under a prefix. The
+	// enrichers track the documents of their indices, so each prefix gets its own.
+	newEventProcessor := func(indexPrefix string, entityExtractor data_processing.EntityExtractor) *consumer.CDCEventProcessor {
+		eventProcessor := consumer.NewCDCEventProcessor(
+			logger,
+			indexer,
+			entityExtractor,
+			indexPrefix,
+		)
+		eventProcessor.SetSink(sinks)
+		if redactor != nil {
+			eventProcessor.SetRedactor(redactor)
+		}
+		if cfg.Consumer.Enrichment.Enabled {
+			enricher := data_processing.NewRelationEnricher(data_processing.DefaultRelations(), indexPrefix, logger)
+			if cfg.Consumer.Enrichment.ParentLookup && openSearchSink {
+				enricher.SetDocumentGetter(openSearchIndexer)
+			}
+			if cfg.Consumer.Enrichment.UpdateChildren && openSearchSink {
+				enricher.SetReferenceUpdater(referenceUpdater)
+			}
+			eventProcessor.AddEnricher(enricher)
+		}
+		if cfg.Consumer.ControlPlane.Enabled {
+			registry := data_processing.NewControlPlaneRegistry(indexPrefix, logger)
+			if cfg.Consumer.ControlPlane.Lookup && openSearchSink {
+				lookupTTL, err := time.ParseDuration(cfg.Consumer.ControlPlane.LookupTTL)
+				if err != nil {
+					logger.Fatal("Invalid control plane lookup TTL", zap.Error(err))
+				}
+				registry.SetDocumentGetter(openSearchIndexer, lookupTTL)
+			}
+			if cfg.Consumer.ControlPlane.UpdateDocuments && openSearchSink {
+				registry.SetControlPlaneUpdater(controlPlaneUpdater)
+			}
+			eventProcessor.AddEnricher(registry)
+		}
+		if cfg.Consumer.Search.Enabled {
+			eventProcessor.SetSearchProjector(data_processing.DefaultProjections())
+		}
+		if cfg.Consumer.Diff.Enabled {
+			eventProcessor.SetDiffer(differ)
+			eventProcessor.SetChangeHistory(cfg.Consumer.Diff.History)
+		}
+		if freshnessTracker != nil {
+			eventProcessor.SetSystemEventHandler(freshnessTracker)
+		}
+		if changeDetector != nil {
+			eventProcessor.SetWriteFilter(changeDetector)
+		}
+		return eventProcessor
+	}
 
 	// Create event processor
-	eventProcessor := consumer.NewCDCEventProcessor(
-		logger,
-		indexer,
-		entityExtractor,
-		cfg.OpenSearch.IndexPrefix,
-	)
+	eventProcessor := newEventProcessor(cfg.OpenSearch.IndexPrefix, data_processing.NewCDCEntityExtractor(logger))
 
 	// Create consumer handler
 	consumerHandler := consumer.NewKafkaConsumerHandler(logger)
 	consumerHandler.SetEventProcessor(eventProcessor)
+	consumerHandler.AddFlusher(sinks)
+	consumerHandler.SetSkipEntityTypes(cfg.Consumer.SkipEntityTypes)
+	consumerHandler.SetPartitionStrategy(cfg.Kafka.Partitioner.Strategy)
+	for _, topicProcessing := range cfg.Consumer.Topics {
+		entityExtractor, err := topicEntityExtractor(topicProcessing, logger)
+		if err != nil {
+			logger.Fatal("Invalid topic processing configuration", zap.String("match", topicProcessing.Match), zap.Error(err))
+		}
+		indexPrefix := topicProcessing.IndexPrefix
+		if indexPrefix == "" {
+			indexPrefix = cfg.OpenSearch.IndexPrefix
+		}
+		if err := consumerHandler.SetTopicProcessor(topicProcessing.Match, newEventProcessor(indexPrefix, entityExtractor)); err != nil {
+			logger.Fatal("Invalid topic processing configuration", zap.Error(err))
+		}
+	}
+	coalesceWindow, err := time.ParseDuration(cfg.Consumer.Coalesce.Window)
+	if err != nil {
+		logger.Fatal("Invalid coalesce window", zap.Error(err))
+	}
+	consumerHandler.SetCoalescing(coalesceWindow, cfg.Consumer.Coalesce.MaxKeys)
+	consumerHandler.SetConcurrency(cfg.Consumer.Concurrency.Workers, cfg.Consumer.Concurrency.MaxInFlight)
+	if backpressure != nil {
+		consumerHandler.SetBackpressure(backpressure)
+	}
+	if cfg.Consumer.CommitMode == consumer.CommitModeManual {
+		consumerHandler.SetManualCommit(commitInterval, cfg.Consumer.BatchSize)
+	}
+	if changeDetector != nil {
+		consumerHandler.AddSessionState(changeDetector)
+	}
 
-	// Start consuming
-	for {
-		err := kafkaConsumer.Consume(ctx, []string{cfg.Kafka.Topic}, consumerHandler)
+	if cfg.Consumer.Snapshot.Bootstrap {
+		if err := bootstrapFromSnapshot(ctx, cfg, kafkaConfig, consumerHandler, logger); err != nil {
+			logger.Fatal("Failed to bootstrap from snapshot", zap.Error(err))
+		}
+	}
+
+	if cfg.Consumer.Snapshot.Publish {
+		snapshotWriter, err := consumer.NewKafkaSnapshotWriter(cfg.Kafka.Brokers, cfg.Kafka.SnapshotTopic, logger)
 		if err != nil {
-			if err == context.Canceled {
+			logger.Fatal("Failed to create snapshot writer", zap.Error(err))
+		}
+		defer snapshotWriter.Close()
+		consumerHandler.SetSnapshotWriter(snapshotWriter)
+	}
+
+	if cfg.Consumer.StatusAddr != "" {
+		if freshnessTracker == nil {
+			freshnessTracker = consumer.NewFreshnessTracker(logger)
+		}
+		statusServer := &http.Server{Addr: cfg.Consumer.StatusAddr, Handler: consumer.NewStatusHandler(freshnessTracker)}
+		go func() {
+			if err := statusServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Error("Status server failed", zap.Error(err))
+			}
+		}()
+		defer statusServer.Close()
+	}
+
+	// Subscribe to the configured topics and to the topics matching the patterns
+	var topicLister consumer.TopicLister
+	if len(cfg.Kafka.TopicPatterns) > 0 {
+		metadataClient, err := sarama.NewClient(cfg.Kafka.Brokers, kafkaConfig)
+		if err != nil {
+			logger.Fatal("Failed to create metadata client", zap.Error(err))
+		}
+		defer metadataClient.Close()
+		topicLister = metadataClient
+	}
+	subscription, err := consumer.NewTopicSubscription(subscribedTopics(cfg), cfg.Kafka.TopicPatterns, topicLister, logger)
+	if err != nil {
+		logger.Fatal("Failed to resolve subscribed topics", zap.Error(err))
+	}
+	metadataRefreshInterval, err := time.ParseDuration(cfg.Kafka.MetadataRefreshInterval)
+	if err != nil {
+		logger.Fatal("Invalid metadata refresh interval", zap.Error(err))
+	}
+	go subscription.Run(ctx, metadataRefreshInterval)
+	logger.Info("Subscribing to topics", zap.Strings("topics", subscription.Topics()))
+
+	// After the snapshot bootstrap, which commits offsets of its own
+	if initialPosition.Kind == admin.PositionTimestamp {
+		if err := startAtTimestamp(cfg, kafkaConfig, subscription.Topics(), initialPosition.TimestampMs, logger); err != nil {
+			logger.Fatal("Failed to start at the initial offset timestamp", zap.Error(err))
+		}
+	}
+
+	// Start consuming
+	consumeDone := make(chan struct{})
+	go func() {
+		defer close(consumeDone)
+		for {
+			if len(subscription.Topics()) == 0 {
+				logger.Warn("No topic matches the subscription yet, waiting for one")
+				select {
+				case <-subscription.Changed():
+					continue
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			// A change of the subscribed topics ends the session to subscribe again
+			sessionCtx, endSession := context.WithCancel(ctx)
+			go func() {
+				select {
+				case <-subscription.Changed():
+					endSession()
+				case <-sessionCtx.Done():
+				}
+			}()
+			err := kafkaConsumer.Consume(sessionCtx, subscription.Topics(), consumerHandler)
+			endSession()
+			if ctx.Err() != nil || errors.Is(err, sarama.ErrClosedConsumerGroup) {
 				return
 			}
-			logger.Error("Error from consumer", zap.Error(err))
+			if err != nil {
+				logger.Error("Error from consumer", zap.Error(err))
+			}
 		}
+	}()
+
+	select {
+	case <-signals:
+		logger.Info("Received shutdown signal, draining in-flight messages",
+			zap.Duration("shutdownTimeout", shutdownTimeout),
+		)
+	case <-consumeDone:
+		logger.Error("Consumer group stopped consuming without a shutdown signal, shutting down")
+		shutdown(kafkaConsumer, consumerHandler, backpressure, cancel, consumeDone, shutdownTimeout, logger)
+		return exitConsumeStopped
 	}
+	return shutdown(kafkaConsumer, consumerHandler, backpressure, cancel, consumeDone, shutdownTimeout, logger)
 }
 
+// shutdown stops fetching, lets the claims drain and commit their offsets and closes the
+// group, all within the timeout. It returns the exit code of the consumer.
+func shutdown(
+	kafkaConsumer sarama.ConsumerGroup,
+	handler *consumer.KafkaConsumerHandler,
+	backpressure *consumer.BackpressureController,
+	cancel context.CancelFunc,
+	consumeDone <-chan struct{},
+	timeout time.Duration,
+	logger *zap.Logger,
+) int {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	// Backpressure would resume the partitions once its own pause elapsed
+	if backpressure != nil {
+		backpressure.Detach()
+	}
+	kafkaConsumer.PauseAll()
+	cancel()
 
+	// The session ends once every claim drained; its Cleanup commits the marked offsets
+	select {
+	case <-consumeDone:
+	case <-deadline.C:
+		logger.Error("Shutdown deadline exceeded before the claims drained, uncommitted messages will be consumed again")
+		return exitDrainIncomplete
+	}
+
+	closed := make(chan error, 1)
+	go func() { closed <- kafkaConsumer.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			logger.Error("Failed to close consumer group", zap.Error(err))
+			return exitDrainIncomplete
+		}
+	case <-deadline.C:
+		logger.Error("Shutdown deadline exceeded while closing the consumer group")
+		return exitDrainIncomplete
+	}
+
+	if err := handler.DrainError(); err != nil {
+		logger.Error("Shutdown completed without draining every claim", zap.Error(err))
+		return exitDrainIncomplete
+	}
+	logger.Info("Shutdown complete, in-flight messages drained and offsets committed")
+	return exitOK
+}
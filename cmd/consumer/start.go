@@ -0,0 +1,57 @@
+package main
+
+import (
+	"github.com/Shopify/sarama"
+	"github.com/kong/konnect-ingest/internal/admin"
+	"github.com/kong/konnect-ingest/internal/config"
+	"go.uber.org/zap"
+)
+
+// startAtTimestamp commits the offsets of the first records at or after the timestamp on
+// every topic the group has no committed offsets for, so that a new group skips the older
+// records. Topics the group already committed on resume where it left off.
+func startAtTimestamp(cfg *config.Config, kafkaConfig *sarama.Config, topics []string, timestampMs int64, logger *zap.Logger) error {
+	client, err := sarama.NewClient(cfg.Kafka.Brokers, kafkaConfig)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	clusterAdmin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		return err
+	}
+
+	for _, topic := range topics {
+		partitions, err := client.Partitions(topic)
+		if err != nil {
+			return err
+		}
+		committed, err := admin.CommittedOffsets(clusterAdmin, cfg.Kafka.GroupID, topic, partitions)
+		if err != nil {
+			return err
+		}
+		if len(committed) > 0 {
+			logger.Info("Group has committed offsets, ignoring the initial offset timestamp",
+				zap.String("groupId", cfg.Kafka.GroupID),
+				zap.String("topic", topic),
+			)
+			continue
+		}
+
+		offsets, err := admin.ResolveOffsets(client, topic, admin.Position{Kind: admin.PositionTimestamp, TimestampMs: timestampMs})
+		if err != nil {
+			return err
+		}
+		if err := admin.CommitGroupOffsets(client, cfg.Kafka.GroupID, topic, offsets); err != nil {
+			return err
+		}
+		logger.Info("Starting new group at timestamp",
+			zap.String("groupId", cfg.Kafka.GroupID),
+			zap.String("topic", topic),
+			zap.Int64("timestampMs", timestampMs),
+			zap.Any("offsets", offsets),
+		)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/kong/konnect-ingest/internal/config"
+	"github.com/kong/konnect-ingest/internal/data_processing"
+	"go.uber.org/zap"
+)
+
+// Entity extractors selectable per topic
+const (
+	extractorCDC    = "cdc"
+	extractorObject = "object"
+)
+
+// topicEntityExtractor creates the entity extractor configured for a topic
+func topicEntityExtractor(topicProcessing config.TopicProcessing, logger *zap.Logger) (data_processing.EntityExtractor, error) {
+	switch topicProcessing.Extractor {
+	case "", extractorCDC:
+		var extractor data_processing.EntityExtractor = data_processing.NewCDCEntityExtractor(logger)
+		if topicProcessing.EntityType != "" {
+			extractor = data_processing.NewEntityTypeOverride(extractor, topicProcessing.EntityType)
+		}
+		return extractor, nil
+	case extractorObject:
+		if topicProcessing.EntityType == "" {
+			return nil, fmt.Errorf("the object extractor requires an entity_type")
+		}
+		return data_processing.NewObjectEntityExtractor(topicProcessing.EntityType, topicProcessing.IDField, logger), nil
+	default:
+		return nil, fmt.Errorf("unknown extractor %q", topicProcessing.Extractor)
+	}
+}
+
+// subscribedTopics returns the configured topic followed by the additional topics, without
+// duplicates
+func subscribedTopics(cfg *config.Config) []string {
+	seen := make(map[string]bool)
+	var topics []string
+	for _, topic := range append([]string{cfg.Kafka.Topic}, cfg.Kafka.Topics...) {
+		if topic != "" && !seen[topic] {
+			seen[topic] = true
+			topics = append(topics, topic)
+		}
+	}
+	return topics
+}
@@ -1,17 +1,26 @@
 package main
 
 import (
+	"flag"
 	"io"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
+	"github.com/kong/konnect-ingest/internal/archive"
 	"github.com/kong/konnect-ingest/internal/config"
 	"github.com/kong/konnect-ingest/internal/producer"
 	"go.uber.org/zap"
 )
 
 func main() {
+	os.Exit(run())
+}
+
+// run replays the input file and returns the exit code of the producer: non-zero when an
+// event was not delivered
+func run() int {
 	logger, _ := zap.NewProduction()
 	defer logger.Sync()
 
@@ -21,21 +30,84 @@ func main() {
 		logger.Fatal("Failed to load configuration", zap.Error(err))
 	}
 
-	// Create event producer
-	eventProducer, err := producer.NewKafkaEventProducer(
-		cfg.Kafka.Brokers,
-		cfg.Kafka.Topic,
-		logger,
-	)
+	// Command line flags override the producer section of the configuration
+	filterCfg := cfg.Producer.Filter
+	inputFile := flag.String("input", cfg.Producer.InputFile, "path of the jsonl file to replay")
+	archiveDir := flag.String("archive", cfg.Producer.ArchiveDir, "directory of an event archive to replay instead of -input, the time and type filters select the archived files")
+	topic := flag.String("topic", firstNonEmpty(cfg.Producer.Topic, cfg.Kafka.Topic), "topic to produce to")
+	dryRun := flag.Bool("dry-run", cfg.Producer.DryRun, "print the events that would be sent instead of producing them")
+	includeTypes := flag.String("include-types", strings.Join(filterCfg.IncludeEntityTypes, ","), "comma separated entity types to include")
+	excludeTypes := flag.String("exclude-types", strings.Join(filterCfg.ExcludeEntityTypes, ","), "comma separated entity types to exclude")
+	includeCPs := flag.String("include-control-planes", strings.Join(filterCfg.IncludeControlPlanes, ","), "comma separated control plane ids to include")
+	excludeCPs := flag.String("exclude-control-planes", strings.Join(filterCfg.ExcludeControlPlanes, ","), "comma separated control plane ids to exclude")
+	includeOps := flag.String("include-ops", strings.Join(filterCfg.IncludeOps, ","), "comma separated ops (c,u,d,r) to include")
+	excludeOps := flag.String("exclude-ops", strings.Join(filterCfg.ExcludeOps, ","), "comma separated ops (c,u,d,r) to exclude")
+	fromTs := flag.String("from-ts", filterCfg.FromTs, "only send events with ts_ms at or after this time (epoch ms or RFC3339)")
+	toTs := flag.String("to-ts", filterCfg.ToTs, "only send events with ts_ms before this time (epoch ms or RFC3339)")
+	fields := flag.String("fields", strings.Join(filterCfg.Fields, ","), "comma separated jq-like projections of the object, e.g. .name,.service.id")
+	flag.Parse()
+
+	fromTsMs, err := producer.ParseTimestampMs(*fromTs)
 	if err != nil {
-		logger.Fatal("Failed to create event producer", zap.Error(err))
+		logger.Fatal("Invalid from-ts", zap.Error(err))
+	}
+	toTsMs, err := producer.ParseTimestampMs(*toTs)
+	if err != nil {
+		logger.Fatal("Invalid to-ts", zap.Error(err))
 	}
-	defer eventProducer.Close()
 
-	// Create event reader
-	eventReader, err := producer.NewEventReader(cfg.Producer.InputFile, logger)
+	eventFilter, err := producer.NewRuleFilter(producer.FilterRules{
+		IncludeEntityTypes:   splitList(*includeTypes),
+		ExcludeEntityTypes:   splitList(*excludeTypes),
+		IncludeControlPlanes: splitList(*includeCPs),
+		ExcludeControlPlanes: splitList(*excludeCPs),
+		IncludeOps:           splitList(*includeOps),
+		ExcludeOps:           splitList(*excludeOps),
+		FromTsMs:             fromTsMs,
+		ToTsMs:               toTsMs,
+		Fields:               splitList(*fields),
+	})
 	if err != nil {
-		logger.Fatal("Failed to create event reader", zap.Error(err))
+		logger.Fatal("Invalid filter configuration", zap.Error(err))
+	}
+
+	// Create event producer
+	var eventProducer producer.EventProducer
+	if *dryRun {
+		eventProducer = producer.NewDryRunEventProducer(os.Stdout)
+	} else {
+		eventProducer, err = producer.NewKafkaEventProducer(
+			cfg.Kafka.Brokers,
+			*topic,
+			producer.PartitionerConfig{
+				Strategy:     cfg.Kafka.Partitioner.Strategy,
+				VirtualNodes: cfg.Kafka.Partitioner.VirtualNodes,
+			},
+			logger,
+		)
+		if err != nil {
+			logger.Fatal("Failed to create event producer", zap.Error(err))
+		}
+	}
+
+	// Create event reader
+	var eventReader producer.EventSource
+	if *archiveDir != "" {
+		archiveReader, err := archive.NewReader(*archiveDir, archive.Range{
+			FromTsMs:    fromTsMs,
+			ToTsMs:      toTsMs,
+			EntityTypes: splitList(*includeTypes),
+		}, logger)
+		if err != nil {
+			logger.Fatal("Failed to read the archive manifest", zap.Error(err))
+		}
+		logger.Info("Replaying archive", zap.String("dir", *archiveDir), zap.Int("files", len(archiveReader.Files())))
+		eventReader = archiveReader
+	} else {
+		eventReader, err = producer.NewEventReader(*inputFile, logger)
+		if err != nil {
+			logger.Fatal("Failed to create event reader", zap.Error(err))
+		}
 	}
 	defer eventReader.Close()
 
@@ -43,26 +115,84 @@ func main() {
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
 
-	// Process events
-	for {
+	var read, sent, filtered int
+	var undelivered []string
+	interrupted := false
+
+	// Process events until the input is exhausted or a signal stops reading
+	for !interrupted {
 		select {
 		case <-signals:
-			logger.Info("Received shutdown signal, stopping...")
-			return
+			logger.Info("Received shutdown signal, flushing the producer...")
+			interrupted = true
+			continue
 		default:
-			event, err := eventReader.ReadEvent()
-			if err == io.EOF {
-				return
-			}
-			if err != nil {
-				logger.Error("Failed to read event", zap.Error(err))
-				continue
-			}
-
-			if err := eventProducer.ProduceEvent(*event); err != nil {
-				logger.Error("Failed to produce event", zap.Error(err))
-				continue
-			}
+		}
+
+		event, err := eventReader.ReadEvent()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			logger.Error("Failed to read event", zap.Error(err))
+			continue
+		}
+		read++
+
+		transformed, ok := eventFilter.Apply(*event)
+		if !ok {
+			filtered++
+			continue
+		}
+
+		if err := eventProducer.ProduceEvent(transformed); err != nil {
+			logger.Error("Failed to produce event", zap.String("key", transformed.After.Key), zap.Error(err))
+			undelivered = append(undelivered, transformed.After.Key)
+			continue
+		}
+		sent++
+	}
+
+	// Closing flushes the messages still buffered by the producer
+	closeErr := eventProducer.Close()
+	if closeErr != nil {
+		logger.Error("Failed to flush the producer", zap.Error(closeErr))
+	}
+
+	logger.Info("Producer finished",
+		zap.String("topic", *topic),
+		zap.Bool("dryRun", *dryRun),
+		zap.Bool("interrupted", interrupted),
+		zap.Int("read", read),
+		zap.Int("sent", sent),
+		zap.Int("filtered", filtered),
+		zap.Int("undelivered", len(undelivered)),
+	)
+	if len(undelivered) > 0 {
+		logger.Error("Events were not delivered", zap.Strings("keys", undelivered))
+	}
+	if len(undelivered) > 0 || closeErr != nil {
+		return 1
+	}
+	return 0
+}
+
+// splitList splits a comma separated flag value, dropping empty entries
+func splitList(value string) []string {
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
 		}
 	}
+	return ""
 }
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/kong/konnect-ingest/internal/config"
+	"github.com/kong/konnect-ingest/internal/consumer"
+	"github.com/kong/konnect-ingest/internal/data_processing"
+	"github.com/kong/konnect-ingest/internal/reindex"
+	"github.com/opensearch-project/opensearch-go/v2"
+	"go.uber.org/zap"
+)
+
+func main() {
+	logger, _ := zap.NewProduction()
+	defer logger.Sync()
+
+	// Load configuration
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		logger.Fatal("Failed to load configuration", zap.Error(err))
+	}
+
+	refreshInterval, err := time.ParseDuration(cfg.OpenSearch.AliasRefreshInterval)
+	if err != nil {
+		logger.Fatal("Invalid alias refresh interval", zap.Error(err))
+	}
+
+	entityTypes := flag.String("types", "", "comma separated entity types to reindex, e.g. service,route")
+	source := flag.String("source", reindex.SourceKafka, "catch-up source of the new generation: kafka or opensearch")
+	bodyFile := flag.String("body", "", "json file with settings and mappings of the new generation")
+	tolerance := flag.Float64("tolerance", 0, "accepted relative difference between old and new document counts")
+	settle := flag.Duration("settle", 2*refreshInterval, "time for consumers to pick up alias changes, before catch-up and before a swap")
+	rollback := flag.Bool("rollback", false, "swap the aliases back to the previous generation")
+	finalize := flag.Bool("finalize", false, "stop dual-writing to previous generations")
+	flag.Parse()
+
+	types := splitList(*entityTypes)
+	if len(types) == 0 {
+		fmt.Fprintln(os.Stderr, "at least one entity type is required, see -types")
+		os.Exit(2)
+	}
+
+	var body map[string]interface{}
+	if *bodyFile != "" {
+		data, err := os.ReadFile(*bodyFile)
+		if err != nil {
+			logger.Fatal("Failed to read index body", zap.Error(err))
+		}
+		if err := json.Unmarshal(data, &body); err != nil {
+			logger.Fatal("Invalid index body", zap.Error(err))
+		}
+	}
+
+	// Create OpenSearch client
+	osClient, err := opensearch.NewClient(opensearch.Config{
+		Addresses: cfg.OpenSearch.Hosts,
+	})
+	if err != nil {
+		logger.Fatal("Failed to create OpenSearch client", zap.Error(err))
+	}
+	aliasManager := data_processing.NewAliasManager(osClient, logger)
+	indexer := data_processing.NewOpenSearchIndexer(osClient, logger)
+
+	// Replayed documents must be redacted exactly like the ones the consumer indexes
+	var redactor data_processing.Redactor
+	if cfg.Redaction.Enabled {
+		redactor, err = data_processing.NewFieldRedactor(data_processing.RedactionRulesFromConfig(cfg))
+		if err != nil {
+			logger.Fatal("Invalid redaction configuration", zap.Error(err))
+		}
+	}
+
+	var replayer reindex.Replayer
+	if *source == reindex.SourceKafka && !*rollback && !*finalize {
+		kafkaClient, err := sarama.NewClient(cfg.Kafka.Brokers, sarama.NewConfig())
+		if err != nil {
+			logger.Fatal("Failed to create Kafka client", zap.Error(err))
+		}
+		defer kafkaClient.Close()
+
+		replayer = reindex.NewKafkaReplayer(kafkaClient, cfg.Kafka.Topic,
+			func(indexer data_processing.DocumentIndexer) consumer.EventProcessor {
+				processor := consumer.NewCDCEventProcessor(
+					logger,
+					indexer,
+					data_processing.NewCDCEntityExtractor(logger),
+					cfg.OpenSearch.IndexPrefix,
+				)
+				if redactor != nil {
+					processor.SetRedactor(redactor)
+				}
+				// A replay reads every partition, so the local lookup sees every parent. Children
+				// replayed before their parent are updated in the replayed documents.
+				if cfg.Consumer.Enrichment.Enabled {
+					enricher := data_processing.NewRelationEnricher(data_processing.DefaultRelations(), cfg.OpenSearch.IndexPrefix, logger)
+					if updater, ok := indexer.(data_processing.ReferenceUpdater); ok {
+						enricher.SetReferenceUpdater(updater)
+					}
+					processor.AddEnricher(enricher)
+				}
+				// Cluster events may be replayed after the entities of their control plane,
+				// whose replayed documents are restamped then
+				if cfg.Consumer.ControlPlane.Enabled {
+					registry := data_processing.NewControlPlaneRegistry(cfg.OpenSearch.IndexPrefix, logger)
+					if updater, ok := indexer.(data_processing.ControlPlaneUpdater); ok {
+						registry.SetControlPlaneUpdater(updater)
+					}
+					processor.AddEnricher(registry)
+				}
+				if cfg.Consumer.SystemEvents.Enabled {
+					processor.SetSystemEventHandler(consumer.NewFreshnessTracker(logger))
+				}
+				if cfg.Consumer.Search.Enabled {
+					processor.SetSearchProjector(data_processing.DefaultProjections())
+				}
+				return processor
+			},
+			logger,
+		)
+	}
+
+	reindexer := reindex.NewReindexer(aliasManager, indexer, replayer, logger)
+
+	// Setup signal handling for graceful shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		logger.Info("Received shutdown signal, aborting...")
+		cancel()
+	}()
+
+	failed := false
+	for _, entityType := range types {
+		logical := cfg.OpenSearch.IndexPrefix + "-" + entityType
+
+		switch {
+		case *finalize:
+			if err := reindexer.Finalize(logical); err != nil {
+				logger.Error("Failed to finalize reindex", zap.String("index", logical), zap.Error(err))
+				failed = true
+				continue
+			}
+			fmt.Printf("%s: finalized, previous generations no longer receive writes\n", logical)
+		case *rollback:
+			result, err := reindexer.Rollback(ctx, logical, *settle)
+			if err != nil {
+				logger.Error("Failed to roll back", zap.String("index", logical), zap.Error(err))
+				failed = true
+				continue
+			}
+			fmt.Printf("%s: rolled back from %s to %s\n", logical, result.From, result.To)
+		default:
+			result, err := reindexer.Run(ctx, logical, reindex.Options{
+				Source:          *source,
+				Body:            body,
+				Tolerance:       *tolerance,
+				DualWriteSettle: *settle,
+			})
+			if err != nil {
+				logger.Error("Reindex failed", zap.String("index", logical), zap.Error(err))
+				failed = true
+				continue
+			}
+			fmt.Printf("%s: swapped from %s (%d documents) to %s (%d documents)\n",
+				logical, result.From, result.OldCount, result.To, result.NewCount)
+		}
+	}
+
+	if failed {
+		logger.Sync()
+		os.Exit(1)
+	}
+}
+
+// splitList splits a comma separated flag value, dropping empty entries
+func splitList(value string) []string {
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
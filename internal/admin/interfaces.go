@@ -0,0 +1,29 @@
+package admin
+
+import "github.com/Shopify/sarama"
+
+// ClusterAdmin is the subset of sarama.ClusterAdmin used for topic administration
+type ClusterAdmin interface {
+	ListTopics() (map[string]sarama.TopicDetail, error)
+	CreateTopic(topic string, detail *sarama.TopicDetail, validateOnly bool) error
+	CreatePartitions(topic string, count int32, assignment [][]int32, validateOnly bool) error
+	DescribeConfig(resource sarama.ConfigResource) ([]sarama.ConfigEntry, error)
+	AlterConfig(resourceType sarama.ConfigResourceType, name string, entries map[string]*string, validateOnly bool) error
+}
+
+// OffsetAdmin is the subset of sarama.ClusterAdmin used to inspect consumer group offsets
+type OffsetAdmin interface {
+	ListConsumerGroupOffsets(group string, topicPartitions map[string][]int32) (*sarama.OffsetFetchResponse, error)
+}
+
+// GroupAdmin is the subset of sarama.ClusterAdmin used to inspect and reset consumer groups
+type GroupAdmin interface {
+	OffsetAdmin
+	DescribeConsumerGroups(groups []string) ([]*sarama.GroupDescription, error)
+}
+
+// OffsetClient is the subset of sarama.Client used to look up partition offsets
+type OffsetClient interface {
+	Partitions(topic string) ([]int32, error)
+	GetOffset(topic string, partitionID int32, time int64) (int64, error)
+}
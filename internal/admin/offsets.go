@@ -0,0 +1,244 @@
+package admin
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/kong/konnect-ingest/internal/producer"
+)
+
+// CommittedOffsets returns the committed next offset per partition of a group on a topic.
+// Partitions without a committed offset are omitted.
+func CommittedOffsets(admin OffsetAdmin, group string, topic string, partitions []int32) (map[int32]int64, error) {
+	response, err := admin.ListConsumerGroupOffsets(group, map[string][]int32{topic: partitions})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch offsets of group %s: %w", group, err)
+	}
+	if response.Err != sarama.ErrNoError {
+		return nil, fmt.Errorf("failed to fetch offsets of group %s: %w", group, response.Err)
+	}
+
+	committed := make(map[int32]int64)
+	for _, partition := range partitions {
+		block := response.GetBlock(topic, partition)
+		if block == nil || block.Offset < 0 {
+			continue
+		}
+		if block.Err != sarama.ErrNoError {
+			return nil, fmt.Errorf("failed to fetch offset of %s/%d: %w", topic, partition, block.Err)
+		}
+		committed[partition] = block.Offset
+	}
+	return committed, nil
+}
+
+// CommitGroupOffsets commits the given next offsets for a group on a topic. Offsets may move
+// backwards as well as forwards, so the group must not have active members.
+func CommitGroupOffsets(client sarama.Client, group string, topic string, offsets map[int32]int64) error {
+	offsetManager, err := sarama.NewOffsetManagerFromClient(group, client)
+	if err != nil {
+		return fmt.Errorf("failed to create offset manager for group %s: %w", group, err)
+	}
+
+	var managers []sarama.PartitionOffsetManager
+	for partition, offset := range offsets {
+		manager, err := offsetManager.ManagePartition(topic, partition)
+		if err != nil {
+			closePartitionManagers(managers)
+			offsetManager.Close()
+			return fmt.Errorf("failed to manage %s/%d: %w", topic, partition, err)
+		}
+		manager.ResetOffset(offset, "")
+		managers = append(managers, manager)
+	}
+
+	offsetManager.Commit()
+	closePartitionManagers(managers)
+	return offsetManager.Close()
+}
+
+func closePartitionManagers(managers []sarama.PartitionOffsetManager) {
+	for _, manager := range managers {
+		manager.Close()
+	}
+}
+
+// Kinds of position a group can start at or be reset to
+const (
+	PositionOldest    = "oldest"
+	PositionNewest    = "newest"
+	PositionTimestamp = "timestamp"
+	PositionOffsets   = "offsets"
+)
+
+// Position is where a consumer group starts or is reset to on every partition of a topic
+type Position struct {
+	Kind string
+	// TimestampMs is the time of the first record to consume, for PositionTimestamp
+	TimestampMs int64
+	// Offsets are the next offsets per partition, for PositionOffsets
+	Offsets map[int32]int64
+}
+
+// explicitOffsets matches partition:offset pairs such as 0:120,1:98
+var explicitOffsets = regexp.MustCompile(`^\d+:\d+(,\d+:\d+)*$`)
+
+// ParsePosition parses oldest (or earliest), newest (or latest), a timestamp in epoch
+// milliseconds or RFC3339, or comma separated partition:offset pairs
+func ParsePosition(value string) (Position, error) {
+	switch value {
+	case PositionOldest, "earliest":
+		return Position{Kind: PositionOldest}, nil
+	case PositionNewest, "latest":
+		return Position{Kind: PositionNewest}, nil
+	case "":
+		return Position{}, fmt.Errorf("empty position")
+	}
+
+	if explicitOffsets.MatchString(value) {
+		offsets := make(map[int32]int64)
+		for _, pair := range strings.Split(value, ",") {
+			parts := strings.SplitN(pair, ":", 2)
+			partition, err := strconv.ParseInt(parts[0], 10, 32)
+			if err != nil {
+				return Position{}, fmt.Errorf("invalid partition in %q: %w", pair, err)
+			}
+			offset, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil {
+				return Position{}, fmt.Errorf("invalid offset in %q: %w", pair, err)
+			}
+			offsets[int32(partition)] = offset
+		}
+		return Position{Kind: PositionOffsets, Offsets: offsets}, nil
+	}
+
+	timestampMs, err := producer.ParseTimestampMs(value)
+	if err != nil {
+		return Position{}, fmt.Errorf("invalid position %q: expected oldest, newest, a timestamp or partition:offset pairs", value)
+	}
+	return Position{Kind: PositionTimestamp, TimestampMs: timestampMs}, nil
+}
+
+// ResolveOffsets returns the next offset per partition of a topic for a position. A
+// timestamp after the last record resolves to the end of the partition. Explicit offsets
+// must lie within the retained range of their partition.
+func ResolveOffsets(client OffsetClient, topic string, position Position) (map[int32]int64, error) {
+	partitions, err := client.Partitions(topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list partitions of %s: %w", topic, err)
+	}
+	known := make(map[int32]bool, len(partitions))
+	for _, partition := range partitions {
+		known[partition] = true
+	}
+
+	offsets := make(map[int32]int64)
+	switch position.Kind {
+	case PositionOldest, PositionNewest:
+		at := sarama.OffsetOldest
+		if position.Kind == PositionNewest {
+			at = sarama.OffsetNewest
+		}
+		for _, partition := range partitions {
+			if offsets[partition], err = client.GetOffset(topic, partition, at); err != nil {
+				return nil, fmt.Errorf("failed to get offset of %s/%d: %w", topic, partition, err)
+			}
+		}
+
+	case PositionTimestamp:
+		for _, partition := range partitions {
+			offset, err := client.GetOffset(topic, partition, position.TimestampMs)
+			if err == nil && offset < 0 {
+				offset, err = client.GetOffset(topic, partition, sarama.OffsetNewest)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to get offset of %s/%d: %w", topic, partition, err)
+			}
+			offsets[partition] = offset
+		}
+
+	case PositionOffsets:
+		for partition, offset := range position.Offsets {
+			if !known[partition] {
+				return nil, fmt.Errorf("topic %s has no partition %d", topic, partition)
+			}
+			oldest, err := client.GetOffset(topic, partition, sarama.OffsetOldest)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get offset of %s/%d: %w", topic, partition, err)
+			}
+			newest, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get offset of %s/%d: %w", topic, partition, err)
+			}
+			if offset < oldest || offset > newest {
+				return nil, fmt.Errorf("offset %d of %s/%d is outside the retained range [%d, %d]", offset, topic, partition, oldest, newest)
+			}
+			offsets[partition] = offset
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown position %q", position.Kind)
+	}
+	return offsets, nil
+}
+
+// PartitionLag is the committed offset of a group on a partition and how far it is behind
+type PartitionLag struct {
+	Partition int32
+	// Committed is the committed next offset, -1 when the group has none
+	Committed int64
+	Newest    int64
+	// Lag is the number of records after the committed offset, -1 when the group has none
+	Lag int64
+}
+
+// GroupLag returns the committed offset and lag of a group on every partition of a topic
+func GroupLag(admin OffsetAdmin, client OffsetClient, group string, topic string) ([]PartitionLag, error) {
+	partitions, err := client.Partitions(topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list partitions of %s: %w", topic, err)
+	}
+	committed, err := CommittedOffsets(admin, group, topic, partitions)
+	if err != nil {
+		return nil, err
+	}
+
+	lags := make([]PartitionLag, 0, len(partitions))
+	for _, partition := range partitions {
+		newest, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get offset of %s/%d: %w", topic, partition, err)
+		}
+		lag := PartitionLag{Partition: partition, Committed: -1, Newest: newest, Lag: -1}
+		if offset, ok := committed[partition]; ok {
+			lag.Committed = offset
+			lag.Lag = newest - offset
+		}
+		lags = append(lags, lag)
+	}
+	sort.Slice(lags, func(i, j int) bool { return lags[i].Partition < lags[j].Partition })
+	return lags, nil
+}
+
+// EnsureInactive returns an error when the group has active members, which would keep
+// committing their own offsets over a reset
+func EnsureInactive(admin GroupAdmin, group string) error {
+	descriptions, err := admin.DescribeConsumerGroups([]string{group})
+	if err != nil {
+		return fmt.Errorf("failed to describe group %s: %w", group, err)
+	}
+	for _, description := range descriptions {
+		if description.Err != sarama.ErrNoError {
+			return fmt.Errorf("failed to describe group %s: %w", group, description.Err)
+		}
+		if len(description.Members) > 0 {
+			return fmt.Errorf("group %s has %d active members (state %s), stop its consumers first",
+				group, len(description.Members), description.State)
+		}
+	}
+	return nil
+}
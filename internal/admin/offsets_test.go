@@ -0,0 +1,179 @@
+package admin
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+// fakeOffsetClient serves the retained offset range of each partition and the offset of the
+// first record at or after a timestamp
+type fakeOffsetClient struct {
+	oldest map[int32]int64
+	newest map[int32]int64
+	// byTime maps a timestamp to the offset per partition, missing partitions have no record after it
+	byTime map[int64]map[int32]int64
+}
+
+func (f *fakeOffsetClient) Partitions(topic string) ([]int32, error) {
+	partitions := make([]int32, 0, len(f.newest))
+	for partition := int32(0); int(partition) < len(f.newest); partition++ {
+		partitions = append(partitions, partition)
+	}
+	return partitions, nil
+}
+
+func (f *fakeOffsetClient) GetOffset(topic string, partition int32, time int64) (int64, error) {
+	switch time {
+	case sarama.OffsetOldest:
+		return f.oldest[partition], nil
+	case sarama.OffsetNewest:
+		return f.newest[partition], nil
+	}
+	if offset, ok := f.byTime[time][partition]; ok {
+		return offset, nil
+	}
+	return -1, nil
+}
+
+// fakeGroupAdmin serves the committed offsets and members of one group
+type fakeGroupAdmin struct {
+	committed map[int32]int64
+	members   int
+}
+
+func (f *fakeGroupAdmin) ListConsumerGroupOffsets(group string, topicPartitions map[string][]int32) (*sarama.OffsetFetchResponse, error) {
+	response := &sarama.OffsetFetchResponse{}
+	for topic, partitions := range topicPartitions {
+		for _, partition := range partitions {
+			offset, ok := f.committed[partition]
+			if !ok {
+				offset = -1
+			}
+			response.AddBlock(topic, partition, &sarama.OffsetFetchResponseBlock{Offset: offset})
+		}
+	}
+	return response, nil
+}
+
+func (f *fakeGroupAdmin) DescribeConsumerGroups(groups []string) ([]*sarama.GroupDescription, error) {
+	description := &sarama.GroupDescription{GroupId: groups[0], State: "Empty", Members: map[string]*sarama.GroupMemberDescription{}}
+	for i := 0; i < f.members; i++ {
+		description.State = "Stable"
+		description.Members[string(rune('a'+i))] = &sarama.GroupMemberDescription{}
+	}
+	return []*sarama.GroupDescription{description}, nil
+}
+
+func newFakeOffsetClient() *fakeOffsetClient {
+	return &fakeOffsetClient{
+		oldest: map[int32]int64{0: 10, 1: 0, 2: 5},
+		newest: map[int32]int64{0: 100, 1: 40, 2: 5},
+		byTime: map[int64]map[int32]int64{1706814000000: {0: 60, 1: 12}},
+	}
+}
+
+func TestParsePosition(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Position
+		wantErr bool
+	}{
+		{value: "oldest", want: Position{Kind: PositionOldest}},
+		{value: "earliest", want: Position{Kind: PositionOldest}},
+		{value: "latest", want: Position{Kind: PositionNewest}},
+		{value: "1706814000000", want: Position{Kind: PositionTimestamp, TimestampMs: 1706814000000}},
+		{value: "2024-02-01T19:00:00Z", want: Position{Kind: PositionTimestamp, TimestampMs: 1706814000000}},
+		{value: "0:120,2:98", want: Position{Kind: PositionOffsets, Offsets: map[int32]int64{0: 120, 2: 98}}},
+		{value: "", wantErr: true},
+		{value: "yesterday", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParsePosition(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("ParsePosition(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParsePosition(%q) = %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestResolveOffsets(t *testing.T) {
+	tests := []struct {
+		name     string
+		position Position
+		want     map[int32]int64
+		wantErr  bool
+	}{
+		{
+			name:     "oldest",
+			position: Position{Kind: PositionOldest},
+			want:     map[int32]int64{0: 10, 1: 0, 2: 5},
+		},
+		{
+			name:     "newest",
+			position: Position{Kind: PositionNewest},
+			want:     map[int32]int64{0: 100, 1: 40, 2: 5},
+		},
+		{
+			name:     "timestamp after the last record of a partition",
+			position: Position{Kind: PositionTimestamp, TimestampMs: 1706814000000},
+			want:     map[int32]int64{0: 60, 1: 12, 2: 5},
+		},
+		{
+			name:     "explicit offsets",
+			position: Position{Kind: PositionOffsets, Offsets: map[int32]int64{0: 50, 1: 40}},
+			want:     map[int32]int64{0: 50, 1: 40},
+		},
+		{
+			name:     "explicit offset no longer retained",
+			position: Position{Kind: PositionOffsets, Offsets: map[int32]int64{0: 5}},
+			wantErr:  true,
+		},
+		{
+			name:     "explicit offset of an unknown partition",
+			position: Position{Kind: PositionOffsets, Offsets: map[int32]int64{7: 0}},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveOffsets(newFakeOffsetClient(), "cdc-events", tt.position)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveOffsets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ResolveOffsets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGroupLag(t *testing.T) {
+	groupAdmin := &fakeGroupAdmin{committed: map[int32]int64{0: 90, 2: 5}}
+	got, err := GroupLag(groupAdmin, newFakeOffsetClient(), "cdc-consumer-group", "cdc-events")
+	if err != nil {
+		t.Fatalf("GroupLag() error = %v", err)
+	}
+	want := []PartitionLag{
+		{Partition: 0, Committed: 90, Newest: 100, Lag: 10},
+		{Partition: 1, Committed: -1, Newest: 40, Lag: -1},
+		{Partition: 2, Committed: 5, Newest: 5, Lag: 0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GroupLag() = %+v, want %+v", got, want)
+	}
+}
+
+func TestEnsureInactive(t *testing.T) {
+	if err := EnsureInactive(&fakeGroupAdmin{}, "cdc-consumer-group"); err != nil {
+		t.Errorf("EnsureInactive() on an empty group error = %v", err)
+	}
+	if err := EnsureInactive(&fakeGroupAdmin{members: 2}, "cdc-consumer-group"); err == nil {
+		t.Error("EnsureInactive() should refuse a group with active members")
+	}
+}
@@ -0,0 +1,278 @@
+package admin
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/Shopify/sarama"
+	"github.com/kong/konnect-ingest/internal/config"
+	"go.uber.org/zap"
+)
+
+// Topic configuration keys managed by the reconciler
+const (
+	ConfigRetentionMs   = "retention.ms"
+	ConfigCleanupPolicy = "cleanup.policy"
+)
+
+// DriftKind classifies a difference between the desired and the actual topic state
+type DriftKind string
+
+const (
+	DriftMissing             DriftKind = "missing"
+	DriftPartitionsBelow     DriftKind = "partitions_below"
+	DriftPartitionsAbove     DriftKind = "partitions_above"
+	DriftReplicationMismatch DriftKind = "replication_mismatch"
+	DriftConfigMismatch      DriftKind = "config_mismatch"
+)
+
+// TopicSpec is the desired state of a topic
+type TopicSpec struct {
+	Name              string
+	Partitions        int32
+	ReplicationFactor int16
+	Configs           map[string]string
+}
+
+// TopicDrift describes one difference found during reconciliation and whether it was fixed
+type TopicDrift struct {
+	Topic string
+	Kind  DriftKind
+	Key   string
+	Want  string
+	Have  string
+	Fixed bool
+}
+
+func (d TopicDrift) String() string {
+	status := "not fixed"
+	if d.Fixed {
+		status = "fixed"
+	}
+	if d.Key != "" {
+		return fmt.Sprintf("%s: %s %s want=%s have=%s (%s)", d.Topic, d.Kind, d.Key, d.Want, d.Have, status)
+	}
+	return fmt.Sprintf("%s: %s want=%s have=%s (%s)", d.Topic, d.Kind, d.Want, d.Have, status)
+}
+
+// ReconcileOptions controls which changes the reconciler is allowed to make
+type ReconcileOptions struct {
+	// DryRun only reports drift without changing the cluster
+	DryRun bool
+	// AllowPartitionIncrease permits adding partitions. Adding partitions remaps keys for
+	// hash based partitioners, so per-key ordering is only preserved for new events.
+	AllowPartitionIncrease bool
+}
+
+// TopicReconciler idempotently creates topics and reconciles them with their specs
+type TopicReconciler struct {
+	admin  ClusterAdmin
+	logger *zap.Logger
+}
+
+// NewTopicReconciler creates a new topic reconciler
+func NewTopicReconciler(admin ClusterAdmin, logger *zap.Logger) *TopicReconciler {
+	return &TopicReconciler{
+		admin:  admin,
+		logger: logger,
+	}
+}
+
+// Reconcile brings every topic in specs to its desired state and returns the drift found
+func (r *TopicReconciler) Reconcile(specs []TopicSpec, opts ReconcileOptions) ([]TopicDrift, error) {
+	existing, err := r.admin.ListTopics()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list topics: %w", err)
+	}
+
+	var drifts []TopicDrift
+	for _, spec := range specs {
+		detail, ok := existing[spec.Name]
+		if !ok {
+			drift, err := r.createTopic(spec, opts)
+			if err != nil {
+				return drifts, err
+			}
+			drifts = append(drifts, drift)
+			continue
+		}
+
+		topicDrifts, err := r.reconcileTopic(spec, detail, opts)
+		drifts = append(drifts, topicDrifts...)
+		if err != nil {
+			return drifts, err
+		}
+	}
+
+	return drifts, nil
+}
+
+func (r *TopicReconciler) createTopic(spec TopicSpec, opts ReconcileOptions) (TopicDrift, error) {
+	drift := TopicDrift{
+		Topic: spec.Name,
+		Kind:  DriftMissing,
+		Want:  fmt.Sprintf("partitions=%d replication=%d", spec.Partitions, spec.ReplicationFactor),
+	}
+	if opts.DryRun {
+		return drift, nil
+	}
+
+	entries := make(map[string]*string, len(spec.Configs))
+	for key, value := range spec.Configs {
+		value := value
+		entries[key] = &value
+	}
+
+	err := r.admin.CreateTopic(spec.Name, &sarama.TopicDetail{
+		NumPartitions:     spec.Partitions,
+		ReplicationFactor: spec.ReplicationFactor,
+		ConfigEntries:     entries,
+	}, false)
+	if err != nil && err != sarama.ErrTopicAlreadyExists {
+		return drift, fmt.Errorf("failed to create topic %s: %w", spec.Name, err)
+	}
+
+	r.logger.Info("Created topic",
+		zap.String("topic", spec.Name),
+		zap.Int32("partitions", spec.Partitions),
+		zap.Int16("replicationFactor", spec.ReplicationFactor),
+	)
+	drift.Fixed = true
+	return drift, nil
+}
+
+func (r *TopicReconciler) reconcileTopic(spec TopicSpec, detail sarama.TopicDetail, opts ReconcileOptions) ([]TopicDrift, error) {
+	var drifts []TopicDrift
+
+	switch {
+	case detail.NumPartitions < spec.Partitions:
+		drift := TopicDrift{
+			Topic: spec.Name,
+			Kind:  DriftPartitionsBelow,
+			Want:  strconv.Itoa(int(spec.Partitions)),
+			Have:  strconv.Itoa(int(detail.NumPartitions)),
+		}
+		if !opts.DryRun && opts.AllowPartitionIncrease {
+			if err := r.admin.CreatePartitions(spec.Name, spec.Partitions, nil, false); err != nil {
+				return append(drifts, drift), fmt.Errorf("failed to increase partitions of %s: %w", spec.Name, err)
+			}
+			r.logger.Warn("Increased topic partitions, keys now map to different partitions",
+				zap.String("topic", spec.Name),
+				zap.Int32("from", detail.NumPartitions),
+				zap.Int32("to", spec.Partitions),
+			)
+			drift.Fixed = true
+		}
+		drifts = append(drifts, drift)
+	case detail.NumPartitions > spec.Partitions:
+		// Kafka cannot remove partitions, this can only be reported
+		drifts = append(drifts, TopicDrift{
+			Topic: spec.Name,
+			Kind:  DriftPartitionsAbove,
+			Want:  strconv.Itoa(int(spec.Partitions)),
+			Have:  strconv.Itoa(int(detail.NumPartitions)),
+		})
+	}
+
+	if detail.ReplicationFactor != spec.ReplicationFactor {
+		// Changing the replication factor requires a partition reassignment plan
+		drifts = append(drifts, TopicDrift{
+			Topic: spec.Name,
+			Kind:  DriftReplicationMismatch,
+			Want:  strconv.Itoa(int(spec.ReplicationFactor)),
+			Have:  strconv.Itoa(int(detail.ReplicationFactor)),
+		})
+	}
+
+	configDrifts, err := r.reconcileConfigs(spec, detail, opts)
+	return append(drifts, configDrifts...), err
+}
+
+func (r *TopicReconciler) reconcileConfigs(spec TopicSpec, detail sarama.TopicDetail, opts ReconcileOptions) ([]TopicDrift, error) {
+	if len(spec.Configs) == 0 {
+		return nil, nil
+	}
+
+	// DescribeConfig includes defaults, unlike the entries returned by ListTopics
+	entries, err := r.admin.DescribeConfig(sarama.ConfigResource{Type: sarama.TopicResource, Name: spec.Name})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe configs of %s: %w", spec.Name, err)
+	}
+	actual := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		actual[entry.Name] = entry.Value
+	}
+
+	keys := make([]string, 0, len(spec.Configs))
+	for key := range spec.Configs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var drifts []TopicDrift
+	for _, key := range keys {
+		if actual[key] != spec.Configs[key] {
+			drifts = append(drifts, TopicDrift{
+				Topic: spec.Name,
+				Kind:  DriftConfigMismatch,
+				Key:   key,
+				Want:  spec.Configs[key],
+				Have:  actual[key],
+			})
+		}
+	}
+	if len(drifts) == 0 || opts.DryRun {
+		return drifts, nil
+	}
+
+	// AlterConfig replaces the whole set of overrides, so keep the existing ones
+	merged := make(map[string]*string, len(detail.ConfigEntries)+len(spec.Configs))
+	for key, value := range detail.ConfigEntries {
+		merged[key] = value
+	}
+	for key, value := range spec.Configs {
+		value := value
+		merged[key] = &value
+	}
+	if err := r.admin.AlterConfig(sarama.TopicResource, spec.Name, merged, false); err != nil {
+		return drifts, fmt.Errorf("failed to alter configs of %s: %w", spec.Name, err)
+	}
+
+	for i := range drifts {
+		drifts[i].Fixed = true
+	}
+	r.logger.Info("Updated topic configuration", zap.String("topic", spec.Name), zap.Int("changes", len(drifts)))
+	return drifts, nil
+}
+
+// SpecsFromConfig returns the desired state of the main, dead letter, retry and snapshot topics
+func SpecsFromConfig(cfg *config.Config) []TopicSpec {
+	specs := []TopicSpec{newTopicSpec(cfg.Kafka.Topic, cfg.Kafka.TopicConfig)}
+	if cfg.Kafka.DLQTopic != "" {
+		specs = append(specs, newTopicSpec(cfg.Kafka.DLQTopic, cfg.Kafka.DLQTopicConfig))
+	}
+	if cfg.Kafka.RetryTopic != "" {
+		specs = append(specs, newTopicSpec(cfg.Kafka.RetryTopic, cfg.Kafka.RetryTopicConfig))
+	}
+	if cfg.Kafka.SnapshotTopic != "" {
+		specs = append(specs, newTopicSpec(cfg.Kafka.SnapshotTopic, cfg.Kafka.SnapshotTopicConfig))
+	}
+	return specs
+}
+
+func newTopicSpec(name string, topicConfig config.TopicConfig) TopicSpec {
+	spec := TopicSpec{
+		Name:              name,
+		Partitions:        int32(topicConfig.Partitions),
+		ReplicationFactor: int16(topicConfig.ReplicationFactor),
+		Configs:           make(map[string]string),
+	}
+	if topicConfig.RetentionMs != 0 {
+		spec.Configs[ConfigRetentionMs] = strconv.FormatInt(topicConfig.RetentionMs, 10)
+	}
+	if topicConfig.CleanupPolicy != "" {
+		spec.Configs[ConfigCleanupPolicy] = topicConfig.CleanupPolicy
+	}
+	return spec
+}
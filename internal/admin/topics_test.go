@@ -0,0 +1,210 @@
+package admin
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"go.uber.org/zap"
+)
+
+// fakeClusterAdmin keeps topics in memory and records mutations
+type fakeClusterAdmin struct {
+	topics    map[string]sarama.TopicDetail
+	configs   map[string]map[string]string
+	created   []string
+	increased map[string]int32
+	altered   map[string]map[string]*string
+}
+
+func newFakeClusterAdmin() *fakeClusterAdmin {
+	return &fakeClusterAdmin{
+		topics:    make(map[string]sarama.TopicDetail),
+		configs:   make(map[string]map[string]string),
+		increased: make(map[string]int32),
+		altered:   make(map[string]map[string]*string),
+	}
+}
+
+func (f *fakeClusterAdmin) addTopic(name string, partitions int32, replication int16, configs map[string]string) {
+	entries := make(map[string]*string)
+	for key, value := range configs {
+		value := value
+		entries[key] = &value
+	}
+	f.topics[name] = sarama.TopicDetail{NumPartitions: partitions, ReplicationFactor: replication, ConfigEntries: entries}
+	f.configs[name] = configs
+}
+
+func (f *fakeClusterAdmin) ListTopics() (map[string]sarama.TopicDetail, error) {
+	return f.topics, nil
+}
+
+func (f *fakeClusterAdmin) CreateTopic(topic string, detail *sarama.TopicDetail, validateOnly bool) error {
+	if _, ok := f.topics[topic]; ok {
+		return sarama.ErrTopicAlreadyExists
+	}
+	configs := make(map[string]string)
+	for key, value := range detail.ConfigEntries {
+		configs[key] = *value
+	}
+	f.addTopic(topic, detail.NumPartitions, detail.ReplicationFactor, configs)
+	f.created = append(f.created, topic)
+	return nil
+}
+
+func (f *fakeClusterAdmin) CreatePartitions(topic string, count int32, assignment [][]int32, validateOnly bool) error {
+	detail := f.topics[topic]
+	detail.NumPartitions = count
+	f.topics[topic] = detail
+	f.increased[topic] = count
+	return nil
+}
+
+func (f *fakeClusterAdmin) DescribeConfig(resource sarama.ConfigResource) ([]sarama.ConfigEntry, error) {
+	// Unset keys report the broker defaults
+	entries := []sarama.ConfigEntry{
+		{Name: ConfigCleanupPolicy, Value: "delete", Default: true},
+		{Name: ConfigRetentionMs, Value: "604800000", Default: true},
+	}
+	for i, entry := range entries {
+		if value, ok := f.configs[resource.Name][entry.Name]; ok {
+			entries[i].Value = value
+			entries[i].Default = false
+		}
+	}
+	return entries, nil
+}
+
+func (f *fakeClusterAdmin) AlterConfig(resourceType sarama.ConfigResourceType, name string, entries map[string]*string, validateOnly bool) error {
+	configs := make(map[string]string)
+	for key, value := range entries {
+		configs[key] = *value
+	}
+	f.configs[name] = configs
+	f.altered[name] = entries
+	return nil
+}
+
+func TestTopicReconciler(t *testing.T) {
+	spec := TopicSpec{
+		Name:              "cdc-events",
+		Partitions:        3,
+		ReplicationFactor: 1,
+		Configs:           map[string]string{ConfigCleanupPolicy: "compact"},
+	}
+
+	tests := []struct {
+		name          string
+		setup         func(f *fakeClusterAdmin)
+		opts          ReconcileOptions
+		wantKinds     []DriftKind
+		wantFixed     bool
+		wantCreated   bool
+		wantIncreased bool
+		wantAltered   bool
+	}{
+		{
+			name:        "creates missing topic",
+			setup:       func(f *fakeClusterAdmin) {},
+			wantKinds:   []DriftKind{DriftMissing},
+			wantFixed:   true,
+			wantCreated: true,
+		},
+		{
+			name: "matching topic is left alone",
+			setup: func(f *fakeClusterAdmin) {
+				f.addTopic("cdc-events", 3, 1, map[string]string{ConfigCleanupPolicy: "compact"})
+			},
+		},
+		{
+			name:      "dry run reports missing topic only",
+			setup:     func(f *fakeClusterAdmin) {},
+			opts:      ReconcileOptions{DryRun: true},
+			wantKinds: []DriftKind{DriftMissing},
+		},
+		{
+			name: "partition increase requires opt in",
+			setup: func(f *fakeClusterAdmin) {
+				f.addTopic("cdc-events", 1, 1, map[string]string{ConfigCleanupPolicy: "compact"})
+			},
+			wantKinds: []DriftKind{DriftPartitionsBelow},
+		},
+		{
+			name: "partition increase when allowed",
+			setup: func(f *fakeClusterAdmin) {
+				f.addTopic("cdc-events", 1, 1, map[string]string{ConfigCleanupPolicy: "compact"})
+			},
+			opts:          ReconcileOptions{AllowPartitionIncrease: true},
+			wantKinds:     []DriftKind{DriftPartitionsBelow},
+			wantFixed:     true,
+			wantIncreased: true,
+		},
+		{
+			name: "extra partitions and replication are reported",
+			setup: func(f *fakeClusterAdmin) {
+				f.addTopic("cdc-events", 6, 3, map[string]string{ConfigCleanupPolicy: "compact"})
+			},
+			wantKinds: []DriftKind{DriftPartitionsAbove, DriftReplicationMismatch},
+		},
+		{
+			name: "config drift is fixed keeping other overrides",
+			setup: func(f *fakeClusterAdmin) {
+				f.addTopic("cdc-events", 3, 1, map[string]string{"max.message.bytes": "2000000"})
+			},
+			wantKinds:   []DriftKind{DriftConfigMismatch},
+			wantFixed:   true,
+			wantAltered: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeClusterAdmin()
+			tt.setup(fake)
+
+			reconciler := NewTopicReconciler(fake, zap.NewNop())
+			drifts, err := reconciler.Reconcile([]TopicSpec{spec}, tt.opts)
+			if err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+
+			if len(drifts) != len(tt.wantKinds) {
+				t.Fatalf("Reconcile() drifts = %v, want kinds %v", drifts, tt.wantKinds)
+			}
+			for i, drift := range drifts {
+				if drift.Kind != tt.wantKinds[i] {
+					t.Errorf("drift %d kind = %s, want %s", i, drift.Kind, tt.wantKinds[i])
+				}
+				if i == 0 && drift.Fixed != tt.wantFixed {
+					t.Errorf("drift %d fixed = %v, want %v", i, drift.Fixed, tt.wantFixed)
+				}
+			}
+
+			if (len(fake.created) > 0) != tt.wantCreated {
+				t.Errorf("created = %v, want created %v", fake.created, tt.wantCreated)
+			}
+			if (fake.increased["cdc-events"] == 3) != tt.wantIncreased {
+				t.Errorf("increased = %v, want increased %v", fake.increased, tt.wantIncreased)
+			}
+			if (fake.altered["cdc-events"] != nil) != tt.wantAltered {
+				t.Errorf("altered = %v, want altered %v", fake.altered, tt.wantAltered)
+			}
+			if tt.wantAltered {
+				if value := fake.altered["cdc-events"]["max.message.bytes"]; value == nil || *value != "2000000" {
+					t.Errorf("existing override was dropped: %v", fake.altered["cdc-events"])
+				}
+			}
+
+			// A second run must find nothing left to fix
+			if tt.wantFixed {
+				again, err := reconciler.Reconcile([]TopicSpec{spec}, tt.opts)
+				if err != nil {
+					t.Fatalf("second Reconcile() error = %v", err)
+				}
+				if len(again) != 0 {
+					t.Errorf("second Reconcile() drifts = %v, want none", again)
+				}
+			}
+		})
+	}
+}
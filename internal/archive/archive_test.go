@@ -0,0 +1,257 @@
+package archive
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kong/konnect-ingest/internal/models"
+	"go.uber.org/zap"
+)
+
+// day is 2024-02-01T00:00:00Z in epoch milliseconds
+const day = int64(1706745600000)
+
+func newEvent(entityType string, id string, tsMs int64) models.CDCEvent {
+	var event models.CDCEvent
+	event.Op = models.OpUpdate
+	event.TsMs = tsMs
+	event.After.Key = "c/cp-1/o/" + entityType + "/" + id
+	event.After.Value.Object = map[string]interface{}{"id": id, "name": entityType + "-" + id}
+	return event
+}
+
+func readAll(t *testing.T, dir string, eventsRange Range) []string {
+	t.Helper()
+	reader, err := NewReader(dir, eventsRange, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewReader() error = %v", err)
+	}
+	defer reader.Close()
+
+	var keys []string
+	for {
+		event, err := reader.ReadEvent()
+		if err == io.EOF {
+			return keys
+		}
+		if err != nil {
+			t.Fatalf("ReadEvent() error = %v", err)
+		}
+		keys = append(keys, event.After.Key)
+	}
+}
+
+func TestWriterReplay(t *testing.T) {
+	events := []models.CDCEvent{
+		newEvent("service", "s1", day+1000),
+		newEvent("route", "r1", day+2000),
+		newEvent("service", "s2", day+3000),
+		newEvent("service", "s3", day+4000),
+		newEvent("route", "r2", day+86400000+1000),
+	}
+
+	for _, format := range []Format{FormatJSONL, FormatParquet} {
+		t.Run(string(format), func(t *testing.T) {
+			dir := t.TempDir()
+			// Small enough to rotate after every second service event
+			writer, err := NewWriter(Config{Dir: dir, Format: format, MaxFileBytes: 200}, zap.NewNop())
+			if err != nil {
+				t.Fatalf("NewWriter() error = %v", err)
+			}
+			for _, event := range events {
+				if err := writer.RecordEvent(event); err != nil {
+					t.Fatalf("RecordEvent() error = %v", err)
+				}
+			}
+			if err := writer.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+
+			entries, err := ReadManifest(dir)
+			if err != nil {
+				t.Fatalf("ReadManifest() error = %v", err)
+			}
+			var records int64
+			partitions := map[string]int{}
+			for _, entry := range entries {
+				records += entry.Records
+				partitions[entry.Date+"/"+entry.EntityType]++
+				if !strings.HasSuffix(entry.Path, format.extension()) || entry.Bytes == 0 {
+					t.Errorf("unexpected manifest entry %+v", entry)
+				}
+				if _, err := os.Stat(filepath.Join(dir, entry.Path)); err != nil {
+					t.Errorf("archived file missing: %v", err)
+				}
+			}
+			if records != int64(len(events)) {
+				t.Errorf("manifest lists %d records, want %d", records, len(events))
+			}
+			wantPartitions := map[string]int{"2024-02-01/service": 2, "2024-02-01/route": 1, "2024-02-02/route": 1}
+			if !reflect.DeepEqual(partitions, wantPartitions) {
+				t.Errorf("files per partition = %v, want %v", partitions, wantPartitions)
+			}
+
+			// Files of all partitions are merged back into event time order
+			var want []string
+			for _, event := range events {
+				want = append(want, event.After.Key)
+			}
+			if got := readAll(t, dir, Range{}); !reflect.DeepEqual(got, want) {
+				t.Errorf("replayed %v, want %v", got, want)
+			}
+			got := readAll(t, dir, Range{FromTsMs: day + 2000, ToTsMs: day + 4000, EntityTypes: []string{"service", "route"}})
+			if want := []string{"c/cp-1/o/route/r1", "c/cp-1/o/service/s2"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("replayed range = %v, want %v", got, want)
+			}
+			got = readAll(t, dir, Range{EntityTypes: []string{"route"}})
+			if want := []string{"c/cp-1/o/route/r1", "c/cp-1/o/route/r2"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("replayed routes = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestWriterRotatesByAge(t *testing.T) {
+	for _, format := range []Format{FormatJSONL, FormatParquet} {
+		t.Run(string(format), func(t *testing.T) {
+			dir := t.TempDir()
+			writer, err := NewWriter(Config{Dir: dir, Format: format, MaxFileAge: time.Minute}, zap.NewNop())
+			if err != nil {
+				t.Fatalf("NewWriter() error = %v", err)
+			}
+			now := time.UnixMilli(day)
+			writer.now = func() time.Time { return now }
+
+			writer.RecordEvent(newEvent("service", "s1", day))
+			now = now.Add(30 * time.Second)
+			if err := writer.Flush(); err != nil {
+				t.Fatalf("Flush() error = %v", err)
+			}
+			if entries, _ := ReadManifest(dir); len(entries) != 0 {
+				t.Fatalf("young file completed by a flush: %+v", entries)
+			}
+
+			now = now.Add(time.Minute)
+			if err := writer.Flush(); err != nil {
+				t.Fatalf("Flush() error = %v", err)
+			}
+			if entries, _ := ReadManifest(dir); len(entries) != 1 || entries[0].Records != 1 {
+				t.Fatalf("expired file not completed by a flush: %+v", entries)
+			}
+		})
+	}
+}
+
+func TestWriterSkipsRedeliveredEvents(t *testing.T) {
+	dir := t.TempDir()
+	writer, err := NewWriter(Config{Dir: dir, Format: FormatJSONL}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+	first := newEvent("service", "s1", day+1000)
+	second := newEvent("service", "s1", day+2000)
+	second.After.Value.Object = map[string]interface{}{"id": "s1", "name": "renamed"}
+	// Another change within the same millisecond is not a redelivery
+	third := newEvent("service", "s1", day+2000)
+
+	for _, event := range []models.CDCEvent{first, second, first, second, third} {
+		if err := writer.RecordEvent(event); err != nil {
+			t.Fatalf("RecordEvent() error = %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if got := readAll(t, dir, Range{}); len(got) != 3 {
+		t.Errorf("archived %d events, want the 3 distinct ones", len(got))
+	}
+}
+
+func TestWriterRecovery(t *testing.T) {
+	for _, format := range []Format{FormatJSONL, FormatParquet} {
+		t.Run(string(format)+" keeps flushed events", func(t *testing.T) {
+			dir := t.TempDir()
+			writer, err := NewWriter(Config{Dir: dir, Format: format}, zap.NewNop())
+			if err != nil {
+				t.Fatalf("NewWriter() error = %v", err)
+			}
+			writer.RecordEvent(newEvent("service", "s1", day+1000))
+			writer.RecordEvent(newEvent("service", "s2", day+2000))
+			if err := writer.Flush(); err != nil {
+				t.Fatalf("Flush() error = %v", err)
+			}
+			writer.RecordEvent(newEvent("service", "s3", day+3000))
+			// Crash: the file is abandoned without completing it
+			for _, current := range writer.segments {
+				current.file.Close()
+				if current.wal != nil {
+					current.wal.Close()
+				}
+			}
+
+			if _, err := NewWriter(Config{Dir: dir, Format: format}, zap.NewNop()); err != nil {
+				t.Fatalf("NewWriter() after crash error = %v", err)
+			}
+			entries, _ := ReadManifest(dir)
+			if len(entries) != 1 || entries[0].Records != 2 || entries[0].EntityType != "service" || entries[0].Date != "2024-02-01" {
+				t.Fatalf("recovered manifest = %+v", entries)
+			}
+			if got := readAll(t, dir, Range{}); !reflect.DeepEqual(got, []string{"c/cp-1/o/service/s1", "c/cp-1/o/service/s2"}) {
+				t.Errorf("recovered events = %v", got)
+			}
+			matches, _ := filepath.Glob(filepath.Join(dir, "*", "*", "*"))
+			if len(matches) != 1 {
+				t.Errorf("files after recovery = %v, want the recovered file", matches)
+			}
+		})
+	}
+
+	t.Run("unfinished parquet is removed", func(t *testing.T) {
+		dir := t.TempDir()
+		writer, err := NewWriter(Config{Dir: dir, Format: FormatParquet}, zap.NewNop())
+		if err != nil {
+			t.Fatalf("NewWriter() error = %v", err)
+		}
+		writer.RecordEvent(newEvent("service", "s1", day))
+		for _, current := range writer.segments {
+			current.file.Close()
+		}
+
+		if _, err := NewWriter(Config{Dir: dir, Format: FormatParquet}, zap.NewNop()); err != nil {
+			t.Fatalf("NewWriter() after crash error = %v", err)
+		}
+		matches, _ := filepath.Glob(filepath.Join(dir, "*", "*", "*"))
+		if len(matches) != 0 {
+			t.Errorf("unfinished files left: %v", matches)
+		}
+	})
+}
+
+func TestRecordRoundTrip(t *testing.T) {
+	event := newEvent("service", "s1", day)
+	event.Before = map[string]interface{}{"id": "s1", "name": "old"}
+	record, err := newRecord(event)
+	if err != nil {
+		t.Fatalf("newRecord() error = %v", err)
+	}
+	got, err := record.Event()
+	if err != nil {
+		t.Fatalf("Event() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, event) {
+		t.Errorf("Event() = %+v, want %+v", got, event)
+	}
+
+	var tombstone models.CDCEvent
+	tombstone.Op = models.OpDelete
+	tombstone.After.Key = "c/cp-1/o/service/s1"
+	record, _ = newRecord(tombstone)
+	if got, _ := record.Event(); !reflect.DeepEqual(got, tombstone) {
+		t.Errorf("tombstone Event() = %+v", got)
+	}
+}
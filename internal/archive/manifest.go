@@ -0,0 +1,80 @@
+package archive
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ManifestFile is the name of the manifest in the archive directory
+const ManifestFile = "manifest.jsonl"
+
+// ManifestEntry describes a completed archive file. The manifest lists one entry per line, in
+// the order the files were completed.
+type ManifestEntry struct {
+	// Path is relative to the archive directory
+	Path       string `json:"path"`
+	Format     Format `json:"format"`
+	Date       string `json:"date"`
+	EntityType string `json:"entity_type"`
+	Records    int64  `json:"records"`
+	// FirstTsMs and LastTsMs are the smallest and largest ts_ms of the events of the file
+	FirstTsMs int64 `json:"first_ts_ms"`
+	LastTsMs  int64 `json:"last_ts_ms"`
+	Bytes     int64 `json:"bytes"`
+}
+
+// overlaps reports whether the file may hold events of the time range, to 0 being unbounded
+func (e ManifestEntry) overlaps(fromTsMs int64, toTsMs int64) bool {
+	return e.LastTsMs >= fromTsMs && (toTsMs == 0 || e.FirstTsMs < toTsMs)
+}
+
+// ReadManifest reads the manifest of an archive directory, empty when there is none
+func ReadManifest(dir string) ([]ManifestEntry, error) {
+	file, err := os.Open(filepath.Join(dir, ManifestFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []ManifestEntry
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry ManifestEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("manifest line %d: %w", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// appendManifest appends an entry to the manifest and syncs it
+func appendManifest(dir string, entry ManifestEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filepath.Join(dir, ManifestFile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
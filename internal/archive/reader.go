@@ -0,0 +1,229 @@
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/kong/konnect-ingest/internal/models"
+	"github.com/parquet-go/parquet-go"
+	"go.uber.org/zap"
+)
+
+// Range selects the archived events to replay
+type Range struct {
+	// FromTsMs and ToTsMs bound ts_ms to [FromTsMs, ToTsMs), 0 is unbounded
+	FromTsMs int64
+	ToTsMs   int64
+	// EntityTypes restricts the replay to these types, all types when empty
+	EntityTypes []string
+}
+
+// contains reports whether the event time is in the range
+func (r Range) contains(tsMs int64) bool {
+	return tsMs >= r.FromTsMs && (r.ToTsMs == 0 || tsMs < r.ToTsMs)
+}
+
+// Reader reads the archived events of a range, like producer.EventReader reads a jsonl file.
+// Files are selected from the manifest and merged on ts_ms, keeping the order of the events
+// within each file; only the files overlapping the events being read are open.
+type Reader struct {
+	dir         string
+	eventsRange Range
+	pending     []ManifestEntry
+	open        cursorHeap
+	logger      *zap.Logger
+}
+
+// cursor is an open archive file with its next event
+type cursor struct {
+	entry ManifestEntry
+	file  *os.File
+	next  func() (models.CDCEvent, error)
+	head  models.CDCEvent
+}
+
+// cursorHeap orders open files by the time of their next event
+type cursorHeap []*cursor
+
+func (h cursorHeap) Len() int            { return len(h) }
+func (h cursorHeap) Less(i, j int) bool  { return h[i].head.TsMs < h[j].head.TsMs }
+func (h cursorHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *cursorHeap) Push(x interface{}) { *h = append(*h, x.(*cursor)) }
+func (h *cursorHeap) Pop() interface{} {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+// NewReader creates a reader of the events of the range archived in dir
+func NewReader(dir string, eventsRange Range, logger *zap.Logger) (*Reader, error) {
+	entries, err := ReadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{
+		dir:         dir,
+		eventsRange: eventsRange,
+		pending:     selectEntries(entries, eventsRange),
+		logger:      logger,
+	}, nil
+}
+
+// Files returns the manifest entries of the files not opened yet
+func (r *Reader) Files() []ManifestEntry {
+	return r.pending
+}
+
+// selectEntries returns the entries possibly holding events of the range, by first event
+func selectEntries(entries []ManifestEntry, eventsRange Range) []ManifestEntry {
+	types := make(map[string]bool, len(eventsRange.EntityTypes))
+	for _, entityType := range eventsRange.EntityTypes {
+		types[entityType] = true
+	}
+
+	var selected []ManifestEntry
+	for _, entry := range entries {
+		if len(types) > 0 && !types[entry.EntityType] {
+			continue
+		}
+		if entry.Records == 0 || !entry.overlaps(eventsRange.FromTsMs, eventsRange.ToTsMs) {
+			continue
+		}
+		selected = append(selected, entry)
+	}
+	sort.SliceStable(selected, func(i, j int) bool {
+		return selected[i].FirstTsMs < selected[j].FirstTsMs
+	})
+	return selected
+}
+
+// ReadEvent returns the next event of the range, io.EOF after the last one
+func (r *Reader) ReadEvent() (*models.CDCEvent, error) {
+	for {
+		// Open the files starting before the next event, they may hold earlier events
+		for len(r.pending) > 0 && (r.open.Len() == 0 || r.pending[0].FirstTsMs <= r.open[0].head.TsMs) {
+			entry := r.pending[0]
+			r.pending = r.pending[1:]
+			if err := r.openFile(entry); err != nil {
+				return nil, fmt.Errorf("open %s: %w", entry.Path, err)
+			}
+		}
+		if r.open.Len() == 0 {
+			return nil, io.EOF
+		}
+
+		current := r.open[0]
+		event := current.head
+		r.advance(current)
+		if r.eventsRange.contains(event.TsMs) {
+			return &event, nil
+		}
+	}
+}
+
+// Close closes the open files
+func (r *Reader) Close() error {
+	var firstErr error
+	for _, current := range r.open {
+		if err := current.file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	r.open = nil
+	return firstErr
+}
+
+// openFile opens an archive file and adds it to the merge unless it is empty
+func (r *Reader) openFile(entry ManifestEntry) error {
+	file, err := os.Open(filepath.Join(r.dir, entry.Path))
+	if err != nil {
+		return err
+	}
+	current := &cursor{entry: entry, file: file}
+	if current.next, err = decoder(entry.Format, file); err != nil {
+		file.Close()
+		return err
+	}
+	current.head, err = current.next()
+	if err == io.EOF {
+		return file.Close()
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	heap.Push(&r.open, current)
+	return nil
+}
+
+// advance reads the next event of the first file, closing it after its last event. The rest
+// of a corrupt file is logged and skipped, so that reading continues with the other files.
+func (r *Reader) advance(current *cursor) {
+	event, err := current.next()
+	if err == nil {
+		current.head = event
+		heap.Fix(&r.open, 0)
+		return
+	}
+	if err != io.EOF {
+		r.logger.Error("Failed to decode archived event, skipping the rest of the file",
+			zap.String("file", current.entry.Path),
+			zap.Error(err),
+		)
+	}
+	heap.Pop(&r.open)
+	current.file.Close()
+}
+
+// decoder returns the function reading the events of an archive file one by one
+func decoder(format Format, file *os.File) (func() (models.CDCEvent, error), error) {
+	if format == FormatParquet {
+		info, err := file.Stat()
+		if err != nil {
+			return nil, err
+		}
+		// Opening the file first reports invalid files, which NewGenericReader panics on
+		parquetFile, err := parquet.OpenFile(file, info.Size())
+		if err != nil {
+			return nil, err
+		}
+		reader := parquet.NewGenericReader[Record](parquetFile)
+		rows := make([]Record, 1)
+		return func() (models.CDCEvent, error) {
+			n, err := reader.Read(rows)
+			if n == 0 {
+				if err == nil {
+					err = io.EOF
+				}
+				return models.CDCEvent{}, err
+			}
+			return rows[0].Event()
+		}, nil
+	}
+
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(gzipReader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	return func() (models.CDCEvent, error) {
+		var event models.CDCEvent
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return event, err
+			}
+			return event, io.EOF
+		}
+		err := json.Unmarshal(scanner.Bytes(), &event)
+		return event, err
+	}, nil
+}
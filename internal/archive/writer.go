@@ -0,0 +1,595 @@
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kong/konnect-ingest/internal/data_processing"
+	"github.com/kong/konnect-ingest/internal/metrics"
+	"github.com/kong/konnect-ingest/internal/models"
+	"github.com/parquet-go/parquet-go"
+	"go.uber.org/zap"
+)
+
+// Format is the file format of an archive
+type Format string
+
+const (
+	// FormatJSONL writes gzip compressed JSON lines, one CDC event per line like stream.jsonl
+	FormatJSONL Format = "jsonl"
+	// FormatParquet writes zstd compressed Parquet files of Record rows
+	FormatParquet Format = "parquet"
+)
+
+// ParseFormat parses an archive format
+func ParseFormat(value string) (Format, error) {
+	switch Format(value) {
+	case FormatJSONL, FormatParquet:
+		return Format(value), nil
+	default:
+		return "", fmt.Errorf("unknown archive format %q", value)
+	}
+}
+
+// extension returns the file name extension of the format
+func (f Format) extension() string {
+	if f == FormatParquet {
+		return ".parquet"
+	}
+	return ".jsonl.gz"
+}
+
+// tmpSuffix marks Parquet files still being written, they are only readable once closed
+const tmpSuffix = ".tmp"
+
+// walSuffix marks the gzip JSONL log of the events of a Parquet file still being written. It
+// is synced on flush, so the file can be rebuilt after a crash.
+const walSuffix = ".wal"
+
+// Record is the Parquet row of an event. Before and Object hold JSON, empty when null.
+type Record struct {
+	Key    string `parquet:"key"`
+	Op     string `parquet:"op"`
+	TsMs   int64  `parquet:"ts_ms"`
+	Before string `parquet:"before"`
+	Object string `parquet:"object"`
+}
+
+// newRecord converts an event to its Parquet row
+func newRecord(event models.CDCEvent) (Record, error) {
+	record := Record{Key: event.After.Key, Op: event.Op, TsMs: event.TsMs}
+	if event.Before != nil {
+		before, err := json.Marshal(event.Before)
+		if err != nil {
+			return Record{}, err
+		}
+		record.Before = string(before)
+	}
+	if event.After.Value.Object != nil {
+		object, err := json.Marshal(event.After.Value.Object)
+		if err != nil {
+			return Record{}, err
+		}
+		record.Object = string(object)
+	}
+	return record, nil
+}
+
+// fingerprint hashes the content of an event but its time
+func fingerprint(event models.CDCEvent) (uint64, error) {
+	record, err := newRecord(event)
+	if err != nil {
+		return 0, err
+	}
+	hash := fnv.New64a()
+	for _, field := range []string{record.Key, record.Op, record.Before, record.Object} {
+		hash.Write([]byte(field))
+		hash.Write([]byte{0})
+	}
+	return hash.Sum64(), nil
+}
+
+// Event converts the row back to the event
+func (r Record) Event() (models.CDCEvent, error) {
+	var event models.CDCEvent
+	event.After.Key = r.Key
+	event.Op = r.Op
+	event.TsMs = r.TsMs
+	if r.Before != "" {
+		if err := json.Unmarshal([]byte(r.Before), &event.Before); err != nil {
+			return event, err
+		}
+	}
+	if r.Object != "" {
+		if err := json.Unmarshal([]byte(r.Object), &event.After.Value.Object); err != nil {
+			return event, err
+		}
+	}
+	return event, nil
+}
+
+// Config configures a Writer
+type Config struct {
+	Dir    string
+	Format Format
+	// MaxFileBytes rotates a file once this many bytes of uncompressed events were written to it
+	MaxFileBytes int64
+	// MaxFileAge rotates a file once it is open for this long, 0 disables it
+	MaxFileAge time.Duration
+}
+
+// partition identifies the open file events are appended to
+type partition struct {
+	date       string
+	entityType string
+}
+
+// segment is an open archive file
+type segment struct {
+	entry   ManifestEntry
+	file    *os.File
+	gzip    *gzip.Writer
+	parquet *parquet.GenericWriter[Record]
+	// wal and walGzip log the events of a Parquet file
+	wal     *os.File
+	walGzip *gzip.Writer
+	opened  time.Time
+	size    int64
+}
+
+// archived identifies the last event archived for a key
+type archived struct {
+	tsMs        int64
+	fingerprint uint64
+}
+
+// Writer implements data_processing.Sink and data_processing.EventSink by archiving every
+// consumed event to files partitioned by event date and entity type, under
+// <dir>/date=<yyyy-mm-dd>/entity_type=<type>/. Files are rotated by size and age and listed in
+// the manifest once completed. Documents themselves are not archived.
+//
+// Events of a key consumed again, because their offsets were not committed when a claim
+// ended, are skipped: the writer remembers the last event archived per key since it started.
+type Writer struct {
+	config Config
+	logger *zap.Logger
+
+	mu       sync.Mutex
+	segments map[partition]*segment
+	last     map[string]archived
+
+	events     *expvar.Int
+	duplicates *expvar.Int
+	files      *expvar.Int
+	now        func() time.Time
+}
+
+// NewWriter creates a writer. Files left open by a crash are completed with their flushed
+// events and added to the manifest: JSONL files from their own content, Parquet files from
+// their log.
+func NewWriter(config Config, logger *zap.Logger) (*Writer, error) {
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	w := &Writer{
+		config:     config,
+		logger:     logger,
+		segments:   make(map[partition]*segment),
+		last:       make(map[string]archived),
+		events:     metrics.Counter("archive_events"),
+		duplicates: metrics.Counter("archive_duplicates"),
+		files:      metrics.Counter("archive_files"),
+		now:        time.Now,
+	}
+	if err := w.recover(); err != nil {
+		return nil, fmt.Errorf("recover archive: %w", err)
+	}
+	return w, nil
+}
+
+// Name returns the name of the sink
+func (w *Writer) Name() string {
+	return "archive"
+}
+
+// Capabilities reports a buffered event sink
+func (w *Writer) Capabilities() data_processing.SinkCapabilities {
+	return data_processing.SinkCapabilities{Buffered: true, Events: true}
+}
+
+// Upsert is a no-op, the events the documents come from are archived
+func (w *Writer) Upsert(key string, indexName string, id string, document interface{}) error {
+	return nil
+}
+
+// Delete is unsupported, deletes are archived as events
+func (w *Writer) Delete(indexName string, id string) error {
+	return data_processing.ErrUnsupported
+}
+
+// RecordEvent appends the event to the file of its date and entity type, unless it is older
+// than, or the same as, the last event archived for its key
+func (w *Writer) RecordEvent(event models.CDCEvent) error {
+	now := w.now()
+	if event.TsMs == 0 {
+		event.TsMs = now.UnixMilli()
+	}
+	sum, err := fingerprint(event)
+	if err != nil {
+		return err
+	}
+	entityType := "unknown"
+	if key, ok := models.ParseEntityKey(event.After.Key); ok {
+		entityType = key.EntityType
+	}
+	p := partition{
+		date:       time.UnixMilli(event.TsMs).UTC().Format("2006-01-02"),
+		entityType: entityType,
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	key := event.After.Key
+	if last, ok := w.last[key]; ok && key != "" && (event.TsMs < last.tsMs || event.TsMs == last.tsMs && sum == last.fingerprint) {
+		w.duplicates.Add(1)
+		return nil
+	}
+
+	current := w.segments[p]
+	if current != nil && w.expired(current, now) {
+		if err := w.closeSegment(p, current); err != nil {
+			return err
+		}
+		current = nil
+	}
+	if current == nil {
+		var err error
+		if current, err = w.openSegment(p, now); err != nil {
+			return err
+		}
+	}
+
+	if err := current.write(event); err != nil {
+		return fmt.Errorf("archive %s: %w", current.entry.Path, err)
+	}
+	if key != "" {
+		w.last[key] = archived{tsMs: event.TsMs, fingerprint: sum}
+	}
+	w.events.Add(1)
+	if w.config.MaxFileBytes > 0 && current.size >= w.config.MaxFileBytes {
+		return w.closeSegment(p, current)
+	}
+	return nil
+}
+
+// Flush makes the archived events durable. Open files are synced, or for Parquet their log,
+// and stay open; files past their age are rotated.
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := w.now()
+	var firstErr error
+	for p, current := range w.segments {
+		var err error
+		if w.expired(current, now) {
+			err = w.closeSegment(p, current)
+		} else {
+			err = current.flush()
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Close completes every open file
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var firstErr error
+	for p, current := range w.segments {
+		if err := w.closeSegment(p, current); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (w *Writer) expired(current *segment, now time.Time) bool {
+	return w.config.MaxFileAge > 0 && now.Sub(current.opened) >= w.config.MaxFileAge
+}
+
+// openSegment creates the next file of the partition
+func (w *Writer) openSegment(p partition, now time.Time) (*segment, error) {
+	dir := filepath.Join("date="+p.date, "entity_type="+p.entityType)
+	if err := os.MkdirAll(filepath.Join(w.config.Dir, dir), 0o755); err != nil {
+		return nil, err
+	}
+
+	var path string
+	var file *os.File
+	for seq := 0; file == nil; seq++ {
+		path = filepath.Join(dir, fmt.Sprintf("%d-%d%s", now.UnixMilli(), seq, w.config.Format.extension()))
+		name := filepath.Join(w.config.Dir, path)
+		if w.config.Format == FormatParquet {
+			name += tmpSuffix
+		}
+		var err error
+		file, err = os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err != nil && !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+	}
+
+	current := &segment{
+		entry: ManifestEntry{
+			Path:       path,
+			Format:     w.config.Format,
+			Date:       p.date,
+			EntityType: p.entityType,
+		},
+		file:   file,
+		opened: now,
+	}
+	if w.config.Format == FormatParquet {
+		wal, err := os.Create(filepath.Join(w.config.Dir, path+walSuffix))
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		current.parquet = parquet.NewGenericWriter[Record](file, parquet.Compression(&parquet.Zstd))
+		current.wal = wal
+		current.walGzip = gzip.NewWriter(wal)
+	} else {
+		current.gzip = gzip.NewWriter(file)
+	}
+	w.segments[p] = current
+	return current, nil
+}
+
+// closeSegment completes the file and adds it to the manifest
+func (w *Writer) closeSegment(p partition, current *segment) error {
+	delete(w.segments, p)
+	if err := current.close(w.config.Dir); err != nil {
+		return fmt.Errorf("complete %s: %w", current.entry.Path, err)
+	}
+	if err := appendManifest(w.config.Dir, current.entry); err != nil {
+		return err
+	}
+	if err := current.removeWAL(w.config.Dir); err != nil {
+		return err
+	}
+	w.files.Add(1)
+	w.logger.Info("Archived file",
+		zap.String("path", current.entry.Path),
+		zap.Int64("records", current.entry.Records),
+		zap.Int64("bytes", current.entry.Bytes),
+	)
+	return nil
+}
+
+// recover completes the JSONL files missing from the manifest, rebuilds the Parquet files
+// missing from it from their log and removes logs of listed files
+func (w *Writer) recover() error {
+	entries, err := ReadManifest(w.config.Dir)
+	if err != nil {
+		return err
+	}
+	listed := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		listed[entry.Path] = true
+	}
+
+	return filepath.WalkDir(w.config.Dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		path, err := filepath.Rel(w.config.Dir, name)
+		if err != nil {
+			return err
+		}
+		switch {
+		case strings.HasSuffix(path, FormatParquet.extension()+tmpSuffix):
+			// Rebuilt from the log, if any
+			if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		case strings.HasSuffix(path, FormatParquet.extension()+walSuffix):
+			if parquetPath := strings.TrimSuffix(path, walSuffix); !listed[parquetPath] {
+				return w.recoverSegment(parquetPath, name, FormatParquet)
+			}
+			return os.Remove(name)
+		case strings.HasSuffix(path, FormatJSONL.extension()) && !listed[path]:
+			return w.recoverSegment(path, name, FormatJSONL)
+		}
+		return nil
+	})
+}
+
+// recoverSegment writes the complete events of the gzip JSONL source, a JSONL file or the log
+// of a Parquet file left open by a crash, as the valid file at path and adds it to the
+// manifest. The source is removed when it holds no event.
+func (w *Writer) recoverSegment(path string, source string, format Format) error {
+	events, err := readTruncatedJSONL(source)
+	if err != nil {
+		return err
+	}
+	if len(events) == 0 {
+		w.logger.Warn("Removing archive file without events", zap.String("path", path))
+		return os.Remove(source)
+	}
+
+	dir := filepath.Dir(path)
+	current := &segment{entry: ManifestEntry{
+		Path:       path,
+		Format:     format,
+		Date:       strings.TrimPrefix(filepath.Base(filepath.Dir(dir)), "date="),
+		EntityType: strings.TrimPrefix(filepath.Base(dir), "entity_type="),
+	}}
+	tmpName := filepath.Join(w.config.Dir, path+tmpSuffix)
+	if current.file, err = os.Create(tmpName); err != nil {
+		return err
+	}
+	if format == FormatParquet {
+		current.parquet = parquet.NewGenericWriter[Record](current.file, parquet.Compression(&parquet.Zstd))
+	} else {
+		current.gzip = gzip.NewWriter(current.file)
+	}
+	for _, event := range events {
+		if err := current.write(event); err != nil {
+			current.file.Close()
+			return err
+		}
+	}
+	// Parquet files are renamed by close
+	if err := current.close(w.config.Dir); err != nil {
+		return err
+	}
+	if format == FormatJSONL {
+		if err := os.Rename(tmpName, filepath.Join(w.config.Dir, path)); err != nil {
+			return err
+		}
+	}
+	w.logger.Warn("Recovered archive file", zap.String("path", path), zap.Int("records", len(events)))
+	if err := appendManifest(w.config.Dir, current.entry); err != nil {
+		return err
+	}
+	if format == FormatParquet {
+		return os.Remove(source)
+	}
+	return nil
+}
+
+// readTruncatedJSONL reads the events of a gzip JSONL file up to its first truncated or
+// malformed part
+func readTruncatedJSONL(name string) ([]models.CDCEvent, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader, err := gzip.NewReader(file)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var events []models.CDCEvent
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var event models.CDCEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			break
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// write appends the event to the file
+func (s *segment) write(event models.CDCEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if s.parquet != nil {
+		record, err := newRecord(event)
+		if err != nil {
+			return err
+		}
+		if _, err := s.parquet.Write([]Record{record}); err != nil {
+			return err
+		}
+		if s.walGzip != nil {
+			if _, err := s.walGzip.Write(append(line, '\n')); err != nil {
+				return err
+			}
+		}
+	} else if _, err := s.gzip.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	s.size += int64(len(line)) + 1
+	if s.entry.Records == 0 || event.TsMs < s.entry.FirstTsMs {
+		s.entry.FirstTsMs = event.TsMs
+	}
+	if event.TsMs > s.entry.LastTsMs {
+		s.entry.LastTsMs = event.TsMs
+	}
+	s.entry.Records++
+	return nil
+}
+
+// flush writes the buffered JSONL events, or the log of a Parquet file, to disk
+func (s *segment) flush() error {
+	if s.walGzip != nil {
+		if err := s.walGzip.Flush(); err != nil {
+			return err
+		}
+		return s.wal.Sync()
+	}
+	if s.gzip == nil {
+		return nil
+	}
+	if err := s.gzip.Flush(); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// removeWAL removes the log of a completed Parquet file
+func (s *segment) removeWAL(dir string) error {
+	if s.wal == nil {
+		return nil
+	}
+	s.wal.Close()
+	return os.Remove(filepath.Join(dir, s.entry.Path+walSuffix))
+}
+
+// close completes the file, records its size and renames finished Parquet files
+func (s *segment) close(dir string) error {
+	var err error
+	if s.parquet != nil {
+		err = s.parquet.Close()
+	} else {
+		err = s.gzip.Close()
+	}
+	if err == nil {
+		err = s.file.Sync()
+	}
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(s.file.Name())
+	if err != nil {
+		return err
+	}
+	s.entry.Bytes = info.Size()
+	if s.parquet != nil {
+		return os.Rename(s.file.Name(), filepath.Join(dir, s.entry.Path))
+	}
+	return nil
+}
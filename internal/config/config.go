@@ -3,32 +3,263 @@ package config
 // Config holds all configuration for the application
 type Config struct {
 	Kafka struct {
-		Brokers  []string `mapstructure:"brokers"`
-		Topic    string   `mapstructure:"topic"`
-		GroupID  string   `mapstructure:"group_id"`
-		ClientID string   `mapstructure:"client_id"`
-		TopicConfig struct {
-			Partitions        int `mapstructure:"partitions"`
-			ReplicationFactor int `mapstructure:"replication_factor"`
-		} `mapstructure:"topic_config"`
+		Brokers []string `mapstructure:"brokers"`
+		Topic   string   `mapstructure:"topic"`
+		// Topics are consumed in addition to topic
+		Topics []string `mapstructure:"topics"`
+		// TopicPatterns are regular expressions matched against whole topic names; matching
+		// topics are subscribed to as they are created, checked every metadata_refresh_interval
+		TopicPatterns           []string    `mapstructure:"topic_patterns"`
+		MetadataRefreshInterval string      `mapstructure:"metadata_refresh_interval"`
+		GroupID                 string      `mapstructure:"group_id"`
+		ClientID                string      `mapstructure:"client_id"`
+		TopicConfig             TopicConfig `mapstructure:"topic_config"`
+		// Dead letter and retry topics are managed alongside the main topic
+		DLQTopic         string      `mapstructure:"dlq_topic"`
+		DLQTopicConfig   TopicConfig `mapstructure:"dlq_topic_config"`
+		RetryTopic       string      `mapstructure:"retry_topic"`
+		RetryTopicConfig TopicConfig `mapstructure:"retry_topic_config"`
+		// Log-compacted topic holding the latest record per key, used to bootstrap new consumers
+		SnapshotTopic       string      `mapstructure:"snapshot_topic"`
+		SnapshotTopicConfig TopicConfig `mapstructure:"snapshot_topic_config"`
+		// RebalanceStrategy assigns partitions to group members: range, roundrobin or sticky
+		RebalanceStrategy string `mapstructure:"rebalance_strategy"`
+		// Partitioner must match between producer and consumer, it defines the ordering guarantee
+		Partitioner struct {
+			Strategy     string `mapstructure:"strategy"`
+			VirtualNodes int    `mapstructure:"virtual_nodes"`
+		} `mapstructure:"partitioner"`
 	} `mapstructure:"kafka"`
 
 	OpenSearch struct {
-		Hosts      []string `mapstructure:"hosts"`
+		Hosts       []string `mapstructure:"hosts"`
 		IndexPrefix string   `mapstructure:"index_prefix"`
+		// UseAliases writes through versioned indices behind read/write aliases
+		UseAliases           bool   `mapstructure:"use_aliases"`
+		AliasRefreshInterval string `mapstructure:"alias_refresh_interval"`
 	} `mapstructure:"opensearch"`
 
 	Producer struct {
 		InputFile string `mapstructure:"input_file"`
+		// ArchiveDir replays the events of an archive written by the archive sink instead of input_file
+		ArchiveDir string `mapstructure:"archive_dir"`
+		Topic      string `mapstructure:"topic"`
+		DryRun     bool   `mapstructure:"dry_run"`
+		Filter     struct {
+			IncludeEntityTypes   []string `mapstructure:"include_entity_types"`
+			ExcludeEntityTypes   []string `mapstructure:"exclude_entity_types"`
+			IncludeControlPlanes []string `mapstructure:"include_control_planes"`
+			ExcludeControlPlanes []string `mapstructure:"exclude_control_planes"`
+			IncludeOps           []string `mapstructure:"include_ops"`
+			ExcludeOps           []string `mapstructure:"exclude_ops"`
+			FromTs               string   `mapstructure:"from_ts"`
+			ToTs                 string   `mapstructure:"to_ts"`
+			Fields               []string `mapstructure:"fields"`
+		} `mapstructure:"filter"`
 	} `mapstructure:"producer"`
 
 	Consumer struct {
 		BatchSize      int    `mapstructure:"batch_size"`
 		CommitInterval string `mapstructure:"commit_interval"`
+		// CommitMode is auto (background commits by the Kafka client) or manual (explicit commits
+		// of offsets acknowledged by OpenSearch, every batch_size offsets and commit_interval)
+		CommitMode string `mapstructure:"commit_mode"`
+		// InitialOffset is where a group without committed offsets starts: oldest, newest or a
+		// timestamp (epoch milliseconds or RFC3339)
+		InitialOffset string `mapstructure:"initial_offset"`
+		// Topics overrides the processing of the topics they match, first match wins
+		Topics []TopicProcessing `mapstructure:"topics"`
+		// Entity types acknowledged without processing, decided from record headers when present
+		SkipEntityTypes []string `mapstructure:"skip_entity_types"`
+		// Coalesce collapses updates of the same key
+		Coalesce struct {
+			// Window is how long messages of a partition are buffered, 0s disables coalescing
+			Window  string `mapstructure:"window"`
+			MaxKeys int    `mapstructure:"max_keys"`
+		} `mapstructure:"coalesce"`
+		// ChangeDetection skips writes of documents whose content hash did not change
+		ChangeDetection struct {
+			Enabled   bool `mapstructure:"enabled"`
+			CacheSize int  `mapstructure:"cache_size"`
+			// StoredHashLookup reads the hash stored in the indexed document on a cache miss
+			StoredHashLookup bool `mapstructure:"stored_hash_lookup"`
+			// VolatileFields lists per entity type the fields whose changes alone do not cause a write
+			VolatileFields map[string][]string `mapstructure:"volatile_fields"`
+			// FreshnessInterval rewrites documents with only volatile changes at most this often
+			FreshnessInterval string `mapstructure:"freshness_interval"`
+		} `mapstructure:"change_detection"`
+		// Enrichment embeds the names of referenced parents (service, upstream, certificate) into children
+		Enrichment struct {
+			Enabled bool `mapstructure:"enabled"`
+			// ParentLookup reads parents missing from the local lookup from their index
+			ParentLookup bool `mapstructure:"parent_lookup"`
+			// UpdateChildren re-enriches indexed children when a parent name changes
+			UpdateChildren bool `mapstructure:"update_children"`
+		} `mapstructure:"enrichment"`
+		// ControlPlane stamps control plane name, organization and health from cluster and
+		// composite-status events on every document
+		ControlPlane struct {
+			Enabled bool `mapstructure:"enabled"`
+			// Lookup reads control planes missing from the registry from their indices
+			Lookup bool `mapstructure:"lookup"`
+			// LookupTTL bounds how often a control plane missing from its index is looked up
+			LookupTTL string `mapstructure:"lookup_ttl"`
+			// UpdateDocuments restamps indexed documents when control plane metadata changes
+			UpdateDocuments bool `mapstructure:"update_documents"`
+		} `mapstructure:"control_plane"`
+		// Concurrency processes each partition with several workers, preserving per key order
+		Concurrency struct {
+			// Workers per partition, 1 processes synchronously
+			Workers int `mapstructure:"workers"`
+			// MaxInFlight bounds the messages per partition dispatched but not completed
+			MaxInFlight int `mapstructure:"max_in_flight"`
+		} `mapstructure:"concurrency"`
+		// Backpressure slows down and pauses consumption while OpenSearch is slow or rejects writes
+		Backpressure struct {
+			Enabled bool `mapstructure:"enabled"`
+			// DegradedLatency is the smoothed write latency above which work in flight is shrunk
+			DegradedLatency string `mapstructure:"degraded_latency"`
+			// PauseLatency is the smoothed write latency above which partitions are paused
+			PauseLatency string `mapstructure:"pause_latency"`
+			// PauseRejectionRate is the smoothed share of 429 responses above which partitions are paused
+			PauseRejectionRate float64 `mapstructure:"pause_rejection_rate"`
+			// PauseDuration is how long partitions stay paused before probing again
+			PauseDuration string `mapstructure:"pause_duration"`
+			// DegradedScale is the share of max_in_flight and coalesce.max_keys used while degraded
+			DegradedScale float64 `mapstructure:"degraded_scale"`
+			// MaxRetries bounds the retries of a rejected write
+			MaxRetries     int    `mapstructure:"max_retries"`
+			InitialBackoff string `mapstructure:"initial_backoff"`
+		} `mapstructure:"backpressure"`
+		// SystemEvents consumes store_event/last_update events as per control plane freshness
+		// watermarks instead of indexing them
+		SystemEvents struct {
+			Enabled bool `mapstructure:"enabled"`
+			// ConsistencyChecks verifies that the resource of every last_update event gets indexed
+			ConsistencyChecks bool `mapstructure:"consistency_checks"`
+			// CheckDelay is how long after the event the resource is looked up
+			CheckDelay string `mapstructure:"check_delay"`
+		} `mapstructure:"system_events"`
+		// ShutdownTimeout bounds draining in-flight messages and committing offsets on shutdown
+		ShutdownTimeout string `mapstructure:"shutdown_timeout"`
+		// StatusAddr is the listen address of the /status and /debug/vars endpoints, empty disables
+		StatusAddr string `mapstructure:"status_addr"`
+		// Search writes searchable entities to the unified <prefix>-search index as well
+		Search struct {
+			Enabled bool `mapstructure:"enabled"`
+		} `mapstructure:"search"`
+		// Diff computes the field changes of events carrying their before image and stores
+		// their summary in the last_change field of the document
+		Diff struct {
+			Enabled bool `mapstructure:"enabled"`
+			// IgnoreFields lists the fields, top-level names or dotted paths, left out of changes
+			IgnoreFields []string `mapstructure:"ignore_fields"`
+			// History writes every change to the <prefix>-history index as well
+			History bool `mapstructure:"history"`
+		} `mapstructure:"diff"`
+		Snapshot struct {
+			// Publish republishes every consumed record to the snapshot topic
+			Publish bool `mapstructure:"publish"`
+			// Bootstrap loads the snapshot topic before consuming the live topic, for new groups only
+			Bootstrap bool `mapstructure:"bootstrap"`
+		} `mapstructure:"snapshot"`
 	} `mapstructure:"consumer"`
 
+	// Sinks are the destinations documents are written to, all of them receive every write
+	Sinks struct {
+		OpenSearch SinkConfig `mapstructure:"opensearch"`
+		// SQL keeps the latest state of every entity in a table per entity type
+		SQL struct {
+			SinkConfig `mapstructure:",squash"`
+			// Dialect is sqlite or postgres
+			Dialect string `mapstructure:"dialect"`
+			// DSN is the sqlite database file or the postgres connection string
+			DSN string `mapstructure:"dsn"`
+		} `mapstructure:"sql"`
+		// Archive writes every consumed event to compressed files for replay
+		Archive struct {
+			SinkConfig `mapstructure:",squash"`
+			Dir        string `mapstructure:"dir"`
+			// Format is jsonl (gzip compressed) or parquet
+			Format string `mapstructure:"format"`
+			// MaxFileBytes and MaxFileAge rotate files by uncompressed size and age
+			MaxFileBytes int64  `mapstructure:"max_file_bytes"`
+			MaxFileAge   string `mapstructure:"max_file_age"`
+		} `mapstructure:"archive"`
+		// Webhook posts signed notifications of entity changes to HTTP endpoints
+		Webhook struct {
+			SinkConfig     `mapstructure:",squash"`
+			Timeout        string `mapstructure:"timeout"`
+			MaxRetries     int    `mapstructure:"max_retries"`
+			InitialBackoff string `mapstructure:"initial_backoff"`
+			// QueueSize bounds the notifications queued per endpoint under the best_effort
+			// policy, which are delivered in the background
+			QueueSize int               `mapstructure:"queue_size"`
+			Endpoints []WebhookEndpoint `mapstructure:"endpoints"`
+		} `mapstructure:"webhook"`
+	} `mapstructure:"sinks"`
+
+	// Redaction removes or hashes sensitive fields before documents reach any sink
+	Redaction struct {
+		Enabled bool `mapstructure:"enabled"`
+		// Action is drop or hash
+		Action      string                   `mapstructure:"action"`
+		HashSalt    string                   `mapstructure:"hash_salt"`
+		EntityTypes map[string]RedactionRule `mapstructure:"entity_types"`
+	} `mapstructure:"redaction"`
+
 	Log struct {
 		Level  string `mapstructure:"level"`
 		Format string `mapstructure:"format"`
 	} `mapstructure:"log"`
 }
+
+// TopicConfig holds the settings used when creating or reconciling a topic
+type TopicConfig struct {
+	Partitions        int    `mapstructure:"partitions"`
+	ReplicationFactor int    `mapstructure:"replication_factor"`
+	RetentionMs       int64  `mapstructure:"retention_ms"`
+	CleanupPolicy     string `mapstructure:"cleanup_policy"`
+}
+
+// RedactionRule restricts the fields indexed for one entity type
+type RedactionRule struct {
+	// Allow lists the top-level fields kept, all fields are kept when empty
+	Allow []string `mapstructure:"allow"`
+	// Deny lists dotted field paths that are always redacted
+	Deny []string `mapstructure:"deny"`
+}
+
+// TopicProcessing overrides how the messages of the matching topics are processed
+type TopicProcessing struct {
+	// Match is a topic name or a regular expression matched against whole topic names
+	Match string `mapstructure:"match"`
+	// IndexPrefix replaces opensearch.index_prefix for the documents of the topic
+	IndexPrefix string `mapstructure:"index_prefix"`
+	// Extractor is cdc (type and id from the c/<cp>/o/<type>/<id> key) or object (id read
+	// from id_field of the object, type from entity_type)
+	Extractor string `mapstructure:"extractor"`
+	// EntityType overrides the entity type, and therefore the index, of every document
+	EntityType string `mapstructure:"entity_type"`
+	IDField    string `mapstructure:"id_field"`
+}
+
+// SinkConfig enables a sink and sets how its failures affect the write
+type SinkConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Policy is required (a failure fails the write, the event is consumed again) or
+	// best_effort (a failure is logged and counted)
+	Policy string `mapstructure:"policy"`
+}
+
+// WebhookEndpoint receives the notifications matching its filters, empty filters match everything
+type WebhookEndpoint struct {
+	Name string `mapstructure:"name"`
+	URL  string `mapstructure:"url"`
+	// Secret signs every request with HMAC-SHA256, requests are not signed when empty
+	Secret      string   `mapstructure:"secret"`
+	EntityTypes []string `mapstructure:"entity_types"`
+	// Ops are create, update, delete or read
+	Ops           []string `mapstructure:"ops"`
+	ControlPlanes []string `mapstructure:"control_planes"`
+}
@@ -20,6 +20,8 @@ func LoadConfig() (*Config, error) {
 	// Allow overrides from environment variables
 	v.AutomaticEnv()
 	v.SetEnvPrefix("APP")
+	// kafka.brokers is APP_KAFKA_BROKERS
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 
 	// Handle environment variable overrides for arrays
 	handleArrayOverrides(v)
@@ -42,13 +44,98 @@ func LoadConfig() (*Config, error) {
 func setDefaults(v *viper.Viper) {
 	v.SetDefault("kafka.brokers", []string{"localhost:9092"})
 	v.SetDefault("kafka.topic", "cdc-events")
+	v.SetDefault("kafka.metadata_refresh_interval", "1m")
 	v.SetDefault("kafka.group_id", "cdc-consumer-group")
 	v.SetDefault("kafka.client_id", "cdc-client")
+	v.SetDefault("kafka.rebalance_strategy", "roundrobin")
+	v.SetDefault("kafka.topic_config.partitions", 3)
+	v.SetDefault("kafka.topic_config.replication_factor", 1)
+	v.SetDefault("kafka.topic_config.retention_ms", 7*24*60*60*1000)
+	v.SetDefault("kafka.topic_config.cleanup_policy", "delete")
+	v.SetDefault("kafka.dlq_topic", "cdc-events-dlq")
+	v.SetDefault("kafka.dlq_topic_config.partitions", 1)
+	v.SetDefault("kafka.dlq_topic_config.replication_factor", 1)
+	v.SetDefault("kafka.dlq_topic_config.retention_ms", 30*24*60*60*1000)
+	v.SetDefault("kafka.dlq_topic_config.cleanup_policy", "delete")
+	v.SetDefault("kafka.retry_topic", "cdc-events-retry")
+	v.SetDefault("kafka.retry_topic_config.partitions", 3)
+	v.SetDefault("kafka.retry_topic_config.replication_factor", 1)
+	v.SetDefault("kafka.retry_topic_config.retention_ms", 7*24*60*60*1000)
+	v.SetDefault("kafka.retry_topic_config.cleanup_policy", "delete")
+	v.SetDefault("kafka.snapshot_topic", "cdc-events-snapshot")
+	v.SetDefault("kafka.snapshot_topic_config.partitions", 3)
+	v.SetDefault("kafka.snapshot_topic_config.replication_factor", 1)
+	v.SetDefault("kafka.snapshot_topic_config.retention_ms", -1)
+	v.SetDefault("kafka.snapshot_topic_config.cleanup_policy", "compact")
+	v.SetDefault("kafka.partitioner.strategy", "key")
+	v.SetDefault("kafka.partitioner.virtual_nodes", 64)
 	v.SetDefault("opensearch.hosts", []string{"http://localhost:9200"})
 	v.SetDefault("opensearch.index_prefix", "cdc")
+	v.SetDefault("opensearch.use_aliases", false)
+	v.SetDefault("opensearch.alias_refresh_interval", "10s")
 	v.SetDefault("producer.input_file", "stream.jsonl")
+	v.SetDefault("producer.archive_dir", "")
+	v.SetDefault("producer.dry_run", false)
 	v.SetDefault("consumer.batch_size", 100)
 	v.SetDefault("consumer.commit_interval", "1s")
+	v.SetDefault("consumer.commit_mode", "auto")
+	v.SetDefault("consumer.initial_offset", "oldest")
+	v.SetDefault("consumer.coalesce.window", "0s")
+	v.SetDefault("consumer.coalesce.max_keys", 1000)
+	v.SetDefault("consumer.change_detection.enabled", false)
+	v.SetDefault("consumer.change_detection.cache_size", 100000)
+	v.SetDefault("consumer.change_detection.stored_hash_lookup", false)
+	v.SetDefault("consumer.change_detection.volatile_fields", map[string][]string{"node": {"last_ping", "updated_at"}})
+	v.SetDefault("consumer.change_detection.freshness_interval", "1m")
+	v.SetDefault("consumer.enrichment.enabled", false)
+	v.SetDefault("consumer.enrichment.parent_lookup", true)
+	v.SetDefault("consumer.enrichment.update_children", false)
+	v.SetDefault("consumer.control_plane.enabled", false)
+	v.SetDefault("consumer.control_plane.lookup", true)
+	v.SetDefault("consumer.control_plane.lookup_ttl", "1m")
+	v.SetDefault("consumer.control_plane.update_documents", false)
+	v.SetDefault("consumer.search.enabled", false)
+	v.SetDefault("consumer.diff.enabled", false)
+	v.SetDefault("consumer.diff.ignore_fields", []string{"updated_at"})
+	v.SetDefault("consumer.diff.history", false)
+	v.SetDefault("consumer.concurrency.workers", 1)
+	v.SetDefault("consumer.concurrency.max_in_flight", 256)
+	v.SetDefault("consumer.backpressure.enabled", false)
+	v.SetDefault("consumer.backpressure.degraded_latency", "500ms")
+	v.SetDefault("consumer.backpressure.pause_latency", "5s")
+	v.SetDefault("consumer.backpressure.pause_rejection_rate", 0.2)
+	v.SetDefault("consumer.backpressure.pause_duration", "10s")
+	v.SetDefault("consumer.backpressure.degraded_scale", 0.25)
+	v.SetDefault("consumer.backpressure.max_retries", 5)
+	v.SetDefault("consumer.backpressure.initial_backoff", "100ms")
+	v.SetDefault("consumer.system_events.enabled", false)
+	v.SetDefault("consumer.system_events.consistency_checks", false)
+	v.SetDefault("consumer.system_events.check_delay", "30s")
+	v.SetDefault("consumer.status_addr", "127.0.0.1:8081")
+	v.SetDefault("consumer.shutdown_timeout", "30s")
+	v.SetDefault("consumer.snapshot.publish", false)
+	v.SetDefault("consumer.snapshot.bootstrap", false)
+	v.SetDefault("sinks.opensearch.enabled", true)
+	v.SetDefault("sinks.opensearch.policy", "required")
+	v.SetDefault("sinks.sql.enabled", false)
+	v.SetDefault("sinks.sql.policy", "best_effort")
+	v.SetDefault("sinks.sql.dialect", "sqlite")
+	v.SetDefault("sinks.sql.dsn", "konnect.db")
+	v.SetDefault("sinks.archive.enabled", false)
+	v.SetDefault("sinks.archive.policy", "required")
+	v.SetDefault("sinks.archive.dir", "archive")
+	v.SetDefault("sinks.archive.format", "jsonl")
+	v.SetDefault("sinks.archive.max_file_bytes", 64*1024*1024)
+	v.SetDefault("sinks.archive.max_file_age", "1h")
+	v.SetDefault("sinks.webhook.enabled", false)
+	v.SetDefault("sinks.webhook.policy", "best_effort")
+	v.SetDefault("sinks.webhook.timeout", "5s")
+	v.SetDefault("sinks.webhook.max_retries", 3)
+	v.SetDefault("sinks.webhook.initial_backoff", "500ms")
+	v.SetDefault("sinks.webhook.queue_size", 1000)
+	v.SetDefault("redaction.enabled", false)
+	v.SetDefault("redaction.action", "drop")
+	v.SetDefault("redaction.hash_salt", "")
 	v.SetDefault("log.level", "info")
 	v.SetDefault("log.format", "json")
 }
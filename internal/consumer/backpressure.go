@@ -0,0 +1,300 @@
+package consumer
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kong/konnect-ingest/internal/config"
+	"github.com/kong/konnect-ingest/internal/data_processing"
+	"github.com/kong/konnect-ingest/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// ThrottleState is the load the sink is under, as seen by the consumer
+type ThrottleState string
+
+const (
+	// ThrottleNormal consumes at full speed
+	ThrottleNormal ThrottleState = "normal"
+	// ThrottleDegraded shrinks the amount of work in flight
+	ThrottleDegraded ThrottleState = "degraded"
+	// ThrottlePaused stops fetching from all partitions until the sink recovers
+	ThrottlePaused ThrottleState = "paused"
+)
+
+// BackpressureConfig configures when the consumer slows down and pauses
+type BackpressureConfig struct {
+	// DegradedLatency is the smoothed write latency above which work in flight is shrunk
+	DegradedLatency time.Duration
+	// PauseLatency is the smoothed write latency above which partitions are paused
+	PauseLatency time.Duration
+	// PauseRejectionRate is the smoothed share of rejected writes above which partitions are paused
+	PauseRejectionRate float64
+	// PauseDuration is how long partitions stay paused before consumption is probed again
+	PauseDuration time.Duration
+	// DegradedScale is the share of the configured batch and in-flight sizes used while degraded
+	DegradedScale float64
+	// MaxRetries bounds the retries of a rejected write, each after a doubling backoff
+	MaxRetries     int
+	InitialBackoff time.Duration
+}
+
+// DefaultBackpressureConfig returns the default backpressure configuration
+func DefaultBackpressureConfig() BackpressureConfig {
+	return BackpressureConfig{
+		DegradedLatency:    500 * time.Millisecond,
+		PauseLatency:       5 * time.Second,
+		PauseRejectionRate: 0.2,
+		PauseDuration:      10 * time.Second,
+		DegradedScale:      0.25,
+		MaxRetries:         5,
+		InitialBackoff:     100 * time.Millisecond,
+	}
+}
+
+// BackpressureConfigFromConfig builds the backpressure configuration from the application configuration
+func BackpressureConfigFromConfig(cfg *config.Config) (BackpressureConfig, error) {
+	backpressure := cfg.Consumer.Backpressure
+	result := BackpressureConfig{
+		PauseRejectionRate: backpressure.PauseRejectionRate,
+		DegradedScale:      backpressure.DegradedScale,
+		MaxRetries:         backpressure.MaxRetries,
+	}
+	for _, duration := range []struct {
+		name   string
+		value  string
+		target *time.Duration
+	}{
+		{"degraded_latency", backpressure.DegradedLatency, &result.DegradedLatency},
+		{"pause_latency", backpressure.PauseLatency, &result.PauseLatency},
+		{"pause_duration", backpressure.PauseDuration, &result.PauseDuration},
+		{"initial_backoff", backpressure.InitialBackoff, &result.InitialBackoff},
+	} {
+		parsed, err := time.ParseDuration(duration.value)
+		if err != nil {
+			return BackpressureConfig{}, fmt.Errorf("invalid backpressure %s: %w", duration.name, err)
+		}
+		*duration.target = parsed
+	}
+	if result.PauseDuration <= 0 {
+		return BackpressureConfig{}, fmt.Errorf("backpressure pause_duration must be positive")
+	}
+	return result, nil
+}
+
+// smoothing is the weight of a new observation in the moving averages
+const smoothing = 0.2
+
+// degradedRejectionRate is the smoothed share of rejected writes above which the sink is degraded
+const degradedRejectionRate = 0.01
+
+// BackpressureController tracks the latency and rejections of sink writes and throttles
+// consumption accordingly: it shrinks the work in flight when the sink slows down and pauses
+// all partitions when the sink is overloaded
+type BackpressureController struct {
+	config BackpressureConfig
+	logger *zap.Logger
+	now    func() time.Time
+
+	pauses     *expvar.Int
+	rejections *expvar.Int
+
+	mu            sync.Mutex
+	pauser        Pauser
+	latency       float64
+	rejectionRate float64
+	state         ThrottleState
+	pausedAt      time.Time
+}
+
+// NewBackpressureController creates a new backpressure controller and publishes its state as
+// the backpressure expvar
+func NewBackpressureController(config BackpressureConfig, logger *zap.Logger) *BackpressureController {
+	c := &BackpressureController{
+		config:     config,
+		logger:     logger,
+		now:        time.Now,
+		pauses:     metrics.Counter("backpressure_pauses"),
+		rejections: metrics.Counter("sink_rejections"),
+		state:      ThrottleNormal,
+	}
+	metrics.Func("backpressure", func() interface{} {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return map[string]interface{}{
+			"state":          c.state,
+			"latency_ms":     c.latency,
+			"rejection_rate": c.rejectionRate,
+		}
+	})
+	return c
+}
+
+// SetPauser sets the consumer group whose partitions are paused while the sink is overloaded
+func (c *BackpressureController) SetPauser(pauser Pauser) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pauser = pauser
+}
+
+// Detach stops the controller from pausing and resuming partitions, so that it cannot resume
+// the partitions the consumer paused itself, e.g. to drain on shutdown. Writes are still
+// observed and throttled.
+func (c *BackpressureController) Detach() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pauser = nil
+}
+
+// State returns the current throttle state
+func (c *BackpressureController) State() ThrottleState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// Scale returns the share of the configured batch and in-flight sizes to use, at least one
+func (c *BackpressureController) Scale(size int) int {
+	c.mu.Lock()
+	state := c.state
+	c.mu.Unlock()
+
+	if state == ThrottleNormal {
+		return size
+	}
+	scaled := int(float64(size) * c.config.DegradedScale)
+	if scaled < 1 {
+		return 1
+	}
+	return scaled
+}
+
+// Observe records the outcome of a sink write
+func (c *BackpressureController) Observe(latency time.Duration, rejected bool) {
+	rejection := 0.0
+	if rejected {
+		rejection = 1
+		c.rejections.Add(1)
+	}
+
+	c.mu.Lock()
+	c.latency += smoothing * (float64(latency.Milliseconds()) - c.latency)
+	c.rejectionRate += smoothing * (rejection - c.rejectionRate)
+	c.mu.Unlock()
+	c.evaluate()
+}
+
+// Run resumes paused partitions once the pause duration elapsed, until the context is done
+func (c *BackpressureController) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.config.PauseDuration / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.evaluate()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// evaluate moves to the state matching the observations. A paused consumer makes no writes,
+// so it is resumed as degraded after the pause duration to probe the sink again.
+func (c *BackpressureController) evaluate() {
+	c.mu.Lock()
+	previous := c.state
+	next := previous
+	switch {
+	case previous == ThrottlePaused:
+		if c.now().Sub(c.pausedAt) >= c.config.PauseDuration {
+			next = ThrottleDegraded
+			// Forget the rejections that caused the pause, or the first write pauses again
+			c.rejectionRate = 0
+			c.latency = float64(c.config.DegradedLatency.Milliseconds())
+		}
+	case c.rejectionRate >= c.config.PauseRejectionRate || c.latency >= float64(c.config.PauseLatency.Milliseconds()):
+		next = ThrottlePaused
+		c.pausedAt = c.now()
+	case c.rejectionRate >= degradedRejectionRate || c.latency >= float64(c.config.DegradedLatency.Milliseconds()):
+		next = ThrottleDegraded
+	default:
+		next = ThrottleNormal
+	}
+	c.state = next
+	latency, rejectionRate := c.latency, c.rejectionRate
+	pauser := c.pauser
+	c.mu.Unlock()
+
+	if next == previous {
+		return
+	}
+	fields := []zap.Field{
+		zap.String("from", string(previous)),
+		zap.String("to", string(next)),
+		zap.Float64("latencyMs", latency),
+		zap.Float64("rejectionRate", rejectionRate),
+	}
+	switch next {
+	case ThrottlePaused:
+		c.pauses.Add(1)
+		c.logger.Warn("Sink overloaded, pausing partitions", fields...)
+		if pauser != nil {
+			pauser.PauseAll()
+		}
+	default:
+		c.logger.Info("Sink throttle state changed", fields...)
+		if previous == ThrottlePaused && pauser != nil {
+			pauser.ResumeAll()
+		}
+	}
+}
+
+// ObservedIndexer implements DocumentIndexer. It reports the latency and rejections of every
+// write to a backpressure controller and retries rejected writes with a doubling backoff.
+type ObservedIndexer struct {
+	indexer    data_processing.DocumentIndexer
+	controller *BackpressureController
+	sleep      func(time.Duration)
+}
+
+// NewObservedIndexer creates a new observed indexer
+func NewObservedIndexer(indexer data_processing.DocumentIndexer, controller *BackpressureController) *ObservedIndexer {
+	return &ObservedIndexer{indexer: indexer, controller: controller, sleep: time.Sleep}
+}
+
+// IndexDocument indexes a document, retrying while the sink rejects it
+func (i *ObservedIndexer) IndexDocument(indexName string, id string, document interface{}) error {
+	return i.observe(func() error {
+		return i.indexer.IndexDocument(indexName, id, document)
+	})
+}
+
+// DeleteDocument deletes a document, retrying while the sink rejects it
+func (i *ObservedIndexer) DeleteDocument(indexName string, id string) error {
+	deleter, ok := i.indexer.(data_processing.DocumentDeleter)
+	if !ok {
+		return data_processing.ErrUnsupported
+	}
+	return i.observe(func() error {
+		return deleter.DeleteDocument(indexName, id)
+	})
+}
+
+// observe reports the latency and rejection of a write and retries it with a doubling backoff
+func (i *ObservedIndexer) observe(write func() error) error {
+	backoff := i.controller.config.InitialBackoff
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		err := write()
+		rejected := data_processing.IsRejected(err)
+		i.controller.Observe(time.Since(start), rejected)
+		if !rejected || attempt >= i.controller.config.MaxRetries {
+			return err
+		}
+		i.sleep(backoff)
+		backoff *= 2
+	}
+}
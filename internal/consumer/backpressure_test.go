@@ -0,0 +1,163 @@
+package consumer
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kong/konnect-ingest/internal/data_processing"
+	"go.uber.org/zap"
+)
+
+// recordingPauser records pause and resume calls
+type recordingPauser struct {
+	calls []string
+}
+
+func (p *recordingPauser) PauseAll()  { p.calls = append(p.calls, "pause") }
+func (p *recordingPauser) ResumeAll() { p.calls = append(p.calls, "resume") }
+
+// rejectingIndexer rejects the first writes with 429
+type rejectingIndexer struct {
+	rejections int
+	attempts   int
+}
+
+func (i *rejectingIndexer) IndexDocument(indexName string, id string, document interface{}) error {
+	i.attempts++
+	if i.attempts <= i.rejections {
+		return fmt.Errorf("index: %w", &data_processing.ResponseError{Operation: "index document " + id, StatusCode: http.StatusTooManyRequests})
+	}
+	return nil
+}
+
+func TestBackpressureController(t *testing.T) {
+	config := DefaultBackpressureConfig()
+	controller := NewBackpressureController(config, zap.NewNop())
+	pauser := &recordingPauser{}
+	controller.SetPauser(pauser)
+	now := time.Unix(0, 0)
+	controller.now = func() time.Time { return now }
+
+	steps := []struct {
+		name      string
+		latency   time.Duration
+		rejected  bool
+		count     int
+		advance   time.Duration
+		wantState ThrottleState
+		wantScale int
+	}{
+		{name: "fast writes", latency: 10 * time.Millisecond, count: 20, wantState: ThrottleNormal, wantScale: 100},
+		{name: "slow writes", latency: 2 * time.Second, count: 5, wantState: ThrottleDegraded, wantScale: 25},
+		{name: "rejected writes", latency: 10 * time.Millisecond, rejected: true, count: 2, wantState: ThrottlePaused, wantScale: 25},
+		{name: "still paused", advance: config.PauseDuration / 2, wantState: ThrottlePaused, wantScale: 25},
+		{name: "probing after the pause", advance: config.PauseDuration / 2, wantState: ThrottleDegraded, wantScale: 25},
+		{name: "recovered", latency: 10 * time.Millisecond, count: 20, wantState: ThrottleNormal, wantScale: 100},
+	}
+	for _, step := range steps {
+		now = now.Add(step.advance)
+		for i := 0; i < step.count; i++ {
+			controller.Observe(step.latency, step.rejected)
+		}
+		if step.count == 0 {
+			controller.evaluate()
+		}
+		if got := controller.State(); got != step.wantState {
+			t.Fatalf("%s: state = %s, want %s", step.name, got, step.wantState)
+		}
+		if got := controller.Scale(100); got != step.wantScale {
+			t.Errorf("%s: Scale(100) = %d, want %d", step.name, got, step.wantScale)
+		}
+	}
+
+	if want := []string{"pause", "resume"}; !reflect.DeepEqual(pauser.calls, want) {
+		t.Errorf("pauser calls = %v, want %v", pauser.calls, want)
+	}
+	if got := controller.Scale(2); got != 2 {
+		t.Errorf("Scale(2) = %d, want 2", got)
+	}
+}
+
+func TestBackpressureControllerDetach(t *testing.T) {
+	config := DefaultBackpressureConfig()
+	controller := NewBackpressureController(config, zap.NewNop())
+	pauser := &recordingPauser{}
+	controller.SetPauser(pauser)
+	now := time.Unix(0, 0)
+	controller.now = func() time.Time { return now }
+
+	controller.Observe(10*time.Millisecond, true)
+	controller.Observe(10*time.Millisecond, true)
+	if got := controller.State(); got != ThrottlePaused {
+		t.Fatalf("state = %s, want %s", got, ThrottlePaused)
+	}
+
+	// The consumer pauses the partitions itself to drain, the pause must not end early
+	controller.Detach()
+	now = now.Add(config.PauseDuration)
+	controller.evaluate()
+	if got := controller.State(); got != ThrottleDegraded {
+		t.Fatalf("state = %s, want %s", got, ThrottleDegraded)
+	}
+	if want := []string{"pause"}; !reflect.DeepEqual(pauser.calls, want) {
+		t.Errorf("pauser calls = %v, want %v", pauser.calls, want)
+	}
+}
+
+func TestObservedIndexer(t *testing.T) {
+	tests := []struct {
+		name         string
+		rejections   int
+		wantErr      bool
+		wantAttempts int
+		wantBackoffs []time.Duration
+	}{
+		{
+			name:         "accepted",
+			wantAttempts: 1,
+		},
+		{
+			name:         "retried after rejections",
+			rejections:   2,
+			wantAttempts: 3,
+			wantBackoffs: []time.Duration{100 * time.Millisecond, 200 * time.Millisecond},
+		},
+		{
+			name:         "gives up after max retries",
+			rejections:   10,
+			wantErr:      true,
+			wantAttempts: 6,
+			wantBackoffs: []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, 1600 * time.Millisecond},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &rejectingIndexer{rejections: tt.rejections}
+			controller := NewBackpressureController(DefaultBackpressureConfig(), zap.NewNop())
+			indexer := NewObservedIndexer(sink, controller)
+			var backoffs []time.Duration
+			indexer.sleep = func(backoff time.Duration) { backoffs = append(backoffs, backoff) }
+
+			err := indexer.IndexDocument("cdc-service", "svc-1", map[string]interface{}{"id": "svc-1"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("IndexDocument() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !data_processing.IsRejected(err) {
+				t.Errorf("error %v should be a rejection", err)
+			}
+			if sink.attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", sink.attempts, tt.wantAttempts)
+			}
+			if !reflect.DeepEqual(backoffs, tt.wantBackoffs) {
+				t.Errorf("backoffs = %v, want %v", backoffs, tt.wantBackoffs)
+			}
+			if tt.rejections > 0 && controller.State() == ThrottleNormal {
+				t.Error("rejections should throttle consumption")
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Shopify/sarama"
+	"go.uber.org/zap"
+)
+
+// SnapshotBootstrapper loads the compacted snapshot topic into a fresh index set and works
+// out where the live topic must be resumed from so that nothing is skipped or repeated
+type SnapshotBootstrapper struct {
+	client        sarama.Client
+	processor     MessageProcessor
+	snapshotTopic string
+	liveTopic     string
+	logger        *zap.Logger
+}
+
+// NewSnapshotBootstrapper creates a new snapshot bootstrapper
+func NewSnapshotBootstrapper(
+	client sarama.Client,
+	processor MessageProcessor,
+	snapshotTopic string,
+	liveTopic string,
+	logger *zap.Logger,
+) *SnapshotBootstrapper {
+	return &SnapshotBootstrapper{
+		client:        client,
+		processor:     processor,
+		snapshotTopic: snapshotTopic,
+		liveTopic:     liveTopic,
+		logger:        logger,
+	}
+}
+
+// Load processes every snapshot record up to the high watermarks observed when it starts,
+// flushes the sinks and returns the next live topic offset to consume per partition.
+// Partitions absent from the result have no record reflected in the snapshot and must be
+// consumed from the start. Any failed write fails the load, so no offset is committed for
+// a partially loaded snapshot.
+func (b *SnapshotBootstrapper) Load(ctx context.Context) (map[int32]int64, error) {
+	consumer, err := sarama.NewConsumerFromClient(b.client)
+	if err != nil {
+		return nil, err
+	}
+	defer consumer.Close()
+
+	partitions, err := b.client.Partitions(b.snapshotTopic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list partitions of %s: %w", b.snapshotTopic, err)
+	}
+
+	positions := newSourcePositions(b.liveTopic)
+	for _, partition := range partitions {
+		loaded, err := b.loadPartition(ctx, consumer, partition, positions)
+		if err != nil {
+			return nil, err
+		}
+		b.logger.Info("Loaded snapshot partition",
+			zap.String("topic", b.snapshotTopic),
+			zap.Int32("partition", partition),
+			zap.Int("records", loaded),
+		)
+	}
+
+	if err := b.processor.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to flush snapshot writes: %w", err)
+	}
+	return positions.nextOffsets(), nil
+}
+
+func (b *SnapshotBootstrapper) loadPartition(ctx context.Context, consumer sarama.Consumer, partition int32, positions *sourcePositions) (int, error) {
+	oldest, err := b.client.GetOffset(b.snapshotTopic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return 0, err
+	}
+	newest, err := b.client.GetOffset(b.snapshotTopic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, err
+	}
+	if newest <= oldest {
+		return 0, nil
+	}
+
+	partitionConsumer, err := consumer.ConsumePartition(b.snapshotTopic, partition, oldest)
+	if err != nil {
+		return 0, err
+	}
+	defer partitionConsumer.Close()
+
+	loaded := 0
+	for {
+		select {
+		case message := <-partitionConsumer.Messages():
+			positions.observe(message)
+			// Tombstones only matter for indices that already hold the key
+			if message.Value != nil {
+				if err := b.processor.ProcessMessage(message); err != nil {
+					return loaded, fmt.Errorf("failed to load snapshot record %s/%d at offset %d: %w",
+						b.snapshotTopic, partition, message.Offset, err)
+				}
+				loaded++
+			}
+			if message.Offset >= newest-1 {
+				return loaded, nil
+			}
+		case <-ctx.Done():
+			return loaded, ctx.Err()
+		}
+	}
+}
+
+// sourcePositions tracks the highest resume offset recorded in the snapshot per partition.
+// Records are written as they complete, which with several workers is out of offset order,
+// so the highest source offset may sit above records that never reached the snapshot. The
+// resume offset only advances over contiguous offsets, and it never decreases within a
+// partition, so the record carrying the highest one survives compaction.
+type sourcePositions struct {
+	topic  string
+	resume map[int32]int64
+}
+
+func newSourcePositions(topic string) *sourcePositions {
+	return &sourcePositions{topic: topic, resume: make(map[int32]int64)}
+}
+
+func (p *sourcePositions) observe(message *sarama.ConsumerMessage) {
+	topic, partition, resume, ok := snapshotResume(message)
+	if !ok || topic != p.topic {
+		return
+	}
+	if current, seen := p.resume[partition]; !seen || resume > current {
+		p.resume[partition] = resume
+	}
+}
+
+// nextOffsets returns the resume offset per partition. Records above it are replayed,
+// which rewrites the same state rather than skipping any.
+func (p *sourcePositions) nextOffsets() map[int32]int64 {
+	next := make(map[int32]int64, len(p.resume))
+	for partition, offset := range p.resume {
+		next[partition] = offset
+	}
+	return next
+}
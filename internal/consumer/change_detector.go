@@ -0,0 +1,216 @@
+package consumer
+
+import (
+	"container/list"
+	"expvar"
+	"sync"
+	"time"
+
+	"github.com/kong/konnect-ingest/internal/data_processing"
+	"github.com/kong/konnect-ingest/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// ContentHashField holds the content hash of a document in the document itself, so that
+// change detection survives restarts and partition reassignment
+const ContentHashField = "content_hash"
+
+// DefaultChangeCacheSize bounds the number of document hashes kept in memory
+const DefaultChangeCacheSize = 100000
+
+// ChangeDetector implements WriteFilter by skipping writes of documents whose content hash,
+// ignoring the volatile fields of their entity type, equals the last written one. Documents
+// with only volatile changes are still rewritten once the last write is older than the
+// freshness interval.
+type ChangeDetector struct {
+	volatileFields map[string][]string
+	freshness      time.Duration
+	getter         data_processing.DocumentGetter
+	logger         *zap.Logger
+	now            func() time.Time
+
+	mu      sync.Mutex
+	cache   *hashCache
+	skipped *expvar.Int
+	written *expvar.Int
+	hits    *expvar.Int
+	misses  *expvar.Int
+	lookups *expvar.Int
+}
+
+// NewChangeDetector creates a change detector remembering up to cacheSize documents. A
+// freshness interval of zero never rewrites a document for volatile changes alone.
+func NewChangeDetector(volatileFields map[string][]string, freshness time.Duration, cacheSize int, logger *zap.Logger) *ChangeDetector {
+	if cacheSize <= 0 {
+		cacheSize = DefaultChangeCacheSize
+	}
+	return &ChangeDetector{
+		volatileFields: volatileFields,
+		freshness:      freshness,
+		logger:         logger,
+		now:            time.Now,
+		cache:          newHashCache(cacheSize),
+		skipped:        metrics.Counter("change_detection_skipped_writes"),
+		written:        metrics.Counter("change_detection_writes"),
+		hits:           metrics.Counter("change_detection_cache_hits"),
+		misses:         metrics.Counter("change_detection_cache_misses"),
+		lookups:        metrics.Counter("change_detection_stored_hash_lookups"),
+	}
+}
+
+// SetDocumentGetter enables reading the stored hash of a document on a cache miss
+func (d *ChangeDetector) SetDocumentGetter(getter data_processing.DocumentGetter) {
+	d.getter = getter
+}
+
+// Filter returns the document stamped with its content hash, or false when the write can be skipped
+func (d *ChangeDetector) Filter(entityType string, indexName string, id string, document interface{}) (interface{}, bool) {
+	object, ok := document.(map[string]interface{})
+	if !ok {
+		return document, true
+	}
+	hash := d.contentHash(entityType, object)
+	key := indexName + "/" + id
+
+	d.mu.Lock()
+	last, cached := d.cache.get(key)
+	d.mu.Unlock()
+
+	if cached {
+		d.hits.Add(1)
+	} else {
+		d.misses.Add(1)
+		if stored, ok := d.storedHash(indexName, id); ok {
+			// The stored write time is unknown, so freshness counts from now
+			last = cachedHash{hash: stored, at: d.now()}
+			cached = true
+			d.mu.Lock()
+			d.cache.put(key, last)
+			d.mu.Unlock()
+		}
+	}
+
+	if cached && last.hash == hash && (d.freshness <= 0 || d.now().Sub(last.at) < d.freshness) {
+		d.skipped.Add(1)
+		return nil, false
+	}
+
+	stamped := make(map[string]interface{}, len(object)+1)
+	for field, value := range object {
+		stamped[field] = value
+	}
+	stamped[ContentHashField] = hash
+	return stamped, true
+}
+
+// Written records a successful write of a document returned by Filter
+func (d *ChangeDetector) Written(entityType string, indexName string, id string, document interface{}) {
+	object, ok := document.(map[string]interface{})
+	if !ok {
+		return
+	}
+	hash, ok := object[ContentHashField].(string)
+	if !ok {
+		return
+	}
+
+	d.written.Add(1)
+	d.mu.Lock()
+	d.cache.put(indexName+"/"+id, cachedHash{hash: hash, at: d.now()})
+	d.mu.Unlock()
+}
+
+// Deleted forgets the hash of a deleted document, so that recreating it with the same
+// content is written
+func (d *ChangeDetector) Deleted(indexName string, id string) {
+	d.mu.Lock()
+	d.cache.remove(indexName + "/" + id)
+	d.mu.Unlock()
+}
+
+// Reset forgets every cached hash. Another consumer may have written the documents of a
+// partition while it was assigned elsewhere, so the cache must not outlive a session.
+func (d *ChangeDetector) Reset() {
+	d.mu.Lock()
+	d.cache = newHashCache(d.cache.capacity)
+	d.mu.Unlock()
+}
+
+func (d *ChangeDetector) contentHash(entityType string, object map[string]interface{}) string {
+	ignored := append([]string{ContentHashField, data_processing.LastChangeField}, d.volatileFields[entityType]...)
+	return data_processing.ContentHash(object, ignored)
+}
+
+// storedHash reads the content hash of the indexed document, if any
+func (d *ChangeDetector) storedHash(indexName string, id string) (string, bool) {
+	if d.getter == nil {
+		return "", false
+	}
+	d.lookups.Add(1)
+	stored, err := d.getter.GetDocument(indexName, id)
+	if err != nil {
+		d.logger.Warn("Failed to read stored content hash", zap.String("indexName", indexName), zap.String("id", id), zap.Error(err))
+		return "", false
+	}
+	hash, ok := stored[ContentHashField].(string)
+	return hash, ok
+}
+
+type cachedHash struct {
+	hash string
+	at   time.Time
+}
+
+// hashCache is a least recently used cache of document hashes
+type hashCache struct {
+	capacity int
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+type hashCacheEntry struct {
+	key   string
+	value cachedHash
+}
+
+func newHashCache(capacity int) *hashCache {
+	return &hashCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (c *hashCache) get(key string) (cachedHash, bool) {
+	element, ok := c.entries[key]
+	if !ok {
+		return cachedHash{}, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*hashCacheEntry).value, true
+}
+
+func (c *hashCache) put(key string, value cachedHash) {
+	if element, ok := c.entries[key]; ok {
+		element.Value.(*hashCacheEntry).value = value
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&hashCacheEntry{key: key, value: value})
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*hashCacheEntry).key)
+	}
+}
+
+func (c *hashCache) remove(key string) {
+	if element, ok := c.entries[key]; ok {
+		c.order.Remove(element)
+		delete(c.entries, key)
+	}
+}
+
+func (c *hashCache) len() int {
+	return c.order.Len()
+}
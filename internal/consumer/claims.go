@@ -0,0 +1,75 @@
+package consumer
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/Shopify/sarama"
+)
+
+// Rebalance strategies accepted by kafka.rebalance_strategy
+const (
+	RebalanceRange      = "range"
+	RebalanceRoundRobin = "roundrobin"
+	RebalanceSticky     = "sticky"
+)
+
+// RebalanceCooperativeSticky is the incremental strategy of other Kafka clients, rejected
+const RebalanceCooperativeSticky = "cooperative-sticky"
+
+// RebalanceStrategy returns the balance strategy configured for the consumer group. The
+// client does not implement the cooperative protocol, so cooperative-sticky is rejected
+// rather than run as an eager strategy.
+func RebalanceStrategy(name string) (sarama.BalanceStrategy, error) {
+	switch name {
+	case "", RebalanceRoundRobin:
+		return sarama.BalanceStrategyRoundRobin, nil
+	case RebalanceRange:
+		return sarama.BalanceStrategyRange, nil
+	case RebalanceSticky:
+		return sarama.BalanceStrategySticky, nil
+	case RebalanceCooperativeSticky:
+		return nil, fmt.Errorf("rebalance strategy %q is not supported: the Kafka client only implements the eager protocol, use %q to keep partitions on their member across rebalances", name, RebalanceSticky)
+	default:
+		return nil, fmt.Errorf("unknown rebalance strategy %q", name)
+	}
+}
+
+// claimKey identifies a claimed partition
+type claimKey struct {
+	topic     string
+	partition int32
+}
+
+// claimState is the state of a claimed partition. It is allocated when the partition is
+// assigned and flushed when the session ends, so nothing buffered for a partition survives
+// its revocation.
+type claimState struct {
+	coalescer *Coalescer
+	// failed is set when the claim ended on an error: its buffered messages must not be
+	// processed past the failed one
+	failed bool
+}
+
+// assignmentChanges returns the partitions added to and revoked from an assignment
+func assignmentChanges(previous, current map[string][]int32) (added, revoked map[string][]int32) {
+	return partitionsMissing(current, previous), partitionsMissing(previous, current)
+}
+
+// partitionsMissing returns the partitions of from that are not in other
+func partitionsMissing(from, other map[string][]int32) map[string][]int32 {
+	missing := make(map[string][]int32)
+	for topic, partitions := range from {
+		present := make(map[int32]bool, len(other[topic]))
+		for _, partition := range other[topic] {
+			present[partition] = true
+		}
+		for _, partition := range partitions {
+			if !present[partition] {
+				missing[topic] = append(missing[topic], partition)
+			}
+		}
+		sort.Slice(missing[topic], func(i, j int) bool { return missing[topic][i] < missing[topic][j] })
+	}
+	return missing
+}
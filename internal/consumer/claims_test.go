@@ -0,0 +1,99 @@
+package consumer
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"go.uber.org/zap"
+)
+
+func TestRebalanceStrategy(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    sarama.BalanceStrategy
+		wantErr bool
+	}{
+		{name: "", want: sarama.BalanceStrategyRoundRobin},
+		{name: RebalanceRange, want: sarama.BalanceStrategyRange},
+		{name: RebalanceSticky, want: sarama.BalanceStrategySticky},
+		{name: RebalanceCooperativeSticky, wantErr: true},
+		{name: "cooperative", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := RebalanceStrategy(tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("RebalanceStrategy(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("RebalanceStrategy(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAssignmentChanges(t *testing.T) {
+	previous := map[string][]int32{"cdc-events": {0, 1, 2}}
+	current := map[string][]int32{"cdc-events": {2, 3}, "cdc-events-retry": {0}}
+
+	added, revoked := assignmentChanges(previous, current)
+	if want := map[string][]int32{"cdc-events": {3}, "cdc-events-retry": {0}}; !reflect.DeepEqual(added, want) {
+		t.Errorf("added = %v, want %v", added, want)
+	}
+	if want := map[string][]int32{"cdc-events": {0, 1}}; !reflect.DeepEqual(revoked, want) {
+		t.Errorf("revoked = %v, want %v", revoked, want)
+	}
+}
+
+func TestKafkaConsumerHandlerClaimLifecycle(t *testing.T) {
+	value := `{"after": {"key": "c/cp-1/o/service/svc-1", "value": {"object": {"id": "svc-1"}}}, "op": "c", "ts_ms": 1}`
+
+	tests := []struct {
+		name          string
+		failed        bool
+		wantProcessed int
+		wantCommitted int64
+	}{
+		{name: "buffered messages flushed on revocation", wantProcessed: 1, wantCommitted: 8},
+		{name: "failed claim not flushed", failed: true, wantProcessed: 0, wantCommitted: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor := &MockEventProcessor{}
+			handler := NewKafkaConsumerHandler(zap.NewNop())
+			handler.SetEventProcessor(processor)
+			handler.SetCoalescing(time.Hour, 10)
+
+			session := NewMockConsumerGroupSession(context.Background())
+			session.claims = map[string][]int32{"test-topic": {0, 1}}
+			session.generation = 3
+			if err := handler.Setup(session); err != nil {
+				t.Fatalf("Setup() error = %v", err)
+			}
+			if len(handler.claims) != 2 {
+				t.Fatalf("allocated %d claim states, want 2", len(handler.claims))
+			}
+
+			// A claim that ended with messages still buffered
+			state := handler.claimState("test-topic", 0)
+			state.coalescer.Add(newTestMessage(7, "c/cp-1/o/service/svc-1", value, nil))
+			state.failed = tt.failed
+
+			if err := handler.Cleanup(session); err != nil {
+				t.Fatalf("Cleanup() error = %v", err)
+			}
+			if len(processor.events) != tt.wantProcessed {
+				t.Errorf("processed %d events, want %d", len(processor.events), tt.wantProcessed)
+			}
+			if session.committed[0] != tt.wantCommitted {
+				t.Errorf("committed offset = %d, want %d", session.committed[0], tt.wantCommitted)
+			}
+			if len(handler.claims) != 0 {
+				t.Errorf("%d claim states outlived the session", len(handler.claims))
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package consumer
+
+import (
+	"github.com/Shopify/sarama"
+)
+
+// DefaultCoalesceMaxKeys bounds the distinct keys buffered before a coalescer is flushed
+const DefaultCoalesceMaxKeys = 1000
+
+// Coalescer buffers the messages of one claim and keeps only the latest message per record
+// key, along with the earlier messages collapsed into it. Messages without a key are never
+// collapsed.
+type Coalescer struct {
+	maxKeys    int
+	messages   []*sarama.ConsumerMessage
+	positions  map[string]int
+	earlier    map[int][]*sarama.ConsumerMessage
+	superseded int
+	oldest     int64
+}
+
+// NewCoalescer creates a coalescer flushing once maxKeys distinct keys are buffered
+func NewCoalescer(maxKeys int) *Coalescer {
+	if maxKeys <= 0 {
+		maxKeys = DefaultCoalesceMaxKeys
+	}
+	return &Coalescer{
+		maxKeys:   maxKeys,
+		positions: make(map[string]int),
+		earlier:   make(map[int][]*sarama.ConsumerMessage),
+	}
+}
+
+// Add buffers a message, replacing an earlier message with the same key, and reports
+// whether the coalescer is full
+func (c *Coalescer) Add(message *sarama.ConsumerMessage) bool {
+	if len(c.messages) == 0 {
+		c.oldest = message.Offset
+	}
+	if len(message.Key) > 0 {
+		key := string(message.Key)
+		if position, ok := c.positions[key]; ok {
+			c.earlier[len(c.messages)] = append(c.earlier[position], c.messages[position])
+			delete(c.earlier, position)
+			c.messages[position] = nil
+			c.superseded++
+		}
+		c.positions[key] = len(c.messages)
+	}
+	c.messages = append(c.messages, message)
+	return c.Len() >= c.maxKeys
+}
+
+// Len returns the number of messages a flush would process
+func (c *Coalescer) Len() int {
+	return len(c.messages) - c.superseded
+}
+
+// Oldest returns the offset of the first message buffered since the last drain, superseded
+// or not. It is only meaningful while messages are buffered.
+func (c *Coalescer) Oldest() int64 {
+	return c.oldest
+}
+
+// Drain returns the buffered messages in offset order and resets the coalescer, along with
+// the messages collapsed into each of them, in offset order. The last message returned
+// always carries the highest buffered offset.
+func (c *Coalescer) Drain() ([]*sarama.ConsumerMessage, map[*sarama.ConsumerMessage][]*sarama.ConsumerMessage) {
+	messages := make([]*sarama.ConsumerMessage, 0, c.Len())
+	superseded := make(map[*sarama.ConsumerMessage][]*sarama.ConsumerMessage, len(c.earlier))
+	for position, message := range c.messages {
+		if message != nil {
+			messages = append(messages, message)
+		}
+		if earlier, ok := c.earlier[position]; ok {
+			superseded[message] = earlier
+		}
+	}
+
+	c.messages = c.messages[:0]
+	c.positions = make(map[string]int)
+	c.earlier = make(map[int][]*sarama.ConsumerMessage)
+	c.superseded = 0
+	return messages, superseded
+}
@@ -0,0 +1,126 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/kong/konnect-ingest/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// Commit modes accepted by consumer.commit_mode
+const (
+	// CommitModeAuto lets the Kafka client commit the marked offsets in the background
+	CommitModeAuto = "auto"
+	// CommitModeManual commits the marked offsets explicitly, and only marks offsets whose
+	// documents the sink acknowledged
+	CommitModeManual = "manual"
+)
+
+// ValidateCommitMode returns an error for an unknown commit mode
+func ValidateCommitMode(mode string) error {
+	switch mode {
+	case CommitModeAuto, CommitModeManual:
+		return nil
+	default:
+		return fmt.Errorf("unknown commit mode %q", mode)
+	}
+}
+
+// SinkError is a failed write to the sink. Unlike an invalid event, it must not be
+// acknowledged: the event is consumed again once the sink is back.
+type SinkError struct {
+	Err error
+}
+
+func (e *SinkError) Error() string { return e.Err.Error() }
+
+func (e *SinkError) Unwrap() error { return e.Err }
+
+// IsSinkError reports whether err is a failed write to the sink
+func IsSinkError(err error) bool {
+	var sinkErr *SinkError
+	return errors.As(err, &sinkErr)
+}
+
+// offsetCommitter commits the offsets marked in a session on batch boundaries and on a
+// timer, and reports the commit latency
+type offsetCommitter struct {
+	session   sarama.ConsumerGroupSession
+	batchSize int
+	// flush makes the writes of the marked offsets durable before they are committed
+	flush  func() error
+	logger *zap.Logger
+
+	commits   *expvar.Int
+	latencyMs *expvar.Int
+
+	mu      sync.Mutex
+	pending int
+}
+
+func newOffsetCommitter(session sarama.ConsumerGroupSession, batchSize int, flush func() error, logger *zap.Logger) *offsetCommitter {
+	return &offsetCommitter{
+		session:   session,
+		batchSize: batchSize,
+		flush:     flush,
+		logger:    logger,
+		commits:   metrics.Counter("offset_commits"),
+		latencyMs: metrics.Counter("offset_commit_latency_ms"),
+	}
+}
+
+// marked records a marked offset and commits once batchSize offsets are pending
+func (c *offsetCommitter) marked() {
+	c.mu.Lock()
+	c.pending++
+	full := c.batchSize > 0 && c.pending >= c.batchSize
+	c.mu.Unlock()
+	if full {
+		c.commit()
+	}
+}
+
+// commit flushes the sinks and synchronously commits the marked offsets, when any are
+// pending. Offsets stay pending when the flush fails.
+func (c *offsetCommitter) commit() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pending == 0 {
+		return
+	}
+
+	start := time.Now()
+	if err := c.flush(); err != nil {
+		c.logger.Error("Failed to flush sinks, offsets not committed", zap.Error(err))
+		return
+	}
+	c.session.Commit()
+	latency := time.Since(start)
+	c.commits.Add(1)
+	c.latencyMs.Add(latency.Milliseconds())
+	c.logger.Debug("Committed offsets",
+		zap.Int("marked", c.pending),
+		zap.Duration("latency", latency),
+	)
+	c.pending = 0
+}
+
+// run commits every interval until the context is done
+func (c *offsetCommitter) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.commit()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/kong/konnect-ingest/internal/data_processing"
+	"go.uber.org/zap"
+)
+
+// crashingIndexer stores documents until the crashAt-th write, where the process crashes
+// either before or after the write reached the sink
+type crashingIndexer struct {
+	mu      sync.Mutex
+	stored  map[string]int
+	writes  int
+	crashAt int
+	durable bool
+}
+
+func (i *crashingIndexer) IndexDocument(indexName string, id string, document interface{}) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.writes++
+	if i.writes == i.crashAt {
+		if i.durable {
+			i.stored[id]++
+		}
+		return fmt.Errorf("process crashed")
+	}
+	if i.crashAt > 0 && i.writes > i.crashAt {
+		return fmt.Errorf("process crashed")
+	}
+	i.stored[id]++
+	return nil
+}
+
+func TestKafkaConsumerHandlerManualCommitAtLeastOnce(t *testing.T) {
+	const messageCount = 10
+	messages := make([]*sarama.ConsumerMessage, messageCount)
+	for offset := range messages {
+		key := fmt.Sprintf("c/cp-1/o/service/svc-%d", offset)
+		value := fmt.Sprintf(`{"after": {"key": %q, "value": {"object": {"id": "svc-%d"}}}, "op": "c", "ts_ms": %d}`, key, offset, offset)
+		messages[offset] = newTestMessage(int64(offset), key, value, nil)
+	}
+
+	modes := []struct {
+		name      string
+		configure func(*KafkaConsumerHandler)
+	}{
+		{name: "sequential", configure: func(h *KafkaConsumerHandler) {}},
+		{name: "concurrent", configure: func(h *KafkaConsumerHandler) { h.SetConcurrency(4, 4) }},
+		{name: "coalesced", configure: func(h *KafkaConsumerHandler) { h.SetCoalescing(time.Hour, 4) }},
+	}
+
+	newHandler := func(indexer data_processing.DocumentIndexer, configure func(*KafkaConsumerHandler)) *KafkaConsumerHandler {
+		processor := NewCDCEventProcessor(zap.NewNop(), indexer, data_processing.NewCDCEntityExtractor(zap.NewNop()), "cdc")
+		handler := NewKafkaConsumerHandler(zap.NewNop())
+		handler.SetEventProcessor(processor)
+		handler.SetManualCommit(0, 3)
+		configure(handler)
+		return handler
+	}
+
+	for _, mode := range modes {
+		for crashAt := 1; crashAt <= messageCount; crashAt++ {
+			for _, durable := range []bool{false, true} {
+				name := fmt.Sprintf("%s/crash at write %d/durable %v", mode.name, crashAt, durable)
+				t.Run(name, func(t *testing.T) {
+					indexer := &crashingIndexer{stored: make(map[string]int), crashAt: crashAt, durable: durable}
+
+					// First run, up to the crash. Cleanup does not run in a crashed process,
+					// so only the offsets committed while consuming survive.
+					handler := newHandler(indexer, mode.configure)
+					session := NewMockConsumerGroupSession(context.Background())
+					session.claims = map[string][]int32{"test-topic": {0}}
+					if err := handler.Setup(session); err != nil {
+						t.Fatalf("Setup() error = %v", err)
+					}
+					if err := handler.ConsumeClaim(session, NewMockConsumerGroupClaim("test-topic", 0, messages)); err == nil {
+						t.Fatal("ConsumeClaim() should end on the failed write")
+					}
+					committed := session.committed[0]
+					for offset := int64(0); offset < committed; offset++ {
+						if indexer.stored[fmt.Sprintf("svc-%d", offset)] == 0 {
+							t.Fatalf("offset %d committed but never indexed", offset)
+						}
+					}
+
+					// Restart from the committed offset with a healthy sink
+					indexer.crashAt = 0
+					handler = newHandler(indexer, mode.configure)
+					session = NewMockConsumerGroupSession(context.Background())
+					session.claims = map[string][]int32{"test-topic": {0}}
+					if err := handler.Setup(session); err != nil {
+						t.Fatalf("Setup() error = %v", err)
+					}
+					if err := handler.ConsumeClaim(session, NewMockConsumerGroupClaim("test-topic", 0, messages[committed:])); err != nil {
+						t.Fatalf("ConsumeClaim() after restart error = %v", err)
+					}
+					if err := handler.Cleanup(session); err != nil {
+						t.Fatalf("Cleanup() error = %v", err)
+					}
+
+					for offset := 0; offset < messageCount; offset++ {
+						if indexer.stored[fmt.Sprintf("svc-%d", offset)] == 0 {
+							t.Errorf("offset %d was never indexed", offset)
+						}
+					}
+					if session.committed[0] != messageCount {
+						t.Errorf("committed offset = %d, want %d", session.committed[0], messageCount)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestOffsetCommitter(t *testing.T) {
+	session := NewMockConsumerGroupSession(context.Background())
+	committer := newOffsetCommitter(session, 2, func() error { return nil }, zap.NewNop())
+
+	session.MarkOffset("test-topic", 0, 1, "")
+	committer.marked()
+	if session.committed != nil {
+		t.Fatalf("committed %v before the batch was full", session.committed)
+	}
+	session.MarkOffset("test-topic", 0, 2, "")
+	committer.marked()
+	if session.committed[0] != 2 {
+		t.Fatalf("committed offset = %d, want 2", session.committed[0])
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		committer.run(ctx, time.Millisecond)
+		close(done)
+	}()
+	committer.mu.Lock()
+	session.MarkOffset("test-topic", 0, 3, "")
+	committer.pending++
+	committer.mu.Unlock()
+	deadline := time.Now().Add(time.Second)
+	for {
+		committer.mu.Lock()
+		pending := committer.pending
+		committer.mu.Unlock()
+		if pending == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the timer did not commit the pending offset")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+	if session.committed[0] != 3 {
+		t.Errorf("committed offset = %d, want 3", session.committed[0])
+	}
+}
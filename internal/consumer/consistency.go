@@ -0,0 +1,68 @@
+package consumer
+
+import (
+	"expvar"
+	"time"
+
+	"github.com/kong/konnect-ingest/internal/data_processing"
+	"github.com/kong/konnect-ingest/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// DefaultConsistencyCheckDelay leaves the referenced resource time to be indexed, as its
+// event may be consumed from another partition
+const DefaultConsistencyCheckDelay = 30 * time.Second
+
+// IndexConsistencyChecker implements ConsistencyChecker by reading the referenced resource
+// back from its index after a delay
+type IndexConsistencyChecker struct {
+	getter      data_processing.DocumentGetter
+	indexPrefix string
+	delay       time.Duration
+	logger      *zap.Logger
+	afterFunc   func(time.Duration, func())
+
+	checked *expvar.Int
+	missing *expvar.Int
+	failed  *expvar.Int
+}
+
+// NewIndexConsistencyChecker creates a new index consistency checker
+func NewIndexConsistencyChecker(getter data_processing.DocumentGetter, indexPrefix string, delay time.Duration, logger *zap.Logger) *IndexConsistencyChecker {
+	return &IndexConsistencyChecker{
+		getter:      getter,
+		indexPrefix: indexPrefix,
+		delay:       delay,
+		logger:      logger,
+		afterFunc:   func(delay time.Duration, f func()) { time.AfterFunc(delay, f) },
+		checked:     metrics.Counter("consistency_checks"),
+		missing:     metrics.Counter("consistency_checks_missing"),
+		failed:      metrics.Counter("consistency_checks_failed"),
+	}
+}
+
+// Check schedules a check that the resource is indexed
+func (c *IndexConsistencyChecker) Check(controlPlaneID string, resourceType string, id string) {
+	c.afterFunc(c.delay, func() {
+		indexName := c.indexPrefix + "-" + resourceType
+		document, err := c.getter.GetDocument(indexName, id)
+		c.checked.Add(1)
+		if err != nil {
+			c.failed.Add(1)
+			c.logger.Warn("Consistency check failed",
+				zap.String("indexName", indexName),
+				zap.String("id", id),
+				zap.Error(err),
+			)
+			return
+		}
+		if document == nil {
+			c.missing.Add(1)
+			c.logger.Warn("Last updated resource is not indexed",
+				zap.String("controlPlaneID", controlPlaneID),
+				zap.String("indexName", indexName),
+				zap.String("id", id),
+			)
+		}
+	})
+}
@@ -0,0 +1,126 @@
+package consumer
+
+import (
+	"expvar"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kong/konnect-ingest/internal/metrics"
+	"github.com/kong/konnect-ingest/internal/models"
+	"go.uber.org/zap"
+)
+
+// StoreEventType is the entity type of the per control plane last_update system events
+const StoreEventType = "store_event"
+
+// Watermark is the freshness of a control plane: the last resource updated in its store,
+// as reported by its store_event/last_update events
+type Watermark struct {
+	ControlPlaneID string `json:"control_plane_id"`
+	ResourceType   string `json:"resource_type"`
+	ResourceID     string `json:"resource_id"`
+	// UpdatedAt is the event time of the last update in epoch milliseconds
+	UpdatedAt int64 `json:"updated_at"`
+	// Events is the number of last_update events seen for the control plane
+	Events int64 `json:"events"`
+}
+
+// FreshnessTracker implements SystemEventHandler for store_event/last_update events. It keeps
+// a freshness watermark per control plane instead of indexing the events.
+type FreshnessTracker struct {
+	checker ConsistencyChecker
+	logger  *zap.Logger
+	events  *expvar.Int
+
+	mu         sync.Mutex
+	watermarks map[string]*Watermark
+}
+
+// NewFreshnessTracker creates a new freshness tracker and publishes its watermarks as the
+// control_plane_freshness expvar
+func NewFreshnessTracker(logger *zap.Logger) *FreshnessTracker {
+	t := &FreshnessTracker{
+		logger:     logger,
+		events:     metrics.Counter("system_events_store_event"),
+		watermarks: make(map[string]*Watermark),
+	}
+	metrics.Func("control_plane_freshness", func() interface{} { return t.Watermarks() })
+	return t
+}
+
+// SetConsistencyChecker enables checking that the resource referenced by every last_update
+// event gets indexed
+func (t *FreshnessTracker) SetConsistencyChecker(checker ConsistencyChecker) {
+	t.checker = checker
+}
+
+// HandleSystemEvent updates the watermark of the control plane of a last_update event. Events
+// of other types are not handled.
+func (t *FreshnessTracker) HandleSystemEvent(key models.EntityKey, event models.CDCEvent) bool {
+	if key.EntityType != StoreEventType {
+		return false
+	}
+	t.events.Add(1)
+
+	object, ok := event.After.Value.Object.(map[string]interface{})
+	if !ok {
+		t.logger.Warn("Ignoring store event without object", zap.String("key", event.After.Key))
+		return true
+	}
+	resourceType, _ := object["resource_type"].(string)
+	resourceID, _ := object["value"].(string)
+	updatedAt := event.TsMs
+	if updatedAt == 0 {
+		updatedAt = time.Now().UnixMilli()
+	}
+
+	t.mu.Lock()
+	watermark, ok := t.watermarks[key.ControlPlaneID]
+	if !ok {
+		watermark = &Watermark{ControlPlaneID: key.ControlPlaneID}
+		t.watermarks[key.ControlPlaneID] = watermark
+	}
+	watermark.Events++
+	// Events of a control plane share a key and arrive in order, but replays may not
+	if updatedAt >= watermark.UpdatedAt {
+		watermark.ResourceType = resourceType
+		watermark.ResourceID = resourceID
+		watermark.UpdatedAt = updatedAt
+	}
+	t.mu.Unlock()
+
+	if t.checker != nil && resourceType != "" && resourceID != "" {
+		t.checker.Check(key.ControlPlaneID, resourceType, resourceID)
+	}
+	return true
+}
+
+// Watermark returns the freshness watermark of a control plane
+func (t *FreshnessTracker) Watermark(controlPlaneID string) (Watermark, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	watermark, ok := t.watermarks[controlPlaneID]
+	if !ok {
+		return Watermark{}, false
+	}
+	return *watermark, true
+}
+
+// Watermarks returns the freshness watermarks of all control planes, most recent first
+func (t *FreshnessTracker) Watermarks() []Watermark {
+	t.mu.Lock()
+	watermarks := make([]Watermark, 0, len(t.watermarks))
+	for _, watermark := range t.watermarks {
+		watermarks = append(watermarks, *watermark)
+	}
+	t.mu.Unlock()
+
+	sort.Slice(watermarks, func(i, j int) bool {
+		if watermarks[i].UpdatedAt != watermarks[j].UpdatedAt {
+			return watermarks[i].UpdatedAt > watermarks[j].UpdatedAt
+		}
+		return watermarks[i].ControlPlaneID < watermarks[j].ControlPlaneID
+	})
+	return watermarks
+}
@@ -0,0 +1,120 @@
+package consumer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kong/konnect-ingest/internal/data_processing"
+	"go.uber.org/zap"
+)
+
+// recordingChecker records the resources it is asked to check
+type recordingChecker struct {
+	checks []string
+}
+
+func (c *recordingChecker) Check(controlPlaneID string, resourceType string, id string) {
+	c.checks = append(c.checks, controlPlaneID+" "+resourceType+"/"+id)
+}
+
+func TestFreshnessTrackerSampleStream(t *testing.T) {
+	indexer := NewMockDocumentIndexer(false)
+	processor := NewCDCEventProcessor(zap.NewNop(), indexer, data_processing.NewCDCEntityExtractor(zap.NewNop()), "cdc")
+	tracker := NewFreshnessTracker(zap.NewNop())
+	checker := &recordingChecker{}
+	tracker.SetConsistencyChecker(checker)
+	processor.SetSystemEventHandler(tracker)
+
+	for _, event := range loadStreamEvents(t) {
+		processor.ProcessEvent(event)
+	}
+
+	for key := range indexer.indexedDocs {
+		if strings.HasPrefix(key, "cdc-store_event/") {
+			t.Errorf("store event %s was indexed", key)
+		}
+	}
+	if len(checker.checks) != 86 {
+		t.Errorf("checked %d resources, want one per store event (86)", len(checker.checks))
+	}
+
+	watermarks := tracker.Watermarks()
+	if len(watermarks) != 11 {
+		t.Fatalf("got %d watermarks, want 11", len(watermarks))
+	}
+	if watermarks[0].ControlPlaneID != "6bfe3033-b0c1-415d-9437-e315ecdc818e" {
+		t.Errorf("freshest control plane = %s", watermarks[0].ControlPlaneID)
+	}
+
+	want := Watermark{
+		ControlPlaneID: "04397908-e846-4019-aeaa-2422a1cb7b6c",
+		ResourceType:   "service",
+		ResourceID:     "e4b059ca-2ab3-4385-bc8e-e43f14dccdd0",
+		UpdatedAt:      1706812679971,
+		Events:         4,
+	}
+	if got, ok := tracker.Watermark(want.ControlPlaneID); !ok || got != want {
+		t.Errorf("Watermark() = %+v, want %+v", got, want)
+	}
+
+	server := httptest.NewServer(NewStatusHandler(tracker))
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/status")
+	if err != nil {
+		t.Fatalf("GET /status error = %v", err)
+	}
+	defer res.Body.Close()
+	var status struct {
+		ControlPlanes []struct {
+			ControlPlaneID string `json:"control_plane_id"`
+			AgeMs          int64  `json:"age_ms"`
+		} `json:"control_planes"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&status); err != nil {
+		t.Fatalf("failed to decode status: %v", err)
+	}
+	if len(status.ControlPlanes) != 11 || status.ControlPlanes[0].AgeMs <= 0 {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	vars, err := http.Get(server.URL + "/debug/vars")
+	if err != nil {
+		t.Fatalf("GET /debug/vars error = %v", err)
+	}
+	defer vars.Body.Close()
+	var published map[string]json.RawMessage
+	if err := json.NewDecoder(vars.Body).Decode(&published); err != nil {
+		t.Fatalf("failed to decode vars: %v", err)
+	}
+	if _, ok := published["control_plane_freshness"]; !ok {
+		t.Error("control_plane_freshness is not published")
+	}
+}
+
+func TestIndexConsistencyChecker(t *testing.T) {
+	getter := &MockDocumentGetter{documents: map[string]map[string]interface{}{
+		"cdc-service/svc-1": {"id": "svc-1"},
+	}}
+	checker := NewIndexConsistencyChecker(getter, "cdc", time.Minute, zap.NewNop())
+	var delays []time.Duration
+	checker.afterFunc = func(delay time.Duration, f func()) {
+		delays = append(delays, delay)
+		f()
+	}
+
+	missing := checker.missing.Value()
+	checker.Check("cp-1", "service", "svc-1")
+	checker.Check("cp-1", "service", "svc-2")
+
+	if got := checker.missing.Value() - missing; got != 1 {
+		t.Errorf("missing resources = %d, want 1", got)
+	}
+	if getter.reads != 2 || len(delays) != 2 || delays[0] != time.Minute {
+		t.Errorf("reads = %d, delays = %v", getter.reads, delays)
+	}
+}
@@ -1,6 +1,10 @@
 package consumer
 
 import (
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/Shopify/sarama"
 	"github.com/kong/konnect-ingest/internal/models"
 	"go.uber.org/zap"
@@ -8,56 +12,654 @@ import (
 
 // KafkaConsumerHandler implements MessageHandler for Kafka consumer group
 type KafkaConsumerHandler struct {
-	logger    *zap.Logger
-	processor EventProcessor
+	logger          *zap.Logger
+	processor       EventProcessor
+	snapshotWriter  SnapshotWriter
+	typeProcessors  map[string]EventProcessor
+	topicRoutes     []topicRoute
+	topicProcessors sync.Map
+	topicStats      *topicStats
+	skipEntityTypes map[string]bool
+	strategy        string
+	ordering        models.OrderingScope
+	mismatchOnce    sync.Once
+	coalesceWindow  time.Duration
+	coalesceMaxKeys int
+	workers         int
+	maxInFlight     int
+	backpressure    *BackpressureController
+	sessionStates   []SessionState
+
+	drainMu  sync.Mutex
+	drainErr error
+
+	commitMode     string
+	commitInterval time.Duration
+	commitBatch    int
+	committer      *offsetCommitter
+	flushers       []Flusher
+
+	claimsMu   sync.Mutex
+	claims     map[claimKey]*claimState
+	assignment map[string][]int32
 }
 
 // NewKafkaConsumerHandler creates a new Kafka consumer handler
 func NewKafkaConsumerHandler(logger *zap.Logger) *KafkaConsumerHandler {
 	return &KafkaConsumerHandler{
-		logger: logger,
+		logger:          logger,
+		typeProcessors:  make(map[string]EventProcessor),
+		topicStats:      newTopicStats(),
+		skipEntityTypes: make(map[string]bool),
+		strategy:        models.PartitionByKey,
+		ordering:        models.OrderingPerEntity,
+		claims:          make(map[claimKey]*claimState),
+		commitMode:      CommitModeAuto,
 	}
 }
 
+// SetPartitionStrategy declares the partitioning strategy the producers are configured with,
+// which determines the ordering guarantee the handler can rely on
+func (h *KafkaConsumerHandler) SetPartitionStrategy(strategy string) {
+	h.strategy = strategy
+	h.ordering = models.OrderingScopeFor(strategy)
+}
+
+// OrderingScope returns the ordering guarantee in effect for consumed events
+func (h *KafkaConsumerHandler) OrderingScope() models.OrderingScope {
+	return h.ordering
+}
+
 // SetEventProcessor sets the event processor for the handler
 func (h *KafkaConsumerHandler) SetEventProcessor(processor EventProcessor) {
 	h.processor = processor
 }
 
-// Setup is run at the beginning of a new session, before ConsumeClaim
-func (h *KafkaConsumerHandler) Setup(_ sarama.ConsumerGroupSession) error {
+// SetSnapshotWriter enables publishing every consumed record to the compacted snapshot topic
+func (h *KafkaConsumerHandler) SetSnapshotWriter(writer SnapshotWriter) {
+	h.snapshotWriter = writer
+}
+
+// SetEntityTypeProcessor routes events of the given entity type to a dedicated processor
+func (h *KafkaConsumerHandler) SetEntityTypeProcessor(entityType string, processor EventProcessor) {
+	h.typeProcessors[entityType] = processor
+}
+
+// SetTopicProcessor routes the messages of the topics matching a pattern to a dedicated
+// processor. Patterns match whole topic names and are tried in the order they were set.
+func (h *KafkaConsumerHandler) SetTopicProcessor(pattern string, processor EventProcessor) error {
+	compiled, err := compileTopicPattern(pattern)
+	if err != nil {
+		return err
+	}
+	h.topicRoutes = append(h.topicRoutes, topicRoute{pattern: compiled, processor: processor})
+	h.topicProcessors = sync.Map{}
 	return nil
 }
 
-// Cleanup is run at the end of a session, once all ConsumeClaim goroutines have exited
-func (h *KafkaConsumerHandler) Cleanup(_ sarama.ConsumerGroupSession) error {
+// SetSkipEntityTypes configures entity types that are acknowledged without being processed
+func (h *KafkaConsumerHandler) SetSkipEntityTypes(entityTypes []string) {
+	h.skipEntityTypes = make(map[string]bool, len(entityTypes))
+	for _, entityType := range entityTypes {
+		h.skipEntityTypes[entityType] = true
+	}
+}
+
+// SetCoalescing buffers each claim for up to window, or until maxKeys distinct keys are
+// buffered, and only processes the latest message per key. A zero window disables it.
+func (h *KafkaConsumerHandler) SetCoalescing(window time.Duration, maxKeys int) {
+	h.coalesceWindow = window
+	h.coalesceMaxKeys = maxKeys
+}
+
+// SetConcurrency processes the messages of each claim with the given number of workers.
+// Messages sharing an ordering key are still processed in order, and at most maxInFlight
+// messages per claim are dispatched but not completed. One worker processes synchronously.
+func (h *KafkaConsumerHandler) SetConcurrency(workers int, maxInFlight int) {
+	h.workers = workers
+	h.maxInFlight = maxInFlight
+}
+
+// SetBackpressure shrinks the in-flight window and the coalesced flush size while the
+// controller reports a degraded sink
+func (h *KafkaConsumerHandler) SetBackpressure(controller *BackpressureController) {
+	h.backpressure = controller
+}
+
+// SetManualCommit marks offsets only once the sink acknowledged their documents and commits
+// them explicitly: after every batchSize marked offsets, after every coalesced flush and
+// every interval. The Kafka client's auto-commit must be disabled.
+func (h *KafkaConsumerHandler) SetManualCommit(interval time.Duration, batchSize int) {
+	h.commitMode = CommitModeManual
+	h.commitInterval = interval
+	h.commitBatch = batchSize
+}
+
+// AddFlusher registers a sink whose buffered writes are flushed before offsets are committed
+// explicitly and at the end of every session
+func (h *KafkaConsumerHandler) AddFlusher(flusher Flusher) {
+	h.flushers = append(h.flushers, flusher)
+}
+
+// Flush flushes the buffered writes of every registered sink
+func (h *KafkaConsumerHandler) Flush() error {
+	for _, flusher := range h.flushers {
+		if err := flusher.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddSessionState registers state that is reset at the start of every session, when the
+// partitions it was built from may have been consumed elsewhere
+func (h *KafkaConsumerHandler) AddSessionState(state SessionState) {
+	h.sessionStates = append(h.sessionStates, state)
+}
+
+// DrainError returns the first error that ended a claim of the last session before its
+// messages were drained, nil when every claim drained
+func (h *KafkaConsumerHandler) DrainError() error {
+	h.drainMu.Lock()
+	defer h.drainMu.Unlock()
+	return h.drainErr
+}
+
+// Setup is run at the beginning of a new session, before ConsumeClaim. It allocates the
+// state of every claimed partition and logs the partitions gained and lost in the rebalance.
+func (h *KafkaConsumerHandler) Setup(session sarama.ConsumerGroupSession) error {
+	h.drainMu.Lock()
+	h.drainErr = nil
+	h.drainMu.Unlock()
+	for _, state := range h.sessionStates {
+		state.Reset()
+	}
+
+	assignment := session.Claims()
+	h.claimsMu.Lock()
+	added, revoked := assignmentChanges(h.assignment, assignment)
+	h.assignment = assignment
+	h.claims = make(map[claimKey]*claimState)
+	for topic, partitions := range assignment {
+		for _, partition := range partitions {
+			h.claims[claimKey{topic: topic, partition: partition}] = h.newClaimState()
+		}
+	}
+	h.claimsMu.Unlock()
+
+	h.committer = nil
+	if h.commitMode == CommitModeManual {
+		h.committer = newOffsetCommitter(session, h.commitBatch, h.Flush, h.logger)
+		if h.commitInterval > 0 {
+			go h.committer.run(session.Context(), h.commitInterval)
+		}
+	}
+
+	h.logger.Info("Consumer session starting",
+		zap.Int32("generationId", session.GenerationID()),
+		zap.String("memberId", session.MemberID()),
+		zap.Any("assigned", assignment),
+		zap.Any("added", added),
+		zap.Any("revoked", revoked),
+		zap.String("partitionStrategy", h.strategy),
+		zap.String("orderingScope", string(h.ordering)),
+	)
 	return nil
 }
 
+// Cleanup is run at the end of a session, once all ConsumeClaim goroutines have exited. The
+// partitions may be assigned elsewhere next, so whatever is still buffered for a claim is
+// flushed and the offsets are committed synchronously rather than left to the auto-commit
+// interval.
+func (h *KafkaConsumerHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	h.claimsMu.Lock()
+	claims := h.claims
+	h.claims = make(map[claimKey]*claimState)
+	h.claimsMu.Unlock()
+
+	var flushErr error
+	for key, state := range claims {
+		if state.coalescer == nil || state.failed || state.coalescer.Len() == 0 {
+			continue
+		}
+		if err := h.flushCoalesced(session, state.coalescer, nil, nil, nil); err != nil {
+			h.logger.Error("Failed to flush claim before revocation",
+				zap.String("topic", key.topic),
+				zap.Int32("partition", key.partition),
+				zap.Error(err),
+			)
+			h.recordDrainError(err)
+			if flushErr == nil {
+				flushErr = err
+			}
+		}
+	}
+
+	if h.committer != nil {
+		// Flushes the sinks first and keeps the offsets uncommitted when that fails
+		h.committer.commit()
+	} else {
+		// Auto-committed offsets are not bound to the flush, commit them regardless
+		if err := h.Flush(); err != nil {
+			h.logger.Error("Failed to flush sinks at the end of the session", zap.Error(err))
+			h.recordDrainError(err)
+			if flushErr == nil {
+				flushErr = err
+			}
+		}
+		session.Commit()
+	}
+	h.logger.Info("Consumer session ended, offsets committed",
+		zap.Int32("generationId", session.GenerationID()),
+		zap.String("memberId", session.MemberID()),
+		zap.Any("released", session.Claims()),
+	)
+	return flushErr
+}
+
 // ConsumeClaim must start a consumer loop of ConsumerGroupClaim's Messages()
 func (h *KafkaConsumerHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	state := h.claimState(claim.Topic(), claim.Partition())
+	h.topicStats.claimed(claim)
+	defer h.topicStats.released(claim)
+	err := h.consumeClaim(session, claim, state)
+	if err != nil {
+		state.failed = true
+		h.recordDrainError(err)
+	}
+	return err
+}
+
+// claimState returns the state allocated for a claimed partition in Setup
+func (h *KafkaConsumerHandler) claimState(topic string, partition int32) *claimState {
+	h.claimsMu.Lock()
+	defer h.claimsMu.Unlock()
+	key := claimKey{topic: topic, partition: partition}
+	state, ok := h.claims[key]
+	if !ok {
+		state = h.newClaimState()
+		h.claims[key] = state
+	}
+	return state
+}
+
+func (h *KafkaConsumerHandler) newClaimState() *claimState {
+	state := &claimState{}
+	if h.coalesceWindow > 0 {
+		state.coalescer = NewCoalescer(h.coalesceMaxKeys)
+	}
+	return state
+}
+
+// recordDrainError keeps the first error that ended a claim of the session
+func (h *KafkaConsumerHandler) recordDrainError(err error) {
+	h.drainMu.Lock()
+	defer h.drainMu.Unlock()
+	if h.drainErr == nil {
+		h.drainErr = err
+	}
+}
+
+func (h *KafkaConsumerHandler) consumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim, state *claimState) error {
+	if state.coalescer != nil {
+		return h.consumeCoalesced(session, claim, state.coalescer)
+	}
+	if h.workers > 1 {
+		return h.consumeConcurrently(session, claim)
+	}
+
 	for {
 		select {
 		case message := <-claim.Messages():
 			if message == nil {
 				return nil
 			}
+			// Every earlier record of the claim is in the snapshot already
+			if err := h.processAndSnapshot(message, nil, message.Offset+1); err != nil {
+				return err
+			}
+			session.MarkMessage(message, "")
+			h.offsetMarked()
+
+		case <-session.Context().Done():
+			return nil
+		}
+	}
+}
+
+// consumeConcurrently is the consumer loop used with more than one worker. Offsets are
+// marked up to the contiguous completed watermark, so no unfinished message is skipped.
+func (h *KafkaConsumerHandler) consumeConcurrently(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	// The snapshot resume offset follows the watermark: records completed out of order
+	// above it are not covered by the snapshot until the gap below them is
+	var resume atomic.Int64
+	resume.Store(-1)
+	workers := h.newPartitionWorkers(&resume, nil, func(offset int64) {
+		resume.Store(offset + 1)
+		session.MarkOffset(claim.Topic(), claim.Partition(), offset+1, "")
+		h.offsetMarked()
+	})
 
-			var event models.CDCEvent
-			if err := event.UnmarshalJSON(message.Value); err != nil {
-				h.logger.Error("Failed to unmarshal event", zap.Error(err))
-				session.MarkMessage(message, "")
-				continue
+	for {
+		select {
+		case message := <-claim.Messages():
+			if message == nil {
+				return workers.close()
+			}
+			// Earlier offsets were marked by a previous session
+			resume.CompareAndSwap(-1, message.Offset)
+			if err := workers.dispatch(message); err != nil {
+				workers.close()
+				return err
 			}
 
-			if err := h.processor.ProcessEvent(event); err != nil {
-				h.logger.Error("Failed to process event", zap.Error(err))
+		case result := <-workers.results:
+			workers.complete(result)
+			if workers.err != nil {
+				workers.close()
+				return workers.err
 			}
 
-			session.MarkMessage(message, "")
+		case <-session.Context().Done():
+			return workers.close()
+		}
+	}
+}
+
+// newPartitionWorkers starts the workers of a claim. Their snapshot records carry the resume
+// offset current when they are written. superseded, when given, returns the messages coalesced
+// into a dispatched message.
+func (h *KafkaConsumerHandler) newPartitionWorkers(resume *atomic.Int64, superseded func(*sarama.ConsumerMessage) []*sarama.ConsumerMessage, onWatermark func(offset int64)) *partitionWorkers {
+	process := func(message *sarama.ConsumerMessage) error {
+		var earlier []*sarama.ConsumerMessage
+		if superseded != nil {
+			earlier = superseded(message)
+		}
+		return h.processAndSnapshot(message, earlier, resume.Load())
+	}
+	workers := newPartitionWorkers(h.workers, h.maxInFlight, h.ordering, process, onWatermark)
+	if h.backpressure != nil {
+		workers.limit = h.backpressure.Scale
+	}
+	return workers
+}
+
+// offsetMarked reports a marked offset to the committer of the session in manual commit mode
+func (h *KafkaConsumerHandler) offsetMarked() {
+	if h.committer != nil {
+		h.committer.marked()
+	}
+}
+
+// processAndSnapshot processes a message, after the messages coalesced into it, and publishes
+// it to the snapshot topic along with the live offset below which every record of the claim
+// is in the snapshot
+func (h *KafkaConsumerHandler) processAndSnapshot(message *sarama.ConsumerMessage, superseded []*sarama.ConsumerMessage, resume int64) error {
+	err := h.processCoalesced(message, superseded)
+	h.topicStats.processed(message, err)
+	if err != nil {
+		h.logger.Error("Failed to process event", zap.String("topic", message.Topic), zap.Error(err))
+		// In manual commit mode an offset is only marked once the sink acknowledged it
+		if h.commitMode == CommitModeManual && IsSinkError(err) {
+			h.logger.Error("Sink write failed, ending claim without marking its offset",
+				zap.String("topic", message.Topic),
+				zap.Int32("partition", message.Partition),
+				zap.Int64("offset", message.Offset),
+			)
+			return err
+		}
+	}
+
+	// The snapshot must cover every marked offset, otherwise a consumer
+	// bootstrapping from it would skip this record
+	if h.snapshotWriter != nil {
+		if err := h.snapshotWriter.WriteSnapshot(message, resume); err != nil {
+			h.logger.Error("Failed to write snapshot, ending session", zap.Error(err))
+			return err
+		}
+	}
+	return nil
+}
+
+// consumeCoalesced is the consumer loop used when coalescing is enabled. Offsets are only
+// marked after a flush, so every marked offset is covered by a processed message.
+func (h *KafkaConsumerHandler) consumeCoalesced(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim, coalescer *Coalescer) error {
+	ticker := time.NewTicker(h.coalesceWindow)
+	defer ticker.Stop()
+
+	// Offsets are marked per flush only: a watermark inside a flush could cover a superseded
+	// message whose latest version is still in flight
+	var workers *partitionWorkers
+	var resume atomic.Int64
+	// The messages coalesced in the flush being processed, set before dispatching them
+	var superseded map[*sarama.ConsumerMessage][]*sarama.ConsumerMessage
+	if h.workers > 1 {
+		workers = h.newPartitionWorkers(&resume, func(message *sarama.ConsumerMessage) []*sarama.ConsumerMessage {
+			return superseded[message]
+		}, func(int64) {})
+		defer workers.close()
+	}
+	flush := func() error {
+		return h.flushCoalesced(session, coalescer, workers, &resume, &superseded)
+	}
+
+	for {
+		select {
+		case message := <-claim.Messages():
+			if message == nil {
+				return flush()
+			}
+			if coalescer.Add(message) || h.coalescerFull(coalescer) {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+
+		case <-ticker.C:
+			if err := flush(); err != nil {
+				return err
+			}
 
 		case <-session.Context().Done():
-			return nil
+			return flush()
+		}
+	}
+}
+
+// coalescerFull reports whether the coalescer reached the flush size shrunk by backpressure
+func (h *KafkaConsumerHandler) coalescerFull(coalescer *Coalescer) bool {
+	return h.backpressure != nil && coalescer.Len() >= h.backpressure.Scale(coalescer.maxKeys)
+}
+
+// flushCoalesced processes the latest buffered message per key, concurrently when workers
+// are given, and marks the highest offset. The workers read the snapshot resume offset
+// from resume and the messages coalesced into the one they process from superseded.
+func (h *KafkaConsumerHandler) flushCoalesced(session sarama.ConsumerGroupSession, coalescer *Coalescer, workers *partitionWorkers, resume *atomic.Int64, superseded *map[*sarama.ConsumerMessage][]*sarama.ConsumerMessage) error {
+	// Offsets below the window were marked by the previous flush, which wrote their snapshots
+	oldest := coalescer.Oldest()
+	messages, earlier := coalescer.Drain()
+	if len(messages) == 0 {
+		return nil
+	}
+	if workers != nil {
+		resume.Store(oldest)
+		*superseded = earlier
+	}
+
+	// Superseded messages share their key with a later one, which the compacted
+	// snapshot topic would keep anyway
+	for _, message := range messages {
+		if workers != nil {
+			if err := workers.dispatch(message); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := h.processAndSnapshot(message, earlier[message], oldest); err != nil {
+			return err
+		}
+	}
+	if workers != nil {
+		if err := workers.drain(); err != nil {
+			return err
+		}
+	}
+
+	collapsed := 0
+	for _, messages := range earlier {
+		collapsed += len(messages)
+	}
+	last := messages[len(messages)-1]
+	session.MarkMessage(last, "")
+	if h.committer != nil {
+		// A flush is a batch boundary
+		h.committer.marked()
+		h.committer.commit()
+	}
+	h.logger.Debug("Flushed coalesced messages",
+		zap.Int32("partition", last.Partition),
+		zap.Int("processed", len(messages)),
+		zap.Int("superseded", collapsed),
+		zap.Int64("offset", last.Offset),
+	)
+	return nil
+}
+
+// ProcessMessage routes a single message and returns the processing error. Snapshot records
+// are routed by the live topic they were derived from.
+func (h *KafkaConsumerHandler) ProcessMessage(message *sarama.ConsumerMessage) error {
+	if topic, _, _, ok := snapshotSource(message); ok {
+		routed := *message
+		routed.Topic = topic
+		message = &routed
+	}
+	return h.processMessage(message)
+}
+
+// processCoalesced processes a message after passing the messages coalesced into it, in offset
+// order, to the processors doing work for every event. The event of the message carries the
+// changes made since the first coalesced one, so that its diff covers the whole window.
+func (h *KafkaConsumerHandler) processCoalesced(message *sarama.ConsumerMessage, superseded []*sarama.ConsumerMessage) error {
+	var first *models.CDCEvent
+	for _, earlier := range superseded {
+		event, processor, ok := h.routeMessage(earlier)
+		if !ok {
+			continue
+		}
+		if first == nil {
+			first = &event
+		}
+		if supersededProcessor, ok := processor.(SupersededEventProcessor); ok {
+			if err := supersededProcessor.ProcessSupersededEvent(event); err != nil {
+				return err
+			}
+		}
+	}
+
+	event, processor, ok := h.routeMessage(message)
+	if !ok {
+		return nil
+	}
+	if first != nil {
+		event = event.Since(*first)
+	}
+	return processor.ProcessEvent(event)
+}
+
+// processMessage routes a single message. Invalid events are logged and skipped, processing
+// errors are returned.
+func (h *KafkaConsumerHandler) processMessage(message *sarama.ConsumerMessage) error {
+	event, processor, ok := h.routeMessage(message)
+	if !ok {
+		return nil
+	}
+	return processor.ProcessEvent(event)
+}
+
+// routeMessage decodes a message and returns the processor of its event, or false when the
+// message is skipped. Headers are consulted first so that skipped entity types never pay for
+// unmarshalling; the payload stays authoritative otherwise.
+func (h *KafkaConsumerHandler) routeMessage(message *sarama.ConsumerMessage) (models.CDCEvent, EventProcessor, bool) {
+	headers, trusted := models.ParseEventHeaders(messageHeaders(message))
+	if trusted {
+		h.checkPartitionStrategy(headers.PartitionStrategy)
+	}
+	if trusted && h.skipEntityTypes[headers.EntityType] {
+		h.logger.Debug("Skipping event by header",
+			zap.String("entityType", headers.EntityType),
+			zap.Int32("partition", message.Partition),
+			zap.Int64("offset", message.Offset),
+		)
+		return models.CDCEvent{}, nil, false
+	}
+
+	var event models.CDCEvent
+	if err := event.UnmarshalJSON(message.Value); err != nil {
+		h.logger.Error("Failed to unmarshal event", zap.Error(err))
+		return models.CDCEvent{}, nil, false
+	}
+
+	entityType := headers.EntityType
+	if !trusted {
+		key, _ := models.ParseEntityKey(event.After.Key)
+		entityType = key.EntityType
+		if h.skipEntityTypes[entityType] {
+			return models.CDCEvent{}, nil, false
+		}
+	}
+
+	return event, h.processorFor(message.Topic, entityType), true
+}
+
+// checkPartitionStrategy warns once when producers partition differently from what the
+// consumer assumes, since the assumed ordering guarantee may then not hold
+func (h *KafkaConsumerHandler) checkPartitionStrategy(strategy string) {
+	if strategy == "" || strategy == h.strategy {
+		return
+	}
+	if models.OrderingScopeFor(strategy) == h.ordering {
+		return
+	}
+	h.mismatchOnce.Do(func() {
+		h.logger.Warn("Producer partitioning strategy does not match consumer configuration",
+			zap.String("producerStrategy", strategy),
+			zap.String("consumerStrategy", h.strategy),
+			zap.String("assumedOrderingScope", string(h.ordering)),
+		)
+	})
+}
+
+// processorFor returns the processor routed for the entity type, then for the topic, falling
+// back to the default
+func (h *KafkaConsumerHandler) processorFor(topic string, entityType string) EventProcessor {
+	if processor, ok := h.typeProcessors[entityType]; ok {
+		return processor
+	}
+	if len(h.topicRoutes) == 0 {
+		return h.processor
+	}
+	if processor, ok := h.topicProcessors.Load(topic); ok {
+		return processor.(EventProcessor)
+	}
+	processor := h.processor
+	for _, route := range h.topicRoutes {
+		if route.pattern.MatchString(topic) {
+			processor = route.processor
+			break
 		}
 	}
-}
\ No newline at end of file
+	h.topicProcessors.Store(topic, processor)
+	return processor
+}
+
+// messageHeaders flattens the record headers of a message into a name/value map
+func messageHeaders(message *sarama.ConsumerMessage) map[string]string {
+	values := make(map[string]string, len(message.Headers))
+	for _, header := range message.Headers {
+		if header == nil {
+			continue
+		}
+		values[string(header.Key)] = string(header.Value)
+	}
+	return values
+}
@@ -0,0 +1,254 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/kong/konnect-ingest/internal/models"
+	"go.uber.org/zap"
+)
+
+func newTestMessage(offset int64, key string, value string, headers map[string]string) *sarama.ConsumerMessage {
+	msg := &sarama.ConsumerMessage{
+		Topic:     "test-topic",
+		Partition: 0,
+		Offset:    offset,
+		Key:       []byte(key),
+		Value:     []byte(value),
+	}
+	for name, val := range headers {
+		msg.Headers = append(msg.Headers, &sarama.RecordHeader{Key: []byte(name), Value: []byte(val)})
+	}
+	return msg
+}
+
+func TestKafkaConsumerHandlerHeaders(t *testing.T) {
+	serviceValue := `{"after": {"key": "c/cp-1/o/service/svc-1", "value": {"object": {"id": "svc-1"}}}, "op": "c", "ts_ms": 1}`
+	nodeValue := `{"after": {"key": "c/cp-1/o/node/node-1", "value": {"object": {"id": "node-1"}}}, "op": "u", "ts_ms": 2}`
+	nodeHeaders := models.EventHeaders{EntityType: "node", ControlPlaneID: "cp-1", Op: "u", TsMs: 2, SchemaVersion: models.HeaderSchemaVersionV1}.ToMap()
+
+	tests := []struct {
+		name          string
+		message       *sarama.ConsumerMessage
+		skip          []string
+		wantProcessed int
+		wantRouted    int
+	}{
+		{
+			name:          "no headers falls back to payload",
+			message:       newTestMessage(0, "c/cp-1/o/service/svc-1", serviceValue, nil),
+			wantProcessed: 1,
+		},
+		{
+			name:    "skipped by header without unmarshalling",
+			message: newTestMessage(0, "c/cp-1/o/node/node-1", "not json", nodeHeaders),
+			skip:    []string{"node"},
+		},
+		{
+			name:    "skipped by payload when headers are missing",
+			message: newTestMessage(0, "c/cp-1/o/node/node-1", nodeValue, nil),
+			skip:    []string{"node"},
+		},
+		{
+			name: "unknown schema version is not trusted",
+			message: newTestMessage(0, "c/cp-1/o/service/svc-1", serviceValue, map[string]string{
+				models.HeaderEntityType:    "node",
+				models.HeaderSchemaVersion: "99",
+			}),
+			skip:          []string{"node"},
+			wantProcessed: 1,
+		},
+		{
+			name:       "routed by header to entity type processor",
+			message:    newTestMessage(0, "c/cp-1/o/node/node-1", nodeValue, nodeHeaders),
+			wantRouted: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defaultProcessor := &MockEventProcessor{}
+			nodeProcessor := &MockEventProcessor{}
+
+			handler := NewKafkaConsumerHandler(zap.NewNop())
+			handler.SetEventProcessor(defaultProcessor)
+			handler.SetEntityTypeProcessor("node", nodeProcessor)
+			handler.SetSkipEntityTypes(tt.skip)
+
+			session := NewMockConsumerGroupSession(context.Background())
+			claim := NewMockConsumerGroupClaim("test-topic", 0, []*sarama.ConsumerMessage{tt.message})
+
+			if err := handler.ConsumeClaim(session, claim); err != nil {
+				t.Fatalf("ConsumeClaim() error = %v", err)
+			}
+
+			if len(defaultProcessor.events) != tt.wantProcessed {
+				t.Errorf("default processor got %d events, want %d", len(defaultProcessor.events), tt.wantProcessed)
+			}
+			if len(nodeProcessor.events) != tt.wantRouted {
+				t.Errorf("node processor got %d events, want %d", len(nodeProcessor.events), tt.wantRouted)
+			}
+			if session.marked[0] != tt.message.Offset+1 {
+				t.Errorf("marked offset = %d, want %d", session.marked[0], tt.message.Offset+1)
+			}
+		})
+	}
+}
+
+func TestKafkaConsumerHandlerCoalescing(t *testing.T) {
+	nodeValue := func(lastPing int) string {
+		return fmt.Sprintf(`{"before": {"id": "node-1", "last_ping": %d}, "after": {"key": "c/cp-1/o/node/node-1", "value": {"object": {"id": "node-1", "last_ping": %d}}}, "op": "u", "ts_ms": %d}`, lastPing-1, lastPing, lastPing)
+	}
+	serviceValue := `{"after": {"key": "c/cp-1/o/service/svc-1", "value": {"object": {"id": "svc-1"}}}, "op": "c", "ts_ms": 1}`
+	messages := []*sarama.ConsumerMessage{
+		newTestMessage(10, "c/cp-1/o/node/node-1", nodeValue(1), nil),
+		newTestMessage(11, "c/cp-1/o/service/svc-1", serviceValue, nil),
+		newTestMessage(12, "c/cp-1/o/node/node-1", nodeValue(2), nil),
+		newTestMessage(13, "c/cp-1/o/node/node-1", nodeValue(3), nil),
+	}
+
+	tests := []struct {
+		name           string
+		maxKeys        int
+		wantProcessed  int
+		wantSuperseded []int64
+		wantSnapshots  int
+		wantBefore     float64
+	}{
+		{
+			name:           "collapsed within window",
+			maxKeys:        10,
+			wantProcessed:  2,
+			wantSuperseded: []int64{1, 2},
+			wantSnapshots:  2,
+			wantBefore:     0,
+		},
+		{
+			name:          "flushed when full",
+			maxKeys:       1,
+			wantProcessed: 4,
+			wantSnapshots: 4,
+			wantBefore:    2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor := &MockEventProcessor{}
+			snapshots := &MockSnapshotWriter{}
+			handler := NewKafkaConsumerHandler(zap.NewNop())
+			handler.SetEventProcessor(processor)
+			handler.SetSnapshotWriter(snapshots)
+			handler.SetCoalescing(time.Hour, tt.maxKeys)
+
+			session := NewMockConsumerGroupSession(context.Background())
+			claim := NewMockConsumerGroupClaim("test-topic", 0, messages)
+			if err := handler.ConsumeClaim(session, claim); err != nil {
+				t.Fatalf("ConsumeClaim() error = %v", err)
+			}
+
+			if len(processor.events) != tt.wantProcessed {
+				t.Fatalf("processed %d events, want %d", len(processor.events), tt.wantProcessed)
+			}
+			// Collapsed events still reach the processor, in order, before the latest one
+			var superseded []int64
+			for _, event := range processor.superseded {
+				superseded = append(superseded, event.TsMs)
+			}
+			if !reflect.DeepEqual(superseded, tt.wantSuperseded) {
+				t.Errorf("superseded events at %v, want %v", superseded, tt.wantSuperseded)
+			}
+			if len(snapshots.written) != tt.wantSnapshots {
+				t.Errorf("wrote %d snapshots, want %d", len(snapshots.written), tt.wantSnapshots)
+			}
+			last := processor.events[len(processor.events)-1]
+			if last.After.Key != "c/cp-1/o/node/node-1" || last.TsMs != 3 {
+				t.Errorf("last processed event = %s at %d, want the latest node update", last.After.Key, last.TsMs)
+			}
+			// The latest event carries the changes of the whole window
+			if before := last.Before.(map[string]interface{})["last_ping"]; before != tt.wantBefore {
+				t.Errorf("last processed event before last_ping = %v, want %v", before, tt.wantBefore)
+			}
+			if session.marked[0] != 14 {
+				t.Errorf("marked offset = %d, want 14", session.marked[0])
+			}
+		})
+	}
+}
+
+func TestKafkaConsumerHandlerShutdown(t *testing.T) {
+	serviceValue := func(offset int) string {
+		return fmt.Sprintf(`{"after": {"key": "c/cp-1/o/service/svc-%d", "value": {"object": {"id": "svc-%d"}}}, "op": "c", "ts_ms": %d}`, offset, offset, offset)
+	}
+
+	tests := []struct {
+		name          string
+		failSnapshots bool
+		wantProcessed int
+		wantCommitted int64
+		wantDrainErr  bool
+	}{
+		{
+			name:          "pending batch flushed and committed",
+			wantProcessed: 3,
+			wantCommitted: 3,
+		},
+		{
+			name:          "failed flush is not committed",
+			failSnapshots: true,
+			wantProcessed: 1,
+			wantCommitted: 0,
+			wantDrainErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor := &MockEventProcessor{}
+			handler := NewKafkaConsumerHandler(zap.NewNop())
+			handler.SetEventProcessor(processor)
+			handler.SetSnapshotWriter(&MockSnapshotWriter{shouldFail: tt.failSnapshots})
+			handler.SetCoalescing(time.Hour, 10)
+
+			// The claim stays open, as it does until the session ends
+			claim := &MockConsumerGroupClaim{topic: "test-topic", messages: make(chan *sarama.ConsumerMessage, 3)}
+			for offset := 0; offset < 3; offset++ {
+				claim.messages <- newTestMessage(int64(offset), fmt.Sprintf("c/cp-1/o/service/svc-%d", offset), serviceValue(offset), nil)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			session := NewMockConsumerGroupSession(ctx)
+			if err := handler.Setup(session); err != nil {
+				t.Fatalf("Setup() error = %v", err)
+			}
+			done := make(chan error)
+			go func() { done <- handler.ConsumeClaim(session, claim) }()
+			for len(claim.messages) > 0 {
+				time.Sleep(time.Millisecond)
+			}
+			cancel()
+
+			err := <-done
+			if (err != nil) != tt.wantDrainErr {
+				t.Fatalf("ConsumeClaim() error = %v, wantErr %v", err, tt.wantDrainErr)
+			}
+			if err := handler.Cleanup(session); err != nil {
+				t.Fatalf("Cleanup() error = %v", err)
+			}
+
+			if len(processor.events) != tt.wantProcessed {
+				t.Errorf("processed %d events, want %d", len(processor.events), tt.wantProcessed)
+			}
+			if session.committed[0] != tt.wantCommitted {
+				t.Errorf("committed offset = %d, want %d", session.committed[0], tt.wantCommitted)
+			}
+			if drainErr := handler.DrainError(); (drainErr != nil) != tt.wantDrainErr {
+				t.Errorf("DrainError() = %v, wantErr %v", drainErr, tt.wantDrainErr)
+			}
+		})
+	}
+}
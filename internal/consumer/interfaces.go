@@ -10,8 +10,67 @@ type EventProcessor interface {
 	ProcessEvent(event models.CDCEvent) error
 }
 
+// SupersededEventProcessor defines the contract for processors doing work for every consumed
+// event, such as recording it, which must also see the events coalesced into a later one
+type SupersededEventProcessor interface {
+	ProcessSupersededEvent(event models.CDCEvent) error
+}
+
 // MessageHandler defines the contract for handling Kafka messages
 type MessageHandler interface {
 	sarama.ConsumerGroupHandler
 	SetEventProcessor(processor EventProcessor)
 }
+
+// SnapshotWriter defines the contract for maintaining the latest-state snapshot of consumed records.
+// resume is the live offset below which every record of the message's partition is in the snapshot.
+type SnapshotWriter interface {
+	WriteSnapshot(message *sarama.ConsumerMessage, resume int64) error
+}
+
+// MessageProcessor defines the contract for processing raw Kafka messages and making their writes durable
+type MessageProcessor interface {
+	ProcessMessage(message *sarama.ConsumerMessage) error
+	Flush() error
+}
+
+// WriteFilter defines the contract for skipping document writes that would not change anything relevant.
+// Filter returns the document to write, or false when the write can be skipped.
+type WriteFilter interface {
+	Filter(entityType string, indexName string, id string, document interface{}) (interface{}, bool)
+	Written(entityType string, indexName string, id string, document interface{})
+	Deleted(indexName string, id string)
+}
+
+// SessionState defines the contract for state that must not outlive a consumer group session
+type SessionState interface {
+	Reset()
+}
+
+// SystemEventHandler defines the contract for consuming control events instead of indexing them.
+// HandleSystemEvent reports whether the event was handled.
+type SystemEventHandler interface {
+	HandleSystemEvent(key models.EntityKey, event models.CDCEvent) bool
+}
+
+// ConsistencyChecker defines the contract for verifying that a resource reported as updated gets indexed
+type ConsistencyChecker interface {
+	Check(controlPlaneID string, resourceType string, id string)
+}
+
+// Pauser defines the contract for pausing and resuming the fetching of all claimed partitions
+type Pauser interface {
+	PauseAll()
+	ResumeAll()
+}
+
+// TopicLister defines the contract for listing the topics of the cluster, satisfied by sarama.Client
+type TopicLister interface {
+	RefreshMetadata(topics ...string) error
+	Topics() ([]string, error)
+}
+
+// Flusher defines the contract for making buffered sink writes durable
+type Flusher interface {
+	Flush() error
+}
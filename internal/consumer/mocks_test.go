@@ -1,12 +1,19 @@
 package consumer
 
 import (
+	"context"
 	"fmt"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"github.com/kong/konnect-ingest/internal/data_processing"
+	"github.com/kong/konnect-ingest/internal/models"
 )
 
 // MockDocumentIndexer is a mock implementation of DocumentIndexer
 type MockDocumentIndexer struct {
 	indexedDocs map[string]interface{}
+	history     []interface{}
 	shouldFail  bool
 }
 
@@ -22,9 +29,34 @@ func (m *MockDocumentIndexer) IndexDocument(index, id string, document interface
 		return fmt.Errorf("mock indexing error")
 	}
 	m.indexedDocs[fmt.Sprintf("%s/%s", index, id)] = document
+	m.history = append(m.history, document)
 	return nil
 }
 
+// MockDocumentDeleter is a MockDocumentIndexer that also deletes documents
+type MockDocumentDeleter struct {
+	*MockDocumentIndexer
+}
+
+func (m *MockDocumentDeleter) DeleteDocument(index, id string) error {
+	if m.shouldFail {
+		return fmt.Errorf("mock delete error")
+	}
+	delete(m.indexedDocs, fmt.Sprintf("%s/%s", index, id))
+	return nil
+}
+
+// MockDocumentGetter returns stored documents by index and id
+type MockDocumentGetter struct {
+	documents map[string]map[string]interface{}
+	reads     int
+}
+
+func (m *MockDocumentGetter) GetDocument(indexName string, id string) (map[string]interface{}, error) {
+	m.reads++
+	return m.documents[indexName+"/"+id], nil
+}
+
 // MockEntityExtractor is a mock implementation of EntityExtractor
 type MockEntityExtractor struct {
 	shouldFail bool
@@ -75,3 +107,157 @@ func splitKey(key string) []string {
 	}
 	return result
 }
+
+// MockEventProcessor records processed events and the superseded events passed to it
+type MockEventProcessor struct {
+	events     []models.CDCEvent
+	superseded []models.CDCEvent
+}
+
+func (m *MockEventProcessor) ProcessEvent(event models.CDCEvent) error {
+	m.events = append(m.events, event)
+	return nil
+}
+
+func (m *MockEventProcessor) ProcessSupersededEvent(event models.CDCEvent) error {
+	m.superseded = append(m.superseded, event)
+	return nil
+}
+
+// MockConsumerGroupSession is a mock implementation of sarama.ConsumerGroupSession
+type MockConsumerGroupSession struct {
+	ctx        context.Context
+	claims     map[string][]int32
+	generation int32
+	marked     map[int32]int64
+	committed  map[int32]int64
+}
+
+func NewMockConsumerGroupSession(ctx context.Context) *MockConsumerGroupSession {
+	return &MockConsumerGroupSession{ctx: ctx, marked: make(map[int32]int64)}
+}
+
+func (m *MockConsumerGroupSession) Claims() map[string][]int32 { return m.claims }
+func (m *MockConsumerGroupSession) MemberID() string           { return "mock-member" }
+func (m *MockConsumerGroupSession) GenerationID() int32        { return m.generation }
+func (m *MockConsumerGroupSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	m.marked[partition] = offset
+}
+func (m *MockConsumerGroupSession) Commit() {
+	m.committed = make(map[int32]int64, len(m.marked))
+	for partition, offset := range m.marked {
+		m.committed[partition] = offset
+	}
+}
+func (m *MockConsumerGroupSession) ResetOffset(topic string, partition int32, offset int64, metadata string) {
+}
+func (m *MockConsumerGroupSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	m.MarkOffset(msg.Topic, msg.Partition, msg.Offset+1, metadata)
+}
+func (m *MockConsumerGroupSession) Context() context.Context { return m.ctx }
+
+// MockConsumerGroupClaim is a mock implementation of sarama.ConsumerGroupClaim
+type MockConsumerGroupClaim struct {
+	topic     string
+	partition int32
+	messages  chan *sarama.ConsumerMessage
+}
+
+// NewMockConsumerGroupClaim returns a claim whose channel holds the given messages and is then closed
+func NewMockConsumerGroupClaim(topic string, partition int32, messages []*sarama.ConsumerMessage) *MockConsumerGroupClaim {
+	ch := make(chan *sarama.ConsumerMessage, len(messages))
+	for _, msg := range messages {
+		ch <- msg
+	}
+	close(ch)
+	return &MockConsumerGroupClaim{topic: topic, partition: partition, messages: ch}
+}
+
+func (m *MockConsumerGroupClaim) Topic() string                            { return m.topic }
+func (m *MockConsumerGroupClaim) Partition() int32                         { return m.partition }
+func (m *MockConsumerGroupClaim) InitialOffset() int64                     { return 0 }
+func (m *MockConsumerGroupClaim) HighWaterMarkOffset() int64               { return 0 }
+func (m *MockConsumerGroupClaim) Messages() <-chan *sarama.ConsumerMessage { return m.messages }
+
+// MockSyncProducer is a mock implementation of sarama.SyncProducer that fails the first failures sends
+type MockSyncProducer struct {
+	failures int
+	messages []*sarama.ProducerMessage
+}
+
+func (m *MockSyncProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	if m.failures > 0 {
+		m.failures--
+		return 0, 0, sarama.ErrBrokerNotAvailable
+	}
+	m.messages = append(m.messages, msg)
+	return 0, int64(len(m.messages)), nil
+}
+
+func (m *MockSyncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	for _, msg := range msgs {
+		if _, _, err := m.SendMessage(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MockSyncProducer) Close() error                            { return nil }
+func (m *MockSyncProducer) TxnStatus() sarama.ProducerTxnStatusFlag { return 0 }
+func (m *MockSyncProducer) IsTransactional() bool                   { return false }
+func (m *MockSyncProducer) BeginTxn() error                         { return nil }
+func (m *MockSyncProducer) CommitTxn() error                        { return nil }
+func (m *MockSyncProducer) AbortTxn() error                         { return nil }
+func (m *MockSyncProducer) AddMessageToTxn(msg *sarama.ConsumerMessage, groupID string, metadata *string) error {
+	return nil
+}
+func (m *MockSyncProducer) AddOffsetsToTxn(offsets map[string][]*sarama.PartitionOffsetMetadata, groupID string) error {
+	return nil
+}
+
+// MockSnapshotWriter is a mock implementation of SnapshotWriter
+type MockSnapshotWriter struct {
+	mu         sync.Mutex
+	shouldFail bool
+	written    []*sarama.ConsumerMessage
+	resumes    map[int64]int64
+}
+
+func (m *MockSnapshotWriter) WriteSnapshot(message *sarama.ConsumerMessage, resume int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.shouldFail {
+		return fmt.Errorf("mock snapshot error")
+	}
+	m.written = append(m.written, message)
+	if m.resumes == nil {
+		m.resumes = make(map[int64]int64)
+	}
+	m.resumes[message.Offset] = resume
+	return nil
+}
+
+// MockEventSink records the events passed to it
+type MockEventSink struct {
+	events     []models.CDCEvent
+	shouldFail bool
+}
+
+func (m *MockEventSink) Name() string { return "events" }
+func (m *MockEventSink) Capabilities() data_processing.SinkCapabilities {
+	return data_processing.SinkCapabilities{Events: true}
+}
+func (m *MockEventSink) Upsert(key string, indexName string, id string, document interface{}) error {
+	return nil
+}
+func (m *MockEventSink) Delete(indexName string, id string) error { return nil }
+func (m *MockEventSink) Flush() error                             { return nil }
+
+func (m *MockEventSink) RecordEvent(event models.CDCEvent) error {
+	if m.shouldFail {
+		return fmt.Errorf("mock record error")
+	}
+	m.events = append(m.events, event)
+	return nil
+}
@@ -6,11 +6,22 @@ import (
 	"go.uber.org/zap"
 )
 
+// maxReenrichAttempts bounds the rewrites of a document whose enrichment went stale while
+// it was written
+const maxReenrichAttempts = 3
+
 // CDCEventProcessor implements EventProcessor for CDC events
 type CDCEventProcessor struct {
 	logger          *zap.Logger
-	indexer         data_processing.DocumentIndexer
+	sink            data_processing.Sink
 	entityExtractor data_processing.EntityExtractor
+	redactor        data_processing.Redactor
+	enrichers       []data_processing.Enricher
+	writeFilter     WriteFilter
+	searchProjector data_processing.SearchProjector
+	differ          data_processing.Differ
+	changeHistory   bool
+	systemEvents    SystemEventHandler
 	indexPrefix     string
 }
 
@@ -23,14 +34,92 @@ func NewCDCEventProcessor(
 ) *CDCEventProcessor {
 	return &CDCEventProcessor{
 		logger:          logger,
-		indexer:         indexer,
+		sink:            data_processing.NewIndexerSink("opensearch", indexer),
 		entityExtractor: entityExtractor,
 		indexPrefix:     indexPrefix,
 	}
 }
 
-// ProcessEvent processes a single CDC event
+// SetSink replaces the sink documents are written to, the indexer given to the constructor
+// by default
+func (p *CDCEventProcessor) SetSink(sink data_processing.Sink) {
+	p.sink = sink
+}
+
+// SetRedactor sets the redactor applied to every document before indexing
+func (p *CDCEventProcessor) SetRedactor(redactor data_processing.Redactor) {
+	p.redactor = redactor
+}
+
+// AddEnricher adds an enricher embedding related entity data into every document. Enrichers
+// run in the order they were added.
+func (p *CDCEventProcessor) AddEnricher(enricher data_processing.Enricher) {
+	p.enrichers = append(p.enrichers, enricher)
+}
+
+// SetWriteFilter sets the filter deciding which document writes can be skipped
+func (p *CDCEventProcessor) SetWriteFilter(filter WriteFilter) {
+	p.writeFilter = filter
+}
+
+// SetSearchProjector enables writing searchable entities to the unified search index as well
+func (p *CDCEventProcessor) SetSearchProjector(projector data_processing.SearchProjector) {
+	p.searchProjector = projector
+}
+
+// SetDiffer enables computing the field changes of every event carrying its before image,
+// summarized in the last_change field of the document
+func (p *CDCEventProcessor) SetDiffer(differ data_processing.Differ) {
+	p.differ = differ
+}
+
+// SetChangeHistory enables writing every change computed by the differ to the change history
+// index as well
+func (p *CDCEventProcessor) SetChangeHistory(enabled bool) {
+	p.changeHistory = enabled
+}
+
+// SetSystemEventHandler sets the handler consuming control events, which are then not indexed
+func (p *CDCEventProcessor) SetSystemEventHandler(handler SystemEventHandler) {
+	p.systemEvents = handler
+}
+
+// ProcessEvent processes a single CDC event, then passes it to the sinks recording events. An
+// event whose writes failed is not recorded, so that it is only recorded once when consumed
+// again.
 func (p *CDCEventProcessor) ProcessEvent(event models.CDCEvent) error {
+	err := p.processEvent(event)
+	if IsSinkError(err) || !p.sink.Capabilities().Events {
+		return err
+	}
+	if recordErr := p.recordEvent(event); recordErr != nil {
+		return recordErr
+	}
+	return err
+}
+
+// ProcessSupersededEvent passes an event coalesced into a later one of its key to the sinks
+// recording events; only the later one is indexed
+func (p *CDCEventProcessor) ProcessSupersededEvent(event models.CDCEvent) error {
+	if !p.sink.Capabilities().Events {
+		return nil
+	}
+	return p.recordEvent(event)
+}
+
+// processEvent indexes the document of an event, or deletes it
+func (p *CDCEventProcessor) processEvent(event models.CDCEvent) error {
+	if p.systemEvents != nil {
+		if key, ok := models.ParseEntityKey(event.After.Key); ok && p.systemEvents.HandleSystemEvent(key, event) {
+			p.logger.Debug("Handled system event", zap.String("key", event.After.Key))
+			return nil
+		}
+	}
+
+	if event.IsDelete() {
+		return p.deleteEvent(event)
+	}
+
 	// Extract entity type and ID
 	entityType, id, err := p.entityExtractor.ExtractEntityInfo(event.After.Key, event.After.Value.Object)
 	if err != nil {
@@ -45,9 +134,44 @@ func (p *CDCEventProcessor) ProcessEvent(event models.CDCEvent) error {
 		zap.String("key", event.After.Key),
 	)
 
+	key, _ := models.ParseEntityKey(event.After.Key)
+	controlPlaneID := key.ControlPlaneID
+	document := event.After.Value.Object
+	var unenriched map[string]interface{}
+	var changes []data_processing.FieldChange
+	if object, ok := document.(map[string]interface{}); ok {
+		if p.redactor != nil {
+			object = p.redactor.Redact(entityType, object)
+		}
+		if p.differ != nil {
+			object, changes = p.diff(event, entityType, object)
+		}
+		unenriched = object
+		document = p.enrich(controlPlaneID, entityType, object)
+	}
+
+	if p.writeFilter != nil {
+		var write bool
+		if document, write = p.writeFilter.Filter(entityType, indexName, id, document); !write {
+			p.logger.Debug("Skipping write of unchanged document",
+				zap.String("indexName", indexName),
+				zap.String("id", id),
+			)
+			return nil
+		}
+	}
+
 	// Index the document
-	if err := p.indexer.IndexDocument(indexName, id, event.After.Value.Object); err != nil {
-		return err
+	if err := p.sink.Upsert(event.After.Key, indexName, id, document); err != nil {
+		return &SinkError{Err: err}
+	}
+	if p.writeFilter != nil {
+		p.writeFilter.Written(entityType, indexName, id, document)
+	}
+	if unenriched != nil {
+		if document, err = p.reenrich(event.After.Key, controlPlaneID, entityType, indexName, id, unenriched, document); err != nil {
+			return err
+		}
 	}
 
 	p.logger.Info("Successfully indexed document",
@@ -55,5 +179,184 @@ func (p *CDCEventProcessor) ProcessEvent(event models.CDCEvent) error {
 		zap.String("id", id),
 	)
 
+	if p.differ != nil && p.changeHistory {
+		if err := p.indexHistoryDocument(event, changes); err != nil {
+			return err
+		}
+	}
+	if p.searchProjector != nil {
+		return p.indexSearchDocument(event, document)
+	}
 	return nil
-}
\ No newline at end of file
+}
+
+// enrich applies every enricher to a document
+func (p *CDCEventProcessor) enrich(controlPlaneID string, entityType string, object map[string]interface{}) map[string]interface{} {
+	for _, enricher := range p.enrichers {
+		object = enricher.Enrich(controlPlaneID, entityType, object)
+	}
+	return object
+}
+
+// reenrich enriches and writes a document again while an enricher reports the written one
+// stale, e.g. a parent renamed concurrently re-enriched its indexed children before this
+// write landed. It returns the document last written.
+func (p *CDCEventProcessor) reenrich(key string, controlPlaneID string, entityType string, indexName string, id string, object map[string]interface{}, document interface{}) (interface{}, error) {
+	for attempt := 0; attempt < maxReenrichAttempts && p.enrichmentStale(controlPlaneID, entityType, document); attempt++ {
+		var enriched interface{} = p.enrich(controlPlaneID, entityType, object)
+		if p.writeFilter != nil {
+			var write bool
+			if enriched, write = p.writeFilter.Filter(entityType, indexName, id, enriched); !write {
+				return document, nil
+			}
+		}
+		if err := p.sink.Upsert(key, indexName, id, enriched); err != nil {
+			return document, &SinkError{Err: err}
+		}
+		if p.writeFilter != nil {
+			p.writeFilter.Written(entityType, indexName, id, enriched)
+		}
+		document = enriched
+	}
+	return document, nil
+}
+
+// enrichmentStale reports whether an enricher considers the written document stale
+func (p *CDCEventProcessor) enrichmentStale(controlPlaneID string, entityType string, document interface{}) bool {
+	object, ok := document.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	for _, enricher := range p.enrichers {
+		if checker, ok := enricher.(data_processing.StaleChecker); ok && checker.Stale(controlPlaneID, entityType, object) {
+			return true
+		}
+	}
+	return false
+}
+
+// diff computes the changes from the redacted before image of the event to the redacted object,
+// and stamps their summary on a copy of the object
+func (p *CDCEventProcessor) diff(event models.CDCEvent, entityType string, object map[string]interface{}) (map[string]interface{}, []data_processing.FieldChange) {
+	var changes []data_processing.FieldChange
+	if before, ok := event.Before.(map[string]interface{}); ok {
+		if p.redactor != nil {
+			before = p.redactor.Redact(entityType, before)
+		}
+		changes = p.differ.Diff(before, object)
+	}
+
+	stamped := make(map[string]interface{}, len(object)+1)
+	for field, value := range object {
+		stamped[field] = value
+	}
+	stamped[data_processing.LastChangeField] = data_processing.LastChange{
+		Op:            data_processing.ChangeOp(event.Op),
+		TsMs:          event.TsMs,
+		ChangeSummary: data_processing.Summarize(changes),
+	}
+	return stamped, changes
+}
+
+// indexHistoryDocument records the change made by the event in the change history index
+func (p *CDCEventProcessor) indexHistoryDocument(event models.CDCEvent, changes []data_processing.FieldChange) error {
+	key, ok := models.ParseEntityKey(event.After.Key)
+	if !ok {
+		return nil
+	}
+	indexName := p.indexPrefix + "-" + data_processing.HistoryIndexSuffix
+	historyDocument := data_processing.NewHistoryDocument(event, key, changes)
+	if err := p.sink.Upsert(event.After.Key, indexName, data_processing.HistoryDocumentID(key, event.TsMs), historyDocument); err != nil {
+		return &SinkError{Err: err}
+	}
+	return nil
+}
+
+// recordEvent passes the consumed event, with its object redacted, to the sinks recording events
+func (p *CDCEventProcessor) recordEvent(event models.CDCEvent) error {
+	eventSink, ok := p.sink.(data_processing.EventSink)
+	if !ok {
+		return nil
+	}
+	if key, ok := models.ParseEntityKey(event.After.Key); ok && p.redactor != nil {
+		if object, ok := event.After.Value.Object.(map[string]interface{}); ok {
+			event.After.Value.Object = p.redactor.Redact(key.EntityType, object)
+		}
+		if before, ok := event.Before.(map[string]interface{}); ok {
+			event.Before = p.redactor.Redact(key.EntityType, before)
+		}
+	}
+	if err := eventSink.RecordEvent(event); err != nil {
+		return &SinkError{Err: err}
+	}
+	return nil
+}
+
+// indexSearchDocument writes the projection of a searchable entity to the unified search index
+func (p *CDCEventProcessor) indexSearchDocument(event models.CDCEvent, document interface{}) error {
+	object, ok := document.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	key, ok := models.ParseEntityKey(event.After.Key)
+	if !ok {
+		return nil
+	}
+	searchDocument, ok := p.searchProjector.Project(key, object)
+	if !ok {
+		return nil
+	}
+	if searchDocument.UpdatedAt == 0 {
+		searchDocument.UpdatedAt = event.TsMs
+	}
+
+	indexName := p.indexPrefix + "-" + data_processing.SearchIndexSuffix
+	if err := p.sink.Upsert(event.After.Key, indexName, data_processing.SearchDocumentID(key), searchDocument); err != nil {
+		return &SinkError{Err: err}
+	}
+	return nil
+}
+
+// deleteEvent removes the deleted entity, and its search document, from sinks supporting
+// deletes. Deletes may only carry the key, so the entity is identified from the key when the
+// extractor needs the object.
+func (p *CDCEventProcessor) deleteEvent(event models.CDCEvent) error {
+	key, keyOK := models.ParseEntityKey(event.After.Key)
+	entityType, id, err := p.entityExtractor.ExtractEntityInfo(event.After.Key, event.After.Value.Object)
+	if err != nil {
+		if !keyOK {
+			return err
+		}
+		entityType, id = key.EntityType, key.ID
+	}
+
+	if !p.sink.Capabilities().Deletes {
+		p.logger.Debug("Skipping delete, the sink does not support deletes", zap.String("key", event.After.Key))
+		return nil
+	}
+
+	indexName := p.indexPrefix + "-" + entityType
+	if err := p.sink.Delete(indexName, id); err != nil {
+		return &SinkError{Err: err}
+	}
+	if p.writeFilter != nil {
+		p.writeFilter.Deleted(indexName, id)
+	}
+	p.logger.Info("Deleted document",
+		zap.String("indexName", indexName),
+		zap.String("id", id),
+	)
+
+	if p.differ != nil && p.changeHistory {
+		if err := p.indexHistoryDocument(event, nil); err != nil {
+			return err
+		}
+	}
+	if p.searchProjector != nil && keyOK {
+		searchIndex := p.indexPrefix + "-" + data_processing.SearchIndexSuffix
+		if err := p.sink.Delete(searchIndex, data_processing.SearchDocumentID(key)); err != nil {
+			return &SinkError{Err: err}
+		}
+	}
+	return nil
+}
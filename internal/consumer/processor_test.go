@@ -1,9 +1,16 @@
 package consumer
 
 import (
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/kong/konnect-ingest/internal/data_processing"
 	"github.com/kong/konnect-ingest/internal/models"
+	"github.com/kong/konnect-ingest/internal/producer"
 	"go.uber.org/zap"
 )
 
@@ -145,3 +152,503 @@ func TestCDCEventProcessor(t *testing.T) {
 		})
 	}
 }
+
+// loadStreamEvents returns every event of stream.jsonl
+func loadStreamEvents(t *testing.T) []models.CDCEvent {
+	t.Helper()
+	reader, err := producer.NewEventReader("../../stream.jsonl", zap.NewNop())
+	if err != nil {
+		t.Fatalf("failed to open sample stream: %v", err)
+	}
+	defer reader.Close()
+
+	var events []models.CDCEvent
+	for {
+		event, err := reader.ReadEvent()
+		if err == io.EOF {
+			return events
+		}
+		if err != nil {
+			t.Fatalf("failed to read sample stream: %v", err)
+		}
+		events = append(events, *event)
+	}
+}
+
+// streamSecrets returns the private JWK parameters of the key entities in the sample stream
+func streamSecrets(t *testing.T, events []models.CDCEvent) []string {
+	t.Helper()
+	var secrets []string
+	for _, event := range events {
+		object, ok := event.After.Value.Object.(map[string]interface{})
+		if !ok || !strings.Contains(event.After.Key, "/o/key/") {
+			continue
+		}
+		var jwk map[string]interface{}
+		if err := json.Unmarshal([]byte(object["jwk"].(string)), &jwk); err != nil {
+			t.Fatalf("failed to parse jwk of %s: %v", event.After.Key, err)
+		}
+		for _, member := range []string{"d", "p", "q", "dp", "dq", "qi"} {
+			if value, ok := jwk[member].(string); ok {
+				secrets = append(secrets, value)
+			}
+		}
+	}
+	return secrets
+}
+
+func TestCDCEventProcessorRedactsSampleStream(t *testing.T) {
+	events := loadStreamEvents(t)
+	secrets := streamSecrets(t, events)
+	if len(secrets) == 0 {
+		t.Fatal("expected private key material in the sample stream")
+	}
+
+	for _, action := range []string{data_processing.RedactionDrop, data_processing.RedactionHash} {
+		t.Run(action, func(t *testing.T) {
+			redactor, err := data_processing.NewFieldRedactor(data_processing.RedactionRules{
+				Action:      action,
+				HashSalt:    "salt",
+				EntityTypes: data_processing.DefaultFieldRules(),
+			})
+			if err != nil {
+				t.Fatalf("NewFieldRedactor() error = %v", err)
+			}
+			indexer := NewMockDocumentIndexer(false)
+			processor := NewCDCEventProcessor(zap.NewNop(), indexer, data_processing.NewCDCEntityExtractor(zap.NewNop()), "cdc")
+			processor.SetRedactor(redactor)
+
+			for _, event := range events {
+				// Entities without an id are rejected by the extractor and never indexed
+				processor.ProcessEvent(event)
+			}
+			if len(indexer.history) == 0 {
+				t.Fatal("no document was indexed")
+			}
+
+			for _, document := range indexer.history {
+				encoded, err := json.Marshal(document)
+				if err != nil {
+					t.Fatalf("failed to encode document: %v", err)
+				}
+				for _, secret := range secrets {
+					if strings.Contains(string(encoded), secret) {
+						t.Fatalf("secret reached the indexer in %s", encoded)
+					}
+				}
+			}
+
+			for key, document := range indexer.indexedDocs {
+				if !strings.HasPrefix(key, "cdc-vault/") {
+					continue
+				}
+				if _, ok := document.(map[string]interface{})["config"].(map[string]interface{}); ok {
+					t.Errorf("vault config reached the indexer in %s", key)
+				}
+			}
+			if _, ok := indexer.indexedDocs["cdc-key/dc94cb82-7f48-45e5-b81b-4213f4b491b4"]; !ok {
+				t.Error("key entity should still be indexed with its public parameters")
+			}
+		})
+	}
+}
+
+func TestCDCEventProcessorIndexesSearchDocuments(t *testing.T) {
+	indexer := NewMockDocumentIndexer(false)
+	processor := NewCDCEventProcessor(zap.NewNop(), indexer, data_processing.NewCDCEntityExtractor(zap.NewNop()), "cdc")
+	processor.SetSearchProjector(data_processing.DefaultProjections())
+
+	searchable := map[string]bool{}
+	for _, event := range loadStreamEvents(t) {
+		if err := processor.ProcessEvent(event); err != nil {
+			continue
+		}
+		key, ok := models.ParseEntityKey(event.After.Key)
+		if ok && (key.EntityType == "service" || key.EntityType == "route" || key.EntityType == "vault") {
+			searchable["cdc-search/"+data_processing.SearchDocumentID(key)] = true
+		}
+	}
+	if len(searchable) == 0 {
+		t.Fatal("expected services, routes and vaults in the sample stream")
+	}
+
+	for id := range searchable {
+		document, ok := indexer.indexedDocs[id].(data_processing.SearchDocument)
+		if !ok {
+			t.Errorf("missing search document %s", id)
+			continue
+		}
+		if document.UpdatedAt == 0 {
+			t.Errorf("search document %s has no updated_at", id)
+		}
+	}
+	for id, document := range indexer.indexedDocs {
+		if document, ok := document.(data_processing.SearchDocument); ok && (document.EntityType == "hash" || document.EntityType == "store_event") {
+			t.Errorf("%s entities are not searchable: %s", document.EntityType, id)
+		}
+	}
+}
+
+func TestChangeDetectorSampleStream(t *testing.T) {
+	events := loadStreamEvents(t)
+	volatile := map[string][]string{"node": {"last_ping", "updated_at"}}
+
+	tests := []struct {
+		name      string
+		freshness time.Duration
+		wantNodes int
+	}{
+		{
+			// 19 nodes, plus 7 updates changing connection_state, config_hash or created_at
+			name:      "never refreshed",
+			wantNodes: 26,
+		},
+		{
+			// Nodes in the sample report about every 4 minutes
+			name:      "refreshed when stale",
+			freshness: 5 * time.Minute,
+			wantNodes: 319,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			detector := NewChangeDetector(volatile, tt.freshness, 0, zap.NewNop())
+			// The event time drives the clock so that the stream replays like it was produced
+			var now time.Time
+			detector.now = func() time.Time { return now }
+
+			indexer := NewMockDocumentIndexer(false)
+			processor := NewCDCEventProcessor(zap.NewNop(), indexer, data_processing.NewCDCEntityExtractor(zap.NewNop()), "cdc")
+			processor.SetWriteFilter(detector)
+
+			nodes := 0
+			for _, event := range events {
+				now = time.UnixMilli(event.TsMs)
+				before := len(indexer.history)
+				processor.ProcessEvent(event)
+				if len(indexer.history) > before && strings.Contains(event.After.Key, "/o/node/") {
+					nodes++
+				}
+			}
+			if nodes != tt.wantNodes {
+				t.Errorf("wrote %d node documents, want %d", nodes, tt.wantNodes)
+			}
+		})
+	}
+}
+
+func TestChangeDetector(t *testing.T) {
+	service := func(name string) map[string]interface{} {
+		return map[string]interface{}{"id": "svc-1", "name": name, "updated_at": float64(len(name))}
+	}
+	volatile := map[string][]string{"service": {"updated_at"}}
+
+	t.Run("skips identical content", func(t *testing.T) {
+		detector := NewChangeDetector(volatile, 0, 0, zap.NewNop())
+		document, write := detector.Filter("service", "cdc-service", "svc-1", service("a"))
+		if !write {
+			t.Fatal("first write should not be skipped")
+		}
+		if _, ok := document.(map[string]interface{})[ContentHashField].(string); !ok {
+			t.Fatal("written document should carry its content hash")
+		}
+		detector.Written("service", "cdc-service", "svc-1", document)
+
+		// Only the volatile field differs, and the stored hash field is ignored
+		again := service("b")
+		again["name"] = "a"
+		again[ContentHashField] = "stale"
+		if _, write := detector.Filter("service", "cdc-service", "svc-1", again); write {
+			t.Error("write with only volatile changes should be skipped")
+		}
+		if _, write := detector.Filter("service", "cdc-service", "svc-1", service("c")); !write {
+			t.Error("write with a changed name should not be skipped")
+		}
+	})
+
+	t.Run("unwritten documents are not remembered", func(t *testing.T) {
+		detector := NewChangeDetector(volatile, 0, 0, zap.NewNop())
+		detector.Filter("service", "cdc-service", "svc-1", service("a"))
+		if _, write := detector.Filter("service", "cdc-service", "svc-1", service("a")); !write {
+			t.Error("a failed write must not cause the retry to be skipped")
+		}
+	})
+
+	t.Run("stored hash after restart", func(t *testing.T) {
+		first := NewChangeDetector(volatile, 0, 0, zap.NewNop())
+		document, _ := first.Filter("service", "cdc-service", "svc-1", service("a"))
+
+		getter := &MockDocumentGetter{documents: map[string]map[string]interface{}{
+			"cdc-service/svc-1": document.(map[string]interface{}),
+		}}
+		restarted := NewChangeDetector(volatile, 0, 0, zap.NewNop())
+		restarted.SetDocumentGetter(getter)
+		if _, write := restarted.Filter("service", "cdc-service", "svc-1", service("a")); write {
+			t.Error("write matching the stored hash should be skipped")
+		}
+		restarted.Filter("service", "cdc-service", "svc-1", service("a"))
+		if getter.reads != 1 {
+			t.Errorf("stored hash read %d times, want once", getter.reads)
+		}
+	})
+
+	t.Run("reset and eviction", func(t *testing.T) {
+		detector := NewChangeDetector(volatile, 0, 2, zap.NewNop())
+		for _, id := range []string{"a", "b", "c"} {
+			document, _ := detector.Filter("service", "cdc-service", id, service(id))
+			detector.Written("service", "cdc-service", id, document)
+		}
+		if detector.cache.len() != 2 {
+			t.Errorf("cache holds %d hashes, want 2", detector.cache.len())
+		}
+		if _, write := detector.Filter("service", "cdc-service", "a", service("a")); !write {
+			t.Error("evicted document should be written")
+		}
+
+		detector.Reset()
+		if _, write := detector.Filter("service", "cdc-service", "c", service("c")); !write {
+			t.Error("document should be written after a reset")
+		}
+	})
+}
+
+func TestCDCEventProcessorDeletes(t *testing.T) {
+	event := func(op string, object interface{}) models.CDCEvent {
+		var e models.CDCEvent
+		e.Op = op
+		e.After.Key = "c/123/o/service/456"
+		e.After.Value.Object = object
+		return e
+	}
+	service := map[string]interface{}{"id": "456", "name": "test-service"}
+
+	t.Run("delete removes the document and its hash", func(t *testing.T) {
+		indexer := &MockDocumentDeleter{NewMockDocumentIndexer(false)}
+		processor := NewCDCEventProcessor(zap.NewNop(), indexer, data_processing.NewCDCEntityExtractor(zap.NewNop()), "cdc")
+		detector := NewChangeDetector(nil, 0, 0, zap.NewNop())
+		processor.SetWriteFilter(detector)
+
+		if err := processor.ProcessEvent(event(models.OpUpdate, service)); err != nil {
+			t.Fatalf("ProcessEvent() error = %v", err)
+		}
+		// A delete only carrying the key falls back to the type and id of the key
+		if err := processor.ProcessEvent(event(models.OpDelete, nil)); err != nil {
+			t.Fatalf("ProcessEvent() delete error = %v", err)
+		}
+		if _, ok := indexer.indexedDocs["cdc-service/456"]; ok {
+			t.Error("deleted document is still indexed")
+		}
+
+		// The same content recreated after the delete must be written again
+		before := len(indexer.history)
+		processor.ProcessEvent(event(models.OpUpdate, service))
+		if len(indexer.history) == before {
+			t.Error("recreated document was skipped as unchanged")
+		}
+	})
+
+	t.Run("sink without deletes", func(t *testing.T) {
+		indexer := NewMockDocumentIndexer(false)
+		processor := NewCDCEventProcessor(zap.NewNop(), indexer, data_processing.NewCDCEntityExtractor(zap.NewNop()), "cdc")
+		if err := processor.ProcessEvent(event(models.OpDelete, nil)); err != nil {
+			t.Errorf("ProcessEvent() error = %v, want the delete skipped", err)
+		}
+	})
+
+	t.Run("failed delete is a sink error", func(t *testing.T) {
+		indexer := &MockDocumentDeleter{NewMockDocumentIndexer(true)}
+		processor := NewCDCEventProcessor(zap.NewNop(), indexer, data_processing.NewCDCEntityExtractor(zap.NewNop()), "cdc")
+		if err := processor.ProcessEvent(event(models.OpDelete, nil)); !IsSinkError(err) {
+			t.Errorf("ProcessEvent() error = %v, want a sink error", err)
+		}
+	})
+}
+
+func TestCDCEventProcessorRecordsEvents(t *testing.T) {
+	redactor, err := data_processing.NewFieldRedactor(data_processing.RedactionRules{
+		Action:      data_processing.RedactionDrop,
+		EntityTypes: map[string]data_processing.FieldRule{"service": {Deny: []string{"secret"}}},
+	})
+	if err != nil {
+		t.Fatalf("NewFieldRedactor() error = %v", err)
+	}
+
+	var event models.CDCEvent
+	event.Op = models.OpUpdate
+	event.After.Key = "c/123/o/service/456"
+	event.After.Value.Object = map[string]interface{}{"id": "456", "name": "new", "secret": "s3cr3t"}
+	event.Before = map[string]interface{}{"id": "456", "name": "old", "secret": "s3cr3t"}
+	var tombstone models.CDCEvent
+	tombstone.Op = models.OpDelete
+	tombstone.After.Key = "c/123/o/service/456"
+
+	t.Run("events are recorded redacted", func(t *testing.T) {
+		indexer := NewMockDocumentIndexer(false)
+		recorder := &MockEventSink{}
+		sinks := data_processing.NewFanOutSink(zap.NewNop())
+		sinks.AddSink(data_processing.NewIndexerSink("opensearch", indexer), data_processing.SinkRequired)
+		sinks.AddSink(recorder, data_processing.SinkRequired)
+		processor := NewCDCEventProcessor(zap.NewNop(), indexer, data_processing.NewCDCEntityExtractor(zap.NewNop()), "cdc")
+		processor.SetSink(sinks)
+		processor.SetRedactor(redactor)
+
+		for _, e := range []models.CDCEvent{event, tombstone} {
+			if err := processor.ProcessEvent(e); err != nil {
+				t.Fatalf("ProcessEvent() error = %v", err)
+			}
+		}
+		if len(recorder.events) != 2 || !recorder.events[1].IsDelete() {
+			t.Fatalf("recorded %d events, want the update and the delete", len(recorder.events))
+		}
+		recorded := recorder.events[0]
+		if _, ok := recorded.After.Value.Object.(map[string]interface{})["secret"]; ok {
+			t.Error("recorded object was not redacted")
+		}
+		if _, ok := recorded.Before.(map[string]interface{})["secret"]; ok {
+			t.Error("recorded before image was not redacted")
+		}
+		if _, ok := indexer.indexedDocs["cdc-service/456"]; !ok {
+			t.Error("document was not indexed")
+		}
+	})
+
+	t.Run("events whose writes fail are not recorded", func(t *testing.T) {
+		indexer := NewMockDocumentIndexer(true)
+		recorder := &MockEventSink{}
+		sinks := data_processing.NewFanOutSink(zap.NewNop())
+		sinks.AddSink(data_processing.NewIndexerSink("opensearch", indexer), data_processing.SinkRequired)
+		sinks.AddSink(recorder, data_processing.SinkRequired)
+		processor := NewCDCEventProcessor(zap.NewNop(), indexer, data_processing.NewCDCEntityExtractor(zap.NewNop()), "cdc")
+		processor.SetSink(sinks)
+		if err := processor.ProcessEvent(event); !IsSinkError(err) {
+			t.Fatalf("ProcessEvent() error = %v, want a sink error", err)
+		}
+		// The event is recorded when consumed again, not twice
+		if len(recorder.events) != 0 {
+			t.Errorf("recorded %d events, want none", len(recorder.events))
+		}
+	})
+
+	t.Run("superseded events are only recorded", func(t *testing.T) {
+		indexer := NewMockDocumentIndexer(false)
+		recorder := &MockEventSink{}
+		sinks := data_processing.NewFanOutSink(zap.NewNop())
+		sinks.AddSink(data_processing.NewIndexerSink("opensearch", indexer), data_processing.SinkRequired)
+		sinks.AddSink(recorder, data_processing.SinkRequired)
+		processor := NewCDCEventProcessor(zap.NewNop(), indexer, data_processing.NewCDCEntityExtractor(zap.NewNop()), "cdc")
+		processor.SetSink(sinks)
+		if err := processor.ProcessSupersededEvent(event); err != nil {
+			t.Fatalf("ProcessSupersededEvent() error = %v", err)
+		}
+		if len(recorder.events) != 1 || len(indexer.indexedDocs) != 0 {
+			t.Errorf("recorded %d events and indexed %d documents, want 1 and none", len(recorder.events), len(indexer.indexedDocs))
+		}
+	})
+
+	t.Run("failed recording is a sink error", func(t *testing.T) {
+		indexer := NewMockDocumentIndexer(false)
+		sinks := data_processing.NewFanOutSink(zap.NewNop())
+		sinks.AddSink(&MockEventSink{shouldFail: true}, data_processing.SinkRequired)
+		processor := NewCDCEventProcessor(zap.NewNop(), indexer, data_processing.NewCDCEntityExtractor(zap.NewNop()), "cdc")
+		processor.SetSink(sinks)
+		if err := processor.ProcessEvent(event); !IsSinkError(err) {
+			t.Errorf("ProcessEvent() error = %v, want a sink error", err)
+		}
+	})
+}
+
+func TestCDCEventProcessorDiffs(t *testing.T) {
+	var event models.CDCEvent
+	event.Op = models.OpUpdate
+	event.TsMs = 1706812484573
+	event.After.Key = "c/123/o/route/456"
+	event.After.Value.Object = map[string]interface{}{"id": "456", "name": "new", "paths": []interface{}{"/a", "/b"}, "updated_at": float64(2)}
+	event.Before = map[string]interface{}{"id": "456", "name": "old", "paths": []interface{}{"/a"}, "updated_at": float64(1)}
+	var tombstone models.CDCEvent
+	tombstone.Op = models.OpDelete
+	tombstone.TsMs = 1706812485573
+	tombstone.After.Key = "c/123/o/route/456"
+
+	indexer := &MockDocumentDeleter{NewMockDocumentIndexer(false)}
+	processor := NewCDCEventProcessor(zap.NewNop(), indexer, data_processing.NewCDCEntityExtractor(zap.NewNop()), "cdc")
+	processor.SetDiffer(data_processing.NewFieldDiffer([]string{"updated_at"}))
+	processor.SetChangeHistory(true)
+	processor.SetWriteFilter(NewChangeDetector(nil, 0, 0, zap.NewNop()))
+
+	if err := processor.ProcessEvent(event); err != nil {
+		t.Fatalf("ProcessEvent() error = %v", err)
+	}
+	document := indexer.indexedDocs["cdc-route/456"].(map[string]interface{})
+	want := data_processing.LastChange{
+		Op:            "update",
+		TsMs:          1706812484573,
+		ChangeSummary: data_processing.ChangeSummary{Changed: []string{"name", "paths"}},
+	}
+	if got := document[data_processing.LastChangeField]; !reflect.DeepEqual(got, want) {
+		t.Errorf("last_change = %+v, want %+v", got, want)
+	}
+	history, ok := indexer.indexedDocs["cdc-history/123:route:456:1706812484573"].(data_processing.HistoryDocument)
+	if !ok || len(history.Changes) != 2 || history.Op != "update" {
+		t.Fatalf("history document = %+v, want the update with 2 changes", history)
+	}
+
+	// The same content consumed again only differs by last_change, so it is neither rewritten
+	// nor recorded again
+	writes := len(indexer.history)
+	event.TsMs++
+	if err := processor.ProcessEvent(event); err != nil {
+		t.Fatalf("ProcessEvent() error = %v", err)
+	}
+	if len(indexer.history) != writes {
+		t.Errorf("unchanged document written %d more times", len(indexer.history)-writes)
+	}
+
+	if err := processor.ProcessEvent(tombstone); err != nil {
+		t.Fatalf("ProcessEvent() error = %v", err)
+	}
+	if history, ok := indexer.indexedDocs["cdc-history/123:route:456:1706812485573"].(data_processing.HistoryDocument); !ok || history.Op != "delete" {
+		t.Errorf("delete history document = %+v", history)
+	}
+}
+
+// renamingIndexer renames the parent service, as a concurrent worker would, while the first
+// route is being written
+type renamingIndexer struct {
+	*MockDocumentIndexer
+	enricher *data_processing.RelationEnricher
+	renamed  bool
+}
+
+func (r *renamingIndexer) IndexDocument(index, id string, document interface{}) error {
+	if index == "cdc-route" && !r.renamed {
+		r.renamed = true
+		r.enricher.Enrich("cp-1", "service", map[string]interface{}{"id": "svc-1", "name": "invoicing"})
+	}
+	return r.MockDocumentIndexer.IndexDocument(index, id, document)
+}
+
+func TestCDCEventProcessorReenrichesStaleChildren(t *testing.T) {
+	enricher := data_processing.NewRelationEnricher(data_processing.DefaultRelations(), "cdc", zap.NewNop())
+	enricher.Enrich("cp-1", "service", map[string]interface{}{"id": "svc-1", "name": "billing"})
+	indexer := &renamingIndexer{MockDocumentIndexer: NewMockDocumentIndexer(false), enricher: enricher}
+	processor := NewCDCEventProcessor(zap.NewNop(), indexer, data_processing.NewCDCEntityExtractor(zap.NewNop()), "cdc")
+	processor.AddEnricher(enricher)
+
+	var event models.CDCEvent
+	value := `{"after": {"key": "c/cp-1/o/route/route-1", "value": {"object": {"id": "route-1", "service": {"id": "svc-1"}}}}, "op": "c", "ts_ms": 1}`
+	if err := event.UnmarshalJSON([]byte(value)); err != nil {
+		t.Fatalf("UnmarshalJSON() error = %v", err)
+	}
+	if err := processor.ProcessEvent(event); err != nil {
+		t.Fatalf("ProcessEvent() error = %v", err)
+	}
+
+	document := indexer.indexedDocs["cdc-route/route-1"].(map[string]interface{})
+	if name := document["service"].(map[string]interface{})["name"]; name != "invoicing" {
+		t.Errorf("service name = %v, want the name of the concurrent rename", name)
+	}
+	if len(indexer.history) != 2 {
+		t.Errorf("route written %d times, want twice", len(indexer.history))
+	}
+}
@@ -0,0 +1,147 @@
+package consumer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/kong/konnect-ingest/internal/models"
+	"go.uber.org/zap"
+)
+
+const (
+	snapshotWriteAttempts = 5
+	snapshotRetryBackoff  = 200 * time.Millisecond
+)
+
+// KafkaSnapshotWriter implements SnapshotWriter by republishing every consumed record to a
+// log-compacted topic keyed by CDC key, with tombstones for deletes. Compaction keeps the
+// latest state per key, which a new consumer can load instead of replaying the full history.
+type KafkaSnapshotWriter struct {
+	producer     sarama.SyncProducer
+	topic        string
+	retryBackoff time.Duration
+	logger       *zap.Logger
+}
+
+// NewKafkaSnapshotWriter creates a snapshot writer producing to the given compacted topic
+func NewKafkaSnapshotWriter(brokers []string, topic string, logger *zap.Logger) (*KafkaSnapshotWriter, error) {
+	kafkaConfig := sarama.NewConfig()
+	kafkaConfig.Producer.RequiredAcks = sarama.WaitForAll
+	kafkaConfig.Producer.Retry.Max = 5
+	kafkaConfig.Producer.Return.Successes = true
+	kafkaConfig.Producer.Partitioner = sarama.NewHashPartitioner
+
+	producer, err := sarama.NewSyncProducer(brokers, kafkaConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KafkaSnapshotWriter{
+		producer:     producer,
+		topic:        topic,
+		retryBackoff: snapshotRetryBackoff,
+		logger:       logger,
+	}, nil
+}
+
+// WriteSnapshot publishes the latest state of the message's key, retrying transient failures
+func (w *KafkaSnapshotWriter) WriteSnapshot(message *sarama.ConsumerMessage, resume int64) error {
+	if len(message.Key) == 0 {
+		return nil
+	}
+
+	msg := &sarama.ProducerMessage{
+		Topic:   w.topic,
+		Key:     sarama.ByteEncoder(message.Key),
+		Headers: snapshotHeaders(message, resume),
+	}
+	if !isDeleteMessage(message) {
+		msg.Value = sarama.ByteEncoder(message.Value)
+	}
+
+	var err error
+	for attempt := 1; attempt <= snapshotWriteAttempts; attempt++ {
+		if _, _, err = w.producer.SendMessage(msg); err == nil {
+			return nil
+		}
+		w.logger.Warn("Failed to write snapshot record",
+			zap.String("key", string(message.Key)),
+			zap.Int("attempt", attempt),
+			zap.Error(err),
+		)
+		time.Sleep(w.retryBackoff * time.Duration(attempt))
+	}
+	return fmt.Errorf("failed to write snapshot record for %s: %w", message.Key, err)
+}
+
+// Close closes the snapshot producer
+func (w *KafkaSnapshotWriter) Close() error {
+	return w.producer.Close()
+}
+
+// snapshotHeaders keeps the CDC headers of the live record and records where it came from
+func snapshotHeaders(message *sarama.ConsumerMessage, resume int64) []sarama.RecordHeader {
+	headers := make([]sarama.RecordHeader, 0, len(message.Headers)+4)
+	for _, header := range message.Headers {
+		if header != nil {
+			headers = append(headers, *header)
+		}
+	}
+	return append(headers,
+		sarama.RecordHeader{Key: []byte(models.HeaderSourceTopic), Value: []byte(message.Topic)},
+		sarama.RecordHeader{Key: []byte(models.HeaderSourcePartition), Value: []byte(strconv.Itoa(int(message.Partition)))},
+		sarama.RecordHeader{Key: []byte(models.HeaderSourceOffset), Value: []byte(strconv.FormatInt(message.Offset, 10))},
+		sarama.RecordHeader{Key: []byte(models.HeaderSourceResume), Value: []byte(strconv.FormatInt(resume, 10))},
+	)
+}
+
+// isDeleteMessage decides from headers, or the payload when headers are missing, whether
+// the record deletes its key
+func isDeleteMessage(message *sarama.ConsumerMessage) bool {
+	if message.Value == nil {
+		return true
+	}
+	if headers, trusted := models.ParseEventHeaders(messageHeaders(message)); trusted && headers.Op != "" {
+		return headers.Op == models.OpDelete
+	}
+
+	var envelope struct {
+		Op string `json:"op"`
+	}
+	if err := json.Unmarshal(message.Value, &envelope); err != nil {
+		return false
+	}
+	return envelope.Op == models.OpDelete
+}
+
+// snapshotResume returns the live topic position a consumer loading the snapshot up to this
+// record can resume from. Records written before the resume header existed have none.
+func snapshotResume(message *sarama.ConsumerMessage) (topic string, partition int32, resume int64, ok bool) {
+	topic, partition, _, ok = snapshotSource(message)
+	if !ok {
+		return "", 0, 0, false
+	}
+	resume, err := strconv.ParseInt(messageHeaders(message)[models.HeaderSourceResume], 10, 64)
+	if err != nil || resume < 0 {
+		return "", 0, 0, false
+	}
+	return topic, partition, resume, true
+}
+
+// snapshotSource returns the live topic position a snapshot record was derived from
+func snapshotSource(message *sarama.ConsumerMessage) (topic string, partition int32, offset int64, ok bool) {
+	values := messageHeaders(message)
+	topic = values[models.HeaderSourceTopic]
+	p, err := strconv.ParseInt(values[models.HeaderSourcePartition], 10, 32)
+	if err != nil || topic == "" {
+		return "", 0, 0, false
+	}
+	offset, err = strconv.ParseInt(values[models.HeaderSourceOffset], 10, 64)
+	if err != nil {
+		return "", 0, 0, false
+	}
+	return topic, int32(p), offset, true
+}
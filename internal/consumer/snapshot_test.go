@@ -0,0 +1,140 @@
+package consumer
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/kong/konnect-ingest/internal/models"
+	"go.uber.org/zap"
+)
+
+func TestKafkaSnapshotWriter(t *testing.T) {
+	upsert := `{"after": {"key": "c/cp-1/o/service/svc-1", "value": {"object": {"id": "svc-1"}}}, "op": "u", "ts_ms": 1}`
+	deleted := `{"after": {"key": "c/cp-1/o/service/svc-1", "value": {}}, "op": "d", "ts_ms": 2}`
+
+	tests := []struct {
+		name          string
+		message       *sarama.ConsumerMessage
+		failures      int
+		wantErr       bool
+		wantTombstone bool
+	}{
+		{
+			name:    "upsert keeps the record value",
+			message: newTestMessage(7, "c/cp-1/o/service/svc-1", upsert, nil),
+		},
+		{
+			name:          "delete from payload becomes a tombstone",
+			message:       newTestMessage(8, "c/cp-1/o/service/svc-1", deleted, nil),
+			wantTombstone: true,
+		},
+		{
+			name: "delete from header becomes a tombstone",
+			message: newTestMessage(9, "c/cp-1/o/service/svc-1", upsert, map[string]string{
+				models.HeaderOp:            models.OpDelete,
+				models.HeaderSchemaVersion: models.HeaderSchemaVersionV1,
+			}),
+			wantTombstone: true,
+		},
+		{
+			name:     "transient failures are retried",
+			message:  newTestMessage(10, "c/cp-1/o/service/svc-1", upsert, nil),
+			failures: 1,
+		},
+		{
+			name:     "persistent failure is returned",
+			message:  newTestMessage(11, "c/cp-1/o/service/svc-1", upsert, nil),
+			failures: snapshotWriteAttempts,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			producer := &MockSyncProducer{failures: tt.failures}
+			writer := &KafkaSnapshotWriter{producer: producer, topic: "snapshot", logger: zap.NewNop()}
+
+			err := writer.WriteSnapshot(tt.message, tt.message.Offset+1)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WriteSnapshot() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if len(producer.messages) != 1 {
+				t.Fatalf("expected 1 snapshot record, got %d", len(producer.messages))
+			}
+			record := producer.messages[0]
+			if record.Topic != "snapshot" {
+				t.Errorf("snapshot topic = %s, want snapshot", record.Topic)
+			}
+			if (record.Value == nil) != tt.wantTombstone {
+				t.Errorf("snapshot value = %v, want tombstone %v", record.Value, tt.wantTombstone)
+			}
+
+			// The record must point back at its live position
+			consumed := &sarama.ConsumerMessage{}
+			for i := range record.Headers {
+				consumed.Headers = append(consumed.Headers, &record.Headers[i])
+			}
+			topic, partition, offset, ok := snapshotSource(consumed)
+			if !ok || topic != "test-topic" || partition != 0 || offset != tt.message.Offset {
+				t.Errorf("snapshotSource() = %s/%d/%d %v, want test-topic/0/%d", topic, partition, offset, ok, tt.message.Offset)
+			}
+			if _, _, resume, ok := snapshotResume(consumed); !ok || resume != tt.message.Offset+1 {
+				t.Errorf("snapshotResume() = %d %v, want %d", resume, ok, tt.message.Offset+1)
+			}
+		})
+	}
+}
+
+func TestSourcePositions(t *testing.T) {
+	record := func(topic string, partition string, offset string, resume string) *sarama.ConsumerMessage {
+		headers := map[string]string{
+			models.HeaderSourceTopic:     topic,
+			models.HeaderSourcePartition: partition,
+			models.HeaderSourceOffset:    offset,
+		}
+		if resume != "" {
+			headers[models.HeaderSourceResume] = resume
+		}
+		return newTestMessage(0, "key", "", headers)
+	}
+
+	positions := newSourcePositions("cdc-events")
+	// Offset 41 completed while 30 was still in flight
+	positions.observe(record("cdc-events", "0", "41", "30"))
+	positions.observe(record("cdc-events", "0", "12", "13"))
+	positions.observe(record("cdc-events", "2", "5", "6"))
+	positions.observe(record("cdc-events", "3", "8", ""))
+	positions.observe(record("other-topic", "1", "99", "100"))
+	positions.observe(newTestMessage(0, "key", "", nil))
+
+	want := map[int32]int64{0: 30, 2: 6}
+	if got := positions.nextOffsets(); !reflect.DeepEqual(got, want) {
+		t.Errorf("nextOffsets() = %v, want %v", got, want)
+	}
+}
+
+func TestKafkaConsumerHandlerSnapshotFailure(t *testing.T) {
+	value := `{"after": {"key": "c/cp-1/o/service/svc-1", "value": {"object": {"id": "svc-1"}}}, "op": "c", "ts_ms": 1}`
+
+	handler := NewKafkaConsumerHandler(zap.NewNop())
+	handler.SetEventProcessor(&MockEventProcessor{})
+	handler.SetSnapshotWriter(&MockSnapshotWriter{shouldFail: true})
+
+	session := NewMockConsumerGroupSession(context.Background())
+	claim := NewMockConsumerGroupClaim("test-topic", 0, []*sarama.ConsumerMessage{
+		newTestMessage(3, "c/cp-1/o/service/svc-1", value, nil),
+	})
+
+	if err := handler.ConsumeClaim(session, claim); err == nil {
+		t.Fatalf("ConsumeClaim() expected error when the snapshot cannot be written")
+	}
+	if _, marked := session.marked[0]; marked {
+		t.Errorf("offset was marked although the snapshot was not written")
+	}
+}
@@ -0,0 +1,33 @@
+package consumer
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"time"
+)
+
+// controlPlaneStatus is a freshness watermark with its age at the time of the request
+type controlPlaneStatus struct {
+	Watermark
+	AgeMs int64 `json:"age_ms"`
+}
+
+// NewStatusHandler returns the HTTP handler of the consumer status endpoint: /status reports
+// the freshness watermark per control plane and /debug/vars the expvar metrics
+func NewStatusHandler(tracker *FreshnessTracker) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now().UnixMilli()
+		watermarks := tracker.Watermarks()
+		statuses := make([]controlPlaneStatus, 0, len(watermarks))
+		for _, watermark := range watermarks {
+			statuses = append(statuses, controlPlaneStatus{Watermark: watermark, AgeMs: now - watermark.UpdatedAt})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"control_planes": statuses})
+	})
+	return mux
+}
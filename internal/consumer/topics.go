@@ -0,0 +1,231 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/kong/konnect-ingest/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// compileTopicPattern compiles a pattern matched against whole topic names
+func compileTopicPattern(pattern string) (*regexp.Regexp, error) {
+	compiled, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid topic pattern %q: %w", pattern, err)
+	}
+	return compiled, nil
+}
+
+// TopicSubscription resolves the topics the consumer subscribes to: a fixed list plus the
+// topics matching patterns, refreshed from the cluster metadata so that topics created later
+// (one per table or per shard) are picked up
+type TopicSubscription struct {
+	topics   []string
+	patterns []*regexp.Regexp
+	lister   TopicLister
+	logger   *zap.Logger
+
+	mu      sync.Mutex
+	current []string
+	changed chan struct{}
+}
+
+// NewTopicSubscription creates a subscription to the given topics and the topics matching
+// the patterns. The lister is only used when patterns are given.
+func NewTopicSubscription(topics []string, patterns []string, lister TopicLister, logger *zap.Logger) (*TopicSubscription, error) {
+	s := &TopicSubscription{
+		topics:  topics,
+		lister:  lister,
+		logger:  logger,
+		changed: make(chan struct{}, 1),
+	}
+	for _, pattern := range patterns {
+		compiled, err := compileTopicPattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		s.patterns = append(s.patterns, compiled)
+	}
+	if _, err := s.Refresh(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Topics returns the topics currently subscribed to, sorted
+func (s *TopicSubscription) Topics() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.current...)
+}
+
+// Changed is signalled when a refresh changed the subscribed topics. The consumer group
+// session must then be restarted to subscribe to them.
+func (s *TopicSubscription) Changed() <-chan struct{} {
+	return s.changed
+}
+
+// Refresh resolves the subscribed topics again and reports whether they changed
+func (s *TopicSubscription) Refresh() (bool, error) {
+	resolved := make(map[string]bool, len(s.topics))
+	for _, topic := range s.topics {
+		resolved[topic] = true
+	}
+	if len(s.patterns) > 0 {
+		if err := s.lister.RefreshMetadata(); err != nil {
+			return false, fmt.Errorf("failed to refresh topic metadata: %w", err)
+		}
+		existing, err := s.lister.Topics()
+		if err != nil {
+			return false, fmt.Errorf("failed to list topics: %w", err)
+		}
+		for _, topic := range existing {
+			for _, pattern := range s.patterns {
+				if pattern.MatchString(topic) {
+					resolved[topic] = true
+					break
+				}
+			}
+		}
+	}
+	topics := make([]string, 0, len(resolved))
+	for topic := range resolved {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	s.mu.Lock()
+	previous := s.current
+	changed := !reflect.DeepEqual(previous, topics)
+	s.current = topics
+	s.mu.Unlock()
+
+	if changed && previous != nil {
+		s.logger.Info("Subscribed topics changed",
+			zap.Strings("previous", previous),
+			zap.Strings("topics", topics),
+		)
+		select {
+		case s.changed <- struct{}{}:
+		default:
+		}
+	}
+	return changed, nil
+}
+
+// Run refreshes the subscription every interval until the context is done
+func (s *TopicSubscription) Run(ctx context.Context, interval time.Duration) {
+	if len(s.patterns) == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := s.Refresh(); err != nil {
+				s.logger.Error("Failed to refresh topic subscription", zap.Error(err))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// topicRoute routes the messages of the topics matching a pattern to a processor
+type topicRoute struct {
+	pattern   *regexp.Regexp
+	processor EventProcessor
+}
+
+// topicCounters are the metrics of one topic
+type topicCounters struct {
+	messages int64
+	errors   int64
+}
+
+// partitionProgress is the last processed offset of a claimed partition
+type partitionProgress struct {
+	claim  sarama.ConsumerGroupClaim
+	offset int64
+}
+
+// topicStats counts the messages and processing errors per topic and computes the lag of
+// the claimed partitions. It is published as the topics expvar.
+type topicStats struct {
+	mu         sync.Mutex
+	topics     map[string]*topicCounters
+	partitions map[claimKey]*partitionProgress
+}
+
+func newTopicStats() *topicStats {
+	stats := &topicStats{
+		topics:     make(map[string]*topicCounters),
+		partitions: make(map[claimKey]*partitionProgress),
+	}
+	metrics.Func("topics", func() interface{} { return stats.snapshot() })
+	return stats
+}
+
+// claimed starts tracking the lag of a claimed partition
+func (s *topicStats) claimed(claim sarama.ConsumerGroupClaim) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.partitions[claimKey{topic: claim.Topic(), partition: claim.Partition()}] = &partitionProgress{claim: claim, offset: claim.InitialOffset() - 1}
+}
+
+// released stops tracking a partition that is no longer claimed
+func (s *topicStats) released(claim sarama.ConsumerGroupClaim) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.partitions, claimKey{topic: claim.Topic(), partition: claim.Partition()})
+}
+
+// processed records a processed message
+func (s *topicStats) processed(message *sarama.ConsumerMessage, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counters, ok := s.topics[message.Topic]
+	if !ok {
+		counters = &topicCounters{}
+		s.topics[message.Topic] = counters
+	}
+	counters.messages++
+	if err != nil {
+		counters.errors++
+	}
+	if progress, ok := s.partitions[claimKey{topic: message.Topic, partition: message.Partition}]; ok && message.Offset > progress.offset {
+		progress.offset = message.Offset
+	}
+}
+
+// snapshot returns the messages, errors and lag per topic
+func (s *topicStats) snapshot() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lags := make(map[string]int64)
+	for key, progress := range s.partitions {
+		if lag := progress.claim.HighWaterMarkOffset() - progress.offset - 1; lag > 0 {
+			lags[key.topic] += lag
+		} else if _, ok := lags[key.topic]; !ok {
+			lags[key.topic] = 0
+		}
+	}
+	result := make(map[string]interface{})
+	for topic, counters := range s.topics {
+		result[topic] = map[string]int64{"messages": counters.messages, "errors": counters.errors, "lag": lags[topic]}
+	}
+	for topic, lag := range lags {
+		if _, ok := s.topics[topic]; !ok {
+			result[topic] = map[string]int64{"messages": 0, "errors": 0, "lag": lag}
+		}
+	}
+	return result
+}
@@ -0,0 +1,108 @@
+package consumer
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"go.uber.org/zap"
+)
+
+// fakeTopicLister lists a mutable set of topics
+type fakeTopicLister struct {
+	topics    []string
+	refreshes int
+}
+
+func (f *fakeTopicLister) RefreshMetadata(topics ...string) error {
+	f.refreshes++
+	return nil
+}
+
+func (f *fakeTopicLister) Topics() ([]string, error) {
+	return f.topics, nil
+}
+
+func TestTopicSubscription(t *testing.T) {
+	lister := &fakeTopicLister{topics: []string{"cdc-events", "kong.public.services", "kong.public.routes", "kong.audit"}}
+	subscription, err := NewTopicSubscription([]string{"cdc-events", "cdc-events-retry"}, []string{`kong\.public\..*`}, lister, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewTopicSubscription() error = %v", err)
+	}
+	want := []string{"cdc-events", "cdc-events-retry", "kong.public.routes", "kong.public.services"}
+	if got := subscription.Topics(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Topics() = %v, want %v", got, want)
+	}
+
+	// Unchanged metadata does not restart the session
+	if changed, err := subscription.Refresh(); err != nil || changed {
+		t.Fatalf("Refresh() = %v, %v, want no change", changed, err)
+	}
+	select {
+	case <-subscription.Changed():
+		t.Fatal("Changed() signalled without a change")
+	default:
+	}
+
+	// A topic created for a new table is picked up
+	lister.topics = append(lister.topics, "kong.public.upstreams")
+	if changed, err := subscription.Refresh(); err != nil || !changed {
+		t.Fatalf("Refresh() = %v, %v, want a change", changed, err)
+	}
+	select {
+	case <-subscription.Changed():
+	default:
+		t.Fatal("Changed() not signalled")
+	}
+	want = []string{"cdc-events", "cdc-events-retry", "kong.public.routes", "kong.public.services", "kong.public.upstreams"}
+	if got := subscription.Topics(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Topics() = %v, want %v", got, want)
+	}
+
+	if _, err := NewTopicSubscription(nil, []string{"kong.("}, lister, zap.NewNop()); err == nil {
+		t.Error("NewTopicSubscription() should reject an invalid pattern")
+	}
+}
+
+func TestKafkaConsumerHandlerTopicRouting(t *testing.T) {
+	defaultProcessor := &MockEventProcessor{}
+	tableProcessor := &MockEventProcessor{}
+	handler := NewKafkaConsumerHandler(zap.NewNop())
+	handler.SetEventProcessor(defaultProcessor)
+	if err := handler.SetTopicProcessor(`kong\.public\.[a-z_]+`, tableProcessor); err != nil {
+		t.Fatalf("SetTopicProcessor() error = %v", err)
+	}
+
+	value := `{"after": {"key": "c/cp-1/o/service/svc-1", "value": {"object": {"id": "svc-1"}}}, "op": "c", "ts_ms": 1}`
+	claims := map[string][]*sarama.ConsumerMessage{
+		"cdc-events":               {newTestMessage(0, "c/cp-1/o/service/svc-1", value, nil)},
+		"kong.public.services":     {newTestMessage(0, "svc-1", value, nil), newTestMessage(1, "svc-1", value, nil)},
+		"kong.public.services.dlq": {newTestMessage(0, "svc-1", value, nil)},
+	}
+	session := NewMockConsumerGroupSession(context.Background())
+	for topic, messages := range claims {
+		for _, message := range messages {
+			message.Topic = topic
+		}
+		if err := handler.ConsumeClaim(session, NewMockConsumerGroupClaim(topic, 0, messages)); err != nil {
+			t.Fatalf("ConsumeClaim(%s) error = %v", topic, err)
+		}
+	}
+
+	if len(tableProcessor.events) != 2 {
+		t.Errorf("table topic processor got %d events, want 2", len(tableProcessor.events))
+	}
+	if len(defaultProcessor.events) != 2 {
+		t.Errorf("default processor got %d events, want 2", len(defaultProcessor.events))
+	}
+
+	stats := handler.topicStats.snapshot()
+	want := map[string]int64{"messages": 2, "errors": 0, "lag": 0}
+	if got := stats["kong.public.services"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("kong.public.services stats = %v, want %v", got, want)
+	}
+	if len(stats) != 3 {
+		t.Errorf("stats cover %d topics, want 3", len(stats))
+	}
+}
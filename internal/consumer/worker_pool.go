@@ -0,0 +1,161 @@
+package consumer
+
+import (
+	"hash/fnv"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"github.com/kong/konnect-ingest/internal/models"
+)
+
+// DefaultMaxInFlight bounds the messages dispatched to the workers of a partition but not
+// completed yet
+const DefaultMaxInFlight = 256
+
+// offsetTracker tracks the offsets dispatched on a partition and computes the watermark: the
+// highest offset such that every dispatched offset up to it is completed
+type offsetTracker struct {
+	pending   []int64
+	completed map[int64]bool
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{completed: make(map[int64]bool)}
+}
+
+// dispatched records an offset handed to a worker. Offsets must be dispatched in increasing order.
+func (t *offsetTracker) dispatched(offset int64) {
+	t.pending = append(t.pending, offset)
+}
+
+// complete records a completed offset and returns the new watermark, or false when it did
+// not advance
+func (t *offsetTracker) complete(offset int64) (int64, bool) {
+	t.completed[offset] = true
+
+	watermark, advanced := int64(0), false
+	for len(t.pending) > 0 && t.completed[t.pending[0]] {
+		watermark, advanced = t.pending[0], true
+		delete(t.completed, t.pending[0])
+		t.pending = t.pending[1:]
+	}
+	return watermark, advanced
+}
+
+type workResult struct {
+	message *sarama.ConsumerMessage
+	err     error
+}
+
+// partitionWorkers processes the messages of one partition concurrently. Messages are
+// sharded on their ordering key, so messages sharing a key are processed by the same worker
+// in offset order. Offsets are reported through onWatermark only once every message up to
+// them is processed.
+type partitionWorkers struct {
+	queues      []chan *sarama.ConsumerMessage
+	results     chan workResult
+	tracker     *offsetTracker
+	ordering    models.OrderingScope
+	maxInFlight int
+	// limit scales maxInFlight down under backpressure
+	limit       func(int) int
+	inFlight    int
+	onWatermark func(offset int64)
+	err         error
+	wg          sync.WaitGroup
+}
+
+// newPartitionWorkers starts the workers of a partition
+func newPartitionWorkers(
+	workers int,
+	maxInFlight int,
+	ordering models.OrderingScope,
+	process func(*sarama.ConsumerMessage) error,
+	onWatermark func(offset int64),
+) *partitionWorkers {
+	if maxInFlight < 1 {
+		maxInFlight = DefaultMaxInFlight
+	}
+	p := &partitionWorkers{
+		queues:      make([]chan *sarama.ConsumerMessage, workers),
+		results:     make(chan workResult, maxInFlight),
+		tracker:     newOffsetTracker(),
+		ordering:    ordering,
+		maxInFlight: maxInFlight,
+		limit:       func(size int) int { return size },
+		onWatermark: onWatermark,
+	}
+	for i := range p.queues {
+		// Never blocks: at most maxInFlight messages are queued across all workers
+		p.queues[i] = make(chan *sarama.ConsumerMessage, maxInFlight)
+		p.wg.Add(1)
+		go func(queue chan *sarama.ConsumerMessage) {
+			defer p.wg.Done()
+			for message := range queue {
+				p.results <- workResult{message: message, err: process(message)}
+			}
+		}(p.queues[i])
+	}
+	return p
+}
+
+// dispatch hands a message to the worker of its ordering key, first waiting for a completion
+// when the in-flight limit is reached. It returns the first processing error.
+func (p *partitionWorkers) dispatch(message *sarama.ConsumerMessage) error {
+	for p.inFlight >= p.limit(p.maxInFlight) && p.err == nil {
+		p.complete(<-p.results)
+	}
+	if p.err != nil {
+		return p.err
+	}
+
+	p.tracker.dispatched(message.Offset)
+	p.inFlight++
+	p.queues[p.shard(message)] <- message
+	return nil
+}
+
+// complete records the result of a processed message and reports an advanced watermark
+func (p *partitionWorkers) complete(result workResult) {
+	p.inFlight--
+	if result.err != nil {
+		// The watermark no longer advances, so the failed message is consumed again
+		if p.err == nil {
+			p.err = result.err
+		}
+		return
+	}
+	if p.err != nil {
+		return
+	}
+	if watermark, advanced := p.tracker.complete(result.message.Offset); advanced {
+		p.onWatermark(watermark)
+	}
+}
+
+// drain waits for every in-flight message and returns the first processing error
+func (p *partitionWorkers) drain() error {
+	for p.inFlight > 0 {
+		p.complete(<-p.results)
+	}
+	return p.err
+}
+
+// close drains the workers and stops them
+func (p *partitionWorkers) close() error {
+	err := p.drain()
+	for _, queue := range p.queues {
+		close(queue)
+	}
+	p.wg.Wait()
+	return err
+}
+
+func (p *partitionWorkers) shard(message *sarama.ConsumerMessage) int {
+	if len(p.queues) == 1 {
+		return 0
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(p.ordering.OrderingKey(string(message.Key))))
+	return int(hash.Sum32() % uint32(len(p.queues)))
+}
@@ -0,0 +1,267 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/kong/konnect-ingest/internal/models"
+	"go.uber.org/zap"
+)
+
+func TestOffsetTracker(t *testing.T) {
+	tests := []struct {
+		name       string
+		dispatched []int64
+		completed  []int64
+		want       []int64
+	}{
+		{
+			name:       "in order",
+			dispatched: []int64{1, 2, 3},
+			completed:  []int64{1, 2, 3},
+			want:       []int64{1, 2, 3},
+		},
+		{
+			name:       "waits for the oldest pending offset",
+			dispatched: []int64{1, 2, 3},
+			completed:  []int64{3, 2, 1},
+			want:       []int64{-1, -1, 3},
+		},
+		{
+			name:       "gaps between dispatched offsets",
+			dispatched: []int64{5, 8, 13},
+			completed:  []int64{8, 5, 13},
+			want:       []int64{-1, 8, 13},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := newOffsetTracker()
+			for _, offset := range tt.dispatched {
+				tracker.dispatched(offset)
+			}
+			var got []int64
+			for _, offset := range tt.completed {
+				watermark, advanced := tracker.complete(offset)
+				if !advanced {
+					watermark = -1
+				}
+				got = append(got, watermark)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("watermarks = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// latencyProcessor processes events with a random latency and records the order per key
+type latencyProcessor struct {
+	mu        sync.Mutex
+	rand      *rand.Rand
+	processed map[int64]bool
+	perKey    map[string][]int64
+}
+
+func newLatencyProcessor(seed int64) *latencyProcessor {
+	return &latencyProcessor{
+		rand:      rand.New(rand.NewSource(seed)),
+		processed: make(map[int64]bool),
+		perKey:    make(map[string][]int64),
+	}
+}
+
+func (p *latencyProcessor) ProcessEvent(event models.CDCEvent) error {
+	p.mu.Lock()
+	latency := time.Duration(p.rand.Intn(500)) * time.Microsecond
+	p.mu.Unlock()
+	time.Sleep(latency)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.processed[event.TsMs] = true
+	p.perKey[event.After.Key] = append(p.perKey[event.After.Key], event.TsMs)
+	return nil
+}
+
+// checkingSession fails the test when an offset is marked before every message below it
+// was processed
+type checkingSession struct {
+	*MockConsumerGroupSession
+	t         *testing.T
+	processor *latencyProcessor
+}
+
+func (s *checkingSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	s.processor.mu.Lock()
+	for previous := int64(0); previous < offset; previous++ {
+		if !s.processor.processed[previous] {
+			s.t.Errorf("offset %d marked before offset %d was processed", offset, previous)
+			break
+		}
+	}
+	s.processor.mu.Unlock()
+	s.MockConsumerGroupSession.MarkOffset(topic, partition, offset, metadata)
+}
+
+func (s *checkingSession) MarkMessage(message *sarama.ConsumerMessage, metadata string) {
+	s.MarkOffset(message.Topic, message.Partition, message.Offset+1, metadata)
+}
+
+func TestKafkaConsumerHandlerConcurrency(t *testing.T) {
+	const messageCount = 500
+	keys := []string{"c/cp-1/o/node/node-1", "c/cp-1/o/node/node-2", "c/cp-1/o/service/svc-1", "c/cp-2/o/route/route-1", "c/cp-2/o/route/route-2"}
+
+	tests := []struct {
+		name        string
+		workers     int
+		maxInFlight int
+		strategy    string
+	}{
+		{name: "per entity", workers: 4, maxInFlight: 16, strategy: models.PartitionByKey},
+		{name: "per control plane", workers: 4, maxInFlight: 16, strategy: models.PartitionByControlPlane},
+		{name: "more workers than keys", workers: 16, maxInFlight: 3, strategy: models.PartitionByKey},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			random := rand.New(rand.NewSource(int64(i)))
+			messages := make([]*sarama.ConsumerMessage, messageCount)
+			for offset := range messages {
+				key := keys[random.Intn(len(keys))]
+				value := fmt.Sprintf(`{"after": {"key": %q, "value": {"object": {"id": "x"}}}, "ts_ms": %d}`, key, offset)
+				messages[offset] = newTestMessage(int64(offset), key, value, nil)
+			}
+
+			processor := newLatencyProcessor(int64(i))
+			snapshots := &MockSnapshotWriter{}
+			handler := NewKafkaConsumerHandler(zap.NewNop())
+			handler.SetEventProcessor(processor)
+			handler.SetSnapshotWriter(snapshots)
+			handler.SetPartitionStrategy(tt.strategy)
+			handler.SetConcurrency(tt.workers, tt.maxInFlight)
+
+			session := &checkingSession{MockConsumerGroupSession: NewMockConsumerGroupSession(context.Background()), t: t, processor: processor}
+			if err := handler.ConsumeClaim(session, NewMockConsumerGroupClaim("test-topic", 0, messages)); err != nil {
+				t.Fatalf("ConsumeClaim() error = %v", err)
+			}
+
+			if len(processor.processed) != messageCount {
+				t.Fatalf("processed %d messages, want %d", len(processor.processed), messageCount)
+			}
+			for key, offsets := range processor.perKey {
+				for j := 1; j < len(offsets); j++ {
+					if offsets[j] < offsets[j-1] {
+						t.Fatalf("%s processed out of order: %d after %d", key, offsets[j], offsets[j-1])
+					}
+				}
+			}
+			if session.marked[0] != messageCount {
+				t.Errorf("marked offset = %d, want %d", session.marked[0], messageCount)
+			}
+
+			// A bootstrap resuming from a record's resume offset must find every earlier
+			// record in the snapshot already
+			inSnapshot := make(map[int64]bool, messageCount)
+			for _, message := range snapshots.written {
+				resume := snapshots.resumes[message.Offset]
+				for previous := int64(0); previous < resume; previous++ {
+					if !inSnapshot[previous] && previous != message.Offset {
+						t.Fatalf("offset %d resumes from %d before offset %d is in the snapshot", message.Offset, resume, previous)
+					}
+				}
+				inSnapshot[message.Offset] = true
+			}
+		})
+	}
+}
+
+// blockingProcessor blocks every event until released
+type blockingProcessor struct {
+	mu      sync.Mutex
+	started int
+	release chan struct{}
+}
+
+func (p *blockingProcessor) ProcessEvent(event models.CDCEvent) error {
+	p.mu.Lock()
+	p.started++
+	p.mu.Unlock()
+	<-p.release
+	return nil
+}
+
+func TestKafkaConsumerHandlerBoundsInFlight(t *testing.T) {
+	const maxInFlight = 3
+	processor := &blockingProcessor{release: make(chan struct{})}
+	handler := NewKafkaConsumerHandler(zap.NewNop())
+	handler.SetEventProcessor(processor)
+	handler.SetConcurrency(8, maxInFlight)
+
+	claim := &MockConsumerGroupClaim{topic: "test-topic", messages: make(chan *sarama.ConsumerMessage, 20)}
+	for offset := 0; offset < 20; offset++ {
+		key := fmt.Sprintf("c/cp-1/o/service/svc-%d", offset)
+		value := fmt.Sprintf(`{"after": {"key": %q, "value": {"object": {"id": "x"}}}, "ts_ms": %d}`, key, offset)
+		claim.messages <- newTestMessage(int64(offset), key, value, nil)
+	}
+	close(claim.messages)
+
+	session := NewMockConsumerGroupSession(context.Background())
+	done := make(chan error)
+	go func() { done <- handler.ConsumeClaim(session, claim) }()
+
+	time.Sleep(50 * time.Millisecond)
+	processor.mu.Lock()
+	started := processor.started
+	processor.mu.Unlock()
+	if started > maxInFlight {
+		t.Errorf("%d messages in flight, want at most %d", started, maxInFlight)
+	}
+	if remaining := len(claim.messages); remaining < 20-maxInFlight-1 {
+		t.Errorf("%d messages left in the claim, the handler read ahead", remaining)
+	}
+
+	close(processor.release)
+	if err := <-done; err != nil {
+		t.Fatalf("ConsumeClaim() error = %v", err)
+	}
+	if session.marked[0] != 20 {
+		t.Errorf("marked offset = %d, want 20", session.marked[0])
+	}
+}
+
+func TestKafkaConsumerHandlerConcurrentCoalescing(t *testing.T) {
+	messages := make([]*sarama.ConsumerMessage, 0, 200)
+	for offset := 0; offset < 200; offset++ {
+		key := fmt.Sprintf("c/cp-1/o/service/svc-%d", offset%7)
+		value := fmt.Sprintf(`{"after": {"key": %q, "value": {"object": {"id": "x"}}}, "ts_ms": %d}`, key, offset)
+		messages = append(messages, newTestMessage(int64(offset), key, value, nil))
+	}
+
+	processor := newLatencyProcessor(1)
+	handler := NewKafkaConsumerHandler(zap.NewNop())
+	handler.SetEventProcessor(processor)
+	handler.SetCoalescing(time.Hour, 5)
+	handler.SetConcurrency(4, 8)
+
+	session := NewMockConsumerGroupSession(context.Background())
+	if err := handler.ConsumeClaim(session, NewMockConsumerGroupClaim("test-topic", 0, messages)); err != nil {
+		t.Fatalf("ConsumeClaim() error = %v", err)
+	}
+
+	for offset := 193; offset < 200; offset++ {
+		if !processor.processed[int64(offset)] {
+			t.Errorf("latest update at offset %d was not processed", offset)
+		}
+	}
+	if session.marked[0] != 200 {
+		t.Errorf("marked offset = %d, want 200", session.marked[0])
+	}
+}
@@ -0,0 +1,208 @@
+package data_processing
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultAliasRefreshInterval is how often dual-write targets are re-resolved
+const DefaultAliasRefreshInterval = 10 * time.Second
+
+// AliasIndexer implements DocumentIndexer on top of versioned indices. Writes for a logical
+// index go to its write alias, plus every generation carrying the dual-write alias, which
+// lets a reindex build or keep another generation current without stopping the consumer.
+// Deletes leave a tombstone in generations carrying the catch-up alias instead, so that a
+// catch-up only creating missing documents cannot bring the deleted document back.
+type AliasIndexer struct {
+	indexer         DocumentIndexer
+	resolver        AliasResolver
+	refreshInterval time.Duration
+	logger          *zap.Logger
+
+	mu      sync.Mutex
+	ensured map[string]bool
+	targets map[string]aliasTargets
+	now     func() time.Time
+}
+
+type aliasTargets struct {
+	indices    []string
+	resolvedAt time.Time
+}
+
+// NewAliasIndexer creates an indexer writing through aliases of the wrapped indexer
+func NewAliasIndexer(indexer DocumentIndexer, resolver AliasResolver, refreshInterval time.Duration, logger *zap.Logger) *AliasIndexer {
+	if refreshInterval <= 0 {
+		refreshInterval = DefaultAliasRefreshInterval
+	}
+	return &AliasIndexer{
+		indexer:         indexer,
+		resolver:        resolver,
+		refreshInterval: refreshInterval,
+		logger:          logger,
+		ensured:         make(map[string]bool),
+		targets:         make(map[string]aliasTargets),
+		now:             time.Now,
+	}
+}
+
+// IndexDocument writes the document through the write alias and to any dual-write generation
+func (a *AliasIndexer) IndexDocument(indexName string, id string, document interface{}) error {
+	if err := a.ensure(indexName); err != nil {
+		return err
+	}
+
+	if err := a.indexer.IndexDocument(WriteAlias(indexName), id, document); err != nil {
+		return err
+	}
+
+	for _, target := range a.dualWriteTargets(indexName) {
+		if err := a.indexer.IndexDocument(target, id, document); err != nil {
+			a.logger.Error("Failed to dual-write document",
+				zap.String("index", target),
+				zap.String("id", id),
+				zap.Error(err),
+			)
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteDocument deletes the document through the write alias and from any dual-write
+// generation, leaving a tombstone in generations being caught up
+func (a *AliasIndexer) DeleteDocument(indexName string, id string) error {
+	deleter, ok := a.indexer.(DocumentDeleter)
+	if !ok {
+		return ErrUnsupported
+	}
+	if err := a.ensure(indexName); err != nil {
+		return err
+	}
+
+	if err := deleter.DeleteDocument(WriteAlias(indexName), id); err != nil {
+		return err
+	}
+
+	catchUps := a.resolve(CatchUpAlias(indexName))
+	for _, target := range a.dualWriteTargets(indexName) {
+		var err error
+		if containsIndex(catchUps, target) {
+			err = a.indexer.IndexDocument(target, id, map[string]interface{}{TombstoneField: true})
+		} else {
+			err = deleter.DeleteDocument(target, id)
+		}
+		if err != nil {
+			a.logger.Error("Failed to dual-delete document",
+				zap.String("index", target),
+				zap.String("id", id),
+				zap.Error(err),
+			)
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateReferenceName updates the references through the write alias and in any dual-write
+// generation, and returns the number of documents updated through the write alias
+func (a *AliasIndexer) UpdateReferenceName(indexName string, field string, parentID string, name string) (int64, error) {
+	updater, ok := a.indexer.(ReferenceUpdater)
+	if !ok {
+		return 0, ErrUnsupported
+	}
+	if err := a.ensure(indexName); err != nil {
+		return 0, err
+	}
+
+	updated, err := updater.UpdateReferenceName(WriteAlias(indexName), field, parentID, name)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, target := range a.dualWriteTargets(indexName) {
+		if _, err := updater.UpdateReferenceName(target, field, parentID, name); err != nil {
+			a.logger.Error("Failed to dual-update references",
+				zap.String("index", target),
+				zap.String("parentID", parentID),
+				zap.Error(err),
+			)
+			return updated, err
+		}
+	}
+	return updated, nil
+}
+
+// UpdateControlPlane restamps the control plane on the matching indices. The pattern matches
+// the physical generations themselves (cdc-* matches cdc-service-v1 and cdc-service-v2), so
+// every generation is updated, dual-written or not.
+func (a *AliasIndexer) UpdateControlPlane(indexPattern string, controlPlane map[string]interface{}) (int64, error) {
+	updater, ok := a.indexer.(ControlPlaneUpdater)
+	if !ok {
+		return 0, ErrUnsupported
+	}
+	return updater.UpdateControlPlane(indexPattern, controlPlane)
+}
+
+func (a *AliasIndexer) ensure(indexName string) error {
+	a.mu.Lock()
+	ensured := a.ensured[indexName]
+	a.mu.Unlock()
+	if ensured {
+		return nil
+	}
+
+	if err := a.resolver.EnsureIndex(indexName); err != nil {
+		a.logger.Error("Failed to ensure versioned index", zap.String("index", indexName), zap.Error(err))
+		return err
+	}
+
+	a.mu.Lock()
+	a.ensured[indexName] = true
+	a.mu.Unlock()
+	return nil
+}
+
+// dualWriteTargets returns the cached dual-write generations of a logical index
+func (a *AliasIndexer) dualWriteTargets(indexName string) []string {
+	return a.resolve(DualWriteAlias(indexName))
+}
+
+// resolve returns the cached indices of an alias, re-resolving them periodically. Resolution
+// failures keep the previous indices rather than silently dropping dual writes.
+func (a *AliasIndexer) resolve(alias string) []string {
+	a.mu.Lock()
+	cached, ok := a.targets[alias]
+	a.mu.Unlock()
+	if ok && a.now().Sub(cached.resolvedAt) < a.refreshInterval {
+		return cached.indices
+	}
+
+	indices, err := a.resolver.Resolve(alias)
+	if err != nil {
+		a.logger.Warn("Failed to resolve alias", zap.String("alias", alias), zap.Error(err))
+		return cached.indices
+	}
+	if len(indices) != len(cached.indices) {
+		a.logger.Info("Alias targets changed",
+			zap.String("alias", alias),
+			zap.Strings("targets", indices),
+		)
+	}
+
+	a.mu.Lock()
+	a.targets[alias] = aliasTargets{indices: indices, resolvedAt: a.now()}
+	a.mu.Unlock()
+	return indices
+}
+
+func containsIndex(indices []string, index string) bool {
+	for _, candidate := range indices {
+		if candidate == index {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package data_processing
+
+import (
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestAliasIndexerUpdates(t *testing.T) {
+	store := MockUpdatingIndexer{NewMockDocumentStore()}
+	resolver := &MockAliasResolver{aliases: map[string][]string{DualWriteAlias("cdc-route"): {"cdc-route-v2"}}}
+	indexer := NewAliasIndexer(store, resolver, 0, zap.NewNop())
+
+	for _, index := range []string{WriteAlias("cdc-route"), "cdc-route-v2"} {
+		store.Put(index, "r1", map[string]interface{}{"service": map[string]interface{}{"id": "s1", "name": "old"}})
+	}
+
+	updated, err := indexer.UpdateReferenceName("cdc-route", "service", "s1", "new")
+	if err != nil || updated != 1 {
+		t.Fatalf("UpdateReferenceName() = %d, %v, want 1 document", updated, err)
+	}
+	want := []string{"cdc-route-write service s1=new", "cdc-route-v2 service s1=new"}
+	if !reflect.DeepEqual(store.updates, want) {
+		t.Errorf("updates = %v, want %v", store.updates, want)
+	}
+	for _, index := range []string{WriteAlias("cdc-route"), "cdc-route-v2"} {
+		document, _ := store.GetDocument(index, "r1")
+		if name := document["service"].(map[string]interface{})["name"]; name != "new" {
+			t.Errorf("%s service name = %v, want new", index, name)
+		}
+	}
+
+	if _, err := indexer.UpdateControlPlane("cdc-*", map[string]interface{}{"id": "cp-1", "name": "prod"}); err != nil {
+		t.Fatalf("UpdateControlPlane() error = %v", err)
+	}
+	if last := store.updates[len(store.updates)-1]; last != "cdc-* cp-1=prod" {
+		t.Errorf("control plane update = %q, want the pattern passed through", last)
+	}
+}
+
+func TestAliasIndexerDeleteLeavesTombstonesDuringCatchUp(t *testing.T) {
+	store := MockUpdatingIndexer{NewMockDocumentStore()}
+	resolver := &MockAliasResolver{aliases: map[string][]string{
+		DualWriteAlias("cdc-route"): {"cdc-route-v1", "cdc-route-v3"},
+		CatchUpAlias("cdc-route"):   {"cdc-route-v3"},
+	}}
+	indexer := NewAliasIndexer(store, resolver, 0, zap.NewNop())
+
+	for _, index := range []string{WriteAlias("cdc-route"), "cdc-route-v1", "cdc-route-v3"} {
+		store.Put(index, "r1", map[string]interface{}{"name": "r1"})
+	}
+	if err := indexer.DeleteDocument("cdc-route", "r1"); err != nil {
+		t.Fatalf("DeleteDocument() error = %v", err)
+	}
+
+	for _, index := range []string{WriteAlias("cdc-route"), "cdc-route-v1"} {
+		if document, _ := store.GetDocument(index, "r1"); document != nil {
+			t.Errorf("%s still holds %v, want it deleted", index, document)
+		}
+	}
+	want := map[string]interface{}{TombstoneField: true}
+	if document, _ := store.GetDocument("cdc-route-v3", "r1"); !reflect.DeepEqual(document, want) {
+		t.Errorf("cdc-route-v3 holds %v, want a tombstone", document)
+	}
+}
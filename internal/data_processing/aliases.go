@@ -0,0 +1,357 @@
+package data_processing
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/opensearch-project/opensearch-go/v2"
+	"github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrLegacyIndex  = errors.New("a concrete index already uses the alias name")
+	ErrNoGeneration = errors.New("no index generation found")
+)
+
+// Index naming for versioned indices. The logical index name (e.g. "cdc-service") is the read
+// alias used by search; writers go through the write alias, and the dual-write alias marks an
+// index generation that must receive writes too while it is being built or kept for rollback.
+// The catch-up alias marks a dual-written generation that a reindex is still filling.
+const (
+	writeAliasSuffix     = "-write"
+	dualWriteAliasSuffix = "-dualwrite"
+	catchUpAliasSuffix   = "-catchup"
+	generationSeparator  = "-v"
+)
+
+// TombstoneField marks the documents left in place of deletes in a generation being caught
+// up, so that the catch-up does not recreate them. They are purged before the swap.
+const TombstoneField = "reindex_tombstone"
+
+// ReadAlias returns the alias searched by readers of a logical index
+func ReadAlias(logical string) string {
+	return logical
+}
+
+// WriteAlias returns the alias writers of a logical index go through
+func WriteAlias(logical string) string {
+	return logical + writeAliasSuffix
+}
+
+// DualWriteAlias returns the alias of generations receiving additional writes
+func DualWriteAlias(logical string) string {
+	return logical + dualWriteAliasSuffix
+}
+
+// CatchUpAlias returns the alias of generations a reindex is catching up
+func CatchUpAlias(logical string) string {
+	return logical + catchUpAliasSuffix
+}
+
+// PhysicalIndex returns the concrete index name of a generation of a logical index
+func PhysicalIndex(logical string, generation int) string {
+	return logical + generationSeparator + strconv.Itoa(generation)
+}
+
+// ParseGeneration returns the generation of a physical index of a logical index
+func ParseGeneration(logical string, physical string) (int, bool) {
+	suffix := strings.TrimPrefix(physical, logical+generationSeparator)
+	if suffix == physical {
+		return 0, false
+	}
+	generation, err := strconv.Atoi(suffix)
+	if err != nil || generation <= 0 {
+		return 0, false
+	}
+	return generation, true
+}
+
+// AliasManager manages versioned physical indices and their aliases in OpenSearch
+type AliasManager struct {
+	client *opensearch.Client
+	logger *zap.Logger
+}
+
+// NewAliasManager creates a new alias manager
+func NewAliasManager(client *opensearch.Client, logger *zap.Logger) *AliasManager {
+	return &AliasManager{
+		client: client,
+		logger: logger,
+	}
+}
+
+// Resolve returns the physical indices an alias points to
+func (m *AliasManager) Resolve(alias string) ([]string, error) {
+	res, err := m.client.Indices.GetAlias(m.client.Indices.GetAlias.WithName(alias))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if res.IsError() {
+		return nil, responseError("get alias "+alias, res)
+	}
+
+	var body map[string]json.RawMessage
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	indices := make([]string, 0, len(body))
+	for index := range body {
+		indices = append(indices, index)
+	}
+	return indices, nil
+}
+
+// CurrentGeneration returns the generation the read alias of a logical index points to
+func (m *AliasManager) CurrentGeneration(logical string) (int, error) {
+	indices, err := m.Resolve(ReadAlias(logical))
+	if err != nil {
+		return 0, err
+	}
+	for _, index := range indices {
+		if generation, ok := ParseGeneration(logical, index); ok {
+			return generation, nil
+		}
+	}
+	return 0, ErrNoGeneration
+}
+
+// EnsureIndex creates the first generation of a logical index with its read and write
+// aliases when the read alias does not exist yet
+func (m *AliasManager) EnsureIndex(logical string) error {
+	indices, err := m.Resolve(ReadAlias(logical))
+	if err != nil {
+		return err
+	}
+	if len(indices) > 0 {
+		return nil
+	}
+
+	exists, err := m.indexExists(logical)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("%w: %s", ErrLegacyIndex, logical)
+	}
+
+	err = m.CreateIndex(PhysicalIndex(logical, 1), map[string]interface{}{
+		"aliases": map[string]interface{}{
+			ReadAlias(logical):  map[string]interface{}{},
+			WriteAlias(logical): map[string]interface{}{"is_write_index": true},
+		},
+	})
+	if err != nil {
+		// Another consumer may have created it concurrently
+		if indices, resolveErr := m.Resolve(ReadAlias(logical)); resolveErr == nil && len(indices) > 0 {
+			return nil
+		}
+		return err
+	}
+
+	m.logger.Info("Created first index generation", zap.String("index", PhysicalIndex(logical, 1)))
+	return nil
+}
+
+// CreateIndex creates a physical index with an optional settings, mappings and aliases body
+func (m *AliasManager) CreateIndex(index string, body map[string]interface{}) error {
+	options := []func(*opensearchapi.IndicesCreateRequest){}
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		options = append(options, m.client.Indices.Create.WithBody(bytes.NewReader(payload)))
+	}
+
+	res, err := m.client.Indices.Create(index, options...)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return responseError("create index "+index, res)
+	}
+	return nil
+}
+
+// AliasAction is a single add or remove action of the _aliases API
+type AliasAction struct {
+	Add          bool
+	Index        string
+	Alias        string
+	IsWriteIndex bool
+}
+
+// UpdateAliases applies all actions atomically
+func (m *AliasManager) UpdateAliases(actions []AliasAction) error {
+	payload := make([]map[string]interface{}, 0, len(actions))
+	for _, action := range actions {
+		spec := map[string]interface{}{"index": action.Index, "alias": action.Alias}
+		verb := "remove"
+		if action.Add {
+			verb = "add"
+			if action.IsWriteIndex {
+				spec["is_write_index"] = true
+			}
+		}
+		payload = append(payload, map[string]interface{}{verb: spec})
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"actions": payload})
+	if err != nil {
+		return err
+	}
+	res, err := m.client.Indices.UpdateAliases(bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return responseError("update aliases", res)
+	}
+	return nil
+}
+
+// SwapAliases atomically moves the read and write aliases of a logical index from one
+// physical index to another. The previous index keeps receiving writes through the
+// dual-write alias so that swapping back loses nothing.
+func (m *AliasManager) SwapAliases(logical string, from string, to string) error {
+	return m.UpdateAliases([]AliasAction{
+		{Add: false, Index: from, Alias: ReadAlias(logical)},
+		{Add: false, Index: from, Alias: WriteAlias(logical)},
+		{Add: false, Index: to, Alias: DualWriteAlias(logical)},
+		{Add: true, Index: to, Alias: ReadAlias(logical)},
+		{Add: true, Index: to, Alias: WriteAlias(logical), IsWriteIndex: true},
+		{Add: true, Index: from, Alias: DualWriteAlias(logical)},
+	})
+}
+
+// Count returns the number of documents in an index after refreshing it
+func (m *AliasManager) Count(index string) (int64, error) {
+	refresh, err := m.client.Indices.Refresh(m.client.Indices.Refresh.WithIndex(index))
+	if err != nil {
+		return 0, err
+	}
+	refresh.Body.Close()
+
+	res, err := m.client.Count(m.client.Count.WithIndex(index))
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return 0, responseError("count "+index, res)
+	}
+
+	var body struct {
+		Count int64 `json:"count"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return 0, err
+	}
+	return body.Count, nil
+}
+
+// Reindex copies every document of source into dest with OpenSearch _reindex. Documents
+// already present in dest, e.g. from dual writes or tombstones of deletes, are newer and are
+// left untouched.
+func (m *AliasManager) Reindex(source string, dest string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"conflicts": "proceed",
+		"source":    map[string]interface{}{"index": source},
+		"dest":      map[string]interface{}{"index": dest, "op_type": "create"},
+	})
+	if err != nil {
+		return err
+	}
+
+	res, err := m.client.Reindex(bytes.NewReader(body),
+		m.client.Reindex.WithWaitForCompletion(true),
+		m.client.Reindex.WithRefresh(true),
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return responseError("reindex "+source+" into "+dest, res)
+	}
+	return nil
+}
+
+// PurgeTombstones deletes the tombstones left by deletes during a catch-up and returns how
+// many were deleted
+func (m *AliasManager) PurgeTombstones(index string) (int64, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"query": map[string]interface{}{
+			"term": map[string]interface{}{TombstoneField: true},
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := m.client.DeleteByQuery([]string{index}, bytes.NewReader(body),
+		m.client.DeleteByQuery.WithConflicts("proceed"),
+		m.client.DeleteByQuery.WithRefresh(true),
+	)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return 0, responseError("purge tombstones of "+index, res)
+	}
+
+	var result struct {
+		Deleted int64 `json:"deleted"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return 0, err
+	}
+	return result.Deleted, nil
+}
+
+func (m *AliasManager) indexExists(index string) (bool, error) {
+	res, err := m.client.Indices.Exists([]string{index})
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	return res.StatusCode == http.StatusOK, nil
+}
+
+// ResponseError is an OpenSearch error response
+type ResponseError struct {
+	Operation  string
+	StatusCode int
+	Body       string
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("%s failed with status %d: %s", e.Operation, e.StatusCode, e.Body)
+}
+
+// IsRejected reports whether OpenSearch rejected a request because it is overloaded
+func IsRejected(err error) bool {
+	var responseErr *ResponseError
+	return errors.As(err, &responseErr) && responseErr.StatusCode == http.StatusTooManyRequests
+}
+
+// responseError converts an OpenSearch error response into an error
+func responseError(operation string, res *opensearchapi.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+	return &ResponseError{Operation: operation, StatusCode: res.StatusCode, Body: strings.TrimSpace(string(body))}
+}
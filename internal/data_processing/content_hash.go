@@ -0,0 +1,39 @@
+package data_processing
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+)
+
+// ContentHash returns a stable hash of a document, ignoring the given dotted field paths.
+// The document is hashed as canonical JSON: encoding/json writes map keys in sorted order,
+// so equal documents always produce equal hashes.
+func ContentHash(document interface{}, ignoreFields []string) string {
+	if object, ok := document.(map[string]interface{}); ok && len(ignoreFields) > 0 {
+		trimmed := deepCopy(object).(map[string]interface{})
+		for _, path := range ignoreFields {
+			deletePath(trimmed, strings.Split(path, "."))
+		}
+		document = trimmed
+	}
+
+	encoded, err := json.Marshal(document)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:])
+}
+
+// deletePath removes the value at a dotted path, if present
+func deletePath(object map[string]interface{}, path []string) {
+	if len(path) == 1 {
+		delete(object, path[0])
+		return
+	}
+	if nested, ok := object[path[0]].(map[string]interface{}); ok {
+		deletePath(nested, path[1:])
+	}
+}
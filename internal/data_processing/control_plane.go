@@ -0,0 +1,232 @@
+package data_processing
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kong/konnect-ingest/internal/models"
+	"go.uber.org/zap"
+)
+
+// ControlPlaneField is the field holding the control plane metadata stamped on every document
+const ControlPlaneField = "control_plane"
+
+// DefaultControlPlaneLookupTTL bounds how long a control plane missing from its index is not
+// looked up again
+const DefaultControlPlaneLookupTTL = time.Minute
+
+// ControlPlane is the metadata known about a control plane
+type ControlPlane struct {
+	ID             string
+	Name           string
+	Type           string
+	OrganizationID string
+	State          string
+	Health         string
+}
+
+// fields returns the stamped representation of the control plane, omitting unknown values
+func (c ControlPlane) fields() map[string]interface{} {
+	fields := map[string]interface{}{"id": c.ID}
+	for name, value := range map[string]string{
+		"name":            c.Name,
+		"type":            c.Type,
+		"organization_id": c.OrganizationID,
+		"state":           c.State,
+		"health":          c.Health,
+	} {
+		if value != "" {
+			fields[name] = value
+		}
+	}
+	return fields
+}
+
+type controlPlaneEntry struct {
+	controlPlane ControlPlane
+	// clusterSeen is set once a cluster event was observed, after which the indices are no
+	// longer read
+	clusterSeen bool
+	lookedUpAt  time.Time
+}
+
+// ControlPlaneRegistry implements Enricher. It keeps the metadata of control planes from
+// cluster and composite-status events and stamps it on the documents of their entities.
+// Control planes whose events were consumed by another instance are read back from the
+// cluster and composite-status indices.
+type ControlPlaneRegistry struct {
+	indexPrefix string
+	getter      DocumentGetter
+	updater     ControlPlaneUpdater
+	lookupTTL   time.Duration
+	now         func() time.Time
+	logger      *zap.Logger
+
+	mu            sync.Mutex
+	controlPlanes map[string]*controlPlaneEntry
+}
+
+// NewControlPlaneRegistry creates a new control plane registry
+func NewControlPlaneRegistry(indexPrefix string, logger *zap.Logger) *ControlPlaneRegistry {
+	return &ControlPlaneRegistry{
+		indexPrefix:   indexPrefix,
+		lookupTTL:     DefaultControlPlaneLookupTTL,
+		now:           time.Now,
+		logger:        logger,
+		controlPlanes: make(map[string]*controlPlaneEntry),
+	}
+}
+
+// SetDocumentGetter enables reading control planes missing from the registry from their indices
+func (r *ControlPlaneRegistry) SetDocumentGetter(getter DocumentGetter, lookupTTL time.Duration) {
+	r.getter = getter
+	r.lookupTTL = lookupTTL
+}
+
+// SetControlPlaneUpdater enables restamping indexed documents when control plane metadata changes
+func (r *ControlPlaneRegistry) SetControlPlaneUpdater(updater ControlPlaneUpdater) {
+	r.updater = updater
+}
+
+// ControlPlane returns the metadata known about a control plane
+func (r *ControlPlaneRegistry) ControlPlane(id string) (ControlPlane, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, ok := r.controlPlanes[id]
+	if !ok {
+		return ControlPlane{}, false
+	}
+	return entry.controlPlane, true
+}
+
+// Enrich records cluster and composite-status events and stamps the control plane metadata
+// on every other document. The document itself is not modified.
+func (r *ControlPlaneRegistry) Enrich(controlPlaneID string, entityType string, document map[string]interface{}) map[string]interface{} {
+	switch entityType {
+	case "cluster":
+		r.observeCluster(document)
+		return document
+	case "composite-status":
+		r.observeHealth(controlPlaneID, document)
+	}
+	if controlPlaneID == "" || controlPlaneID == models.GlobalControlPlane {
+		return document
+	}
+
+	stamped := make(map[string]interface{}, len(document)+1)
+	for field, value := range document {
+		stamped[field] = value
+	}
+	stamped[ControlPlaneField] = r.lookup(controlPlaneID).fields()
+	return stamped
+}
+
+func (r *ControlPlaneRegistry) observeCluster(document map[string]interface{}) {
+	id := stringField(document, "id")
+	if id == "" {
+		return
+	}
+	r.update(id, true, func(controlPlane *ControlPlane) {
+		controlPlane.Name = stringField(document, "name")
+		controlPlane.Type = stringField(document, "type")
+		controlPlane.State = stringField(document, "state")
+		if metadata, ok := document["metadata"].(map[string]interface{}); ok {
+			controlPlane.OrganizationID = stringField(metadata, "organization_id")
+		}
+	})
+}
+
+func (r *ControlPlaneRegistry) observeHealth(controlPlaneID string, document map[string]interface{}) {
+	health := stringField(document, "state")
+	if controlPlaneID == "" || health == "" {
+		return
+	}
+	r.update(controlPlaneID, false, func(controlPlane *ControlPlane) {
+		controlPlane.Health = health
+	})
+}
+
+// update applies a change to a control plane and restamps its indexed documents when the
+// metadata changed
+func (r *ControlPlaneRegistry) update(id string, cluster bool, change func(*ControlPlane)) {
+	r.mu.Lock()
+	entry, ok := r.controlPlanes[id]
+	if !ok {
+		entry = &controlPlaneEntry{controlPlane: ControlPlane{ID: id}}
+		r.controlPlanes[id] = entry
+	}
+	previous := entry.controlPlane
+	change(&entry.controlPlane)
+	entry.clusterSeen = entry.clusterSeen || cluster
+	current := entry.controlPlane
+	r.mu.Unlock()
+
+	if current == previous || r.updater == nil {
+		return
+	}
+	indexPattern := r.indexPrefix + "-*"
+	updated, err := r.updater.UpdateControlPlane(indexPattern, current.fields())
+	if err != nil {
+		r.logger.Error("Failed to restamp control plane",
+			zap.String("controlPlaneID", id),
+			zap.Error(err),
+		)
+		return
+	}
+	if updated > 0 {
+		r.logger.Info("Restamped control plane",
+			zap.String("controlPlaneID", id),
+			zap.Int64("updated", updated),
+		)
+	}
+}
+
+// lookup returns the metadata of a control plane, reading it from the cluster and
+// composite-status indices while no cluster event was seen, at most once per lookup TTL
+func (r *ControlPlaneRegistry) lookup(id string) ControlPlane {
+	r.mu.Lock()
+	entry, ok := r.controlPlanes[id]
+	if !ok {
+		entry = &controlPlaneEntry{controlPlane: ControlPlane{ID: id}}
+		r.controlPlanes[id] = entry
+	}
+	if r.getter == nil || entry.clusterSeen || (!entry.lookedUpAt.IsZero() && r.now().Sub(entry.lookedUpAt) < r.lookupTTL) {
+		controlPlane := entry.controlPlane
+		r.mu.Unlock()
+		return controlPlane
+	}
+	entry.lookedUpAt = r.now()
+	r.mu.Unlock()
+
+	cluster := r.read(r.indexPrefix+"-cluster", id)
+	status := r.read(r.indexPrefix+"-composite-status", id)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// Events observed while reading take precedence over the indexed documents
+	if cluster != nil && !entry.clusterSeen {
+		entry.controlPlane.Name = stringField(cluster, "name")
+		entry.controlPlane.Type = stringField(cluster, "type")
+		entry.controlPlane.State = stringField(cluster, "state")
+		if metadata, ok := cluster["metadata"].(map[string]interface{}); ok {
+			entry.controlPlane.OrganizationID = stringField(metadata, "organization_id")
+		}
+	}
+	if status != nil && entry.controlPlane.Health == "" {
+		entry.controlPlane.Health = stringField(status, "state")
+	}
+	return entry.controlPlane
+}
+
+func (r *ControlPlaneRegistry) read(indexName string, id string) map[string]interface{} {
+	document, err := r.getter.GetDocument(indexName, id)
+	if err != nil {
+		r.logger.Warn("Failed to read control plane metadata",
+			zap.String("indexName", indexName),
+			zap.String("controlPlaneID", id),
+			zap.Error(err),
+		)
+		return nil
+	}
+	return document
+}
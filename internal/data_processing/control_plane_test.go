@@ -0,0 +1,173 @@
+package data_processing
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kong/konnect-ingest/internal/models"
+	"go.uber.org/zap"
+)
+
+func TestControlPlaneRegistry(t *testing.T) {
+	cluster := func(name string) map[string]interface{} {
+		return map[string]interface{}{
+			"id":       "cp-1",
+			"name":     name,
+			"type":     "CLUSTER_TYPE_HYBRID",
+			"state":    "active",
+			"metadata": map[string]interface{}{"organization_id": "org-1"},
+		}
+	}
+	service := func() map[string]interface{} {
+		return map[string]interface{}{"id": "svc-1", "name": "billing"}
+	}
+
+	t.Run("stamps control plane metadata", func(t *testing.T) {
+		registry := NewControlPlaneRegistry("cdc", zap.NewNop())
+		if got := registry.Enrich(models.GlobalControlPlane, "cluster", cluster("prod")); got[ControlPlaneField] != nil {
+			t.Error("cluster documents should not be stamped")
+		}
+		registry.Enrich("cp-1", "composite-status", map[string]interface{}{"id": "cp-1", "state": "COMPOSITE_STATE_OK"})
+
+		original := service()
+		stamped := registry.Enrich("cp-1", "service", original)
+		want := map[string]interface{}{
+			"id":              "cp-1",
+			"name":            "prod",
+			"type":            "CLUSTER_TYPE_HYBRID",
+			"organization_id": "org-1",
+			"state":           "active",
+			"health":          "COMPOSITE_STATE_OK",
+		}
+		if got := stamped[ControlPlaneField]; !reflect.DeepEqual(got, want) {
+			t.Errorf("control plane = %v, want %v", got, want)
+		}
+		if _, ok := original[ControlPlaneField]; ok {
+			t.Error("Enrich() modified its input")
+		}
+
+		// Unknown control planes are stamped with their id only
+		other := registry.Enrich("cp-2", "service", service())
+		if got := other[ControlPlaneField]; !reflect.DeepEqual(got, map[string]interface{}{"id": "cp-2"}) {
+			t.Errorf("control plane = %v, want id only", got)
+		}
+	})
+
+	t.Run("reads unknown control planes from their indices", func(t *testing.T) {
+		store := NewMockDocumentStore()
+		store.Put("cdc-cluster", "cp-1", cluster("prod"))
+		registry := NewControlPlaneRegistry("cdc", zap.NewNop())
+		now := time.Unix(0, 0)
+		registry.now = func() time.Time { return now }
+		registry.SetDocumentGetter(store, time.Minute)
+
+		registry.Enrich("cp-1", "service", service())
+		stamped := registry.Enrich("cp-1", "service", service())
+		if got := stamped[ControlPlaneField].(map[string]interface{})["organization_id"]; got != "org-1" {
+			t.Errorf("organization id = %v, want org-1", got)
+		}
+		// The cluster and composite-status indices are read once per lookup TTL
+		if store.reads != 2 {
+			t.Errorf("indices read %d times, want 2", store.reads)
+		}
+		now = now.Add(time.Minute)
+		registry.Enrich("cp-1", "service", service())
+		if store.reads != 4 {
+			t.Errorf("indices read %d times after the TTL, want 4", store.reads)
+		}
+
+		// Once the cluster event is seen the indices are no longer read
+		registry.Enrich(models.GlobalControlPlane, "cluster", cluster("prod"))
+		now = now.Add(time.Minute)
+		registry.Enrich("cp-1", "service", service())
+		if store.reads != 4 {
+			t.Errorf("indices read %d times after the cluster event, want 4", store.reads)
+		}
+	})
+
+	t.Run("restamps indexed documents on change", func(t *testing.T) {
+		store := NewMockDocumentStore()
+		registry := NewControlPlaneRegistry("cdc", zap.NewNop())
+		registry.SetControlPlaneUpdater(store)
+
+		store.Put("cdc-service", "svc-1", registry.Enrich("cp-1", "service", service()))
+		registry.Enrich(models.GlobalControlPlane, "cluster", cluster("prod"))
+		registry.Enrich(models.GlobalControlPlane, "cluster", cluster("prod"))
+		registry.Enrich(models.GlobalControlPlane, "cluster", cluster("production"))
+
+		if want := []string{"cdc-* cp-1=prod", "cdc-* cp-1=production"}; !reflect.DeepEqual(store.updates, want) {
+			t.Errorf("updates = %v, want %v", store.updates, want)
+		}
+		document, _ := store.GetDocument("cdc-service", "svc-1")
+		if got := document[ControlPlaneField].(map[string]interface{})["name"]; got != "production" {
+			t.Errorf("control plane name = %v, want production", got)
+		}
+	})
+}
+
+func TestControlPlaneRegistrySampleStream(t *testing.T) {
+	registry := NewControlPlaneRegistry("cdc", zap.NewNop())
+	for _, event := range loadStreamEvents(t) {
+		key, ok := models.ParseEntityKey(event.After.Key)
+		object, isObject := event.After.Value.Object.(map[string]interface{})
+		if !ok || !isObject {
+			continue
+		}
+
+		stamped := registry.Enrich(key.ControlPlaneID, key.EntityType, object)
+		controlPlane, ok := stamped[ControlPlaneField].(map[string]interface{})
+		if key.ControlPlaneID == models.GlobalControlPlane {
+			if ok {
+				t.Errorf("%s: global entities should not be stamped", event.After.Key)
+			}
+			continue
+		}
+		if !ok || controlPlane["id"] != key.ControlPlaneID {
+			t.Errorf("%s: control plane = %v", event.After.Key, stamped[ControlPlaneField])
+		}
+	}
+
+	tests := []struct {
+		id   string
+		want ControlPlane
+	}{
+		{
+			id: "4c75f4f6-ca71-44a9-80ca-e96f6c412b24",
+			want: ControlPlane{
+				ID:             "4c75f4f6-ca71-44a9-80ca-e96f6c412b24",
+				Name:           "12781472e4",
+				Type:           "CLUSTER_TYPE_HYBRID",
+				OrganizationID: "3e5ed2da-40b0-4984-94d4-c67f37bc35eb",
+				State:          "active",
+			},
+		},
+		{
+			id: "6b0bb79a-555b-4c66-911f-8d7ad9076eda",
+			want: ControlPlane{
+				ID:             "6b0bb79a-555b-4c66-911f-8d7ad9076eda",
+				Name:           "22f28cd5b7",
+				Type:           "CLUSTER_TYPE_COMPOSITE",
+				OrganizationID: "6a265df2-e038-4ab7-8bb8-680e50002403",
+				State:          "active",
+			},
+		},
+		{
+			id:   "1a49eb76-6605-4324-b7f6-97348b81b6b7",
+			want: ControlPlane{ID: "1a49eb76-6605-4324-b7f6-97348b81b6b7", Health: "COMPOSITE_STATE_OK"},
+		},
+	}
+	for _, tt := range tests {
+		got, ok := registry.ControlPlane(tt.id)
+		if !ok || got != tt.want {
+			t.Errorf("ControlPlane(%s) = %+v, want %+v", tt.id, got, tt.want)
+		}
+	}
+
+	// The sample stream has no entities of the control planes it creates; entities created
+	// afterwards carry the organization of their control plane
+	stamped := registry.Enrich("4c75f4f6-ca71-44a9-80ca-e96f6c412b24", "service", map[string]interface{}{"id": "svc-1"})
+	if got := stamped[ControlPlaneField].(map[string]interface{})["organization_id"]; got != "3e5ed2da-40b0-4984-94d4-c67f37bc35eb" {
+		t.Errorf("organization id = %v", got)
+	}
+}
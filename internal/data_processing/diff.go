@@ -0,0 +1,320 @@
+package data_processing
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/kong/konnect-ingest/internal/models"
+)
+
+// LastChangeField holds the summary of the last change of a document in the document itself
+const LastChangeField = "last_change"
+
+// HistoryIndexSuffix names the change history index, <prefix>-history
+const HistoryIndexSuffix = "history"
+
+// ChangeKind is the kind of a field change
+type ChangeKind string
+
+const (
+	ChangeAdded   ChangeKind = "added"
+	ChangeRemoved ChangeKind = "removed"
+	ChangeChanged ChangeKind = "changed"
+)
+
+// FieldChange is the change of one field between the before and after images. Paths are
+// dotted, array elements are addressed by index ([2]) or, for objects with an id, by id
+// ([id=1c7e...]).
+type FieldChange struct {
+	Path   string      `json:"path"`
+	Kind   ChangeKind  `json:"kind"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+	// Added and Removed hold the elements added to and removed from an array of scalars,
+	// such as tags; Before and After are only set when just the order changed
+	Added   []interface{} `json:"added,omitempty"`
+	Removed []interface{} `json:"removed,omitempty"`
+}
+
+// FieldDiffer implements Differ. Ignored fields, top-level names or dotted paths, are left
+// out of the changes.
+type FieldDiffer struct {
+	ignored map[string]bool
+}
+
+// NewFieldDiffer creates a differ ignoring the fields
+func NewFieldDiffer(ignoredFields []string) *FieldDiffer {
+	ignored := make(map[string]bool, len(ignoredFields))
+	for _, field := range ignoredFields {
+		ignored[field] = true
+	}
+	return &FieldDiffer{ignored: ignored}
+}
+
+// Diff returns the field changes from before to after, ordered by path
+func (d *FieldDiffer) Diff(before map[string]interface{}, after map[string]interface{}) []FieldChange {
+	var changes []FieldChange
+	d.diffObjects("", before, after, &changes)
+	return changes
+}
+
+func (d *FieldDiffer) diffObjects(path string, before map[string]interface{}, after map[string]interface{}, changes *[]FieldChange) {
+	fields := make([]string, 0, len(before)+len(after))
+	for field := range before {
+		fields = append(fields, field)
+	}
+	for field := range after {
+		if _, ok := before[field]; !ok {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		fieldPath := joinPath(path, field)
+		if d.ignored[fieldPath] {
+			continue
+		}
+		beforeValue, inBefore := before[field]
+		afterValue, inAfter := after[field]
+		switch {
+		case !inBefore:
+			*changes = append(*changes, FieldChange{Path: fieldPath, Kind: ChangeAdded, After: afterValue})
+		case !inAfter:
+			*changes = append(*changes, FieldChange{Path: fieldPath, Kind: ChangeRemoved, Before: beforeValue})
+		default:
+			d.diffValues(fieldPath, beforeValue, afterValue, changes)
+		}
+	}
+}
+
+func (d *FieldDiffer) diffValues(path string, before interface{}, after interface{}, changes *[]FieldChange) {
+	if reflect.DeepEqual(before, after) {
+		return
+	}
+	beforeObject, beforeIsObject := before.(map[string]interface{})
+	afterObject, afterIsObject := after.(map[string]interface{})
+	if beforeIsObject && afterIsObject {
+		d.diffObjects(path, beforeObject, afterObject, changes)
+		return
+	}
+	beforeArray, beforeIsArray := before.([]interface{})
+	afterArray, afterIsArray := after.([]interface{})
+	if beforeIsArray && afterIsArray {
+		d.diffArrays(path, beforeArray, afterArray, changes)
+		return
+	}
+	*changes = append(*changes, FieldChange{Path: path, Kind: ChangeChanged, Before: before, After: after})
+}
+
+// diffArrays compares scalar arrays as sets, object arrays by element id when every element has
+// one, and other arrays by position
+func (d *FieldDiffer) diffArrays(path string, before []interface{}, after []interface{}, changes *[]FieldChange) {
+	if allScalars(before) && allScalars(after) {
+		added, removed := setDifference(after, before), setDifference(before, after)
+		change := FieldChange{Path: path, Kind: ChangeChanged, Added: added, Removed: removed}
+		if len(added) == 0 && len(removed) == 0 {
+			change.Before, change.After = before, after
+		}
+		*changes = append(*changes, change)
+		return
+	}
+
+	beforeByID, beforeOK := elementsByID(before)
+	afterByID, afterOK := elementsByID(after)
+	if beforeOK && afterOK {
+		d.diffObjects(path, beforeByID, afterByID, changes)
+		return
+	}
+
+	for i := 0; i < len(before) || i < len(after); i++ {
+		elementPath := fmt.Sprintf("%s[%d]", path, i)
+		switch {
+		case i >= len(before):
+			*changes = append(*changes, FieldChange{Path: elementPath, Kind: ChangeAdded, After: after[i]})
+		case i >= len(after):
+			*changes = append(*changes, FieldChange{Path: elementPath, Kind: ChangeRemoved, Before: before[i]})
+		default:
+			d.diffValues(elementPath, before[i], after[i], changes)
+		}
+	}
+}
+
+// elementsByID indexes array objects by their id as [id=<id>] keys, it reports false unless
+// every element is an object with a distinct string id
+func elementsByID(elements []interface{}) (map[string]interface{}, bool) {
+	byID := make(map[string]interface{}, len(elements))
+	for _, element := range elements {
+		object, ok := element.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		id, ok := object["id"].(string)
+		if !ok {
+			return nil, false
+		}
+		key := "[id=" + id + "]"
+		if _, duplicate := byID[key]; duplicate {
+			return nil, false
+		}
+		byID[key] = object
+	}
+	return byID, true
+}
+
+func allScalars(elements []interface{}) bool {
+	for _, element := range elements {
+		switch element.(type) {
+		case map[string]interface{}, []interface{}:
+			return false
+		}
+	}
+	return true
+}
+
+// setDifference returns the elements of a missing from b, in the order of a
+func setDifference(a []interface{}, b []interface{}) []interface{} {
+	var difference []interface{}
+	for _, element := range a {
+		found := false
+		for _, other := range b {
+			if element == other {
+				found = true
+				break
+			}
+		}
+		if !found {
+			difference = append(difference, element)
+		}
+	}
+	return difference
+}
+
+func joinPath(path string, field string) string {
+	if path == "" || strings.HasPrefix(field, "[") {
+		return path + field
+	}
+	return path + "." + field
+}
+
+// changeOps maps CDC operation codes to operation names
+var changeOps = map[string]string{
+	models.OpCreate: "create",
+	models.OpUpdate: "update",
+	models.OpDelete: "delete",
+	models.OpRead:   "read",
+}
+
+// ChangeOp names a CDC operation create, update, delete or read (snapshot), unknown codes are
+// updates
+func ChangeOp(op string) string {
+	if name, ok := changeOps[op]; ok {
+		return name
+	}
+	return changeOps[models.OpUpdate]
+}
+
+// ChangeSummary lists the paths added, removed and changed by an update
+type ChangeSummary struct {
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+	Changed []string `json:"changed,omitempty"`
+}
+
+// Summarize lists the paths of the changes by kind
+func Summarize(changes []FieldChange) ChangeSummary {
+	var summary ChangeSummary
+	for _, change := range changes {
+		switch change.Kind {
+		case ChangeAdded:
+			summary.Added = append(summary.Added, change.Path)
+		case ChangeRemoved:
+			summary.Removed = append(summary.Removed, change.Path)
+		default:
+			summary.Changed = append(summary.Changed, change.Path)
+		}
+	}
+	return summary
+}
+
+// LastChange is the compact summary of the last change stored in a document. The changed
+// paths are only known when the event carries its before image.
+type LastChange struct {
+	Op   string `json:"op"`
+	TsMs int64  `json:"ts_ms"`
+	ChangeSummary
+}
+
+// HistoryChange is a field change of a history document. Values are JSON encoded, so that
+// fields changing type do not conflict in the index mapping.
+type HistoryChange struct {
+	Path    string     `json:"path"`
+	Kind    ChangeKind `json:"kind"`
+	Before  string     `json:"before,omitempty"`
+	After   string     `json:"after,omitempty"`
+	Added   []string   `json:"added,omitempty"`
+	Removed []string   `json:"removed,omitempty"`
+}
+
+// HistoryDocument records one change of an entity in the <prefix>-history index
+type HistoryDocument struct {
+	EntityType     string          `json:"entity_type"`
+	EntityID       string          `json:"entity_id"`
+	ControlPlaneID string          `json:"control_plane_id"`
+	Op             string          `json:"op"`
+	TsMs           int64           `json:"ts_ms"`
+	Changes        []HistoryChange `json:"changes,omitempty"`
+	// Link is the CDC key of the entity
+	Link string `json:"link"`
+}
+
+// NewHistoryDocument creates the history document of the change made by an event
+func NewHistoryDocument(event models.CDCEvent, key models.EntityKey, changes []FieldChange) HistoryDocument {
+	document := HistoryDocument{
+		EntityType:     key.EntityType,
+		EntityID:       key.ID,
+		ControlPlaneID: key.ControlPlaneID,
+		Op:             ChangeOp(event.Op),
+		TsMs:           event.TsMs,
+		Link:           event.After.Key,
+	}
+	for _, change := range changes {
+		document.Changes = append(document.Changes, HistoryChange{
+			Path:    change.Path,
+			Kind:    change.Kind,
+			Before:  encodeValue(change.Before),
+			After:   encodeValue(change.After),
+			Added:   encodeValues(change.Added),
+			Removed: encodeValues(change.Removed),
+		})
+	}
+	return document
+}
+
+// HistoryDocumentID identifies a change by entity and event time, so that an event consumed
+// again overwrites its own history document
+func HistoryDocumentID(key models.EntityKey, tsMs int64) string {
+	return fmt.Sprintf("%s:%d", SearchDocumentID(key), tsMs)
+}
+
+func encodeValue(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(encoded)
+}
+
+func encodeValues(values []interface{}) []string {
+	var encoded []string
+	for _, value := range values {
+		encoded = append(encoded, encodeValue(value))
+	}
+	return encoded
+}
@@ -0,0 +1,127 @@
+package data_processing
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kong/konnect-ingest/internal/models"
+)
+
+func TestFieldDiffer(t *testing.T) {
+	tests := []struct {
+		name          string
+		ignoredFields []string
+		before        map[string]interface{}
+		after         map[string]interface{}
+		want          []FieldChange
+	}{
+		{
+			name:   "unchanged",
+			before: map[string]interface{}{"id": "r1", "paths": []interface{}{"/a"}},
+			after:  map[string]interface{}{"id": "r1", "paths": []interface{}{"/a"}},
+		},
+		{
+			name:   "top-level fields",
+			before: map[string]interface{}{"id": "s1", "name": "old", "path": "/"},
+			after:  map[string]interface{}{"id": "s1", "name": "new", "port": float64(80)},
+			want: []FieldChange{
+				{Path: "name", Kind: ChangeChanged, Before: "old", After: "new"},
+				{Path: "path", Kind: ChangeRemoved, Before: "/"},
+				{Path: "port", Kind: ChangeAdded, After: float64(80)},
+			},
+		},
+		{
+			name:   "nested objects",
+			before: map[string]interface{}{"service": map[string]interface{}{"id": "s1"}},
+			after:  map[string]interface{}{"service": map[string]interface{}{"id": "s2", "name": "billing"}},
+			want: []FieldChange{
+				{Path: "service.id", Kind: ChangeChanged, Before: "s1", After: "s2"},
+				{Path: "service.name", Kind: ChangeAdded, After: "billing"},
+			},
+		},
+		{
+			name:   "scalar arrays are sets",
+			before: map[string]interface{}{"tags": []interface{}{"a", "b"}, "methods": []interface{}{"GET", "POST"}},
+			after:  map[string]interface{}{"tags": []interface{}{"b", "c"}, "methods": []interface{}{"POST", "GET"}},
+			want: []FieldChange{
+				{Path: "methods", Kind: ChangeChanged, Before: []interface{}{"GET", "POST"}, After: []interface{}{"POST", "GET"}},
+				{Path: "tags", Kind: ChangeChanged, Added: []interface{}{"c"}, Removed: []interface{}{"a"}},
+			},
+		},
+		{
+			name: "object arrays by id",
+			before: map[string]interface{}{"plugins": []interface{}{
+				map[string]interface{}{"id": "p1", "config": map[string]interface{}{"minute": float64(5)}},
+				map[string]interface{}{"id": "p2"},
+			}},
+			after: map[string]interface{}{"plugins": []interface{}{
+				map[string]interface{}{"id": "p3"},
+				map[string]interface{}{"id": "p1", "config": map[string]interface{}{"minute": float64(10)}},
+			}},
+			want: []FieldChange{
+				{Path: "plugins[id=p1].config.minute", Kind: ChangeChanged, Before: float64(5), After: float64(10)},
+				{Path: "plugins[id=p2]", Kind: ChangeRemoved, Before: map[string]interface{}{"id": "p2"}},
+				{Path: "plugins[id=p3]", Kind: ChangeAdded, After: map[string]interface{}{"id": "p3"}},
+			},
+		},
+		{
+			name:   "object arrays by position",
+			before: map[string]interface{}{"headers": []interface{}{map[string]interface{}{"name": "a"}}},
+			after:  map[string]interface{}{"headers": []interface{}{map[string]interface{}{"name": "b"}, map[string]interface{}{"name": "c"}}},
+			want: []FieldChange{
+				{Path: "headers[0].name", Kind: ChangeChanged, Before: "a", After: "b"},
+				{Path: "headers[1]", Kind: ChangeAdded, After: map[string]interface{}{"name": "c"}},
+			},
+		},
+		{
+			name:          "ignored fields",
+			ignoredFields: []string{"updated_at", "service.name"},
+			before:        map[string]interface{}{"updated_at": float64(1), "service": map[string]interface{}{"name": "a"}},
+			after:         map[string]interface{}{"updated_at": float64(2), "service": map[string]interface{}{"name": "b"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewFieldDiffer(tt.ignoredFields).Diff(tt.before, tt.after)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistoryDocument(t *testing.T) {
+	var event models.CDCEvent
+	event.Op = models.OpUpdate
+	event.TsMs = 1706812484573
+	event.After.Key = "c/cp-1/o/route/r1"
+	key, _ := models.ParseEntityKey(event.After.Key)
+	changes := NewFieldDiffer(nil).Diff(
+		map[string]interface{}{"paths": []interface{}{"/a"}, "service": map[string]interface{}{"id": "s1"}},
+		map[string]interface{}{"paths": []interface{}{"/a", "/b"}, "service": "s2"},
+	)
+
+	got := NewHistoryDocument(event, key, changes)
+	want := HistoryDocument{
+		EntityType:     "route",
+		EntityID:       "r1",
+		ControlPlaneID: "cp-1",
+		Op:             "update",
+		TsMs:           1706812484573,
+		Link:           "c/cp-1/o/route/r1",
+		Changes: []HistoryChange{
+			{Path: "paths", Kind: ChangeChanged, Added: []string{`"/b"`}},
+			{Path: "service", Kind: ChangeChanged, Before: `{"id":"s1"}`, After: `"s2"`},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("NewHistoryDocument() = %+v, want %+v", got, want)
+	}
+	if id := HistoryDocumentID(key, event.TsMs); id != "cp-1:route:r1:1706812484573" {
+		t.Errorf("HistoryDocumentID() = %q", id)
+	}
+	if summary := Summarize(changes); !reflect.DeepEqual(summary, ChangeSummary{Changed: []string{"paths", "service"}}) {
+		t.Errorf("Summarize() = %+v", summary)
+	}
+}
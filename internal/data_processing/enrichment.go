@@ -0,0 +1,256 @@
+package data_processing
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// Relation describes a child entity referencing a parent through an object holding its id,
+// such as the service of a route
+type Relation struct {
+	ChildType  string
+	Field      string
+	ParentType string
+}
+
+// DefaultRelations returns the relations enriched by default
+func DefaultRelations() []Relation {
+	return []Relation{
+		{ChildType: "route", Field: "service", ParentType: "service"},
+		{ChildType: "target", Field: "upstream", ParentType: "upstream"},
+		{ChildType: "sni", Field: "certificate", ParentType: "certificate"},
+	}
+}
+
+type entityRef struct {
+	controlPlaneID string
+	entityType     string
+	id             string
+}
+
+// RelationEnricher implements Enricher. It keeps the names of parent entities per control
+// plane as they stream by and embeds them into the reference objects of their children.
+// Parents consumed by another instance are read back from their index on a lookup miss.
+type RelationEnricher struct {
+	byChild     map[string][]Relation
+	byParent    map[string][]Relation
+	indexPrefix string
+	getter      DocumentGetter
+	updater     ReferenceUpdater
+	logger      *zap.Logger
+
+	mu    sync.Mutex
+	names map[entityRef]string
+}
+
+// NewRelationEnricher creates a new relation enricher
+func NewRelationEnricher(relations []Relation, indexPrefix string, logger *zap.Logger) *RelationEnricher {
+	e := &RelationEnricher{
+		byChild:     make(map[string][]Relation),
+		byParent:    make(map[string][]Relation),
+		indexPrefix: indexPrefix,
+		logger:      logger,
+		names:       make(map[entityRef]string),
+	}
+	for _, relation := range relations {
+		e.byChild[relation.ChildType] = append(e.byChild[relation.ChildType], relation)
+		e.byParent[relation.ParentType] = append(e.byParent[relation.ParentType], relation)
+	}
+	return e
+}
+
+// SetDocumentGetter enables reading parents missing from the local lookup from their index
+func (e *RelationEnricher) SetDocumentGetter(getter DocumentGetter) {
+	e.getter = getter
+}
+
+// SetReferenceUpdater enables re-enriching indexed children when a parent name changes
+func (e *RelationEnricher) SetReferenceUpdater(updater ReferenceUpdater) {
+	e.updater = updater
+}
+
+// Enrich records the name of a parent entity and embeds the names of the parents a child
+// entity references. The document itself is not modified.
+func (e *RelationEnricher) Enrich(controlPlaneID string, entityType string, document map[string]interface{}) map[string]interface{} {
+	if _, ok := e.byParent[entityType]; ok {
+		e.observeParent(controlPlaneID, entityType, document)
+	}
+
+	relations, ok := e.byChild[entityType]
+	if !ok {
+		return document
+	}
+
+	enriched := make(map[string]interface{}, len(document))
+	for field, value := range document {
+		enriched[field] = value
+	}
+	for _, relation := range relations {
+		reference, ok := document[relation.Field].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		parentID, ok := reference["id"].(string)
+		if !ok {
+			continue
+		}
+		name, ok := e.parentName(entityRef{controlPlaneID, relation.ParentType, parentID})
+		if !ok {
+			continue
+		}
+
+		embedded := make(map[string]interface{}, len(reference)+1)
+		for field, value := range reference {
+			embedded[field] = value
+		}
+		embedded["name"] = name
+		enriched[relation.Field] = embedded
+	}
+	return enriched
+}
+
+// Stale reports whether a child embeds a parent name that changed since it was enriched.
+// A parent renamed while the child was being written may have re-enriched the indexed
+// children before the child landed, so the child must then be enriched and written again.
+func (e *RelationEnricher) Stale(controlPlaneID string, entityType string, document map[string]interface{}) bool {
+	for _, relation := range e.byChild[entityType] {
+		reference, ok := document[relation.Field].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		parentID, _ := reference["id"].(string)
+		embedded, _ := reference["name"].(string)
+
+		e.mu.Lock()
+		name, known := e.names[entityRef{controlPlaneID, relation.ParentType, parentID}]
+		e.mu.Unlock()
+		if known && name != embedded {
+			return true
+		}
+	}
+	return false
+}
+
+// observeParent updates the local lookup and re-enriches the indexed children when the
+// name differs from the one last seen by this instance or, for parents it has not seen,
+// from the indexed parent. This covers renames as well as children indexed before their
+// parent was seen, without an update by query for every unchanged parent after a restart.
+func (e *RelationEnricher) observeParent(controlPlaneID string, entityType string, document map[string]interface{}) {
+	id, _ := document["id"].(string)
+	name := EntityName(entityType, document)
+	if id == "" || name == "" {
+		return
+	}
+
+	ref := entityRef{controlPlaneID, entityType, id}
+	e.mu.Lock()
+	previous, known := e.names[ref]
+	e.names[ref] = name
+	e.mu.Unlock()
+
+	if e.updater == nil || (known && previous == name) {
+		return
+	}
+	// Children indexed since the parent was last written embed its indexed name
+	if !known && e.indexedName(ref) == name {
+		return
+	}
+	for _, relation := range e.byParent[entityType] {
+		childIndex := e.indexPrefix + "-" + relation.ChildType
+		updated, err := e.updater.UpdateReferenceName(childIndex, relation.Field, id, name)
+		if err != nil {
+			e.logger.Error("Failed to re-enrich children",
+				zap.String("indexName", childIndex),
+				zap.String("parentID", id),
+				zap.Error(err),
+			)
+			continue
+		}
+		if updated > 0 {
+			e.logger.Info("Re-enriched children",
+				zap.String("indexName", childIndex),
+				zap.String("parentID", id),
+				zap.String("name", name),
+				zap.Int64("updated", updated),
+			)
+		}
+	}
+}
+
+// indexedName returns the name of the indexed parent, empty when it cannot be read
+func (e *RelationEnricher) indexedName(ref entityRef) string {
+	if e.getter == nil {
+		return ""
+	}
+	document, err := e.getter.GetDocument(e.indexPrefix+"-"+ref.entityType, ref.id)
+	if err != nil {
+		e.logger.Warn("Failed to read parent entity",
+			zap.String("entityType", ref.entityType),
+			zap.String("id", ref.id),
+			zap.Error(err),
+		)
+		return ""
+	}
+	return EntityName(ref.entityType, document)
+}
+
+// parentName returns the name of a parent from the local lookup or its index
+func (e *RelationEnricher) parentName(ref entityRef) (string, bool) {
+	e.mu.Lock()
+	name, ok := e.names[ref]
+	e.mu.Unlock()
+	if ok || e.getter == nil {
+		return name, ok
+	}
+
+	document, err := e.getter.GetDocument(e.indexPrefix+"-"+ref.entityType, ref.id)
+	if err != nil {
+		e.logger.Warn("Failed to read parent entity",
+			zap.String("entityType", ref.entityType),
+			zap.String("id", ref.id),
+			zap.Error(err),
+		)
+		return "", false
+	}
+	if name = EntityName(ref.entityType, document); name == "" {
+		return "", false
+	}
+
+	e.mu.Lock()
+	e.names[ref] = name
+	e.mu.Unlock()
+	return name, true
+}
+
+// EntityName returns the display name of an entity. Certificates have no name and are
+// named after the subject of their certificate instead.
+func EntityName(entityType string, document map[string]interface{}) string {
+	if entityType == "certificate" {
+		if cert, ok := document["cert"].(string); ok {
+			return certificateName(cert)
+		}
+	}
+	name, _ := document["name"].(string)
+	return name
+}
+
+func certificateName(certPEM string) string {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil {
+		return ""
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return ""
+	}
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0]
+	}
+	return ""
+}
@@ -0,0 +1,187 @@
+package data_processing
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/kong/konnect-ingest/internal/models"
+	"go.uber.org/zap"
+)
+
+func TestRelationEnricher(t *testing.T) {
+	route := func() map[string]interface{} {
+		return map[string]interface{}{"id": "route-1", "name": "r1", "service": map[string]interface{}{"id": "svc-1"}}
+	}
+	service := func(name string) map[string]interface{} {
+		return map[string]interface{}{"id": "svc-1", "name": name}
+	}
+
+	t.Run("embeds known parent name", func(t *testing.T) {
+		enricher := NewRelationEnricher(DefaultRelations(), "cdc", zap.NewNop())
+		enricher.Enrich("cp-1", "service", service("billing"))
+
+		original := route()
+		enriched := enricher.Enrich("cp-1", "route", original)
+		if got := enriched["service"].(map[string]interface{})["name"]; got != "billing" {
+			t.Errorf("service name = %v, want billing", got)
+		}
+		if _, ok := original["service"].(map[string]interface{})["name"]; ok {
+			t.Error("Enrich() modified its input")
+		}
+
+		// Names are scoped by control plane
+		other := enricher.Enrich("cp-2", "route", route())
+		if _, ok := other["service"].(map[string]interface{})["name"]; ok {
+			t.Error("name of another control plane was embedded")
+		}
+	})
+
+	t.Run("reads unknown parents from their index", func(t *testing.T) {
+		store := NewMockDocumentStore()
+		store.Put("cdc-service", "svc-1", service("billing"))
+		enricher := NewRelationEnricher(DefaultRelations(), "cdc", zap.NewNop())
+		enricher.SetDocumentGetter(store)
+
+		enricher.Enrich("cp-1", "route", route())
+		enriched := enricher.Enrich("cp-1", "route", route())
+		if got := enriched["service"].(map[string]interface{})["name"]; got != "billing" {
+			t.Errorf("service name = %v, want billing", got)
+		}
+		if store.reads != 1 {
+			t.Errorf("parent read %d times, want once", store.reads)
+		}
+	})
+
+	t.Run("re-enriches children on rename", func(t *testing.T) {
+		store := NewMockDocumentStore()
+		enricher := NewRelationEnricher(DefaultRelations(), "cdc", zap.NewNop())
+		enricher.SetReferenceUpdater(store)
+
+		enricher.Enrich("cp-1", "service", service("billing"))
+		store.Put("cdc-route", "route-1", enricher.Enrich("cp-1", "route", route()))
+		enricher.Enrich("cp-1", "service", service("billing"))
+		enricher.Enrich("cp-1", "service", service("invoicing"))
+
+		want := []string{"cdc-route service svc-1=billing", "cdc-route service svc-1=invoicing"}
+		if len(store.updates) != len(want) || store.updates[0] != want[0] || store.updates[1] != want[1] {
+			t.Errorf("updates = %v, want %v", store.updates, want)
+		}
+		if got := store.documents["cdc-route/route-1"]["service"].(map[string]interface{})["name"]; got != "invoicing" {
+			t.Errorf("indexed service name = %v, want invoicing", got)
+		}
+	})
+
+	t.Run("skips parents unchanged since they were indexed", func(t *testing.T) {
+		store := NewMockDocumentStore()
+		store.Put("cdc-service", "svc-1", service("billing"))
+		enricher := NewRelationEnricher(DefaultRelations(), "cdc", zap.NewNop())
+		enricher.SetDocumentGetter(store)
+		enricher.SetReferenceUpdater(store)
+
+		enricher.Enrich("cp-1", "service", service("billing"))
+		if len(store.updates) != 0 {
+			t.Errorf("updates = %v, want none for an unchanged parent", store.updates)
+		}
+		enricher.Enrich("cp-1", "service", service("invoicing"))
+		if want := []string{"cdc-route service svc-1=invoicing"}; len(store.updates) != 1 || store.updates[0] != want[0] {
+			t.Errorf("updates = %v, want %v", store.updates, want)
+		}
+	})
+
+	t.Run("reports children embedding a renamed parent", func(t *testing.T) {
+		enricher := NewRelationEnricher(DefaultRelations(), "cdc", zap.NewNop())
+		enricher.Enrich("cp-1", "service", service("billing"))
+		enriched := enricher.Enrich("cp-1", "route", route())
+		if enricher.Stale("cp-1", "route", enriched) {
+			t.Error("Stale() = true for a current child")
+		}
+		enricher.Enrich("cp-1", "service", service("invoicing"))
+		if !enricher.Stale("cp-1", "route", enriched) {
+			t.Error("Stale() = false after the parent was renamed")
+		}
+	})
+
+	t.Run("names certificates after their subject", func(t *testing.T) {
+		enricher := NewRelationEnricher(DefaultRelations(), "cdc", zap.NewNop())
+		enricher.Enrich("cp-1", "certificate", map[string]interface{}{"id": "cert-1", "cert": testCertificatePEM(t, "demo1.example.com")})
+
+		enriched := enricher.Enrich("cp-1", "sni", map[string]interface{}{
+			"id":          "sni-1",
+			"name":        "demo1.example.com",
+			"certificate": map[string]interface{}{"id": "cert-1"},
+		})
+		if got := enriched["certificate"].(map[string]interface{})["name"]; got != "demo1.example.com" {
+			t.Errorf("certificate name = %v, want demo1.example.com", got)
+		}
+	})
+}
+
+// The sample stream starts after its services and upstreams were created, so every child
+// is indexed before its parent appears and must be re-enriched afterwards
+func TestRelationEnricherSampleStream(t *testing.T) {
+	store := NewMockDocumentStore()
+	enricher := NewRelationEnricher(DefaultRelations(), "cdc", zap.NewNop())
+	enricher.SetReferenceUpdater(store)
+
+	parents := map[models.EntityKey]string{}
+	for _, event := range loadStreamEvents(t) {
+		key, ok := models.ParseEntityKey(event.After.Key)
+		object, isObject := event.After.Value.Object.(map[string]interface{})
+		if !ok || !isObject || (key.EntityType != "route" && key.EntityType != "target") {
+			continue
+		}
+
+		store.Put("cdc-"+key.EntityType, key.ID, enricher.Enrich(key.ControlPlaneID, key.EntityType, object))
+		for _, relation := range DefaultRelations() {
+			if reference, ok := object[relation.Field].(map[string]interface{}); ok && relation.ChildType == key.EntityType {
+				parent := models.EntityKey{ControlPlaneID: key.ControlPlaneID, EntityType: relation.ParentType, ID: reference["id"].(string)}
+				parents[parent] = relation.ParentType + "-" + parent.ID[:8]
+			}
+		}
+	}
+	if len(parents) == 0 {
+		t.Fatal("expected routes and targets in the sample stream")
+	}
+
+	for parent, name := range parents {
+		enricher.Enrich(parent.ControlPlaneID, parent.EntityType, map[string]interface{}{"id": parent.ID, "name": name})
+	}
+
+	for key, document := range store.documents {
+		for _, field := range []string{"service", "upstream"} {
+			reference, ok := document[field].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if want := field + "-" + reference["id"].(string)[:8]; reference["name"] != want {
+				t.Errorf("%s: %s name = %v, want %s", key, field, reference["name"], want)
+			}
+		}
+	}
+}
+
+func testCertificatePEM(t *testing.T, commonName string) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
@@ -2,6 +2,7 @@ package data_processing
 
 import (
 	"errors"
+	"strconv"
 	"strings"
 
 	"go.uber.org/zap"
@@ -48,4 +49,65 @@ func (e *CDCEntityExtractor) ExtractEntityInfo(key string, value interface{}) (s
 	}
 
 	return entityType, id, nil
-}
\ No newline at end of file
+}
+
+// ObjectEntityExtractor implements EntityExtractor for topics carrying a single entity type,
+// such as one Debezium topic per table, whose keys do not follow the c/<cp>/o/<type>/<id> layout
+type ObjectEntityExtractor struct {
+	entityType string
+	idField    string
+	logger     *zap.Logger
+}
+
+// NewObjectEntityExtractor creates an extractor returning the given entity type and reading
+// the ID from the idField of the object
+func NewObjectEntityExtractor(entityType string, idField string, logger *zap.Logger) *ObjectEntityExtractor {
+	if idField == "" {
+		idField = "id"
+	}
+	return &ObjectEntityExtractor{
+		entityType: entityType,
+		idField:    idField,
+		logger:     logger,
+	}
+}
+
+// ExtractEntityInfo returns the configured entity type and the ID of the object
+func (e *ObjectEntityExtractor) ExtractEntityInfo(key string, value interface{}) (string, string, error) {
+	obj, ok := value.(map[string]interface{})
+	if !ok {
+		e.logger.Error("Failed to cast object to map", zap.String("key", key))
+		return "", "", ErrInvalidObject
+	}
+
+	switch id := obj[e.idField].(type) {
+	case string:
+		return e.entityType, id, nil
+	case float64:
+		return e.entityType, strconv.FormatFloat(id, 'f', -1, 64), nil
+	default:
+		e.logger.Error("Failed to get ID from object", zap.String("key", key), zap.String("idField", e.idField))
+		return "", "", ErrMissingID
+	}
+}
+
+// EntityTypeOverride implements EntityExtractor. It keeps the ID found by another extractor
+// and replaces the entity type, so the documents of a topic land in the index of that type.
+type EntityTypeOverride struct {
+	extractor  EntityExtractor
+	entityType string
+}
+
+// NewEntityTypeOverride creates an extractor overriding the entity type of another one
+func NewEntityTypeOverride(extractor EntityExtractor, entityType string) *EntityTypeOverride {
+	return &EntityTypeOverride{extractor: extractor, entityType: entityType}
+}
+
+// ExtractEntityInfo extracts the entity with the wrapped extractor and overrides its type
+func (e *EntityTypeOverride) ExtractEntityInfo(key string, value interface{}) (string, string, error) {
+	_, id, err := e.extractor.ExtractEntityInfo(key, value)
+	if err != nil {
+		return "", "", err
+	}
+	return e.entityType, id, nil
+}
@@ -0,0 +1,65 @@
+package data_processing
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestTopicEntityExtractors(t *testing.T) {
+	object := map[string]interface{}{"id": "svc-1", "service_id": float64(42)}
+
+	tests := []struct {
+		name      string
+		extractor EntityExtractor
+		key       string
+		wantType  string
+		wantID    string
+		wantErr   bool
+	}{
+		{
+			name:      "object id",
+			extractor: NewObjectEntityExtractor("service", "", zap.NewNop()),
+			key:       `{"id": "svc-1"}`,
+			wantType:  "service",
+			wantID:    "svc-1",
+		},
+		{
+			name:      "numeric id field",
+			extractor: NewObjectEntityExtractor("service", "service_id", zap.NewNop()),
+			key:       "42",
+			wantType:  "service",
+			wantID:    "42",
+		},
+		{
+			name:      "missing id field",
+			extractor: NewObjectEntityExtractor("service", "uuid", zap.NewNop()),
+			wantErr:   true,
+		},
+		{
+			name:      "entity type override",
+			extractor: NewEntityTypeOverride(NewCDCEntityExtractor(zap.NewNop()), "legacy_service"),
+			key:       "c/cp-1/o/service/svc-1",
+			wantType:  "legacy_service",
+			wantID:    "svc-1",
+		},
+		{
+			name:      "override keeps key errors",
+			extractor: NewEntityTypeOverride(NewCDCEntityExtractor(zap.NewNop()), "legacy_service"),
+			key:       "svc-1",
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entityType, id, err := tt.extractor.ExtractEntityInfo(tt.key, object)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ExtractEntityInfo() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if entityType != tt.wantType || id != tt.wantID {
+				t.Errorf("ExtractEntityInfo() = %s, %s, want %s, %s", entityType, id, tt.wantType, tt.wantID)
+			}
+		})
+	}
+}
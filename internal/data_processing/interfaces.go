@@ -1,11 +1,89 @@
 package data_processing
 
+import "github.com/kong/konnect-ingest/internal/models"
+
 // DocumentIndexer defines the contract for indexing documents
 type DocumentIndexer interface {
 	IndexDocument(indexName string, id string, document interface{}) error
 }
 
+// DocumentGetter defines the contract for reading an indexed document, nil when it does not exist
+type DocumentGetter interface {
+	GetDocument(indexName string, id string) (map[string]interface{}, error)
+}
+
 // EntityExtractor defines the contract for extracting entity information
 type EntityExtractor interface {
 	ExtractEntityInfo(key string, value interface{}) (entityType string, id string, err error)
 }
+
+// AliasResolver defines the contract for resolving and bootstrapping versioned index aliases
+type AliasResolver interface {
+	EnsureIndex(logical string) error
+	Resolve(alias string) ([]string, error)
+}
+
+// Redactor defines the contract for removing sensitive values from a document before indexing
+type Redactor interface {
+	Redact(entityType string, object map[string]interface{}) map[string]interface{}
+}
+
+// Enricher defines the contract for embedding related entity data into a document before indexing
+type Enricher interface {
+	Enrich(controlPlaneID string, entityType string, document map[string]interface{}) map[string]interface{}
+}
+
+// StaleChecker defines the contract for enrichers whose embedded data may change while the
+// enriched document is written. Stale reports whether the written document must be enriched again.
+type StaleChecker interface {
+	Stale(controlPlaneID string, entityType string, document map[string]interface{}) bool
+}
+
+// Differ defines the contract for computing the field changes between the before and after images of an entity
+type Differ interface {
+	Diff(before map[string]interface{}, after map[string]interface{}) []FieldChange
+}
+
+// ReferenceUpdater defines the contract for updating the parent name embedded in indexed children
+type ReferenceUpdater interface {
+	UpdateReferenceName(indexName string, field string, parentID string, name string) (int64, error)
+}
+
+// ControlPlaneUpdater defines the contract for restamping control plane metadata on indexed documents
+type ControlPlaneUpdater interface {
+	UpdateControlPlane(indexPattern string, controlPlane map[string]interface{}) (int64, error)
+}
+
+// SearchProjector defines the contract for projecting an entity into the unified search document shape
+type SearchProjector interface {
+	Project(key models.EntityKey, object map[string]interface{}) (SearchDocument, bool)
+}
+
+// DocumentDeleter defines the contract for removing an indexed document. Deleting a missing
+// document is not an error.
+type DocumentDeleter interface {
+	DeleteDocument(indexName string, id string) error
+}
+
+// KeyedIndexer is implemented by indexers storing what the CDC key of a document identifies,
+// such as the control plane of a SQL row, which the document itself may not carry
+type KeyedIndexer interface {
+	IndexKeyedDocument(key string, indexName string, id string, document interface{}) error
+}
+
+// Sink defines the contract for a destination of processed documents. Documents are grouped
+// in indices named after their entity type and written with the CDC key of the event they
+// come from; writes may be buffered until Flush.
+type Sink interface {
+	Name() string
+	Capabilities() SinkCapabilities
+	Upsert(key string, indexName string, id string, document interface{}) error
+	Delete(indexName string, id string) error
+	Flush() error
+}
+
+// EventSink is implemented by sinks recording the consumed events themselves, such as an
+// archive, rather than the documents derived from them
+type EventSink interface {
+	RecordEvent(event models.CDCEvent) error
+}
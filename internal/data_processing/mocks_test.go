@@ -0,0 +1,159 @@
+package data_processing
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/kong/konnect-ingest/internal/models"
+	"github.com/kong/konnect-ingest/internal/producer"
+	"go.uber.org/zap"
+)
+
+// loadStreamEvents returns every event of stream.jsonl
+func loadStreamEvents(t *testing.T) []models.CDCEvent {
+	t.Helper()
+	reader, err := producer.NewEventReader("../../stream.jsonl", zap.NewNop())
+	if err != nil {
+		t.Fatalf("failed to open sample stream: %v", err)
+	}
+	defer reader.Close()
+
+	var events []models.CDCEvent
+	for {
+		event, err := reader.ReadEvent()
+		if err == io.EOF {
+			return events
+		}
+		if err != nil {
+			t.Fatalf("failed to read sample stream: %v", err)
+		}
+		events = append(events, *event)
+	}
+}
+
+// MockDocumentStore is an in-memory DocumentGetter, ReferenceUpdater and ControlPlaneUpdater
+type MockDocumentStore struct {
+	documents map[string]map[string]interface{}
+	reads     int
+	updates   []string
+}
+
+func NewMockDocumentStore() *MockDocumentStore {
+	return &MockDocumentStore{documents: make(map[string]map[string]interface{})}
+}
+
+func (m *MockDocumentStore) Put(indexName string, id string, document map[string]interface{}) {
+	m.documents[indexName+"/"+id] = document
+}
+
+func (m *MockDocumentStore) GetDocument(indexName string, id string) (map[string]interface{}, error) {
+	m.reads++
+	return m.documents[indexName+"/"+id], nil
+}
+
+func (m *MockDocumentStore) UpdateReferenceName(indexName string, field string, parentID string, name string) (int64, error) {
+	m.updates = append(m.updates, fmt.Sprintf("%s %s %s=%s", indexName, field, parentID, name))
+
+	var updated int64
+	for key, document := range m.documents {
+		reference, ok := document[field].(map[string]interface{})
+		if !ok || reference["id"] != parentID || !strings.HasPrefix(key, indexName+"/") {
+			continue
+		}
+		if reference["name"] != name {
+			reference["name"] = name
+			updated++
+		}
+	}
+	return updated, nil
+}
+
+func (m *MockDocumentStore) UpdateControlPlane(indexPattern string, controlPlane map[string]interface{}) (int64, error) {
+	m.updates = append(m.updates, fmt.Sprintf("%s %s=%v", indexPattern, controlPlane["id"], controlPlane["name"]))
+
+	prefix := strings.TrimSuffix(indexPattern, "*")
+	var updated int64
+	for key, document := range m.documents {
+		stamped, ok := document[ControlPlaneField].(map[string]interface{})
+		if !ok || stamped["id"] != controlPlane["id"] || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		document[ControlPlaneField] = controlPlane
+		updated++
+	}
+	return updated, nil
+}
+
+// MockAliasResolver resolves aliases from a fixed map and records ensured indices
+type MockAliasResolver struct {
+	aliases map[string][]string
+	ensured []string
+}
+
+func (m *MockAliasResolver) EnsureIndex(logical string) error {
+	m.ensured = append(m.ensured, logical)
+	return nil
+}
+
+func (m *MockAliasResolver) Resolve(alias string) ([]string, error) {
+	return m.aliases[alias], nil
+}
+
+// MockUpdatingIndexer is a DocumentIndexer with the updates of a MockDocumentStore
+type MockUpdatingIndexer struct {
+	*MockDocumentStore
+}
+
+func (m MockUpdatingIndexer) IndexDocument(indexName string, id string, document interface{}) error {
+	m.Put(indexName, id, document.(map[string]interface{}))
+	return nil
+}
+
+func (m MockUpdatingIndexer) DeleteDocument(indexName string, id string) error {
+	delete(m.documents, indexName+"/"+id)
+	return nil
+}
+
+// MockSink records upserts, deletes and flushes and fails them all when shouldFail is set
+type MockSink struct {
+	name         string
+	capabilities SinkCapabilities
+	shouldFail   bool
+	documents    map[string]interface{}
+	deleted      []string
+	flushes      int
+}
+
+func NewMockSink(name string, capabilities SinkCapabilities, shouldFail bool) *MockSink {
+	return &MockSink{name: name, capabilities: capabilities, shouldFail: shouldFail, documents: make(map[string]interface{})}
+}
+
+func (m *MockSink) Name() string                   { return m.name }
+func (m *MockSink) Capabilities() SinkCapabilities { return m.capabilities }
+
+func (m *MockSink) Upsert(key string, indexName string, id string, document interface{}) error {
+	if m.shouldFail {
+		return fmt.Errorf("mock upsert error")
+	}
+	m.documents[indexName+"/"+id] = document
+	return nil
+}
+
+func (m *MockSink) Delete(indexName string, id string) error {
+	if m.shouldFail {
+		return fmt.Errorf("mock delete error")
+	}
+	delete(m.documents, indexName+"/"+id)
+	m.deleted = append(m.deleted, indexName+"/"+id)
+	return nil
+}
+
+func (m *MockSink) Flush() error {
+	if m.shouldFail {
+		return fmt.Errorf("mock flush error")
+	}
+	m.flushes++
+	return nil
+}
@@ -1,7 +1,9 @@
 package data_processing
 
 import (
+	"bytes"
 	"encoding/json"
+	"net/http"
 	"strings"
 
 	"github.com/opensearch-project/opensearch-go/v2"
@@ -30,7 +32,7 @@ func (i *OpenSearchIndexer) IndexDocument(indexName string, id string, document
 		return err
 	}
 
-	_, err = i.client.Index(
+	res, err := i.client.Index(
 		indexName,
 		strings.NewReader(string(objectBytes)),
 		i.client.Index.WithDocumentID(id),
@@ -39,6 +41,183 @@ func (i *OpenSearchIndexer) IndexDocument(indexName string, id string, document
 		i.logger.Error("Failed to index document", zap.Error(err))
 		return err
 	}
+	defer res.Body.Close()
 
+	if res.IsError() {
+		return responseError("index document "+id, res)
+	}
+	return nil
+}
+
+// DeleteDocument deletes a document from OpenSearch, succeeding when it does not exist
+func (i *OpenSearchIndexer) DeleteDocument(indexName string, id string) error {
+	res, err := i.client.Delete(indexName, id)
+	if err != nil {
+		i.logger.Error("Failed to delete document", zap.Error(err))
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if res.IsError() {
+		return responseError("delete document "+id, res)
+	}
 	return nil
 }
+
+// CreateDocument indexes a document only if no document with the same id exists yet.
+// It reports whether the document was created.
+func (i *OpenSearchIndexer) CreateDocument(indexName string, id string, document interface{}) (bool, error) {
+	objectBytes, err := json.Marshal(document)
+	if err != nil {
+		i.logger.Error("Failed to marshal object", zap.Error(err))
+		return false, err
+	}
+
+	res, err := i.client.Index(
+		indexName,
+		strings.NewReader(string(objectBytes)),
+		i.client.Index.WithDocumentID(id),
+		i.client.Index.WithOpType("create"),
+	)
+	if err != nil {
+		i.logger.Error("Failed to create document", zap.Error(err))
+		return false, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusConflict {
+		return false, nil
+	}
+	if res.IsError() {
+		return false, responseError("create document "+id, res)
+	}
+	return true, nil
+}
+
+// GetDocument returns the source of a document, or nil when it does not exist
+func (i *OpenSearchIndexer) GetDocument(indexName string, id string) (map[string]interface{}, error) {
+	res, err := i.client.Get(indexName, id)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if res.IsError() {
+		return nil, responseError("get document "+id, res)
+	}
+
+	var body struct {
+		Source map[string]interface{} `json:"_source"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	return body.Source, nil
+}
+
+// UpdateReferenceName sets the name embedded in the reference field of every document of an
+// index referencing the parent, and returns the number of updated documents. Documents
+// already carrying the name are left untouched. The index is refreshed first, so children
+// written just before are updated too.
+func (i *OpenSearchIndexer) UpdateReferenceName(indexName string, field string, parentID string, name string) (int64, error) {
+	refresh, err := i.client.Indices.Refresh(
+		i.client.Indices.Refresh.WithIndex(indexName),
+		i.client.Indices.Refresh.WithAllowNoIndices(true),
+		i.client.Indices.Refresh.WithIgnoreUnavailable(true),
+	)
+	if err != nil {
+		return 0, err
+	}
+	refresh.Body.Close()
+
+	body, err := json.Marshal(map[string]interface{}{
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"filter": []interface{}{
+					map[string]interface{}{"term": map[string]interface{}{field + ".id.keyword": parentID}},
+				},
+				"must_not": []interface{}{
+					map[string]interface{}{"term": map[string]interface{}{field + ".name.keyword": name}},
+				},
+			},
+		},
+		"script": map[string]interface{}{
+			"lang":   "painless",
+			"source": "ctx._source[params.field].name = params.name",
+			"params": map[string]interface{}{"field": field, "name": name},
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := i.client.UpdateByQuery(
+		[]string{indexName},
+		i.client.UpdateByQuery.WithBody(bytes.NewReader(body)),
+		i.client.UpdateByQuery.WithConflicts("proceed"),
+		i.client.UpdateByQuery.WithAllowNoIndices(true),
+		i.client.UpdateByQuery.WithIgnoreUnavailable(true),
+	)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return 0, responseError("update references in "+indexName, res)
+	}
+
+	var result struct {
+		Updated int64 `json:"updated"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return 0, err
+	}
+	return result.Updated, nil
+}
+
+// UpdateControlPlane replaces the control plane metadata stamped on every document of the
+// matching indices belonging to the control plane, and returns the number of updated documents
+func (i *OpenSearchIndexer) UpdateControlPlane(indexPattern string, controlPlane map[string]interface{}) (int64, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"query": map[string]interface{}{
+			"term": map[string]interface{}{ControlPlaneField + ".id.keyword": controlPlane["id"]},
+		},
+		"script": map[string]interface{}{
+			"lang":   "painless",
+			"source": "ctx._source[params.field] = params.control_plane",
+			"params": map[string]interface{}{"field": ControlPlaneField, "control_plane": controlPlane},
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := i.client.UpdateByQuery(
+		[]string{indexPattern},
+		i.client.UpdateByQuery.WithBody(bytes.NewReader(body)),
+		i.client.UpdateByQuery.WithConflicts("proceed"),
+		i.client.UpdateByQuery.WithAllowNoIndices(true),
+		i.client.UpdateByQuery.WithIgnoreUnavailable(true),
+	)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return 0, responseError("update control plane in "+indexPattern, res)
+	}
+
+	var result struct {
+		Updated int64 `json:"updated"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return 0, err
+	}
+	return result.Updated, nil
+}
@@ -0,0 +1,324 @@
+package data_processing
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/kong/konnect-ingest/internal/config"
+)
+
+// Redaction actions applied to sensitive values
+const (
+	RedactionDrop = "drop"
+	RedactionHash = "hash"
+)
+
+// redactedPlaceholder replaces dropped array elements, which cannot be removed without
+// shifting the positions of their siblings
+const redactedPlaceholder = "[REDACTED]"
+
+// FieldRule restricts the fields of one entity type. Allow lists the top-level fields kept,
+// everything else is dropped; an empty list keeps all fields. Deny lists dotted paths that
+// are always redacted.
+type FieldRule struct {
+	Allow []string
+	Deny  []string
+}
+
+// RedactionRules configures a FieldRedactor
+type RedactionRules struct {
+	Action      string
+	HashSalt    string
+	EntityTypes map[string]FieldRule
+}
+
+// DefaultFieldRules returns the rules for Kong entities known to carry secrets. Detectors
+// catch most of these too; the explicit paths make the common cases independent of them.
+func DefaultFieldRules() map[string]FieldRule {
+	return map[string]FieldRule{
+		"key":                  {Deny: []string{"pem.private_key"}},
+		"certificate":          {Deny: []string{"key", "key_alt"}},
+		"vault":                {Deny: []string{"config"}},
+		"keyauth_credential":   {Deny: []string{"key"}},
+		"basicauth_credential": {Deny: []string{"password"}},
+		"hmacauth_credential":  {Deny: []string{"secret"}},
+		"jwt_secret":           {Deny: []string{"secret"}},
+		"oauth2_credential":    {Deny: []string{"client_secret"}},
+	}
+}
+
+// RedactionRulesFromConfig merges the configured entity rules over the defaults
+func RedactionRulesFromConfig(cfg *config.Config) RedactionRules {
+	rules := RedactionRules{
+		Action:      cfg.Redaction.Action,
+		HashSalt:    cfg.Redaction.HashSalt,
+		EntityTypes: DefaultFieldRules(),
+	}
+	for entityType, rule := range cfg.Redaction.EntityTypes {
+		rules.EntityTypes[entityType] = FieldRule{Allow: rule.Allow, Deny: rule.Deny}
+	}
+	return rules
+}
+
+var (
+	// Private key PEM blocks; certificates and public keys are left searchable
+	pemPrivateKeyPattern = regexp.MustCompile(`-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----`)
+	tokenPatterns        = []*regexp.Regexp{
+		regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`), // JWT
+		regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/-]+=*`),
+		regexp.MustCompile(`\b[ks]pat_[A-Za-z0-9]{20,}`), // Konnect access tokens
+		regexp.MustCompile(`\bAKIA[0-9A-Z]{16}\b`),       // AWS access key ids
+	}
+
+	redactedHashPattern = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+	// JWK members holding private key material, per RFC 7518 section 6
+	jwkPrivateMembers = []string{"d", "p", "q", "dp", "dq", "qi", "oth"}
+
+	credentialNames = map[string]bool{
+		"password":             true,
+		"passwd":               true,
+		"secret":               true,
+		"secret_id":            true,
+		"client_secret":        true,
+		"api_key":              true,
+		"apikey":               true,
+		"private_key":          true,
+		"token":                true,
+		"access_token":         true,
+		"refresh_token":        true,
+		"id_token":             true,
+		"session_token":        true,
+		"secret_access_key":    true,
+		"credentials":          true,
+		"service_account":      true,
+		"service_account_json": true,
+	}
+	credentialSuffixes = []string{"_password", "_secret", "_token", "_private_key", "_api_key"}
+)
+
+// FieldRedactor implements Redactor with per-entity field rules followed by detectors for
+// credentials, JWK private parameters, private key PEM blocks and tokens
+type FieldRedactor struct {
+	action   string
+	hashSalt string
+	rules    map[string]FieldRule
+}
+
+// NewFieldRedactor creates a new field redactor. Without a salt a hash of a low-entropy secret
+// is easily reversed, so hashing requires one.
+func NewFieldRedactor(rules RedactionRules) (*FieldRedactor, error) {
+	action := rules.Action
+	if action == "" {
+		action = RedactionDrop
+	}
+	if action != RedactionDrop && action != RedactionHash {
+		return nil, fmt.Errorf("unknown redaction action %q", rules.Action)
+	}
+	if action == RedactionHash && rules.HashSalt == "" {
+		return nil, fmt.Errorf("redaction action %q requires a hash salt", RedactionHash)
+	}
+	return &FieldRedactor{
+		action:   action,
+		hashSalt: rules.HashSalt,
+		rules:    rules.EntityTypes,
+	}, nil
+}
+
+// Redact returns a copy of the object without sensitive values. The object itself is not modified.
+func (r *FieldRedactor) Redact(entityType string, object map[string]interface{}) map[string]interface{} {
+	redacted := deepCopy(object).(map[string]interface{})
+
+	if rule, ok := r.rules[entityType]; ok {
+		if len(rule.Allow) > 0 {
+			allowed := map[string]bool{"id": true}
+			for _, field := range rule.Allow {
+				allowed[field] = true
+			}
+			for field := range redacted {
+				if !allowed[field] {
+					delete(redacted, field)
+				}
+			}
+		}
+		for _, path := range rule.Deny {
+			r.redactPath(redacted, strings.Split(path, "."))
+		}
+	}
+
+	r.scanObject(redacted)
+	return redacted
+}
+
+// redactPath redacts the value at a dotted path, if present
+func (r *FieldRedactor) redactPath(object map[string]interface{}, path []string) {
+	value, ok := object[path[0]]
+	if !ok {
+		return
+	}
+	if len(path) == 1 {
+		r.redactField(object, path[0])
+		return
+	}
+	if nested, ok := value.(map[string]interface{}); ok {
+		r.redactPath(nested, path[1:])
+	}
+}
+
+// scanObject applies the detectors to every field of an object, recursively
+func (r *FieldRedactor) scanObject(object map[string]interface{}) {
+	if kty, ok := object["kty"].(string); ok {
+		members := jwkPrivateMembers
+		if kty == "oct" {
+			members = append([]string{"k"}, members...)
+		}
+		for _, member := range members {
+			if _, ok := object[member]; ok {
+				r.redactField(object, member)
+			}
+		}
+	}
+
+	for field, value := range object {
+		if isRedacted(value) {
+			continue
+		}
+		if isCredentialName(field) && value != nil {
+			r.redactField(object, field)
+			continue
+		}
+		if replacement, sensitive := r.scanValue(value); sensitive {
+			r.redactField(object, field)
+		} else {
+			object[field] = replacement
+		}
+	}
+}
+
+// scanValue returns the value with nested secrets redacted, or reports that the value
+// itself is a secret
+func (r *FieldRedactor) scanValue(value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		r.scanObject(v)
+		return v, false
+	case []interface{}:
+		for i, element := range v {
+			if replacement, sensitive := r.scanValue(element); sensitive {
+				v[i] = r.redactValue(element)
+			} else {
+				v[i] = replacement
+			}
+		}
+		return v, false
+	case string:
+		if isSecretString(v) {
+			return v, true
+		}
+		return r.scanEmbeddedJSON(v), false
+	default:
+		return value, false
+	}
+}
+
+// scanEmbeddedJSON redacts secrets inside a JSON object encoded as a string, such as the
+// jwk field of key entities
+func (r *FieldRedactor) scanEmbeddedJSON(value string) string {
+	trimmed := strings.TrimSpace(value)
+	if !strings.HasPrefix(trimmed, "{") {
+		return value
+	}
+	var embedded map[string]interface{}
+	if err := json.Unmarshal([]byte(trimmed), &embedded); err != nil {
+		return value
+	}
+
+	r.scanObject(embedded)
+	encoded, err := json.Marshal(embedded)
+	if err != nil {
+		return redactedPlaceholder
+	}
+	return string(encoded)
+}
+
+// redactField drops or hashes a field of an object
+func (r *FieldRedactor) redactField(object map[string]interface{}, field string) {
+	if isRedacted(object[field]) {
+		return
+	}
+	if r.action == RedactionDrop {
+		delete(object, field)
+		return
+	}
+	object[field] = r.redactValue(object[field])
+}
+
+// redactValue returns the replacement of a sensitive value. Hashes are salted so that
+// low entropy secrets cannot be recovered with a dictionary, while equal values still
+// produce equal hashes for change detection.
+func (r *FieldRedactor) redactValue(value interface{}) interface{} {
+	if r.action == RedactionDrop {
+		return redactedPlaceholder
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return redactedPlaceholder
+	}
+	sum := sha256.Sum256(append([]byte(r.hashSalt), encoded...))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// isRedacted reports whether a value is already a redaction result, which keeps redaction
+// idempotent when a field matches several rules or a document is redacted twice
+func isRedacted(value interface{}) bool {
+	s, ok := value.(string)
+	return ok && (s == redactedPlaceholder || redactedHashPattern.MatchString(s))
+}
+
+func isCredentialName(field string) bool {
+	name := strings.ReplaceAll(strings.ToLower(field), "-", "_")
+	if credentialNames[name] {
+		return true
+	}
+	for _, suffix := range credentialSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func isSecretString(value string) bool {
+	if pemPrivateKeyPattern.MatchString(value) {
+		return true
+	}
+	for _, pattern := range tokenPatterns {
+		if pattern.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func deepCopy(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, element := range v {
+			copied[key] = deepCopy(element)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, element := range v {
+			copied[i] = deepCopy(element)
+		}
+		return copied
+	default:
+		return value
+	}
+}
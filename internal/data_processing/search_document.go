@@ -0,0 +1,230 @@
+package data_processing
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kong/konnect-ingest/internal/models"
+)
+
+// SearchIndexSuffix names the unified search index, e.g. "cdc-search"
+const SearchIndexSuffix = "search"
+
+// SearchDocument is the common shape every searchable entity is projected into, so that a
+// single query covers all entity types
+type SearchDocument struct {
+	EntityType     string `json:"entity_type"`
+	EntityID       string `json:"entity_id"`
+	ControlPlaneID string `json:"control_plane_id"`
+	// ControlPlane is the control plane metadata stamped on the entity, if any
+	ControlPlane  map[string]interface{} `json:"control_plane,omitempty"`
+	DisplayName   string                 `json:"display_name"`
+	SecondaryText []string               `json:"secondary_text,omitempty"`
+	Tags          []string               `json:"tags,omitempty"`
+	URLs          []string               `json:"urls,omitempty"`
+	// UpdatedAt is the last update of the entity in epoch milliseconds
+	UpdatedAt int64 `json:"updated_at,omitempty"`
+	// Link is the CDC key of the entity, from which the UI builds a deep link
+	Link string `json:"link"`
+}
+
+// SearchDocumentID returns the id of an entity in the unified search index
+func SearchDocumentID(key models.EntityKey) string {
+	return fmt.Sprintf("%s:%s:%s", key.ControlPlaneID, key.EntityType, key.ID)
+}
+
+// Projection fills the type specific parts of a search document: display name, secondary
+// text and URLs
+type Projection func(object map[string]interface{}, document *SearchDocument)
+
+// ProjectionRegistry implements SearchProjector with one projection per searchable entity type
+type ProjectionRegistry struct {
+	projections map[string]Projection
+}
+
+// NewProjectionRegistry creates an empty projection registry
+func NewProjectionRegistry() *ProjectionRegistry {
+	return &ProjectionRegistry{projections: make(map[string]Projection)}
+}
+
+// DefaultProjections returns a registry with the projections of the entity types the
+// search bar covers
+func DefaultProjections() *ProjectionRegistry {
+	registry := NewProjectionRegistry()
+	registry.Register("service", projectService)
+	registry.Register("route", projectRoute)
+	registry.Register("node", projectNode)
+	registry.Register("consumer", projectConsumer)
+	registry.Register("consumer_group", projectNamed)
+	registry.Register("upstream", projectUpstream)
+	registry.Register("target", projectTarget)
+	registry.Register("vault", projectVault)
+	registry.Register("sni", projectSNI)
+	return registry
+}
+
+// Register sets the projection of an entity type, replacing any previous one
+func (r *ProjectionRegistry) Register(entityType string, projection Projection) {
+	r.projections[entityType] = projection
+}
+
+// Project returns the search document of an entity, or false when its type is not searchable
+func (r *ProjectionRegistry) Project(key models.EntityKey, object map[string]interface{}) (SearchDocument, bool) {
+	projection, ok := r.projections[key.EntityType]
+	if !ok {
+		return SearchDocument{}, false
+	}
+
+	document := SearchDocument{
+		EntityType:     key.EntityType,
+		EntityID:       key.ID,
+		ControlPlaneID: key.ControlPlaneID,
+		Tags:           stringList(object["tags"]),
+		Link:           "c/" + key.ControlPlaneID + "/o/" + key.EntityType + "/" + key.ID,
+	}
+	if controlPlane, ok := object[ControlPlaneField].(map[string]interface{}); ok {
+		document.ControlPlane = controlPlane
+	}
+	if updatedAt, ok := object["updated_at"].(float64); ok {
+		document.UpdatedAt = int64(updatedAt) * 1000
+	}
+
+	projection(object, &document)
+	if document.DisplayName == "" {
+		document.DisplayName = key.ID
+	}
+	return document, true
+}
+
+func projectNamed(object map[string]interface{}, document *SearchDocument) {
+	document.DisplayName = stringField(object, "name")
+}
+
+func projectService(object map[string]interface{}, document *SearchDocument) {
+	document.DisplayName = stringField(object, "name")
+	document.SecondaryText = nonEmpty(stringField(object, "host"), stringField(object, "path"))
+
+	host := stringField(object, "host")
+	if host == "" {
+		return
+	}
+	url := host
+	if protocol := stringField(object, "protocol"); protocol != "" {
+		url = protocol + "://" + host
+	}
+	if port, ok := object["port"].(float64); ok {
+		url += fmt.Sprintf(":%d", int64(port))
+	}
+	document.URLs = []string{url + stringField(object, "path")}
+}
+
+func projectRoute(object map[string]interface{}, document *SearchDocument) {
+	paths := stringList(object["paths"])
+	hosts := stringList(object["hosts"])
+
+	document.DisplayName = stringField(object, "name")
+	if document.DisplayName == "" && len(paths) > 0 {
+		document.DisplayName = paths[0]
+	}
+	document.SecondaryText = append(nonEmpty(referenceName(object, "service")), stringList(object["methods"])...)
+
+	if len(hosts) == 0 {
+		document.URLs = paths
+		return
+	}
+	for _, host := range hosts {
+		if len(paths) == 0 {
+			document.URLs = append(document.URLs, host)
+		}
+		for _, path := range paths {
+			document.URLs = append(document.URLs, host+path)
+		}
+	}
+}
+
+func projectNode(object map[string]interface{}, document *SearchDocument) {
+	document.DisplayName = stringField(object, "hostname")
+	document.SecondaryText = nonEmpty(stringField(object, "version"), stringField(object, "type"))
+
+	if labels, ok := object["labels"].(map[string]interface{}); ok {
+		names := make([]string, 0, len(labels))
+		for name := range labels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if value, ok := labels[name].(string); ok {
+				document.SecondaryText = append(document.SecondaryText, name+"="+value)
+			}
+		}
+	}
+}
+
+func projectConsumer(object map[string]interface{}, document *SearchDocument) {
+	document.DisplayName = stringField(object, "username")
+	customID := stringField(object, "custom_id")
+	if document.DisplayName == "" {
+		document.DisplayName = customID
+		return
+	}
+	document.SecondaryText = nonEmpty(customID)
+}
+
+func projectUpstream(object map[string]interface{}, document *SearchDocument) {
+	document.DisplayName = stringField(object, "name")
+	document.SecondaryText = nonEmpty(stringField(object, "host_header"), stringField(object, "algorithm"))
+}
+
+func projectTarget(object map[string]interface{}, document *SearchDocument) {
+	document.DisplayName = stringField(object, "target")
+	document.SecondaryText = nonEmpty(referenceName(object, "upstream"))
+	document.URLs = nonEmpty(stringField(object, "target"))
+}
+
+func projectVault(object map[string]interface{}, document *SearchDocument) {
+	document.DisplayName = stringField(object, "name")
+	document.SecondaryText = nonEmpty(stringField(object, "prefix"), stringField(object, "description"))
+}
+
+func projectSNI(object map[string]interface{}, document *SearchDocument) {
+	document.DisplayName = stringField(object, "name")
+	document.SecondaryText = nonEmpty(referenceName(object, "certificate"))
+}
+
+func stringField(object map[string]interface{}, field string) string {
+	value, _ := object[field].(string)
+	return strings.TrimSpace(value)
+}
+
+// referenceName returns the name embedded into a reference object by enrichment
+func referenceName(object map[string]interface{}, field string) string {
+	if reference, ok := object[field].(map[string]interface{}); ok {
+		return stringField(reference, "name")
+	}
+	return ""
+}
+
+func stringList(value interface{}) []string {
+	values, ok := value.([]interface{})
+	if !ok {
+		return nil
+	}
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if s, ok := value.(string); ok && s != "" {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+func nonEmpty(values ...string) []string {
+	var result []string
+	for _, value := range values {
+		if value != "" {
+			result = append(result, value)
+		}
+	}
+	return result
+}
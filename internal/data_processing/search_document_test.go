@@ -0,0 +1,176 @@
+package data_processing
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kong/konnect-ingest/internal/models"
+)
+
+func TestProjectionRegistry(t *testing.T) {
+	key := models.EntityKey{ControlPlaneID: "cp-1", EntityType: "route", ID: "route-1"}
+
+	tests := []struct {
+		name   string
+		key    models.EntityKey
+		object map[string]interface{}
+		want   SearchDocument
+		ok     bool
+	}{
+		{
+			name: "route with hosts and enriched service",
+			key:  key,
+			object: map[string]interface{}{
+				"name":       "orders",
+				"paths":      []interface{}{"/orders", "/v2/orders"},
+				"hosts":      []interface{}{"api.example.com"},
+				"methods":    []interface{}{"GET"},
+				"service":    map[string]interface{}{"id": "service-1", "name": "orders-service"},
+				"tags":       []interface{}{"team-a"},
+				"updated_at": float64(1706814908),
+			},
+			want: SearchDocument{
+				EntityType:     "route",
+				EntityID:       "route-1",
+				ControlPlaneID: "cp-1",
+				DisplayName:    "orders",
+				SecondaryText:  []string{"orders-service", "GET"},
+				Tags:           []string{"team-a"},
+				URLs:           []string{"api.example.com/orders", "api.example.com/v2/orders"},
+				UpdatedAt:      1706814908000,
+				Link:           "c/cp-1/o/route/route-1",
+			},
+			ok: true,
+		},
+		{
+			name:   "unnamed route falls back to its first path",
+			key:    key,
+			object: map[string]interface{}{"paths": []interface{}{"/orders"}},
+			want: SearchDocument{
+				EntityType:     "route",
+				EntityID:       "route-1",
+				ControlPlaneID: "cp-1",
+				DisplayName:    "/orders",
+				URLs:           []string{"/orders"},
+				Link:           "c/cp-1/o/route/route-1",
+			},
+			ok: true,
+		},
+		{
+			name:   "entity without a name falls back to its id",
+			key:    models.EntityKey{ControlPlaneID: "cp-1", EntityType: "consumer", ID: "consumer-1"},
+			object: map[string]interface{}{},
+			want: SearchDocument{
+				EntityType:     "consumer",
+				EntityID:       "consumer-1",
+				ControlPlaneID: "cp-1",
+				DisplayName:    "consumer-1",
+				Link:           "c/cp-1/o/consumer/consumer-1",
+			},
+			ok: true,
+		},
+		{
+			name:   "type without projection",
+			key:    models.EntityKey{ControlPlaneID: "cp-1", EntityType: "store_event", ID: "event-1"},
+			object: map[string]interface{}{"name": "event"},
+			ok:     false,
+		},
+	}
+
+	registry := DefaultProjections()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := registry.Project(tt.key, tt.object)
+			if ok != tt.ok {
+				t.Fatalf("Project() ok = %v, want %v", ok, tt.ok)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Project() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// The last event of every entity wins, as it does in the index
+func TestProjectionRegistrySampleStream(t *testing.T) {
+	registry := DefaultProjections()
+	documents := map[string]SearchDocument{}
+	for _, event := range loadStreamEvents(t) {
+		key, ok := models.ParseEntityKey(event.After.Key)
+		object, isObject := event.After.Value.Object.(map[string]interface{})
+		if !ok || !isObject {
+			continue
+		}
+
+		document, ok := registry.Project(key, object)
+		switch key.EntityType {
+		case "service", "route", "node", "consumer", "consumer_group", "upstream", "target", "vault", "sni":
+			if !ok {
+				t.Fatalf("%s: expected a search document", event.After.Key)
+			}
+			if document.DisplayName == "" || document.Link != event.After.Key {
+				t.Errorf("%s: incomplete search document %+v", event.After.Key, document)
+			}
+			documents[SearchDocumentID(key)] = document
+		default:
+			if ok {
+				t.Errorf("%s: unexpected search document for %s", event.After.Key, key.EntityType)
+			}
+		}
+	}
+
+	tests := []struct {
+		id   string
+		want SearchDocument
+	}{
+		{
+			id: "04397908-e846-4019-aeaa-2422a1cb7b6c:service:1c7efe0f-203a-422c-b97c-760b61c4436d",
+			want: SearchDocument{
+				EntityType:     "service",
+				EntityID:       "1c7efe0f-203a-422c-b97c-760b61c4436d",
+				ControlPlaneID: "04397908-e846-4019-aeaa-2422a1cb7b6c",
+				DisplayName:    "gateway-1706812473877",
+				SecondaryText:  []string{"api.cypressregression62.com", "/api/gatewayService/"},
+				Tags:           []string{"tag1", "tag2"},
+				URLs:           []string{"http://api.cypressregression62.com:80/api/gatewayService/"},
+				UpdatedAt:      1706812479000,
+				Link:           "c/04397908-e846-4019-aeaa-2422a1cb7b6c/o/service/1c7efe0f-203a-422c-b97c-760b61c4436d",
+			},
+		},
+		{
+			id: "a839a226-8e0f-4b93-a073-e526a5412077:vault:d23fdad0-774c-41c3-a3ae-d97fe5b33cf6",
+			want: SearchDocument{
+				EntityType:     "vault",
+				EntityID:       "d23fdad0-774c-41c3-a3ae-d97fe5b33cf6",
+				ControlPlaneID: "a839a226-8e0f-4b93-a073-e526a5412077",
+				DisplayName:    "aws",
+				SecondaryText:  []string{"aws-general-prefix-updated", "test description"},
+				Tags:           []string{"tag1", "tag2"},
+				UpdatedAt:      1706823087000,
+				Link:           "c/a839a226-8e0f-4b93-a073-e526a5412077/o/vault/d23fdad0-774c-41c3-a3ae-d97fe5b33cf6",
+			},
+		},
+		{
+			id: "0ea169ef-8c46-4044-9d85-3a1670a79c75:target:51c80fcf-d556-474e-904a-7e3da1a9e7aa",
+			want: SearchDocument{
+				EntityType:     "target",
+				EntityID:       "51c80fcf-d556-474e-904a-7e3da1a9e7aa",
+				ControlPlaneID: "0ea169ef-8c46-4044-9d85-3a1670a79c75",
+				DisplayName:    "198.51.100.11:80",
+				URLs:           []string{"198.51.100.11:80"},
+				UpdatedAt:      1706820609000,
+				Link:           "c/0ea169ef-8c46-4044-9d85-3a1670a79c75/o/target/51c80fcf-d556-474e-904a-7e3da1a9e7aa",
+			},
+		},
+	}
+	for _, tt := range tests {
+		got, ok := documents[tt.id]
+		if !ok {
+			t.Errorf("%s: missing search document", tt.id)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s = %+v, want %+v", tt.id, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package data_processing
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+
+	"github.com/kong/konnect-ingest/internal/metrics"
+	"github.com/kong/konnect-ingest/internal/models"
+	"go.uber.org/zap"
+)
+
+// ErrUnsupported is returned by a sink asked for an operation its capabilities exclude
+var ErrUnsupported = errors.New("operation not supported by sink")
+
+// SinkCapabilities declares what a sink supports, so that writers skip what it cannot do
+type SinkCapabilities struct {
+	// Deletes is set when the sink removes documents; deletes are skipped otherwise
+	Deletes bool
+	// Buffered is set when writes are only durable once flushed
+	Buffered bool
+	// Events is set when the sink is an EventSink
+	Events bool
+}
+
+// FailurePolicy decides how the failure of one sink of a fan-out affects the write
+type FailurePolicy string
+
+const (
+	// SinkRequired fails the write, so the event is not acknowledged
+	SinkRequired FailurePolicy = "required"
+	// SinkBestEffort logs and counts the failure, the write succeeds
+	SinkBestEffort FailurePolicy = "best_effort"
+)
+
+// ParseFailurePolicy parses a failure policy, required when empty
+func ParseFailurePolicy(value string) (FailurePolicy, error) {
+	switch FailurePolicy(value) {
+	case "", SinkRequired:
+		return SinkRequired, nil
+	case SinkBestEffort:
+		return SinkBestEffort, nil
+	default:
+		return "", fmt.Errorf("unknown sink failure policy %q", value)
+	}
+}
+
+// IndexerSink adapts a DocumentIndexer to Sink. It passes the CDC key on when the indexer is
+// also a KeyedIndexer, supports deletes when it is also a DocumentDeleter and writes
+// synchronously.
+type IndexerSink struct {
+	name    string
+	indexer DocumentIndexer
+}
+
+// NewIndexerSink creates a sink writing through the indexer
+func NewIndexerSink(name string, indexer DocumentIndexer) *IndexerSink {
+	return &IndexerSink{name: name, indexer: indexer}
+}
+
+// Name returns the name of the sink
+func (s *IndexerSink) Name() string {
+	return s.name
+}
+
+// Capabilities reports deletes when the indexer can delete
+func (s *IndexerSink) Capabilities() SinkCapabilities {
+	_, deletes := s.indexer.(DocumentDeleter)
+	return SinkCapabilities{Deletes: deletes}
+}
+
+// Upsert indexes the document
+func (s *IndexerSink) Upsert(key string, indexName string, id string, document interface{}) error {
+	if keyed, ok := s.indexer.(KeyedIndexer); ok {
+		return keyed.IndexKeyedDocument(key, indexName, id, document)
+	}
+	return s.indexer.IndexDocument(indexName, id, document)
+}
+
+// Delete deletes the document
+func (s *IndexerSink) Delete(indexName string, id string) error {
+	deleter, ok := s.indexer.(DocumentDeleter)
+	if !ok {
+		return ErrUnsupported
+	}
+	return deleter.DeleteDocument(indexName, id)
+}
+
+// Flush is a no-op, writes are synchronous
+func (s *IndexerSink) Flush() error {
+	return nil
+}
+
+// fanOutTarget is a sink of a fan-out with its failure policy
+type fanOutTarget struct {
+	sink     Sink
+	policy   FailurePolicy
+	failures *expvar.Int
+}
+
+// FanOutSink implements Sink by writing every document to several sinks, for example
+// OpenSearch and an archive. A failing required sink fails the write; a failing best-effort
+// sink is logged and counted in sink_<name>_failures.
+type FanOutSink struct {
+	targets []fanOutTarget
+	logger  *zap.Logger
+}
+
+// NewFanOutSink creates a fan-out without sinks
+func NewFanOutSink(logger *zap.Logger) *FanOutSink {
+	return &FanOutSink{logger: logger}
+}
+
+// AddSink adds a sink with its failure policy. Sinks are written in the order they were added.
+func (f *FanOutSink) AddSink(sink Sink, policy FailurePolicy) {
+	f.targets = append(f.targets, fanOutTarget{
+		sink:     sink,
+		policy:   policy,
+		failures: metrics.Counter("sink_" + sink.Name() + "_failures"),
+	})
+}
+
+// Len returns the number of sinks
+func (f *FanOutSink) Len() int {
+	return len(f.targets)
+}
+
+// RequiredBuffered returns the names of the required sinks buffering writes until Flush
+func (f *FanOutSink) RequiredBuffered() []string {
+	var names []string
+	for _, target := range f.targets {
+		if target.policy == SinkRequired && target.sink.Capabilities().Buffered {
+			names = append(names, target.sink.Name())
+		}
+	}
+	return names
+}
+
+// Name returns the name of the fan-out
+func (f *FanOutSink) Name() string {
+	return "fanout"
+}
+
+// Capabilities reports the union of the capabilities of the sinks
+func (f *FanOutSink) Capabilities() SinkCapabilities {
+	var capabilities SinkCapabilities
+	for _, target := range f.targets {
+		sinkCapabilities := target.sink.Capabilities()
+		capabilities.Deletes = capabilities.Deletes || sinkCapabilities.Deletes
+		capabilities.Buffered = capabilities.Buffered || sinkCapabilities.Buffered
+		capabilities.Events = capabilities.Events || sinkCapabilities.Events
+	}
+	return capabilities
+}
+
+// Upsert writes the document to every sink
+func (f *FanOutSink) Upsert(key string, indexName string, id string, document interface{}) error {
+	return f.each("upsert", indexName, id, func(sink Sink) error {
+		return sink.Upsert(key, indexName, id, document)
+	})
+}
+
+// Delete deletes the document from every sink supporting deletes
+func (f *FanOutSink) Delete(indexName string, id string) error {
+	return f.each("delete", indexName, id, func(sink Sink) error {
+		if !sink.Capabilities().Deletes {
+			return nil
+		}
+		return sink.Delete(indexName, id)
+	})
+}
+
+// RecordEvent records the event in every sink recording events
+func (f *FanOutSink) RecordEvent(event models.CDCEvent) error {
+	return f.each("record", "", event.After.Key, func(sink Sink) error {
+		eventSink, ok := sink.(EventSink)
+		if !ok || !sink.Capabilities().Events {
+			return nil
+		}
+		return eventSink.RecordEvent(event)
+	})
+}
+
+// Flush flushes every buffered sink
+func (f *FanOutSink) Flush() error {
+	return f.each("flush", "", "", func(sink Sink) error {
+		if !sink.Capabilities().Buffered {
+			return nil
+		}
+		return sink.Flush()
+	})
+}
+
+// each applies the operation to every sink, even after a failure, and returns the first
+// failure of a required sink
+func (f *FanOutSink) each(operation string, indexName string, id string, apply func(Sink) error) error {
+	var required error
+	for _, target := range f.targets {
+		err := apply(target.sink)
+		if err == nil {
+			continue
+		}
+		target.failures.Add(1)
+		fields := []zap.Field{
+			zap.String("sink", target.sink.Name()),
+			zap.String("operation", operation),
+			zap.String("indexName", indexName),
+			zap.String("id", id),
+			zap.Error(err),
+		}
+		if target.policy == SinkBestEffort {
+			f.logger.Warn("Best-effort sink failed", fields...)
+			continue
+		}
+		f.logger.Error("Required sink failed", fields...)
+		if required == nil {
+			required = fmt.Errorf("sink %s: %w", target.sink.Name(), err)
+		}
+	}
+	return required
+}
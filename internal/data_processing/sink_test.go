@@ -0,0 +1,138 @@
+package data_processing
+
+import (
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// deletingIndexer is a DocumentIndexer and DocumentDeleter keeping documents in memory
+type deletingIndexer struct {
+	documents map[string]interface{}
+}
+
+func (d *deletingIndexer) IndexDocument(indexName string, id string, document interface{}) error {
+	d.documents[indexName+"/"+id] = document
+	return nil
+}
+
+func (d *deletingIndexer) DeleteDocument(indexName string, id string) error {
+	delete(d.documents, indexName+"/"+id)
+	return nil
+}
+
+// upsertOnlyIndexer cannot delete
+type upsertOnlyIndexer struct{}
+
+func (upsertOnlyIndexer) IndexDocument(indexName string, id string, document interface{}) error {
+	return nil
+}
+
+func TestIndexerSink(t *testing.T) {
+	indexer := &deletingIndexer{documents: make(map[string]interface{})}
+	sink := NewIndexerSink("opensearch", indexer)
+	if got := sink.Capabilities(); got != (SinkCapabilities{Deletes: true}) {
+		t.Errorf("Capabilities() = %+v, want deletes", got)
+	}
+	if err := sink.Upsert("c/cp-1/o/service/svc-1", "cdc-service", "svc-1", map[string]interface{}{"id": "svc-1"}); err != nil {
+		t.Fatalf("Upsert() error = %v", err)
+	}
+	if err := sink.Delete("cdc-service", "svc-1"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if len(indexer.documents) != 0 {
+		t.Errorf("documents = %v, want none", indexer.documents)
+	}
+
+	upsertOnly := NewIndexerSink("legacy", upsertOnlyIndexer{})
+	if upsertOnly.Capabilities().Deletes {
+		t.Error("an indexer without DeleteDocument should not support deletes")
+	}
+	if err := upsertOnly.Delete("cdc-service", "svc-1"); err != ErrUnsupported {
+		t.Errorf("Delete() error = %v, want ErrUnsupported", err)
+	}
+}
+
+func TestFanOutSink(t *testing.T) {
+	document := map[string]interface{}{"id": "svc-1"}
+
+	tests := []struct {
+		name         string
+		requiredFail bool
+		optionalFail bool
+		wantErr      bool
+	}{
+		{name: "all sinks succeed"},
+		{name: "best-effort failure is tolerated", optionalFail: true},
+		{name: "required failure fails the write", requiredFail: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			required := NewMockSink("required", SinkCapabilities{Deletes: true}, tt.requiredFail)
+			archive := NewMockSink("archive", SinkCapabilities{Buffered: true}, tt.optionalFail)
+			fanOut := NewFanOutSink(zap.NewNop())
+			fanOut.AddSink(required, SinkRequired)
+			fanOut.AddSink(archive, SinkBestEffort)
+
+			if got := fanOut.Capabilities(); got != (SinkCapabilities{Deletes: true, Buffered: true}) {
+				t.Errorf("Capabilities() = %+v, want the union", got)
+			}
+			if got := fanOut.RequiredBuffered(); len(got) != 0 {
+				t.Errorf("RequiredBuffered() = %v, want none", got)
+			}
+
+			err := fanOut.Upsert("c/cp-1/o/service/svc-1", "cdc-service", "svc-1", document)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Upsert() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			// A failing sink does not keep the others from being written
+			if !tt.optionalFail && !reflect.DeepEqual(archive.documents["cdc-service/svc-1"], document) {
+				t.Error("archive sink missed the upsert")
+			}
+
+			if err := fanOut.Delete("cdc-service", "svc-1"); (err != nil) != tt.wantErr {
+				t.Fatalf("Delete() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(archive.deleted) != 0 {
+				t.Errorf("deletes sent to a sink without delete support: %v", archive.deleted)
+			}
+			if !tt.requiredFail && !reflect.DeepEqual(required.deleted, []string{"cdc-service/svc-1"}) {
+				t.Errorf("required sink deletes = %v", required.deleted)
+			}
+
+			if err := fanOut.Flush(); err != nil {
+				t.Fatalf("Flush() error = %v", err)
+			}
+			if required.flushes != 0 {
+				t.Error("unbuffered sink was flushed")
+			}
+			if !tt.optionalFail && archive.flushes != 1 {
+				t.Errorf("archive flushes = %d, want 1", archive.flushes)
+			}
+		})
+	}
+}
+
+func TestFanOutSinkRequiredBuffered(t *testing.T) {
+	fanOut := NewFanOutSink(zap.NewNop())
+	fanOut.AddSink(NewMockSink("opensearch", SinkCapabilities{Deletes: true}, false), SinkRequired)
+	fanOut.AddSink(NewMockSink("archive", SinkCapabilities{Buffered: true}, false), SinkRequired)
+	fanOut.AddSink(NewMockSink("spool", SinkCapabilities{Buffered: true}, false), SinkBestEffort)
+
+	if got := fanOut.RequiredBuffered(); !reflect.DeepEqual(got, []string{"archive"}) {
+		t.Errorf("RequiredBuffered() = %v, want [archive]", got)
+	}
+}
+
+func TestParseFailurePolicy(t *testing.T) {
+	for value, want := range map[string]FailurePolicy{"": SinkRequired, "required": SinkRequired, "best_effort": SinkBestEffort} {
+		if got, err := ParseFailurePolicy(value); err != nil || got != want {
+			t.Errorf("ParseFailurePolicy(%q) = %s, %v, want %s", value, got, err, want)
+		}
+	}
+	if _, err := ParseFailurePolicy("optional"); err == nil {
+		t.Error("ParseFailurePolicy() should reject unknown policies")
+	}
+}
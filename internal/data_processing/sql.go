@@ -0,0 +1,243 @@
+package data_processing
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kong/konnect-ingest/internal/models"
+	"go.uber.org/zap"
+)
+
+// SQLDialect is the SQL database a SQLIndexer writes to
+type SQLDialect string
+
+const (
+	// SQLite uses the pure Go sqlite driver
+	SQLite SQLDialect = "sqlite"
+	// Postgres uses the lib/pq driver
+	Postgres SQLDialect = "postgres"
+)
+
+// ParseSQLDialect parses a dialect name
+func ParseSQLDialect(value string) (SQLDialect, error) {
+	switch SQLDialect(value) {
+	case SQLite, Postgres:
+		return SQLDialect(value), nil
+	default:
+		return "", fmt.Errorf("unknown SQL dialect %q", value)
+	}
+}
+
+// Driver returns the database/sql driver name of the dialect
+func (d SQLDialect) Driver() string {
+	return string(d)
+}
+
+// placeholder returns the nth (1-based) query parameter
+func (d SQLDialect) placeholder(n int) string {
+	if d == Postgres {
+		return fmt.Sprintf("$%d", n)
+	}
+	return "?"
+}
+
+// columnTypes returns the types of the document and integer columns
+func (d SQLDialect) columnTypes() (document string, integer string) {
+	if d == Postgres {
+		return "JSONB", "BIGINT"
+	}
+	return "TEXT", "INTEGER"
+}
+
+// SQLIndexer implements DocumentIndexer, KeyedIndexer and DocumentDeleter on a relational
+// database, keeping the latest state of every entity. Each index is a table named after it
+// (cdc-service is cdc_service) with the columns id, control_plane_id (from the CDC key),
+// entity_type, document (the JSON document), updated_at and version, the number of writes of
+// the row. Tables are created on
+// first use. The unified search index, a projection of the entity indices, and the change
+// history index are not copied.
+type SQLIndexer struct {
+	db            *sql.DB
+	dialect       SQLDialect
+	indexPrefixes []string
+	logger        *zap.Logger
+
+	mu     sync.Mutex
+	tables map[string]bool
+
+	now func() time.Time
+}
+
+// NewSQLIndexer creates an indexer writing to the database. The entity type of a row is the
+// index name without indexPrefix.
+func NewSQLIndexer(db *sql.DB, dialect SQLDialect, indexPrefix string, logger *zap.Logger) *SQLIndexer {
+	i := &SQLIndexer{
+		db:      db,
+		dialect: dialect,
+		logger:  logger,
+		tables:  make(map[string]bool),
+		now:     time.Now,
+	}
+	i.AddIndexPrefix(indexPrefix)
+	return i
+}
+
+// AddIndexPrefix adds the prefix of documents written by another processor, such as the one
+// of a topic with its own index_prefix. The longest matching prefix is stripped.
+func (i *SQLIndexer) AddIndexPrefix(indexPrefix string) {
+	if indexPrefix != "" {
+		i.indexPrefixes = append(i.indexPrefixes, indexPrefix)
+	}
+}
+
+// IndexDocument inserts or replaces the row of a document without CDC key, whose control
+// plane is the control_plane_id of the document
+func (i *SQLIndexer) IndexDocument(indexName string, id string, document interface{}) error {
+	return i.IndexKeyedDocument("", indexName, id, document)
+}
+
+// IndexKeyedDocument inserts or replaces the row of the document. The control plane is the one
+// of the CDC key, or the control_plane_id of the document when the key has no control plane.
+func (i *SQLIndexer) IndexKeyedDocument(key string, indexName string, id string, document interface{}) error {
+	if i.skipped(indexName) {
+		return nil
+	}
+	table, err := i.table(indexName)
+	if err != nil {
+		return err
+	}
+	documentBytes, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(documentBytes, &fields); err != nil {
+		// Not an object, stored without control plane or entity update time
+		fields = nil
+	}
+
+	p := i.dialect.placeholder
+	query := fmt.Sprintf(`INSERT INTO %[1]s (id, control_plane_id, entity_type, document, updated_at, version)
+VALUES (%[2]s, %[3]s, %[4]s, %[5]s, %[6]s, 1)
+ON CONFLICT (id) DO UPDATE SET
+	control_plane_id = excluded.control_plane_id,
+	entity_type = excluded.entity_type,
+	document = excluded.document,
+	updated_at = excluded.updated_at,
+	version = %[1]s.version + 1`, table, p(1), p(2), p(3), p(4), p(5))
+
+	_, err = i.db.Exec(query,
+		id,
+		controlPlaneID(key, fields),
+		i.entityType(indexName),
+		string(documentBytes),
+		i.documentUpdatedAt(fields),
+	)
+	if err != nil {
+		return fmt.Errorf("upsert %s into %s: %w", id, table, err)
+	}
+	return nil
+}
+
+// skipped reports whether the index is one of the derived indices not copied, whatever its prefix
+func (i *SQLIndexer) skipped(indexName string) bool {
+	for _, suffix := range []string{SearchIndexSuffix, HistoryIndexSuffix} {
+		if indexName == suffix || strings.HasSuffix(indexName, "-"+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// DeleteDocument deletes the row of the document, succeeding when it does not exist
+func (i *SQLIndexer) DeleteDocument(indexName string, id string) error {
+	if i.skipped(indexName) {
+		return nil
+	}
+	table, err := i.table(indexName)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf("DELETE FROM %s WHERE id = %s", table, i.dialect.placeholder(1))
+	if _, err := i.db.Exec(query, id); err != nil {
+		return fmt.Errorf("delete %s from %s: %w", id, table, err)
+	}
+	return nil
+}
+
+// table returns the quoted table of an index, creating it when it was not used yet
+func (i *SQLIndexer) table(indexName string) (string, error) {
+	table := `"` + tableName(indexName) + `"`
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.tables[table] {
+		return table, nil
+	}
+
+	documentType, integerType := i.dialect.columnTypes()
+	statement := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id TEXT PRIMARY KEY,
+	control_plane_id TEXT NOT NULL,
+	entity_type TEXT NOT NULL,
+	document %s NOT NULL,
+	updated_at %s NOT NULL,
+	version %s NOT NULL
+)`, table, documentType, integerType, integerType)
+	if _, err := i.db.Exec(statement); err != nil {
+		return "", fmt.Errorf("create table %s: %w", table, err)
+	}
+	i.tables[table] = true
+	i.logger.Info("Created SQL table", zap.String("table", table))
+	return table, nil
+}
+
+// entityType returns the index name without the longest matching index prefix
+func (i *SQLIndexer) entityType(indexName string) string {
+	entityType := indexName
+	for _, indexPrefix := range i.indexPrefixes {
+		if trimmed := strings.TrimPrefix(indexName, indexPrefix+"-"); trimmed != indexName && len(trimmed) < len(entityType) {
+			entityType = trimmed
+		}
+	}
+	return entityType
+}
+
+// documentUpdatedAt returns the updated_at of the entity, in epoch seconds like Konnect
+// entities, or the time of the write
+func (i *SQLIndexer) documentUpdatedAt(fields map[string]interface{}) int64 {
+	if updatedAt, ok := fields["updated_at"].(float64); ok && updatedAt > 0 {
+		return int64(updatedAt)
+	}
+	return i.now().Unix()
+}
+
+// controlPlaneID returns the control plane of the CDC key, or the control_plane_id of the
+// document for keys without one
+func controlPlaneID(key string, fields map[string]interface{}) string {
+	if entityKey, ok := models.ParseEntityKey(key); ok {
+		return entityKey.ControlPlaneID
+	}
+	if id, ok := fields["control_plane_id"].(string); ok {
+		return id
+	}
+	return ""
+}
+
+// tableName maps an index name to a table name of lowercase letters, digits and underscores
+func tableName(indexName string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		default:
+			return '_'
+		}
+	}, indexName)
+}
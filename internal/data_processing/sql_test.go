@@ -0,0 +1,170 @@
+package data_processing
+
+import (
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	_ "modernc.org/sqlite"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open(SQLite.Driver(), ":memory:")
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	// Every connection to :memory: is a separate database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+type sqlRow struct {
+	controlPlaneID string
+	entityType     string
+	document       string
+	updatedAt      int64
+	version        int64
+}
+
+func readRow(t *testing.T, db *sql.DB, table string, id string) (sqlRow, bool) {
+	t.Helper()
+	var row sqlRow
+	err := db.QueryRow(`SELECT control_plane_id, entity_type, document, updated_at, version FROM "`+table+`" WHERE id = ?`, id).
+		Scan(&row.controlPlaneID, &row.entityType, &row.document, &row.updatedAt, &row.version)
+	if err == sql.ErrNoRows {
+		return row, false
+	}
+	if err != nil {
+		t.Fatalf("read %s/%s: %v", table, id, err)
+	}
+	return row, true
+}
+
+func TestSQLIndexer(t *testing.T) {
+	db := openTestDB(t)
+	indexer := NewSQLIndexer(db, SQLite, "cdc", zap.NewNop())
+	indexer.now = func() time.Time { return time.Unix(1706812500, 0) }
+
+	// The sink passes the CDC key on, the control plane comes from it as documents are not
+	// stamped by default
+	service := map[string]interface{}{
+		"id":         "svc-1",
+		"name":       "gateway",
+		"updated_at": float64(1706812479),
+	}
+	if err := NewIndexerSink("sql", indexer).Upsert("c/cp-1/o/service/svc-1", "cdc-service", "svc-1", service); err != nil {
+		t.Fatalf("Upsert() error = %v", err)
+	}
+	row, ok := readRow(t, db, "cdc_service", "svc-1")
+	if !ok {
+		t.Fatal("row not inserted")
+	}
+	want := sqlRow{controlPlaneID: "cp-1", entityType: "service", updatedAt: 1706812479, version: 1}
+	want.document = row.document
+	if row != want {
+		t.Errorf("row = %+v, want %+v", row, want)
+	}
+	var document map[string]interface{}
+	if err := json.Unmarshal([]byte(row.document), &document); err != nil || document["name"] != "gateway" {
+		t.Errorf("document = %s, %v", row.document, err)
+	}
+
+	// An update replaces the document and bumps the version
+	service["name"] = "renamed"
+	delete(service, "updated_at")
+	if err := indexer.IndexKeyedDocument("c/cp-1/o/service/svc-1", "cdc-service", "svc-1", service); err != nil {
+		t.Fatalf("IndexKeyedDocument() update error = %v", err)
+	}
+	row, _ = readRow(t, db, "cdc_service", "svc-1")
+	if row.version != 2 || row.updatedAt != 1706812500 || row.controlPlaneID != "cp-1" {
+		t.Errorf("updated row version %d updated_at %d control plane %q, want 2, the write time and cp-1", row.version, row.updatedAt, row.controlPlaneID)
+	}
+	if err := json.Unmarshal([]byte(row.document), &document); err != nil || document["name"] != "renamed" {
+		t.Errorf("updated document = %s, %v", row.document, err)
+	}
+
+	// Without a CDC key, index names with dashes map to valid tables, structs are stored as
+	// their JSON and the control plane is the control_plane_id of the document
+	search := SearchDocument{EntityType: "route", ControlPlaneID: "cp-2", DisplayName: "r"}
+	if err := indexer.IndexDocument("cdc-composite-status", "cs-1", search); err != nil {
+		t.Fatalf("IndexDocument() error = %v", err)
+	}
+	if row, ok := readRow(t, db, "cdc_composite_status", "cs-1"); !ok || row.entityType != "composite-status" || row.controlPlaneID != "cp-2" {
+		t.Errorf("row = %+v, %v", row, ok)
+	}
+
+	if err := indexer.IndexDocument("cdc-search", "cp-2:route:r", search); err != nil {
+		t.Fatalf("IndexDocument() search error = %v", err)
+	}
+	var tables int
+	db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'cdc_search'`).Scan(&tables)
+	if tables != 0 {
+		t.Error("search documents should not be copied")
+	}
+
+	if err := indexer.DeleteDocument("cdc-service", "svc-1"); err != nil {
+		t.Fatalf("DeleteDocument() error = %v", err)
+	}
+	if _, ok := readRow(t, db, "cdc_service", "svc-1"); ok {
+		t.Error("row not deleted")
+	}
+	// Deleting a missing row, or from a table not created yet, succeeds
+	if err := indexer.DeleteDocument("cdc-route", "missing"); err != nil {
+		t.Errorf("DeleteDocument() missing error = %v", err)
+	}
+}
+
+func TestSQLIndexerIndexPrefixes(t *testing.T) {
+	db := openTestDB(t)
+	indexer := NewSQLIndexer(db, SQLite, "cdc", zap.NewNop())
+	indexer.AddIndexPrefix("cdc-shard2")
+
+	for _, indexName := range []string{"cdc-service", "cdc-shard2-service", "cdc-shard2-search", "cdc-shard2-history"} {
+		if err := indexer.IndexDocument(indexName, "svc-1", map[string]interface{}{"id": "svc-1"}); err != nil {
+			t.Fatalf("IndexDocument(%s) error = %v", indexName, err)
+		}
+	}
+	for _, table := range []string{"cdc_service", "cdc_shard2_service"} {
+		if row, ok := readRow(t, db, table, "svc-1"); !ok || row.entityType != "service" {
+			t.Errorf("%s row = %+v, %v, want entity type service", table, row, ok)
+		}
+	}
+	var tables int
+	db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name IN ('cdc_shard2_search', 'cdc_shard2_history')`).Scan(&tables)
+	if tables != 0 {
+		t.Error("derived indices of another prefix should not be copied")
+	}
+}
+
+func TestSQLIndexerRestart(t *testing.T) {
+	db := openTestDB(t)
+	if err := NewSQLIndexer(db, SQLite, "cdc", zap.NewNop()).IndexDocument("cdc-route", "r-1", map[string]interface{}{"id": "r-1"}); err != nil {
+		t.Fatalf("IndexDocument() error = %v", err)
+	}
+	// A new indexer finds the existing table and keeps counting versions
+	if err := NewSQLIndexer(db, SQLite, "cdc", zap.NewNop()).IndexDocument("cdc-route", "r-1", map[string]interface{}{"id": "r-1"}); err != nil {
+		t.Fatalf("IndexDocument() after restart error = %v", err)
+	}
+	if row, _ := readRow(t, db, "cdc_route", "r-1"); row.version != 2 {
+		t.Errorf("version = %d, want 2", row.version)
+	}
+}
+
+func TestSQLDialect(t *testing.T) {
+	if got := Postgres.placeholder(3); got != "$3" {
+		t.Errorf("Postgres placeholder = %s", got)
+	}
+	if got := SQLite.placeholder(3); got != "?" {
+		t.Errorf("SQLite placeholder = %s", got)
+	}
+	if _, err := ParseSQLDialect("mysql"); err == nil {
+		t.Error("ParseSQLDialect() should reject unknown dialects")
+	}
+	if got := tableName("Konnect-cdc.service"); got != "konnect_cdc_service" {
+		t.Errorf("tableName() = %s", got)
+	}
+}
@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"expvar"
+	"sync"
+)
+
+var mu sync.Mutex
+
+// Counter returns the published counter with the given name, creating it on first use.
+// Counters are exported through expvar and therefore process wide.
+func Counter(name string) *expvar.Int {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if existing := expvar.Get(name); existing != nil {
+		if counter, ok := existing.(*expvar.Int); ok {
+			return counter
+		}
+	}
+	return expvar.NewInt(name)
+}
+
+var funcs = map[string]func() interface{}{}
+
+// Func publishes the value computed by f under the given name. Publishing the same name
+// again replaces the function, so a component rebuilt in the same process reports its
+// latest state.
+func Func(name string, f func() interface{}) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, ok := funcs[name]; !ok && expvar.Get(name) == nil {
+		expvar.Publish(name, expvar.Func(func() interface{} {
+			mu.Lock()
+			current := funcs[name]
+			mu.Unlock()
+			return current()
+		}))
+	}
+	funcs[name] = f
+}
@@ -5,6 +5,14 @@ import (
 	"fmt"
 )
 
+// CDC operation codes as emitted by Debezium
+const (
+	OpCreate = "c"
+	OpUpdate = "u"
+	OpDelete = "d"
+	OpRead   = "r"
+)
+
 // CDCEvent represents a CDC event from Debezium
 type CDCEvent struct {
 	Before interface{} `json:"before"`
@@ -14,6 +22,8 @@ type CDCEvent struct {
 			Object interface{} `json:"object"`
 		} `json:"value"`
 	} `json:"after"`
+	Op   string `json:"op,omitempty"`
+	TsMs int64  `json:"ts_ms,omitempty"`
 }
 
 // UnmarshalJSON implements json.Unmarshaler
@@ -32,9 +42,29 @@ func (e *CDCEvent) UnmarshalJSON(data []byte) error {
 	if aux.After.Key == "" {
 		return fmt.Errorf("missing required field: after.key")
 	}
-	if aux.After.Value.Object == nil {
+	// Deletes may only carry the key of the removed entity
+	if aux.After.Value.Object == nil && aux.Op != OpDelete {
 		return fmt.Errorf("missing required field: after.value.object")
 	}
 
 	return nil
 }
+
+// IsDelete reports whether the event removes the entity identified by its key
+func (e CDCEvent) IsDelete() bool {
+	return e.Op == OpDelete
+}
+
+// Since returns the event with the before image of an earlier event of the same entity, so
+// that it carries every change made since then. An entity created by the earlier event is
+// created by the returned one; deletes, and events following a delete, are returned as is.
+func (e CDCEvent) Since(earlier CDCEvent) CDCEvent {
+	if e.IsDelete() || earlier.IsDelete() {
+		return e
+	}
+	e.Before = earlier.Before
+	if earlier.Op == OpCreate {
+		e.Op = OpCreate
+	}
+	return e
+}
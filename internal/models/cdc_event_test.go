@@ -2,6 +2,7 @@ package models
 
 import (
 	"encoding/json"
+	"reflect"
 	"testing"
 )
 
@@ -76,6 +77,41 @@ func TestCDCEventUnmarshalJSON(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "delete without object",
+			json: `{
+				"before": {"id": "456"},
+				"after": {
+					"key": "c/123/o/service/456",
+					"value": {}
+				},
+				"op": "d"
+			}`,
+			want: CDCEvent{
+				Before: map[string]interface{}{"id": "456"},
+				After: struct {
+					Key   string `json:"key"`
+					Value struct {
+						Object interface{} `json:"object"`
+					} `json:"value"`
+				}{
+					Key: "c/123/o/service/456",
+				},
+				Op: OpDelete,
+			},
+			wantErr: false,
+		},
+		{
+			name: "update without object",
+			json: `{
+				"after": {
+					"key": "c/123/o/service/456",
+					"value": {}
+				},
+				"op": "u"
+			}`,
+			wantErr: true,
+		},
 		{
 			name:    "invalid json",
 			json:    `{invalid`,
@@ -120,3 +156,36 @@ func TestCDCEventUnmarshalJSON(t *testing.T) {
 		})
 	}
 }
+
+func TestCDCEventSince(t *testing.T) {
+	event := func(op string, before interface{}) CDCEvent {
+		var e CDCEvent
+		e.Op = op
+		e.Before = before
+		e.After.Key = "c/cp-1/o/service/svc-1"
+		return e
+	}
+	first := map[string]interface{}{"name": "first"}
+	latest := map[string]interface{}{"name": "latest"}
+
+	tests := []struct {
+		name       string
+		earlier    CDCEvent
+		event      CDCEvent
+		wantOp     string
+		wantBefore interface{}
+	}{
+		{name: "updates", earlier: event(OpUpdate, first), event: event(OpUpdate, latest), wantOp: OpUpdate, wantBefore: first},
+		{name: "created in the window", earlier: event(OpCreate, nil), event: event(OpUpdate, latest), wantOp: OpCreate, wantBefore: nil},
+		{name: "deleted in the window", earlier: event(OpUpdate, first), event: event(OpDelete, latest), wantOp: OpDelete, wantBefore: latest},
+		{name: "recreated in the window", earlier: event(OpDelete, first), event: event(OpCreate, nil), wantOp: OpCreate, wantBefore: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.event.Since(tt.earlier)
+			if got.Op != tt.wantOp || !reflect.DeepEqual(got.Before, tt.wantBefore) {
+				t.Errorf("Since() = %s with before %v, want %s with before %v", got.Op, got.Before, tt.wantOp, tt.wantBefore)
+			}
+		})
+	}
+}
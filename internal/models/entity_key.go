@@ -0,0 +1,27 @@
+package models
+
+import "strings"
+
+// GlobalControlPlane is the pseudo control plane used for organization-wide entities
+const GlobalControlPlane = "_global"
+
+// EntityKey is the parsed form of a CDC key such as "c/{controlPlane}/o/{entityType}/{id}"
+type EntityKey struct {
+	ControlPlaneID string
+	EntityType     string
+	ID             string
+}
+
+// ParseEntityKey splits a CDC key into its control plane, entity type and id parts
+func ParseEntityKey(key string) (EntityKey, bool) {
+	parts := strings.Split(key, "/")
+	if len(parts) < 5 || parts[0] != "c" || parts[2] != "o" {
+		return EntityKey{}, false
+	}
+
+	return EntityKey{
+		ControlPlaneID: parts[1],
+		EntityType:     parts[3],
+		ID:             strings.Join(parts[4:], "/"),
+	}, true
+}
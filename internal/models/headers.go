@@ -0,0 +1,101 @@
+package models
+
+import (
+	"strconv"
+)
+
+// Kafka record header contract for CDC events.
+//
+// Producers set every header below on each record so consumers can route or skip a
+// message without unmarshalling its payload. Consumers must treat all headers as
+// optional: records written by older producers carry none, in which case the payload
+// remains the source of truth. Headers are only trusted when the schema version is
+// one the consumer understands.
+const (
+	HeaderEntityType     = "cdc-entity-type"
+	HeaderControlPlaneID = "cdc-control-plane-id"
+	HeaderOp             = "cdc-op"
+	HeaderTsMs           = "cdc-ts-ms"
+	HeaderSchemaVersion  = "cdc-schema-version"
+	HeaderProducerID     = "cdc-producer-id"
+	// HeaderPartitionStrategy names the partitioning strategy, see PartitionByKey and friends
+	HeaderPartitionStrategy = "cdc-partition-strategy"
+)
+
+// Headers added to records of the compacted snapshot topic. They locate the live record a
+// snapshot entry was derived from, so a bootstrapping consumer knows where to resume.
+const (
+	HeaderSourceTopic     = "cdc-source-topic"
+	HeaderSourcePartition = "cdc-source-partition"
+	HeaderSourceOffset    = "cdc-source-offset"
+	// HeaderSourceResume is the live offset below which every record of the source partition
+	// was in the snapshot when the record was written
+	HeaderSourceResume = "cdc-source-resume"
+)
+
+// HeaderSchemaVersionV1 is the current version of the header contract
+const HeaderSchemaVersionV1 = "1"
+
+// EventHeaders is the typed view of the CDC record headers
+type EventHeaders struct {
+	EntityType     string
+	ControlPlaneID string
+	Op             string
+	TsMs           int64
+	SchemaVersion  string
+	ProducerID     string
+	// PartitionStrategy tells consumers which ordering guarantee the record was produced under
+	PartitionStrategy string
+}
+
+// NewEventHeaders builds the headers describing an event
+func NewEventHeaders(event CDCEvent, producerID string) EventHeaders {
+	key, _ := ParseEntityKey(event.After.Key)
+	return EventHeaders{
+		EntityType:     key.EntityType,
+		ControlPlaneID: key.ControlPlaneID,
+		Op:             event.Op,
+		TsMs:           event.TsMs,
+		SchemaVersion:  HeaderSchemaVersionV1,
+		ProducerID:     producerID,
+	}
+}
+
+// ToMap returns the non-empty headers keyed by header name
+func (h EventHeaders) ToMap() map[string]string {
+	values := map[string]string{
+		HeaderEntityType:        h.EntityType,
+		HeaderControlPlaneID:    h.ControlPlaneID,
+		HeaderOp:                h.Op,
+		HeaderSchemaVersion:     h.SchemaVersion,
+		HeaderProducerID:        h.ProducerID,
+		HeaderPartitionStrategy: h.PartitionStrategy,
+	}
+	if h.TsMs != 0 {
+		values[HeaderTsMs] = strconv.FormatInt(h.TsMs, 10)
+	}
+	for name, value := range values {
+		if value == "" {
+			delete(values, name)
+		}
+	}
+	return values
+}
+
+// ParseEventHeaders reads the headers from a name/value map. The second return value
+// reports whether the headers follow a supported schema version and can be trusted.
+func ParseEventHeaders(values map[string]string) (EventHeaders, bool) {
+	headers := EventHeaders{
+		EntityType:        values[HeaderEntityType],
+		ControlPlaneID:    values[HeaderControlPlaneID],
+		Op:                values[HeaderOp],
+		SchemaVersion:     values[HeaderSchemaVersion],
+		ProducerID:        values[HeaderProducerID],
+		PartitionStrategy: values[HeaderPartitionStrategy],
+	}
+	if ts, err := strconv.ParseInt(values[HeaderTsMs], 10, 64); err == nil {
+		headers.TsMs = ts
+	}
+
+	return headers, headers.SchemaVersion == HeaderSchemaVersionV1
+}
@@ -0,0 +1,42 @@
+package models
+
+// Partitioning strategies shared by the producer and the consumer. The strategy decides
+// which events land on the same partition and therefore which ordering the consumer can rely on.
+const (
+	// PartitionByKey hashes the full CDC key
+	PartitionByKey = "key"
+	// PartitionByControlPlane hashes the control plane id so a whole control plane shares a partition
+	PartitionByControlPlane = "control_plane"
+	// PartitionByEntity hashes entity type and id, ignoring the control plane
+	PartitionByEntity = "entity"
+	// PartitionByConsistentHash places the full key on a hash ring with virtual nodes
+	PartitionByConsistentHash = "consistent_hash"
+)
+
+// OrderingScope describes the set of events whose relative order is preserved end to end
+type OrderingScope string
+
+const (
+	// OrderingPerEntity guarantees order only between events of the same CDC key
+	OrderingPerEntity OrderingScope = "entity"
+	// OrderingPerControlPlane guarantees order between all events of a control plane
+	OrderingPerControlPlane OrderingScope = "control_plane"
+)
+
+// OrderingScopeFor returns the ordering guarantee provided by a partitioning strategy
+func OrderingScopeFor(strategy string) OrderingScope {
+	if strategy == PartitionByControlPlane {
+		return OrderingPerControlPlane
+	}
+	return OrderingPerEntity
+}
+
+// OrderingKey returns the key events must be serialized on to honour the scope
+func (s OrderingScope) OrderingKey(key string) string {
+	if s == OrderingPerControlPlane {
+		if parsed, ok := ParseEntityKey(key); ok {
+			return parsed.ControlPlaneID
+		}
+	}
+	return key
+}
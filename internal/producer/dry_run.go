@@ -0,0 +1,40 @@
+package producer
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/kong/konnect-ingest/internal/models"
+)
+
+// DryRunEventProducer implements EventProducer by printing events instead of sending them
+type DryRunEventProducer struct {
+	encoder *json.Encoder
+	sent    int
+}
+
+// NewDryRunEventProducer creates a producer that writes every event as a JSON line to out
+func NewDryRunEventProducer(out io.Writer) *DryRunEventProducer {
+	return &DryRunEventProducer{
+		encoder: json.NewEncoder(out),
+	}
+}
+
+// ProduceEvent prints the event that would have been sent
+func (p *DryRunEventProducer) ProduceEvent(event models.CDCEvent) error {
+	if err := p.encoder.Encode(event); err != nil {
+		return err
+	}
+	p.sent++
+	return nil
+}
+
+// Sent returns the number of events printed so far
+func (p *DryRunEventProducer) Sent() int {
+	return p.sent
+}
+
+// Close is a no-op for the dry-run producer
+func (p *DryRunEventProducer) Close() error {
+	return nil
+}
@@ -0,0 +1,209 @@
+package producer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kong/konnect-ingest/internal/models"
+)
+
+// FilterRules describes which events are forwarded to the producer and how they are reshaped.
+// Empty include lists match everything; exclude lists always win over include lists.
+type FilterRules struct {
+	IncludeEntityTypes   []string
+	ExcludeEntityTypes   []string
+	IncludeControlPlanes []string
+	ExcludeControlPlanes []string
+	IncludeOps           []string
+	ExcludeOps           []string
+	FromTsMs             int64 // inclusive, 0 means unbounded
+	ToTsMs               int64 // exclusive, 0 means unbounded
+	Fields               []string
+}
+
+// RuleFilter implements EventFilter using declarative FilterRules
+type RuleFilter struct {
+	includeTypes map[string]bool
+	excludeTypes map[string]bool
+	includeCPs   map[string]bool
+	excludeCPs   map[string]bool
+	includeOps   map[string]bool
+	excludeOps   map[string]bool
+	fromTsMs     int64
+	toTsMs       int64
+	projections  [][]string
+}
+
+// NewRuleFilter creates a filter from the given rules
+func NewRuleFilter(rules FilterRules) (*RuleFilter, error) {
+	if rules.FromTsMs > 0 && rules.ToTsMs > 0 && rules.FromTsMs >= rules.ToTsMs {
+		return nil, fmt.Errorf("invalid ts_ms range: from %d is not before to %d", rules.FromTsMs, rules.ToTsMs)
+	}
+
+	projections := make([][]string, 0, len(rules.Fields))
+	for _, field := range rules.Fields {
+		path, err := parseFieldPath(field)
+		if err != nil {
+			return nil, err
+		}
+		projections = append(projections, path)
+	}
+
+	return &RuleFilter{
+		includeTypes: toSet(rules.IncludeEntityTypes),
+		excludeTypes: toSet(rules.ExcludeEntityTypes),
+		includeCPs:   toSet(rules.IncludeControlPlanes),
+		excludeCPs:   toSet(rules.ExcludeControlPlanes),
+		includeOps:   toSet(rules.IncludeOps),
+		excludeOps:   toSet(rules.ExcludeOps),
+		fromTsMs:     rules.FromTsMs,
+		toTsMs:       rules.ToTsMs,
+		projections:  projections,
+	}, nil
+}
+
+// Apply returns the transformed event and whether it should be sent
+func (f *RuleFilter) Apply(event models.CDCEvent) (models.CDCEvent, bool) {
+	key, ok := models.ParseEntityKey(event.After.Key)
+	if !ok {
+		// Unknown key shapes can only pass when no key-based rule is configured
+		if len(f.includeTypes) > 0 || len(f.includeCPs) > 0 {
+			return event, false
+		}
+	}
+
+	if !matches(f.includeTypes, f.excludeTypes, key.EntityType) ||
+		!matches(f.includeCPs, f.excludeCPs, key.ControlPlaneID) ||
+		!matches(f.includeOps, f.excludeOps, event.Op) {
+		return event, false
+	}
+
+	if f.fromTsMs > 0 && event.TsMs < f.fromTsMs {
+		return event, false
+	}
+	if f.toTsMs > 0 && event.TsMs >= f.toTsMs {
+		return event, false
+	}
+
+	if len(f.projections) > 0 {
+		if obj, ok := event.After.Value.Object.(map[string]interface{}); ok {
+			event.After.Value.Object = project(obj, f.projections)
+		}
+	}
+
+	return event, true
+}
+
+// project copies only the requested paths out of obj. The id field is always kept so
+// that downstream consumers can still address the document. Values are deep-copied, so
+// overlapping paths such as .service and .service.id never write into obj.
+func project(obj map[string]interface{}, paths [][]string) map[string]interface{} {
+	result := make(map[string]interface{})
+	if id, ok := obj["id"]; ok {
+		result["id"] = id
+	}
+
+	for _, path := range paths {
+		value, ok := lookupPath(obj, path)
+		if !ok {
+			continue
+		}
+
+		target := result
+		for _, segment := range path[:len(path)-1] {
+			next, ok := target[segment].(map[string]interface{})
+			if !ok {
+				next = make(map[string]interface{})
+				target[segment] = next
+			}
+			target = next
+		}
+		target[path[len(path)-1]] = deepCopy(value)
+	}
+
+	return result
+}
+
+func deepCopy(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, element := range v {
+			copied[key] = deepCopy(element)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, element := range v {
+			copied[i] = deepCopy(element)
+		}
+		return copied
+	default:
+		return value
+	}
+}
+
+func lookupPath(obj map[string]interface{}, path []string) (interface{}, bool) {
+	var current interface{} = obj
+	for _, segment := range path {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current, ok = m[segment]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// parseFieldPath parses a jq-like projection such as ".service.id" into its segments
+func parseFieldPath(field string) ([]string, error) {
+	trimmed := strings.TrimPrefix(strings.TrimSpace(field), ".")
+	if trimmed == "" {
+		return nil, fmt.Errorf("invalid field projection %q", field)
+	}
+
+	segments := strings.Split(trimmed, ".")
+	for _, segment := range segments {
+		if segment == "" {
+			return nil, fmt.Errorf("invalid field projection %q", field)
+		}
+	}
+	return segments, nil
+}
+
+func matches(include, exclude map[string]bool, value string) bool {
+	if exclude[value] {
+		return false
+	}
+	return len(include) == 0 || include[value]
+}
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			set[value] = true
+		}
+	}
+	return set
+}
+
+// ParseTimestampMs parses either epoch milliseconds or an RFC3339 timestamp
+func ParseTimestampMs(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return ms, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timestamp %q: expected epoch milliseconds or RFC3339", value)
+	}
+	return t.UnixMilli(), nil
+}
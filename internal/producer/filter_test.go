@@ -0,0 +1,229 @@
+package producer
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/kong/konnect-ingest/internal/models"
+)
+
+func newTestEvent(key string, op string, tsMs int64, object map[string]interface{}) models.CDCEvent {
+	var event models.CDCEvent
+	event.After.Key = key
+	event.After.Value.Object = object
+	event.Op = op
+	event.TsMs = tsMs
+	return event
+}
+
+func TestRuleFilterApply(t *testing.T) {
+	service := newTestEvent("c/cp-1/o/service/svc-1", models.OpCreate, 1000, map[string]interface{}{
+		"id":   "svc-1",
+		"name": "test-service",
+		"host": "example.com",
+	})
+	route := newTestEvent("c/cp-2/o/route/route-1", models.OpUpdate, 2000, map[string]interface{}{
+		"id":      "route-1",
+		"name":    "r1",
+		"paths":   []interface{}{"/r1"},
+		"service": map[string]interface{}{"id": "svc-1"},
+	})
+
+	tests := []struct {
+		name  string
+		rules FilterRules
+		event models.CDCEvent
+		want  bool
+	}{
+		{
+			name:  "no rules passes everything",
+			rules: FilterRules{},
+			event: service,
+			want:  true,
+		},
+		{
+			name:  "include entity type",
+			rules: FilterRules{IncludeEntityTypes: []string{"service", "route"}},
+			event: route,
+			want:  true,
+		},
+		{
+			name:  "entity type not included",
+			rules: FilterRules{IncludeEntityTypes: []string{"service"}},
+			event: route,
+			want:  false,
+		},
+		{
+			name:  "exclude wins over include",
+			rules: FilterRules{IncludeEntityTypes: []string{"service"}, ExcludeEntityTypes: []string{"service"}},
+			event: service,
+			want:  false,
+		},
+		{
+			name:  "include control plane",
+			rules: FilterRules{IncludeControlPlanes: []string{"cp-2"}},
+			event: service,
+			want:  false,
+		},
+		{
+			name:  "exclude control plane",
+			rules: FilterRules{ExcludeControlPlanes: []string{"cp-1"}},
+			event: route,
+			want:  true,
+		},
+		{
+			name:  "include op",
+			rules: FilterRules{IncludeOps: []string{models.OpCreate}},
+			event: route,
+			want:  false,
+		},
+		{
+			name:  "exclude op",
+			rules: FilterRules{ExcludeOps: []string{models.OpUpdate}},
+			event: service,
+			want:  true,
+		},
+		{
+			name:  "before range",
+			rules: FilterRules{FromTsMs: 1500},
+			event: service,
+			want:  false,
+		},
+		{
+			name:  "range end is exclusive",
+			rules: FilterRules{FromTsMs: 1000, ToTsMs: 2000},
+			event: route,
+			want:  false,
+		},
+		{
+			name:  "inside range",
+			rules: FilterRules{FromTsMs: 1000, ToTsMs: 2000},
+			event: service,
+			want:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := NewRuleFilter(tt.rules)
+			if err != nil {
+				t.Fatalf("NewRuleFilter() error = %v", err)
+			}
+
+			_, got := filter.Apply(tt.event)
+			if got != tt.want {
+				t.Errorf("RuleFilter.Apply() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuleFilterProjection(t *testing.T) {
+	filter, err := NewRuleFilter(FilterRules{Fields: []string{".name", ".service.id", ".missing"}})
+	if err != nil {
+		t.Fatalf("NewRuleFilter() error = %v", err)
+	}
+
+	original := map[string]interface{}{
+		"id":      "route-1",
+		"name":    "r1",
+		"paths":   []interface{}{"/r1"},
+		"service": map[string]interface{}{"id": "svc-1", "name": "ignored"},
+	}
+	event := newTestEvent("c/cp-2/o/route/route-1", models.OpUpdate, 2000, original)
+
+	got, ok := filter.Apply(event)
+	if !ok {
+		t.Fatalf("RuleFilter.Apply() dropped the event")
+	}
+
+	want := map[string]interface{}{
+		"id":      "route-1",
+		"name":    "r1",
+		"service": map[string]interface{}{"id": "svc-1"},
+	}
+	if !reflect.DeepEqual(got.After.Value.Object, want) {
+		t.Errorf("RuleFilter.Apply() object = %v, want %v", got.After.Value.Object, want)
+	}
+	if _, ok := original["paths"]; !ok {
+		t.Errorf("RuleFilter.Apply() modified the source object")
+	}
+}
+
+func TestRuleFilterOverlappingProjections(t *testing.T) {
+	filter, err := NewRuleFilter(FilterRules{Fields: []string{".service", ".service.id"}})
+	if err != nil {
+		t.Fatalf("NewRuleFilter() error = %v", err)
+	}
+
+	service := map[string]interface{}{"id": "svc-1", "tags": []interface{}{"a"}}
+	event := newTestEvent("c/cp-2/o/route/route-1", models.OpUpdate, 2000, map[string]interface{}{"id": "route-1", "service": service})
+
+	got, ok := filter.Apply(event)
+	if !ok {
+		t.Fatalf("RuleFilter.Apply() dropped the event")
+	}
+	projected := got.After.Value.Object.(map[string]interface{})["service"].(map[string]interface{})
+	projected["id"] = "changed"
+	projected["tags"].([]interface{})[0] = "changed"
+
+	if want := map[string]interface{}{"id": "svc-1", "tags": []interface{}{"a"}}; !reflect.DeepEqual(service, want) {
+		t.Errorf("projection shares the source object: %v", service)
+	}
+}
+
+func TestNewRuleFilterErrors(t *testing.T) {
+	if _, err := NewRuleFilter(FilterRules{FromTsMs: 2000, ToTsMs: 1000}); err == nil {
+		t.Errorf("NewRuleFilter() expected error for inverted range")
+	}
+	if _, err := NewRuleFilter(FilterRules{Fields: []string{".service..id"}}); err == nil {
+		t.Errorf("NewRuleFilter() expected error for invalid projection")
+	}
+}
+
+func TestParseTimestampMs(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		{value: "", want: 0},
+		{value: "1706812484573", want: 1706812484573},
+		{value: "2024-02-01T18:34:44Z", want: 1706812484000},
+		{value: "yesterday", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseTimestampMs(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseTimestampMs(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseTimestampMs(%q) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestDryRunEventProducer(t *testing.T) {
+	var out bytes.Buffer
+	producer := NewDryRunEventProducer(&out)
+
+	event := newTestEvent("c/cp-1/o/service/svc-1", models.OpCreate, 1000, map[string]interface{}{"id": "svc-1"})
+	if err := producer.ProduceEvent(event); err != nil {
+		t.Fatalf("DryRunEventProducer.ProduceEvent() error = %v", err)
+	}
+
+	var printed models.CDCEvent
+	if err := json.Unmarshal(out.Bytes(), &printed); err != nil {
+		t.Fatalf("printed event is not valid JSON: %v", err)
+	}
+	if printed.After.Key != event.After.Key || printed.Op != event.Op || printed.TsMs != event.TsMs {
+		t.Errorf("printed event = %+v, want %+v", printed, event)
+	}
+	if producer.Sent() != 1 {
+		t.Errorf("DryRunEventProducer.Sent() = %d, want 1", producer.Sent())
+	}
+}
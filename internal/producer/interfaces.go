@@ -7,3 +7,14 @@ type EventProducer interface {
 	ProduceEvent(event models.CDCEvent) error
 	Close() error
 }
+
+// EventFilter defines the contract for filtering and transforming events before they are produced
+type EventFilter interface {
+	Apply(event models.CDCEvent) (models.CDCEvent, bool)
+}
+
+// EventSource defines the contract for reading the CDC events to produce, io.EOF after the last one
+type EventSource interface {
+	ReadEvent() (*models.CDCEvent, error)
+	Close() error
+}
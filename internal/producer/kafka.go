@@ -2,6 +2,9 @@ package producer
 
 import (
 	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
 
 	"github.com/Shopify/sarama"
 	"github.com/kong/konnect-ingest/internal/models"
@@ -10,18 +13,25 @@ import (
 
 // KafkaEventProducer implements EventProducer for Kafka
 type KafkaEventProducer struct {
-	producer sarama.SyncProducer
-	topic    string
-	logger   *zap.Logger
+	producer   sarama.SyncProducer
+	topic      string
+	producerID string
+	strategy   string
+	logger     *zap.Logger
 }
 
 // NewKafkaEventProducer creates a new Kafka event producer
-func NewKafkaEventProducer(brokers []string, topic string, logger *zap.Logger) (*KafkaEventProducer, error) {
+func NewKafkaEventProducer(brokers []string, topic string, partitioning PartitionerConfig, logger *zap.Logger) (*KafkaEventProducer, error) {
+	partitioner, err := NewPartitioner(partitioning)
+	if err != nil {
+		return nil, err
+	}
+
 	kafkaConfig := sarama.NewConfig()
 	kafkaConfig.Producer.RequiredAcks = sarama.WaitForAll
 	kafkaConfig.Producer.Retry.Max = 5
 	kafkaConfig.Producer.Return.Successes = true
-	kafkaConfig.Producer.Partitioner = sarama.NewHashPartitioner
+	kafkaConfig.Producer.Partitioner = partitioner
 
 	producer, err := sarama.NewSyncProducer(brokers, kafkaConfig)
 	if err != nil {
@@ -29,9 +39,11 @@ func NewKafkaEventProducer(brokers []string, topic string, logger *zap.Logger) (
 	}
 
 	return &KafkaEventProducer{
-		producer: producer,
-		topic:    topic,
-		logger:   logger,
+		producer:   producer,
+		topic:      topic,
+		producerID: defaultProducerID(),
+		strategy:   partitioning.Strategy,
+		logger:     logger,
 	}, nil
 }
 
@@ -44,9 +56,10 @@ func (p *KafkaEventProducer) ProduceEvent(event models.CDCEvent) error {
 	}
 
 	msg := &sarama.ProducerMessage{
-		Topic: p.topic,
-		Key:   sarama.StringEncoder(event.After.Key),
-		Value: sarama.StringEncoder(eventBytes),
+		Topic:   p.topic,
+		Key:     sarama.StringEncoder(event.After.Key),
+		Value:   sarama.StringEncoder(eventBytes),
+		Headers: recordHeaders(p.eventHeaders(event)),
 	}
 
 	partition, offset, err := p.producer.SendMessage(msg)
@@ -67,3 +80,41 @@ func (p *KafkaEventProducer) ProduceEvent(event models.CDCEvent) error {
 func (p *KafkaEventProducer) Close() error {
 	return p.producer.Close()
 }
+
+// eventHeaders builds the record headers for an event produced by this instance
+func (p *KafkaEventProducer) eventHeaders(event models.CDCEvent) models.EventHeaders {
+	headers := models.NewEventHeaders(event, p.producerID)
+	headers.PartitionStrategy = p.strategy
+	if headers.PartitionStrategy == "" {
+		headers.PartitionStrategy = models.PartitionByKey
+	}
+	return headers
+}
+
+// recordHeaders converts the CDC header contract into sarama record headers
+func recordHeaders(headers models.EventHeaders) []sarama.RecordHeader {
+	values := headers.ToMap()
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]sarama.RecordHeader, 0, len(names))
+	for _, name := range names {
+		result = append(result, sarama.RecordHeader{
+			Key:   []byte(name),
+			Value: []byte(values[name]),
+		})
+	}
+	return result
+}
+
+// defaultProducerID identifies this producer instance as host-pid
+func defaultProducerID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
@@ -91,6 +91,8 @@ func TestKafkaEventProducer(t *testing.T) {
 						},
 					},
 				},
+				Op:   models.OpCreate,
+				TsMs: 1706812484573,
 			},
 			wantErr: false,
 		},
@@ -125,9 +127,10 @@ func TestKafkaEventProducer(t *testing.T) {
 			// Create a mock producer for each test
 			mockProducer := &mockSyncProducer{sendMessageError: tt.producerError}
 			producer := &KafkaEventProducer{
-				producer: mockProducer,
-				topic:    "test-topic",
-				logger:   zap.NewNop(),
+				producer:   mockProducer,
+				topic:      "test-topic",
+				producerID: "test-producer",
+				logger:     zap.NewNop(),
 			}
 
 			// Test producing event
@@ -151,6 +154,24 @@ func TestKafkaEventProducer(t *testing.T) {
 				if string(msg.Key.(sarama.StringEncoder)) != tt.event.After.Key {
 					t.Errorf("Expected key %s, got %s", tt.event.After.Key, string(msg.Key.(sarama.StringEncoder)))
 				}
+
+				headers := make(map[string]string)
+				for _, header := range msg.Headers {
+					headers[string(header.Key)] = string(header.Value)
+				}
+				wantHeaders := map[string]string{
+					models.HeaderEntityType:     "service",
+					models.HeaderControlPlaneID: "123",
+					models.HeaderOp:             models.OpCreate,
+					models.HeaderTsMs:           "1706812484573",
+					models.HeaderSchemaVersion:  models.HeaderSchemaVersionV1,
+					models.HeaderProducerID:     "test-producer",
+				}
+				for name, want := range wantHeaders {
+					if headers[name] != want {
+						t.Errorf("Expected header %s=%s, got %s", name, want, headers[name])
+					}
+				}
 			}
 		})
 	}
@@ -0,0 +1,174 @@
+package producer
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"github.com/kong/konnect-ingest/internal/models"
+)
+
+// DefaultVirtualNodes is the number of ring points per partition for consistent hashing
+const DefaultVirtualNodes = 64
+
+// PartitionerConfig selects how CDC keys are mapped to partitions
+type PartitionerConfig struct {
+	Strategy     string
+	VirtualNodes int
+}
+
+// NewPartitioner returns the sarama partitioner constructor for the configured strategy
+func NewPartitioner(cfg PartitionerConfig) (sarama.PartitionerConstructor, error) {
+	switch cfg.Strategy {
+	case "", models.PartitionByKey:
+		return sarama.NewHashPartitioner, nil
+	case models.PartitionByControlPlane:
+		return newDerivedKeyPartitioner(controlPlaneKey), nil
+	case models.PartitionByEntity:
+		return newDerivedKeyPartitioner(entityKey), nil
+	case models.PartitionByConsistentHash:
+		virtualNodes := cfg.VirtualNodes
+		if virtualNodes <= 0 {
+			virtualNodes = DefaultVirtualNodes
+		}
+		return func(_ string) sarama.Partitioner {
+			return &consistentHashPartitioner{virtualNodes: virtualNodes, rings: make(map[int32]*hashRing)}
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown partitioning strategy %q", cfg.Strategy)
+	}
+}
+
+// derivedKeyPartitioner hashes a value derived from the CDC key instead of the key itself
+type derivedKeyPartitioner struct {
+	derive func(key string) string
+}
+
+func newDerivedKeyPartitioner(derive func(key string) string) sarama.PartitionerConstructor {
+	return func(_ string) sarama.Partitioner {
+		return &derivedKeyPartitioner{derive: derive}
+	}
+}
+
+// Partition implements sarama.Partitioner
+func (p *derivedKeyPartitioner) Partition(message *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	key, err := messageKey(message)
+	if err != nil {
+		return -1, err
+	}
+	return int32(hash32(p.derive(key)) % uint32(numPartitions)), nil
+}
+
+// RequiresConsistency implements sarama.Partitioner
+func (p *derivedKeyPartitioner) RequiresConsistency() bool {
+	return true
+}
+
+// consistentHashPartitioner spreads keys over a ring of virtual nodes so that adding
+// partitions only moves a small share of keys, keeping per-entity order for the rest
+type consistentHashPartitioner struct {
+	virtualNodes int
+	mu           sync.Mutex
+	rings        map[int32]*hashRing
+}
+
+// Partition implements sarama.Partitioner
+func (p *consistentHashPartitioner) Partition(message *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	key, err := messageKey(message)
+	if err != nil {
+		return -1, err
+	}
+
+	p.mu.Lock()
+	ring, ok := p.rings[numPartitions]
+	if !ok {
+		ring = newHashRing(numPartitions, p.virtualNodes)
+		p.rings[numPartitions] = ring
+	}
+	p.mu.Unlock()
+
+	return ring.lookup(key), nil
+}
+
+// RequiresConsistency implements sarama.Partitioner
+func (p *consistentHashPartitioner) RequiresConsistency() bool {
+	return true
+}
+
+type hashRing struct {
+	points     []uint32
+	partitions map[uint32]int32
+}
+
+func newHashRing(numPartitions int32, virtualNodes int) *hashRing {
+	ring := &hashRing{partitions: make(map[uint32]int32)}
+	for partition := int32(0); partition < numPartitions; partition++ {
+		for vnode := 0; vnode < virtualNodes; vnode++ {
+			point := ringHash(strconv.Itoa(int(partition)) + "#" + strconv.Itoa(vnode))
+			if _, taken := ring.partitions[point]; taken {
+				continue
+			}
+			ring.partitions[point] = partition
+			ring.points = append(ring.points, point)
+		}
+	}
+	sort.Slice(ring.points, func(i, j int) bool { return ring.points[i] < ring.points[j] })
+	return ring
+}
+
+func (r *hashRing) lookup(key string) int32 {
+	h := ringHash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.partitions[r.points[i]]
+}
+
+// controlPlaneKey keeps every entity of a control plane on the same partition
+func controlPlaneKey(key string) string {
+	if parsed, ok := models.ParseEntityKey(key); ok {
+		return parsed.ControlPlaneID
+	}
+	return key
+}
+
+// entityKey identifies the entity independently of the control plane prefix
+func entityKey(key string) string {
+	if parsed, ok := models.ParseEntityKey(key); ok {
+		return parsed.EntityType + "/" + parsed.ID
+	}
+	return key
+}
+
+func messageKey(message *sarama.ProducerMessage) (string, error) {
+	if message.Key == nil {
+		return "", fmt.Errorf("partitioning requires a message key")
+	}
+	bytes, err := message.Key.Encode()
+	if err != nil {
+		return "", err
+	}
+	return string(bytes), nil
+}
+
+// ringHash is fnv-1a with a murmur3 finalizer; plain fnv clusters similar short strings
+// such as virtual node names, which skews the ring
+func ringHash(value string) uint32 {
+	h := hash32(value)
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+func hash32(value string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(value))
+	return h.Sum32()
+}
@@ -0,0 +1,148 @@
+package producer
+
+import (
+	"io"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/kong/konnect-ingest/internal/models"
+	"go.uber.org/zap"
+)
+
+const samplePartitions = 3
+
+// loadSampleKeys returns the keys of every event in stream.jsonl, in stream order
+func loadSampleKeys(t *testing.T) []string {
+	t.Helper()
+
+	reader, err := NewEventReader("../../stream.jsonl", zap.NewNop())
+	if err != nil {
+		t.Fatalf("Failed to open sample stream: %v", err)
+	}
+	defer reader.Close()
+
+	var keys []string
+	for {
+		event, err := reader.ReadEvent()
+		if err == io.EOF {
+			return keys
+		}
+		if err != nil {
+			t.Fatalf("Failed to read sample stream: %v", err)
+		}
+		keys = append(keys, event.After.Key)
+	}
+}
+
+func partitionOf(t *testing.T, partitioner sarama.Partitioner, key string, numPartitions int32) int32 {
+	t.Helper()
+
+	partition, err := partitioner.Partition(&sarama.ProducerMessage{Key: sarama.StringEncoder(key)}, numPartitions)
+	if err != nil {
+		t.Fatalf("Partition(%s) error = %v", key, err)
+	}
+	if partition < 0 || partition >= numPartitions {
+		t.Fatalf("Partition(%s) = %d, out of range", key, partition)
+	}
+	return partition
+}
+
+func TestPartitionerDistribution(t *testing.T) {
+	keys := loadSampleKeys(t)
+
+	tests := []struct {
+		strategy string
+		// groupOf returns the unit that must always map to a single partition
+		groupOf func(key string) string
+	}{
+		{strategy: models.PartitionByKey, groupOf: func(key string) string { return key }},
+		{strategy: models.PartitionByControlPlane, groupOf: controlPlaneKey},
+		{strategy: models.PartitionByEntity, groupOf: entityKey},
+		{strategy: models.PartitionByConsistentHash, groupOf: func(key string) string { return key }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			constructor, err := NewPartitioner(PartitionerConfig{Strategy: tt.strategy})
+			if err != nil {
+				t.Fatalf("NewPartitioner() error = %v", err)
+			}
+			partitioner := constructor("test-topic")
+
+			events := make([]int, samplePartitions)
+			groups := make(map[string]int32)
+			for _, key := range keys {
+				partition := partitionOf(t, partitioner, key, samplePartitions)
+				events[partition]++
+
+				group := tt.groupOf(key)
+				if previous, ok := groups[group]; ok && previous != partition {
+					t.Fatalf("%s was split across partitions %d and %d", group, previous, partition)
+				}
+				groups[group] = partition
+			}
+
+			distinct := make([]int, samplePartitions)
+			for _, partition := range groups {
+				distinct[partition]++
+			}
+			t.Logf("%s: events per partition %v, ordering groups per partition %v", tt.strategy, events, distinct)
+
+			for partition, count := range distinct {
+				if count == 0 {
+					t.Errorf("partition %d received no ordering groups", partition)
+				}
+			}
+		})
+	}
+}
+
+func TestConsistentHashStableOnPartitionIncrease(t *testing.T) {
+	keys := loadSampleKeys(t)
+	unique := make(map[string]bool)
+	for _, key := range keys {
+		unique[key] = true
+	}
+
+	moved := func(strategy string) int {
+		constructor, err := NewPartitioner(PartitionerConfig{Strategy: strategy})
+		if err != nil {
+			t.Fatalf("NewPartitioner() error = %v", err)
+		}
+		partitioner := constructor("test-topic")
+
+		count := 0
+		for key := range unique {
+			if partitionOf(t, partitioner, key, samplePartitions) != partitionOf(t, partitioner, key, samplePartitions+1) {
+				count++
+			}
+		}
+		return count
+	}
+
+	ring := moved(models.PartitionByConsistentHash)
+	modulo := moved(models.PartitionByKey)
+	t.Logf("keys moved going from %d to %d partitions: consistent_hash=%d key=%d of %d",
+		samplePartitions, samplePartitions+1, ring, modulo, len(unique))
+
+	if ring >= modulo {
+		t.Errorf("consistent hashing moved %d keys, expected fewer than the %d moved by modulo hashing", ring, modulo)
+	}
+}
+
+func TestNewPartitionerUnknownStrategy(t *testing.T) {
+	if _, err := NewPartitioner(PartitionerConfig{Strategy: "random"}); err == nil {
+		t.Errorf("NewPartitioner() expected error for unknown strategy")
+	}
+}
+
+func TestOrderingScopeFor(t *testing.T) {
+	key := "c/cp-1/o/service/svc-1"
+
+	if got := models.OrderingScopeFor(models.PartitionByControlPlane).OrderingKey(key); got != "cp-1" {
+		t.Errorf("control plane ordering key = %s, want cp-1", got)
+	}
+	if got := models.OrderingScopeFor(models.PartitionByConsistentHash).OrderingKey(key); got != key {
+		t.Errorf("entity ordering key = %s, want %s", got, key)
+	}
+}
@@ -0,0 +1,30 @@
+package reindex
+
+import (
+	"context"
+
+	"github.com/kong/konnect-ingest/internal/data_processing"
+)
+
+// IndexAdmin defines the index and alias operations a reindex needs
+type IndexAdmin interface {
+	CurrentGeneration(logical string) (int, error)
+	Resolve(alias string) ([]string, error)
+	CreateIndex(index string, body map[string]interface{}) error
+	UpdateAliases(actions []data_processing.AliasAction) error
+	SwapAliases(logical string, from string, to string) error
+	Count(index string) (int64, error)
+	Reindex(source string, dest string) error
+	PurgeTombstones(index string) (int64, error)
+}
+
+// DocumentCreator defines the contract for writing a document only if it does not exist yet
+type DocumentCreator interface {
+	CreateDocument(indexName string, id string, document interface{}) (bool, error)
+}
+
+// Replayer defines the contract for rebuilding the latest document per id of a logical index,
+// passing each one to create
+type Replayer interface {
+	Replay(ctx context.Context, logical string, create func(id string, document interface{}) error) error
+}
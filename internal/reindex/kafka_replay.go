@@ -0,0 +1,229 @@
+package reindex
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/Shopify/sarama"
+	"github.com/kong/konnect-ingest/internal/consumer"
+	"github.com/kong/konnect-ingest/internal/data_processing"
+	"github.com/kong/konnect-ingest/internal/models"
+	"go.uber.org/zap"
+	_ "modernc.org/sqlite"
+)
+
+// ProcessorFactory builds the event processor used to turn replayed events into documents,
+// so a replay produces exactly what the live consumer would index
+type ProcessorFactory func(indexer data_processing.DocumentIndexer) consumer.EventProcessor
+
+// KafkaReplayer implements Replayer by reading a topic from the oldest offset up to the
+// high watermarks observed when the replay starts
+type KafkaReplayer struct {
+	client       sarama.Client
+	topic        string
+	newProcessor ProcessorFactory
+	logger       *zap.Logger
+}
+
+// NewKafkaReplayer creates a new Kafka replayer
+func NewKafkaReplayer(client sarama.Client, topic string, newProcessor ProcessorFactory, logger *zap.Logger) *KafkaReplayer {
+	return &KafkaReplayer{
+		client:       client,
+		topic:        topic,
+		newProcessor: newProcessor,
+		logger:       logger,
+	}
+}
+
+// Replay passes the latest document per id the processor writes to the logical index to
+// create. The documents are kept on disk until the whole topic has been replayed, so memory
+// does not grow with the topic.
+func (r *KafkaReplayer) Replay(ctx context.Context, logical string, create func(id string, document interface{}) error) error {
+	capture, err := newCaptureIndexer(logical)
+	if err != nil {
+		return fmt.Errorf("failed to create replay scratch file: %w", err)
+	}
+	defer capture.Close()
+	processor := r.newProcessor(capture)
+
+	kafkaConsumer, err := sarama.NewConsumerFromClient(r.client)
+	if err != nil {
+		return err
+	}
+	defer kafkaConsumer.Close()
+
+	partitions, err := r.client.Partitions(r.topic)
+	if err != nil {
+		return fmt.Errorf("failed to list partitions of %s: %w", r.topic, err)
+	}
+
+	for _, partition := range partitions {
+		if err := r.replayPartition(ctx, kafkaConsumer, partition, processor); err != nil {
+			return err
+		}
+	}
+	return capture.each(ctx, create)
+}
+
+func (r *KafkaReplayer) replayPartition(ctx context.Context, kafkaConsumer sarama.Consumer, partition int32, processor consumer.EventProcessor) error {
+	oldest, err := r.client.GetOffset(r.topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return err
+	}
+	newest, err := r.client.GetOffset(r.topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return err
+	}
+	if newest <= oldest {
+		return nil
+	}
+
+	partitionConsumer, err := kafkaConsumer.ConsumePartition(r.topic, partition, oldest)
+	if err != nil {
+		return err
+	}
+	defer partitionConsumer.Close()
+
+	for {
+		select {
+		case message := <-partitionConsumer.Messages():
+			var event models.CDCEvent
+			if err := event.UnmarshalJSON(message.Value); err != nil {
+				r.logger.Warn("Skipping unreadable event during replay", zap.Int64("offset", message.Offset), zap.Error(err))
+			} else if err := processor.ProcessEvent(event); err != nil {
+				r.logger.Warn("Failed to process event during replay", zap.Int64("offset", message.Offset), zap.Error(err))
+			}
+			if message.Offset >= newest-1 {
+				return nil
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// captureIndexer keeps the latest document per id of one index in a scratch SQLite file
+type captureIndexer struct {
+	index string
+	path  string
+	db    *sql.DB
+}
+
+// newCaptureIndexer creates the scratch file in the default temporary directory
+func newCaptureIndexer(index string) (*captureIndexer, error) {
+	file, err := os.CreateTemp("", "replay-*.db")
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+
+	db, err := sql.Open("sqlite", file.Name())
+	if err != nil {
+		os.Remove(file.Name())
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	c := &captureIndexer{index: index, path: file.Name(), db: db}
+	// The file only lives for one replay, durability does not matter
+	for _, statement := range []string{
+		"PRAGMA journal_mode = OFF",
+		"PRAGMA synchronous = OFF",
+		"CREATE TABLE documents (id TEXT PRIMARY KEY, document TEXT NOT NULL)",
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *captureIndexer) IndexDocument(indexName string, id string, document interface{}) error {
+	if indexName != c.index {
+		return nil
+	}
+	documentBytes, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Exec(
+		"INSERT INTO documents (id, document) VALUES (?, ?) ON CONFLICT (id) DO UPDATE SET document = excluded.document",
+		id, string(documentBytes),
+	)
+	return err
+}
+
+func (c *captureIndexer) DeleteDocument(indexName string, id string) error {
+	if indexName != c.index {
+		return nil
+	}
+	_, err := c.db.Exec("DELETE FROM documents WHERE id = ?", id)
+	return err
+}
+
+func (c *captureIndexer) UpdateReferenceName(indexName string, field string, parentID string, name string) (int64, error) {
+	if indexName != c.index {
+		return 0, nil
+	}
+	reference := "$." + field
+	result, err := c.db.Exec(
+		"UPDATE documents SET document = json_set(document, ?, ?) WHERE json_extract(document, ?) = ? AND json_extract(document, ?) IS NOT ?",
+		reference+".name", name, reference+".id", parentID, reference+".name", name,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (c *captureIndexer) UpdateControlPlane(indexPattern string, controlPlane map[string]interface{}) (int64, error) {
+	if matched, _ := path.Match(indexPattern, c.index); !matched {
+		return 0, nil
+	}
+	controlPlaneBytes, err := json.Marshal(controlPlane)
+	if err != nil {
+		return 0, err
+	}
+	field := "$." + data_processing.ControlPlaneField
+	result, err := c.db.Exec(
+		"UPDATE documents SET document = json_set(document, ?, json(?)) WHERE json_extract(document, ?) = ?",
+		field, string(controlPlaneBytes), field+".id", controlPlane["id"],
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// each passes every captured document, as raw JSON, to create in id order
+func (c *captureIndexer) each(ctx context.Context, create func(id string, document interface{}) error) error {
+	rows, err := c.db.QueryContext(ctx, "SELECT id, document FROM documents ORDER BY id")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, document string
+		if err := rows.Scan(&id, &document); err != nil {
+			return err
+		}
+		if err := create(id, json.RawMessage(document)); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Close removes the scratch file
+func (c *captureIndexer) Close() error {
+	err := c.db.Close()
+	if removeErr := os.Remove(c.path); err == nil {
+		err = removeErr
+	}
+	return err
+}
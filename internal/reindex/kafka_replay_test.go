@@ -0,0 +1,85 @@
+package reindex
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/kong/konnect-ingest/internal/data_processing"
+)
+
+func TestCaptureIndexer(t *testing.T) {
+	capture, err := newCaptureIndexer("cdc-route")
+	if err != nil {
+		t.Fatalf("newCaptureIndexer() error = %v", err)
+	}
+
+	controlPlane := map[string]interface{}{"id": "cp-1", "name": "old"}
+	writes := []struct {
+		index    string
+		id       string
+		document interface{}
+	}{
+		{"cdc-route", "r1", map[string]interface{}{"name": "v1", "service": map[string]interface{}{"id": "s1", "name": "a"}}},
+		{"cdc-route", "r1", map[string]interface{}{"name": "v2", "service": map[string]interface{}{"id": "s1", "name": "a"}, "control_plane": controlPlane}},
+		{"cdc-route", "r2", map[string]interface{}{"name": "r2", "service": map[string]interface{}{"id": "s2"}}},
+		{"cdc-route", "r3", map[string]interface{}{"name": "r3"}},
+		{"cdc-route", "r4", data_processing.SearchDocument{EntityType: "route", ControlPlane: controlPlane}},
+		{"cdc-service", "s1", map[string]interface{}{"name": "other index"}},
+	}
+	for _, write := range writes {
+		if err := capture.IndexDocument(write.index, write.id, write.document); err != nil {
+			t.Fatalf("IndexDocument() error = %v", err)
+		}
+	}
+	if err := capture.DeleteDocument("cdc-route", "r3"); err != nil {
+		t.Fatalf("DeleteDocument() error = %v", err)
+	}
+
+	if updated, err := capture.UpdateReferenceName("cdc-route", "service", "s1", "b"); err != nil || updated != 1 {
+		t.Errorf("UpdateReferenceName() = %d, %v, want 1 document", updated, err)
+	}
+	if updated, _ := capture.UpdateReferenceName("cdc-route", "service", "s1", "b"); updated != 0 {
+		t.Errorf("UpdateReferenceName() with the current name updated %d documents", updated)
+	}
+	if updated, err := capture.UpdateControlPlane("cdc-*", map[string]interface{}{"id": "cp-1", "name": "new"}); err != nil || updated != 2 {
+		t.Errorf("UpdateControlPlane() = %d, %v, want 2 documents", updated, err)
+	}
+
+	got := map[string]interface{}{}
+	err = capture.each(context.Background(), func(id string, document interface{}) error {
+		var decoded interface{}
+		if err := json.Unmarshal(document.(json.RawMessage), &decoded); err != nil {
+			return err
+		}
+		got[id] = decoded
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("each() error = %v", err)
+	}
+	want := map[string]interface{}{
+		"r1": map[string]interface{}{
+			"name":          "v2",
+			"service":       map[string]interface{}{"id": "s1", "name": "b"},
+			"control_plane": map[string]interface{}{"id": "cp-1", "name": "new"},
+		},
+		"r2": map[string]interface{}{"name": "r2", "service": map[string]interface{}{"id": "s2"}},
+		"r4": map[string]interface{}{"entity_type": "route", "control_plane": map[string]interface{}{"id": "cp-1", "name": "new"}},
+	}
+	if len(got) != len(want) || !reflect.DeepEqual(got["r1"], want["r1"]) || !reflect.DeepEqual(got["r2"], want["r2"]) {
+		t.Errorf("captured %v, want %v", got, want)
+	}
+	if cp := got["r4"].(map[string]interface{})["control_plane"]; !reflect.DeepEqual(cp, want["r4"].(map[string]interface{})["control_plane"]) {
+		t.Errorf("search document control plane = %v", cp)
+	}
+
+	if err := capture.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if _, err := os.Stat(capture.path); !os.IsNotExist(err) {
+		t.Errorf("scratch file %s not removed", capture.path)
+	}
+}
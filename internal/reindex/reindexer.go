@@ -0,0 +1,294 @@
+package reindex
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/kong/konnect-ingest/internal/data_processing"
+	"go.uber.org/zap"
+)
+
+// Catch-up sources for a new index generation
+const (
+	SourceKafka      = "kafka"
+	SourceOpenSearch = "opensearch"
+)
+
+// Options controls a reindex run
+type Options struct {
+	// Source is where the new generation is built from, SourceKafka or SourceOpenSearch
+	Source string
+	// Body holds optional settings and mappings for the new physical index
+	Body map[string]interface{}
+	// Tolerance is the accepted relative difference between old and new document counts
+	Tolerance float64
+	// DualWriteSettle is how long to wait for consumers to pick up alias changes
+	DualWriteSettle time.Duration
+}
+
+// Result describes a completed reindex
+type Result struct {
+	From     string
+	To       string
+	OldCount int64
+	NewCount int64
+	Swapped  bool
+}
+
+// Reindexer builds a new generation of a logical index while consumers keep writing,
+// validates it and atomically swaps the aliases
+type Reindexer struct {
+	admin    IndexAdmin
+	creator  DocumentCreator
+	replayer Replayer
+	logger   *zap.Logger
+}
+
+// NewReindexer creates a new reindexer. The replayer is only needed for SourceKafka.
+func NewReindexer(admin IndexAdmin, creator DocumentCreator, replayer Replayer, logger *zap.Logger) *Reindexer {
+	return &Reindexer{
+		admin:    admin,
+		creator:  creator,
+		replayer: replayer,
+		logger:   logger,
+	}
+}
+
+// Run builds generation N+1 of a logical index and swaps the aliases to it.
+//
+// The new generation carries the dual-write alias before the catch-up starts, so consumers
+// write every change to it from then on. Catch-up writes only create missing documents, which
+// never overwrites the newer state a consumer dual-wrote in the meantime. Until the catch-up
+// ends, the catch-up alias makes consumers leave tombstones for deletes, which the catch-up
+// does not overwrite either; they are purged before the counts are compared.
+func (r *Reindexer) Run(ctx context.Context, logical string, opts Options) (Result, error) {
+	generation, err := r.admin.CurrentGeneration(logical)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to find current generation of %s: %w", logical, err)
+	}
+
+	result := Result{
+		From: data_processing.PhysicalIndex(logical, generation),
+		To:   data_processing.PhysicalIndex(logical, generation+1),
+	}
+
+	if err := r.admin.CreateIndex(result.To, opts.Body); err != nil {
+		return result, err
+	}
+	if err := r.admin.UpdateAliases([]data_processing.AliasAction{
+		{Add: true, Index: result.To, Alias: data_processing.DualWriteAlias(logical)},
+		{Add: true, Index: result.To, Alias: data_processing.CatchUpAlias(logical)},
+	}); err != nil {
+		return result, err
+	}
+	r.logger.Info("Dual-writing to new generation", zap.String("index", result.To))
+
+	if err := settle(ctx, opts.DualWriteSettle); err != nil {
+		return result, r.abort(logical, result, err)
+	}
+
+	if err := r.catchUp(ctx, logical, result, opts.Source); err != nil {
+		return result, r.abort(logical, result, err)
+	}
+
+	// Consumers still resolving the old aliases keep leaving tombstones until they settle.
+	// The current generation is dual-written from now on, so that consumers still resolving
+	// the pre-swap dual-write targets keep it current once the write alias moves.
+	if err := r.admin.UpdateAliases([]data_processing.AliasAction{
+		{Index: result.To, Alias: data_processing.CatchUpAlias(logical)},
+		{Add: true, Index: result.From, Alias: data_processing.DualWriteAlias(logical)},
+	}); err != nil {
+		return result, r.abort(logical, result, err)
+	}
+	if err := settle(ctx, opts.DualWriteSettle); err != nil {
+		return result, r.abort(logical, result, err)
+	}
+	purged, err := r.admin.PurgeTombstones(result.To)
+	if err != nil {
+		return result, r.abort(logical, result, err)
+	}
+	r.logger.Info("Purged tombstones of deletes during catch-up", zap.String("index", result.To), zap.Int64("tombstones", purged))
+
+	result.OldCount, err = r.admin.Count(result.From)
+	if err != nil {
+		return result, r.abort(logical, result, err)
+	}
+	result.NewCount, err = r.admin.Count(result.To)
+	if err != nil {
+		return result, r.abort(logical, result, err)
+	}
+	if !withinTolerance(result.OldCount, result.NewCount, opts.Tolerance) {
+		return result, r.abort(logical, result, fmt.Errorf("document count mismatch: %s has %d, %s has %d",
+			result.From, result.OldCount, result.To, result.NewCount))
+	}
+
+	if err := r.admin.SwapAliases(logical, result.From, result.To); err != nil {
+		return result, r.abort(logical, result, err)
+	}
+	result.Swapped = true
+
+	r.logger.Info("Swapped aliases to new generation",
+		zap.String("from", result.From),
+		zap.String("to", result.To),
+		zap.Int64("documents", result.NewCount),
+	)
+	return result, nil
+}
+
+// Rollback swaps the aliases back to the previous generation, which was kept current
+// through the dual-write alias since the swap. The current generation is dual-written for
+// settle before the swap, so that it stays current for consumers still resolving the
+// pre-rollback dual-write targets.
+func (r *Reindexer) Rollback(ctx context.Context, logical string, settleFor time.Duration) (Result, error) {
+	generation, err := r.admin.CurrentGeneration(logical)
+	if err != nil {
+		return Result{}, err
+	}
+	if generation <= 1 {
+		return Result{}, fmt.Errorf("%s has no previous generation to roll back to", logical)
+	}
+
+	result := Result{
+		From: data_processing.PhysicalIndex(logical, generation),
+		To:   data_processing.PhysicalIndex(logical, generation-1),
+	}
+	dualWrites, err := r.admin.Resolve(data_processing.DualWriteAlias(logical))
+	if err != nil {
+		return result, err
+	}
+	if !contains(dualWrites, result.To) {
+		return result, fmt.Errorf("%s is no longer dual-written and may be stale", result.To)
+	}
+
+	if err := r.admin.UpdateAliases([]data_processing.AliasAction{
+		{Add: true, Index: result.From, Alias: data_processing.DualWriteAlias(logical)},
+	}); err != nil {
+		return result, err
+	}
+	if err := settle(ctx, settleFor); err != nil {
+		return result, r.undoDualWrite(logical, result.From, err)
+	}
+	if err := r.admin.SwapAliases(logical, result.From, result.To); err != nil {
+		return result, r.undoDualWrite(logical, result.From, err)
+	}
+	result.Swapped = true
+	return result, nil
+}
+
+// Finalize stops dual writes to generations other than the current one, after which a
+// rollback is no longer possible
+func (r *Reindexer) Finalize(logical string) error {
+	dualWrites, err := r.admin.Resolve(data_processing.DualWriteAlias(logical))
+	if err != nil {
+		return err
+	}
+
+	actions := make([]data_processing.AliasAction, 0, len(dualWrites))
+	for _, index := range dualWrites {
+		actions = append(actions, data_processing.AliasAction{Index: index, Alias: data_processing.DualWriteAlias(logical)})
+	}
+	if len(actions) == 0 {
+		return nil
+	}
+	return r.admin.UpdateAliases(actions)
+}
+
+func (r *Reindexer) catchUp(ctx context.Context, logical string, result Result, source string) error {
+	switch source {
+	case SourceOpenSearch:
+		return r.admin.Reindex(result.From, result.To)
+	case SourceKafka:
+		if r.replayer == nil {
+			return fmt.Errorf("kafka catch-up requires a replayer")
+		}
+		replayed, created := 0, 0
+		err := r.replayer.Replay(ctx, logical, func(id string, document interface{}) error {
+			ok, err := r.creator.CreateDocument(result.To, id, document)
+			if err != nil {
+				return err
+			}
+			replayed++
+			if ok {
+				created++
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		r.logger.Info("Replayed documents into new generation",
+			zap.String("index", result.To),
+			zap.Int("replayed", replayed),
+			zap.Int("created", created),
+		)
+		return nil
+	default:
+		return fmt.Errorf("unknown reindex source %q", source)
+	}
+}
+
+// abort stops dual writes to the unfinished generation and keeps it for inspection. The
+// current generation stops being dual-written too, in case it already was.
+func (r *Reindexer) abort(logical string, result Result, cause error) error {
+	var actions []data_processing.AliasAction
+	for _, alias := range []string{data_processing.DualWriteAlias(logical), data_processing.CatchUpAlias(logical)} {
+		indices, err := r.admin.Resolve(alias)
+		if err != nil {
+			r.logger.Error("Failed to resolve alias after aborted reindex", zap.String("alias", alias), zap.Error(err))
+			continue
+		}
+		for _, index := range []string{result.From, result.To} {
+			if contains(indices, index) {
+				actions = append(actions, data_processing.AliasAction{Index: index, Alias: alias})
+			}
+		}
+	}
+	if len(actions) > 0 {
+		if err := r.admin.UpdateAliases(actions); err != nil {
+			r.logger.Error("Failed to remove aliases after aborted reindex", zap.String("index", result.To), zap.Error(err))
+		}
+	}
+	return fmt.Errorf("reindex of %s aborted: %w", logical, cause)
+}
+
+// undoDualWrite stops dual writes to the current generation after a failed rollback
+func (r *Reindexer) undoDualWrite(logical string, index string, cause error) error {
+	err := r.admin.UpdateAliases([]data_processing.AliasAction{
+		{Index: index, Alias: data_processing.DualWriteAlias(logical)},
+	})
+	if err != nil {
+		r.logger.Error("Failed to remove dual-write alias after failed rollback", zap.String("index", index), zap.Error(err))
+	}
+	return fmt.Errorf("rollback of %s failed: %w", logical, cause)
+}
+
+// settle waits for consumers to pick up alias changes
+func settle(ctx context.Context, duration time.Duration) error {
+	select {
+	case <-time.After(duration):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func withinTolerance(oldCount int64, newCount int64, tolerance float64) bool {
+	if oldCount == newCount {
+		return true
+	}
+	if oldCount == 0 {
+		return false
+	}
+	return math.Abs(float64(newCount-oldCount))/float64(oldCount) <= tolerance
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,341 @@
+package reindex
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/kong/konnect-ingest/internal/data_processing"
+	"go.uber.org/zap"
+)
+
+// fakeIndexAdmin keeps aliases and documents in memory
+type fakeIndexAdmin struct {
+	aliases   map[string][]string
+	documents map[string]map[string]interface{}
+	failSwap  bool
+	// dualWrittenAtSwap records whether the generation losing the write alias was already
+	// dual-written when the aliases were swapped
+	dualWrittenAtSwap bool
+}
+
+func newFakeIndexAdmin(logical string, generation int, documents map[string]interface{}) *fakeIndexAdmin {
+	index := data_processing.PhysicalIndex(logical, generation)
+	return &fakeIndexAdmin{
+		aliases: map[string][]string{
+			data_processing.ReadAlias(logical):  {index},
+			data_processing.WriteAlias(logical): {index},
+		},
+		documents: map[string]map[string]interface{}{index: documents},
+	}
+}
+
+func (f *fakeIndexAdmin) CurrentGeneration(logical string) (int, error) {
+	for _, index := range f.aliases[data_processing.ReadAlias(logical)] {
+		if generation, ok := data_processing.ParseGeneration(logical, index); ok {
+			return generation, nil
+		}
+	}
+	return 0, data_processing.ErrNoGeneration
+}
+
+func (f *fakeIndexAdmin) Resolve(alias string) ([]string, error) {
+	return f.aliases[alias], nil
+}
+
+func (f *fakeIndexAdmin) CreateIndex(index string, body map[string]interface{}) error {
+	if _, ok := f.documents[index]; ok {
+		return errors.New("index already exists")
+	}
+	f.documents[index] = map[string]interface{}{}
+	return nil
+}
+
+func (f *fakeIndexAdmin) UpdateAliases(actions []data_processing.AliasAction) error {
+	for _, action := range actions {
+		indices := f.aliases[action.Alias]
+		if action.Add {
+			if !contains(indices, action.Index) {
+				f.aliases[action.Alias] = append(indices, action.Index)
+			}
+			continue
+		}
+		var kept []string
+		for _, index := range indices {
+			if index != action.Index {
+				kept = append(kept, index)
+			}
+		}
+		f.aliases[action.Alias] = kept
+	}
+	return nil
+}
+
+func (f *fakeIndexAdmin) SwapAliases(logical string, from string, to string) error {
+	if f.failSwap {
+		return errors.New("swap failed")
+	}
+	f.dualWrittenAtSwap = contains(f.aliases[data_processing.DualWriteAlias(logical)], from)
+	return f.UpdateAliases([]data_processing.AliasAction{
+		{Index: from, Alias: data_processing.ReadAlias(logical)},
+		{Index: from, Alias: data_processing.WriteAlias(logical)},
+		{Index: to, Alias: data_processing.DualWriteAlias(logical)},
+		{Add: true, Index: to, Alias: data_processing.ReadAlias(logical)},
+		{Add: true, Index: to, Alias: data_processing.WriteAlias(logical), IsWriteIndex: true},
+		{Add: true, Index: from, Alias: data_processing.DualWriteAlias(logical)},
+	})
+}
+
+func (f *fakeIndexAdmin) Count(index string) (int64, error) {
+	return int64(len(f.documents[index])), nil
+}
+
+func (f *fakeIndexAdmin) Reindex(source string, dest string) error {
+	for id, document := range f.documents[source] {
+		f.CreateDocument(dest, id, document)
+	}
+	return nil
+}
+
+func (f *fakeIndexAdmin) PurgeTombstones(index string) (int64, error) {
+	var purged int64
+	for id, document := range f.documents[index] {
+		if fields, ok := document.(map[string]interface{}); ok && fields[data_processing.TombstoneField] == true {
+			delete(f.documents[index], id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (f *fakeIndexAdmin) CreateDocument(indexName string, id string, document interface{}) (bool, error) {
+	if _, ok := f.documents[indexName][id]; ok {
+		return false, nil
+	}
+	f.documents[indexName][id] = document
+	return true, nil
+}
+
+type fakeReplayer struct {
+	documents map[string]interface{}
+}
+
+func (f *fakeReplayer) Replay(ctx context.Context, logical string, create func(id string, document interface{}) error) error {
+	for id, document := range f.documents {
+		if err := create(id, document); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestReindexerRun(t *testing.T) {
+	const logical = "cdc-service"
+	oldDocuments := map[string]interface{}{
+		"1": map[string]interface{}{"name": "a"},
+		"2": map[string]interface{}{"name": "b"},
+	}
+
+	tests := []struct {
+		name        string
+		source      string
+		replayed    map[string]interface{}
+		tolerance   float64
+		failSwap    bool
+		wantErr     bool
+		wantSwapped bool
+	}{
+		{
+			name:        "opensearch source",
+			source:      SourceOpenSearch,
+			wantSwapped: true,
+		},
+		{
+			name:        "kafka source",
+			source:      SourceKafka,
+			replayed:    oldDocuments,
+			wantSwapped: true,
+		},
+		{
+			name:     "count mismatch",
+			source:   SourceKafka,
+			replayed: map[string]interface{}{"1": oldDocuments["1"]},
+			wantErr:  true,
+		},
+		{
+			name:        "count mismatch within tolerance",
+			source:      SourceKafka,
+			replayed:    map[string]interface{}{"1": oldDocuments["1"]},
+			tolerance:   0.5,
+			wantSwapped: true,
+		},
+		{
+			name:     "swap failure",
+			source:   SourceOpenSearch,
+			failSwap: true,
+			wantErr:  true,
+		},
+		{
+			name:    "unknown source",
+			source:  "s3",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			admin := newFakeIndexAdmin(logical, 1, copyDocuments(oldDocuments))
+			admin.failSwap = tt.failSwap
+			reindexer := NewReindexer(admin, admin, &fakeReplayer{documents: tt.replayed}, zap.NewNop())
+
+			result, err := reindexer.Run(context.Background(), logical, Options{
+				Source:    tt.source,
+				Tolerance: tt.tolerance,
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if result.Swapped != tt.wantSwapped {
+				t.Errorf("Run() swapped = %v, want %v", result.Swapped, tt.wantSwapped)
+			}
+
+			read, _ := admin.Resolve(data_processing.ReadAlias(logical))
+			dualWrites, _ := admin.Resolve(data_processing.DualWriteAlias(logical))
+			if catchUps, _ := admin.Resolve(data_processing.CatchUpAlias(logical)); len(catchUps) != 0 {
+				t.Errorf("catch-up alias = %v, want none after the catch-up", catchUps)
+			}
+			if tt.wantSwapped {
+				if len(read) != 1 || read[0] != "cdc-service-v2" {
+					t.Errorf("read alias = %v, want [cdc-service-v2]", read)
+				}
+				if !admin.dualWrittenAtSwap {
+					t.Errorf("cdc-service-v1 was not dual-written before the swap")
+				}
+				if len(dualWrites) != 1 || dualWrites[0] != "cdc-service-v1" {
+					t.Errorf("dual-write alias = %v, want [cdc-service-v1]", dualWrites)
+				}
+				return
+			}
+			if len(read) != 1 || read[0] != "cdc-service-v1" {
+				t.Errorf("read alias = %v, want [cdc-service-v1]", read)
+			}
+			if len(dualWrites) != 0 {
+				t.Errorf("dual-write alias = %v, want none after abort", dualWrites)
+			}
+		})
+	}
+}
+
+func TestReindexerKeepsDualWrittenDocuments(t *testing.T) {
+	const logical = "cdc-route"
+	admin := newFakeIndexAdmin(logical, 1, map[string]interface{}{"1": "old"})
+	// A consumer dual-wrote a newer version before catch-up reached the document
+	replayer := &fakeReplayer{documents: map[string]interface{}{"1": "old"}}
+	reindexer := NewReindexer(admin, &dualWritingCreator{admin: admin, id: "1", document: "new"}, replayer, zap.NewNop())
+
+	if _, err := reindexer.Run(context.Background(), logical, Options{Source: SourceKafka}); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got := admin.documents["cdc-route-v2"]["1"]; got != "new" {
+		t.Errorf("document = %v, want the dual-written version", got)
+	}
+}
+
+func TestReindexerDoesNotRecreateDeletedDocuments(t *testing.T) {
+	const logical = "cdc-route"
+	admin := newFakeIndexAdmin(logical, 1, map[string]interface{}{"1": "a", "2": "b"})
+	replayer := &deletingReplayer{admin: admin, logical: logical, id: "2", documents: map[string]interface{}{"1": "a", "2": "b"}}
+	reindexer := NewReindexer(admin, admin, replayer, zap.NewNop())
+
+	result, err := reindexer.Run(context.Background(), logical, Options{Source: SourceKafka})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if _, ok := admin.documents["cdc-route-v2"]["2"]; ok {
+		t.Errorf("document deleted during the catch-up was recreated")
+	}
+	if result.OldCount != 1 || result.NewCount != 1 {
+		t.Errorf("counts = %d/%d, want 1/1", result.OldCount, result.NewCount)
+	}
+}
+
+// deletingReplayer simulates a consumer deleting a document while it was being replayed.
+// The new generation carries the catch-up alias, so the consumer leaves a tombstone there.
+type deletingReplayer struct {
+	admin     *fakeIndexAdmin
+	logical   string
+	id        string
+	documents map[string]interface{}
+}
+
+func (d *deletingReplayer) Replay(ctx context.Context, logical string, create func(id string, document interface{}) error) error {
+	delete(d.admin.documents["cdc-route-v1"], d.id)
+	for _, index := range d.admin.aliases[data_processing.CatchUpAlias(d.logical)] {
+		d.admin.documents[index][d.id] = map[string]interface{}{data_processing.TombstoneField: true}
+	}
+	for id, document := range d.documents {
+		if err := create(id, document); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dualWritingCreator simulates a consumer dual-write landing just before the first catch-up write
+type dualWritingCreator struct {
+	admin    *fakeIndexAdmin
+	id       string
+	document interface{}
+	done     bool
+}
+
+func (d *dualWritingCreator) CreateDocument(indexName string, id string, document interface{}) (bool, error) {
+	if !d.done {
+		d.admin.documents[indexName][d.id] = d.document
+		d.done = true
+	}
+	return d.admin.CreateDocument(indexName, id, document)
+}
+
+func TestReindexerRollbackAndFinalize(t *testing.T) {
+	const logical = "cdc-node"
+	admin := newFakeIndexAdmin(logical, 1, map[string]interface{}{"1": "a"})
+	reindexer := NewReindexer(admin, admin, nil, zap.NewNop())
+
+	if _, err := reindexer.Rollback(context.Background(), logical, 0); err == nil {
+		t.Fatal("Rollback() of the first generation should fail")
+	}
+
+	if _, err := reindexer.Run(context.Background(), logical, Options{Source: SourceOpenSearch}); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	result, err := reindexer.Rollback(context.Background(), logical, 0)
+	if err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+	if result.To != "cdc-node-v1" {
+		t.Errorf("Rollback() to = %s, want cdc-node-v1", result.To)
+	}
+	if read, _ := admin.Resolve(data_processing.ReadAlias(logical)); len(read) != 1 || read[0] != "cdc-node-v1" {
+		t.Errorf("read alias = %v, want [cdc-node-v1]", read)
+	}
+
+	if err := reindexer.Finalize(logical); err != nil {
+		t.Fatalf("Finalize() error = %v", err)
+	}
+	if dualWrites, _ := admin.Resolve(data_processing.DualWriteAlias(logical)); len(dualWrites) != 0 {
+		t.Errorf("dual-write alias = %v, want none after finalize", dualWrites)
+	}
+	if _, err := reindexer.Rollback(context.Background(), logical, 0); err == nil {
+		t.Error("Rollback() after finalize should fail")
+	}
+}
+
+func copyDocuments(documents map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(documents))
+	for id, document := range documents {
+		copied[id] = document
+	}
+	return copied
+}
@@ -0,0 +1,432 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kong/konnect-ingest/internal/config"
+	"github.com/kong/konnect-ingest/internal/data_processing"
+	"github.com/kong/konnect-ingest/internal/metrics"
+	"github.com/kong/konnect-ingest/internal/models"
+	"go.uber.org/zap"
+)
+
+// Headers of every notification request
+const (
+	// HeaderTimestamp is the unix time in seconds the request was signed at
+	HeaderTimestamp = "X-Ingest-Timestamp"
+	// HeaderSignature is sha256=<hex HMAC-SHA256 of "<timestamp>.<body>">, set when the
+	// endpoint has a secret
+	HeaderSignature = "X-Ingest-Signature"
+	// HeaderIdempotencyKey is the idempotency key of the notification
+	HeaderIdempotencyKey = "X-Ingest-Idempotency-Key"
+)
+
+// Notification is the JSON body posted for an entity change
+type Notification struct {
+	EntityType     string `json:"entity_type"`
+	ID             string `json:"id"`
+	ControlPlaneID string `json:"control_plane_id"`
+	// Op is create, update, delete or read (snapshot)
+	Op   string `json:"op"`
+	TsMs int64  `json:"ts_ms"`
+	// Key is the CDC key of the entity
+	Key string `json:"key"`
+	// Changes lists the changed field paths, when the event carries the before image
+	Changes *data_processing.ChangeSummary `json:"changes,omitempty"`
+	// IdempotencyKey is <key>:<ts_ms>, the same for every delivery of a change. Notifications
+	// are delivered at least once, an event consumed again is notified again.
+	IdempotencyKey string `json:"idempotency_key"`
+}
+
+// NewNotification normalizes an event, summarizing the changes computed by the differ. It
+// reports false for events without an entity key.
+func NewNotification(event models.CDCEvent, differ data_processing.Differ) (Notification, bool) {
+	key, ok := models.ParseEntityKey(event.After.Key)
+	if !ok {
+		return Notification{}, false
+	}
+	notification := Notification{
+		EntityType:     key.EntityType,
+		ID:             key.ID,
+		ControlPlaneID: key.ControlPlaneID,
+		Op:             data_processing.ChangeOp(event.Op),
+		TsMs:           event.TsMs,
+		Key:            event.After.Key,
+		IdempotencyKey: fmt.Sprintf("%s:%d", event.After.Key, event.TsMs),
+	}
+	before, beforeOK := event.Before.(map[string]interface{})
+	after, afterOK := event.After.Value.Object.(map[string]interface{})
+	if beforeOK && afterOK {
+		summary := data_processing.Summarize(differ.Diff(before, after))
+		notification.Changes = &summary
+	}
+	return notification, true
+}
+
+// heartbeatEntityType is the entity type whose updates are the heartbeats of data plane
+// nodes, which make up most of the stream
+const heartbeatEntityType = "node"
+
+// Endpoint is a receiver of notifications. Empty filters match everything but node
+// heartbeats, which are only notified to endpoints listing the node entity type.
+type Endpoint struct {
+	Name string
+	URL  string
+	// Secret signs the requests, they are not signed when empty
+	Secret        string
+	EntityTypes   []string
+	Ops           []string
+	ControlPlanes []string
+}
+
+// matches reports whether the endpoint wants the notification
+func (e Endpoint) matches(notification Notification) bool {
+	if notification.EntityType == heartbeatEntityType && notification.Op == data_processing.ChangeOp(models.OpUpdate) &&
+		!contains(e.EntityTypes, heartbeatEntityType) {
+		return false
+	}
+	return matchesAny(e.EntityTypes, notification.EntityType) &&
+		matchesAny(e.Ops, notification.Op) &&
+		matchesAny(e.ControlPlanes, notification.ControlPlaneID)
+}
+
+func matchesAny(values []string, value string) bool {
+	return len(values) == 0 || contains(values, value)
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Config configures a Notifier
+type Config struct {
+	Endpoints []Endpoint
+	// Timeout bounds every request
+	Timeout time.Duration
+	// MaxRetries bounds the retries of a request failing with a network error, 429 or 5xx,
+	// each after a doubling backoff
+	MaxRetries     int
+	InitialBackoff time.Duration
+	// QueueSize, when positive, queues the notifications of every endpoint up to this many and
+	// delivers them in the background; notifications of a full queue are dropped
+	QueueSize int
+}
+
+// ConfigFromConfig converts the webhook sink configuration
+func ConfigFromConfig(cfg *config.Config) (Config, error) {
+	webhookConfig := Config{MaxRetries: cfg.Sinks.Webhook.MaxRetries}
+	policy, err := data_processing.ParseFailurePolicy(cfg.Sinks.Webhook.Policy)
+	if err != nil {
+		return Config{}, err
+	}
+	// Required notifications must be delivered before the event is acknowledged
+	if policy == data_processing.SinkBestEffort {
+		webhookConfig.QueueSize = cfg.Sinks.Webhook.QueueSize
+	}
+	if webhookConfig.Timeout, err = time.ParseDuration(cfg.Sinks.Webhook.Timeout); err != nil {
+		return Config{}, fmt.Errorf("timeout: %w", err)
+	}
+	if webhookConfig.InitialBackoff, err = time.ParseDuration(cfg.Sinks.Webhook.InitialBackoff); err != nil {
+		return Config{}, fmt.Errorf("initial_backoff: %w", err)
+	}
+	if len(cfg.Sinks.Webhook.Endpoints) == 0 {
+		return Config{}, fmt.Errorf("no endpoint configured")
+	}
+	for _, endpoint := range cfg.Sinks.Webhook.Endpoints {
+		if endpoint.URL == "" {
+			return Config{}, fmt.Errorf("endpoint %q has no url", endpoint.Name)
+		}
+		name := endpoint.Name
+		if name == "" {
+			name = endpoint.URL
+		}
+		webhookConfig.Endpoints = append(webhookConfig.Endpoints, Endpoint{
+			Name:          name,
+			URL:           endpoint.URL,
+			Secret:        endpoint.Secret,
+			EntityTypes:   endpoint.EntityTypes,
+			Ops:           endpoint.Ops,
+			ControlPlanes: endpoint.ControlPlanes,
+		})
+	}
+	return webhookConfig, nil
+}
+
+// deliveryError is a request failure, retryable unless the endpoint refused the notification
+type deliveryError struct {
+	err       error
+	retryable bool
+}
+
+func (e *deliveryError) Error() string { return e.err.Error() }
+func (e *deliveryError) Unwrap() error { return e.err }
+
+// encodedNotification is a notification ready to post
+type encodedNotification struct {
+	key            string
+	idempotencyKey string
+	body           []byte
+}
+
+// Notifier implements data_processing.Sink and data_processing.EventSink by posting a
+// notification of every entity change to the endpoints whose filters match it. Requests are
+// sent in event order and retried with a doubling backoff, synchronously or, with a queue
+// size, by one goroutine per endpoint.
+type Notifier struct {
+	config Config
+	client *http.Client
+	logger *zap.Logger
+	differ data_processing.Differ
+
+	// queues holds the pending notifications of every endpoint, nil for synchronous delivery
+	queues    []chan encodedNotification
+	closing   chan struct{}
+	closeOnce sync.Once
+	workers   sync.WaitGroup
+
+	deliveries *expvar.Int
+	failures   *expvar.Int
+	dropped    *expvar.Int
+	sleep      func(time.Duration)
+	now        func() time.Time
+}
+
+// NewNotifier creates a notifier, with a queue size it starts the delivery goroutines which
+// run until Close
+func NewNotifier(config Config, logger *zap.Logger) *Notifier {
+	n := &Notifier{
+		config:     config,
+		client:     &http.Client{Timeout: config.Timeout},
+		logger:     logger,
+		differ:     data_processing.NewFieldDiffer(nil),
+		closing:    make(chan struct{}),
+		deliveries: metrics.Counter("webhook_deliveries"),
+		failures:   metrics.Counter("webhook_failures"),
+		dropped:    metrics.Counter("webhook_dropped"),
+		sleep:      time.Sleep,
+		now:        time.Now,
+	}
+	if config.QueueSize > 0 {
+		for _, endpoint := range config.Endpoints {
+			queue := make(chan encodedNotification, config.QueueSize)
+			n.queues = append(n.queues, queue)
+			n.workers.Add(1)
+			go n.run(endpoint, queue)
+		}
+	}
+	return n
+}
+
+// SetDiffer replaces the differ computing the changes of notifications, by default no field is
+// ignored
+func (n *Notifier) SetDiffer(differ data_processing.Differ) {
+	n.differ = differ
+}
+
+// Name returns the name of the sink
+func (n *Notifier) Name() string {
+	return "webhook"
+}
+
+// Capabilities reports an event sink
+func (n *Notifier) Capabilities() data_processing.SinkCapabilities {
+	return data_processing.SinkCapabilities{Events: true}
+}
+
+// Upsert is a no-op, notifications are sent for events
+func (n *Notifier) Upsert(key string, indexName string, id string, document interface{}) error {
+	return nil
+}
+
+// Delete is unsupported, deletes are notified as events
+func (n *Notifier) Delete(indexName string, id string) error {
+	return data_processing.ErrUnsupported
+}
+
+// Flush is a no-op, queued notifications are not bound to offset commits
+func (n *Notifier) Flush() error {
+	return nil
+}
+
+// Close stops the delivery goroutines after their current delivery, the notifications still
+// queued are dropped. Closing again is a no-op.
+func (n *Notifier) Close() error {
+	n.closeOnce.Do(func() {
+		close(n.closing)
+		n.workers.Wait()
+		for i, queue := range n.queues {
+			if pending := len(queue); pending > 0 {
+				n.dropped.Add(int64(pending))
+				n.logger.Warn("Dropping queued notifications",
+					zap.String("endpoint", n.config.Endpoints[i].Name),
+					zap.Int("notifications", pending),
+				)
+			}
+		}
+	})
+	return nil
+}
+
+// RecordEvent posts the notification of the event to every matching endpoint, or queues it.
+// Every endpoint is tried; the first failure is returned.
+func (n *Notifier) RecordEvent(event models.CDCEvent) error {
+	notification, ok := NewNotification(event, n.differ)
+	if !ok {
+		return nil
+	}
+	var encoded *encodedNotification
+	var firstErr error
+	for i, endpoint := range n.config.Endpoints {
+		if !endpoint.matches(notification) {
+			continue
+		}
+		if encoded == nil {
+			body, err := json.Marshal(notification)
+			if err != nil {
+				return err
+			}
+			encoded = &encodedNotification{key: notification.Key, idempotencyKey: notification.IdempotencyKey, body: body}
+		}
+		if n.queues != nil {
+			n.enqueue(endpoint, n.queues[i], *encoded)
+			continue
+		}
+		if err := n.send(endpoint, *encoded); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("endpoint %s: %w", endpoint.Name, err)
+		}
+	}
+	return firstErr
+}
+
+// enqueue queues a notification, dropping it when the queue of the endpoint is full
+func (n *Notifier) enqueue(endpoint Endpoint, queue chan encodedNotification, notification encodedNotification) {
+	select {
+	case queue <- notification:
+	default:
+		n.dropped.Add(1)
+		n.logger.Warn("Dropping notification, the endpoint queue is full",
+			zap.String("endpoint", endpoint.Name),
+			zap.String("key", notification.key),
+		)
+	}
+}
+
+// run delivers the queued notifications of an endpoint until Close
+func (n *Notifier) run(endpoint Endpoint, queue chan encodedNotification) {
+	defer n.workers.Done()
+	for {
+		select {
+		case <-n.closing:
+			return
+		case notification := <-queue:
+			n.send(endpoint, notification)
+		}
+	}
+}
+
+// send delivers a notification and counts the outcome
+func (n *Notifier) send(endpoint Endpoint, notification encodedNotification) error {
+	if err := n.deliver(endpoint, notification); err != nil {
+		n.failures.Add(1)
+		n.logger.Error("Failed to deliver notification",
+			zap.String("endpoint", endpoint.Name),
+			zap.String("key", notification.key),
+			zap.Error(err),
+		)
+		return err
+	}
+	n.deliveries.Add(1)
+	return nil
+}
+
+// deliver posts the notification, retrying retryable failures with a doubling backoff until the
+// notifier closes
+func (n *Notifier) deliver(endpoint Endpoint, notification encodedNotification) error {
+	backoff := n.config.InitialBackoff
+	for attempt := 0; ; attempt++ {
+		err := n.post(endpoint, notification)
+		var delivery *deliveryError
+		if err == nil || (errors.As(err, &delivery) && !delivery.retryable) || attempt >= n.config.MaxRetries || n.closed() {
+			return err
+		}
+		n.logger.Warn("Retrying notification",
+			zap.String("endpoint", endpoint.Name),
+			zap.Int("attempt", attempt+1),
+			zap.Error(err),
+		)
+		n.sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// closed reports whether Close was called
+func (n *Notifier) closed() bool {
+	select {
+	case <-n.closing:
+		return true
+	default:
+		return false
+	}
+}
+
+// post sends one signed request
+func (n *Notifier) post(endpoint Endpoint, notification encodedNotification) error {
+	body := notification.body
+	request, err := http.NewRequest(http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return &deliveryError{err: err}
+	}
+	timestamp := strconv.FormatInt(n.now().Unix(), 10)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(HeaderTimestamp, timestamp)
+	request.Header.Set(HeaderIdempotencyKey, notification.idempotencyKey)
+	if endpoint.Secret != "" {
+		request.Header.Set(HeaderSignature, Sign(endpoint.Secret, timestamp, body))
+	}
+
+	response, err := n.client.Do(request)
+	if err != nil {
+		return &deliveryError{err: err, retryable: true}
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return nil
+	}
+	return &deliveryError{
+		err:       fmt.Errorf("unexpected status %s", response.Status),
+		retryable: response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500,
+	}
+}
+
+// Sign returns the signature header value of a request body
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether the signature header value matches the request body, for receivers
+func Verify(secret string, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
@@ -0,0 +1,304 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kong/konnect-ingest/internal/data_processing"
+	"github.com/kong/konnect-ingest/internal/models"
+	"go.uber.org/zap"
+)
+
+// receiver is a webhook endpoint answering with the queued statuses, then 204
+type receiver struct {
+	t        *testing.T
+	secret   string
+	mu       sync.Mutex
+	statuses []int
+	received []Notification
+	requests int
+}
+
+func newReceiver(t *testing.T, secret string, statuses ...int) (*receiver, *httptest.Server) {
+	r := &receiver{t: t, secret: secret, statuses: statuses}
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return r, server
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, request *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests++
+
+	body, _ := io.ReadAll(request.Body)
+	if r.secret != "" && !Verify(r.secret, request.Header.Get(HeaderTimestamp), body, request.Header.Get(HeaderSignature)) {
+		r.t.Errorf("invalid signature %q", request.Header.Get(HeaderSignature))
+	}
+	if len(r.statuses) > 0 {
+		status := r.statuses[0]
+		r.statuses = r.statuses[1:]
+		if status >= 300 {
+			w.WriteHeader(status)
+			return
+		}
+	}
+	var notification Notification
+	if err := json.Unmarshal(body, &notification); err != nil {
+		r.t.Errorf("invalid notification %s: %v", body, err)
+	}
+	if key := request.Header.Get(HeaderIdempotencyKey); key != notification.IdempotencyKey {
+		r.t.Errorf("idempotency key header %q, body %q", key, notification.IdempotencyKey)
+	}
+	r.received = append(r.received, notification)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func newEvent(op string, key string, before interface{}, object interface{}) models.CDCEvent {
+	var event models.CDCEvent
+	event.Op = op
+	event.TsMs = 1706812484573
+	event.Before = before
+	event.After.Key = key
+	event.After.Value.Object = object
+	return event
+}
+
+func newTestNotifier(config Config) (*Notifier, *[]time.Duration) {
+	notifier := NewNotifier(config, zap.NewNop())
+	var sleeps []time.Duration
+	notifier.sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+	return notifier, &sleeps
+}
+
+func TestNotifierDelivers(t *testing.T) {
+	services, servicesServer := newReceiver(t, "s3cr3t")
+	deletes, deletesServer := newReceiver(t, "")
+	all, allServer := newReceiver(t, "")
+	nodes, nodesServer := newReceiver(t, "")
+	notifier, _ := newTestNotifier(Config{
+		Timeout: time.Second,
+		Endpoints: []Endpoint{
+			{Name: "services", URL: servicesServer.URL, Secret: "s3cr3t", EntityTypes: []string{"service"}},
+			{Name: "deletes", URL: deletesServer.URL, Ops: []string{"delete"}, ControlPlanes: []string{"cp-1"}},
+			{Name: "all", URL: allServer.URL},
+			{Name: "nodes", URL: nodesServer.URL, EntityTypes: []string{"node"}},
+		},
+	})
+
+	events := []models.CDCEvent{
+		newEvent(models.OpUpdate, "c/cp-1/o/service/svc-1",
+			map[string]interface{}{"id": "svc-1", "name": "old", "port": float64(80), "path": "/"},
+			map[string]interface{}{"id": "svc-1", "name": "new", "port": float64(80), "tags": []interface{}{"a"}}),
+		newEvent(models.OpCreate, "c/cp-1/o/route/route-1", nil, map[string]interface{}{"id": "route-1"}),
+		newEvent(models.OpDelete, "c/cp-1/o/route/route-1", nil, nil),
+		newEvent(models.OpDelete, "c/cp-2/o/route/route-2", nil, nil),
+		newEvent(models.OpUpdate, "not-an-entity-key", nil, map[string]interface{}{}),
+		newEvent(models.OpCreate, "c/cp-1/o/node/node-1", nil, map[string]interface{}{"id": "node-1"}),
+		newEvent(models.OpUpdate, "c/cp-1/o/node/node-1",
+			map[string]interface{}{"id": "node-1", "last_ping": float64(1)},
+			map[string]interface{}{"id": "node-1", "last_ping": float64(2)}),
+	}
+	for _, event := range events {
+		if err := notifier.RecordEvent(event); err != nil {
+			t.Fatalf("RecordEvent() error = %v", err)
+		}
+	}
+
+	want := []Notification{{
+		EntityType:     "service",
+		ID:             "svc-1",
+		ControlPlaneID: "cp-1",
+		Op:             "update",
+		TsMs:           1706812484573,
+		Key:            "c/cp-1/o/service/svc-1",
+		Changes:        &data_processing.ChangeSummary{Added: []string{"tags"}, Removed: []string{"path"}, Changed: []string{"name"}},
+		IdempotencyKey: "c/cp-1/o/service/svc-1:1706812484573",
+	}}
+	if !reflect.DeepEqual(services.received, want) {
+		t.Errorf("services endpoint received %+v, want %+v", services.received, want)
+	}
+	if len(deletes.received) != 1 || deletes.received[0].Key != "c/cp-1/o/route/route-1" || deletes.received[0].Changes != nil {
+		t.Errorf("deletes endpoint received %+v, want the cp-1 route delete", deletes.received)
+	}
+	// Node heartbeats only reach endpoints asking for nodes
+	if len(all.received) != 5 || all.received[4].Op != "create" {
+		t.Errorf("unfiltered endpoint received %d notifications, want 5 ending with the node create", len(all.received))
+	}
+	if len(nodes.received) != 2 || nodes.received[1].Op != "update" {
+		t.Errorf("nodes endpoint received %+v, want the node create and heartbeat", nodes.received)
+	}
+}
+
+func TestNotifierDiffer(t *testing.T) {
+	endpoint, server := newReceiver(t, "")
+	notifier, _ := newTestNotifier(Config{Timeout: time.Second, Endpoints: []Endpoint{{Name: "test", URL: server.URL}}})
+	notifier.SetDiffer(data_processing.NewFieldDiffer([]string{"updated_at"}))
+
+	event := newEvent(models.OpUpdate, "c/cp-1/o/service/svc-1",
+		map[string]interface{}{"id": "svc-1", "name": "old", "updated_at": float64(1)},
+		map[string]interface{}{"id": "svc-1", "name": "new", "updated_at": float64(2)})
+	if err := notifier.RecordEvent(event); err != nil {
+		t.Fatalf("RecordEvent() error = %v", err)
+	}
+	want := &data_processing.ChangeSummary{Changed: []string{"name"}}
+	if len(endpoint.received) != 1 || !reflect.DeepEqual(endpoint.received[0].Changes, want) {
+		t.Errorf("received %+v, want changes %+v", endpoint.received, want)
+	}
+}
+
+func TestNotifierRetries(t *testing.T) {
+	event := newEvent(models.OpCreate, "c/cp-1/o/service/svc-1", nil, map[string]interface{}{"id": "svc-1"})
+
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantErr      bool
+		wantRequests int
+		wantSleeps   []time.Duration
+	}{
+		{
+			name:         "retries unavailable and throttled",
+			statuses:     []int{http.StatusServiceUnavailable, http.StatusTooManyRequests},
+			wantRequests: 3,
+			wantSleeps:   []time.Duration{100 * time.Millisecond, 200 * time.Millisecond},
+		},
+		{
+			name:         "gives up after max retries",
+			statuses:     []int{500, 500, 500, 500},
+			wantErr:      true,
+			wantRequests: 4,
+			wantSleeps:   []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond},
+		},
+		{
+			name:         "refused notifications are not retried",
+			statuses:     []int{http.StatusBadRequest},
+			wantErr:      true,
+			wantRequests: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			endpoint, server := newReceiver(t, "", tt.statuses...)
+			notifier, sleeps := newTestNotifier(Config{
+				Timeout:        time.Second,
+				MaxRetries:     3,
+				InitialBackoff: 100 * time.Millisecond,
+				Endpoints:      []Endpoint{{Name: "test", URL: server.URL}},
+			})
+
+			err := notifier.RecordEvent(event)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RecordEvent() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if endpoint.requests != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", endpoint.requests, tt.wantRequests)
+			}
+			if !reflect.DeepEqual(*sleeps, tt.wantSleeps) {
+				t.Errorf("backoffs = %v, want %v", *sleeps, tt.wantSleeps)
+			}
+		})
+	}
+
+	t.Run("unreachable endpoint", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+		notifier, sleeps := newTestNotifier(Config{
+			Timeout:    time.Second,
+			MaxRetries: 1,
+			Endpoints:  []Endpoint{{Name: "down", URL: server.URL}},
+		})
+		if err := notifier.RecordEvent(event); err == nil {
+			t.Error("RecordEvent() should fail when the endpoint is down")
+		}
+		if len(*sleeps) != 1 {
+			t.Errorf("network errors should be retried, got %d retries", len(*sleeps))
+		}
+	})
+}
+
+func TestNotifierQueues(t *testing.T) {
+	started := make(chan string, 1)
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var delivered []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		var notification Notification
+		json.NewDecoder(request.Body).Decode(&notification)
+		select {
+		case started <- notification.ID:
+		default:
+		}
+		<-release
+		mu.Lock()
+		delivered = append(delivered, notification.ID)
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(server.Close)
+	notifier, _ := newTestNotifier(Config{
+		Timeout:   10 * time.Second,
+		QueueSize: 1,
+		Endpoints: []Endpoint{{Name: "slow", URL: server.URL}},
+	})
+	dropped := notifier.dropped.Value()
+
+	record := func(id string) {
+		t.Helper()
+		event := newEvent(models.OpCreate, "c/cp-1/o/service/"+id, nil, map[string]interface{}{"id": id})
+		if err := notifier.RecordEvent(event); err != nil {
+			t.Fatalf("RecordEvent() error = %v", err)
+		}
+	}
+	// s1 is in flight, s2 queued and s3 dropped without waiting for the endpoint
+	record("s1")
+	<-started
+	record("s2")
+	record("s3")
+	if got := notifier.dropped.Value() - dropped; got != 1 {
+		t.Errorf("dropped %d notifications, want 1", got)
+	}
+
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		done := len(delivered) == 2
+		mu.Unlock()
+		if done || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := notifier.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	// Closing twice, e.g. on shutdown after a failed start, is harmless
+	if err := notifier.Close(); err != nil {
+		t.Fatalf("second Close() error = %v", err)
+	}
+	if !reflect.DeepEqual(delivered, []string{"s1", "s2"}) {
+		t.Errorf("delivered %v, want [s1 s2]", delivered)
+	}
+}
+
+func TestSignature(t *testing.T) {
+	body := []byte(`{"id":"svc-1"}`)
+	signature := Sign("secret", "1706812484", body)
+	if !Verify("secret", "1706812484", body, signature) {
+		t.Error("signature should verify")
+	}
+	if Verify("secret", "1706812485", body, signature) {
+		t.Error("signature of another timestamp should not verify")
+	}
+	if Verify("other", "1706812484", body, signature) {
+		t.Error("signature of another secret should not verify")
+	}
+}